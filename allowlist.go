@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var ErrEmptyAllowlist = errors.New("the branch allowlist has no branches")
+
+// branchRefPrefix is the prefix of the branch reference names.
+const branchRefPrefix = "refs/heads/"
+
+// readBranchAllowlist reads a branch allowlist file with one branch per line,
+// either as a branch name (e.g. 'main') or as a full reference name (e.g.
+// 'refs/heads/main'). Empty lines and lines starting with '#' are ignored. It
+// returns the set of the allowed branch reference names. An allowlist without
+// branches fails with ErrEmptyAllowlist as it would prune all the
+// destination branches, e.g. when the file is truncated while edited.
+func readBranchAllowlist(path string) (map[plumbing.ReferenceName]bool, error) {
+	branches, err := readRefsFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(branches) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyAllowlist, path)
+	}
+
+	allowed := make(map[plumbing.ReferenceName]bool, len(branches))
+
+	for _, branch := range branches {
+		if !strings.HasPrefix(branch, branchRefPrefix) {
+			branch = branchRefPrefix + branch
+		}
+
+		allowed[plumbing.ReferenceName(branch)] = true
+	}
+
+	return allowed, nil
+}
+
+// filterBranchAllowlist removes the branches of a repository that are not in
+// the allowlist. The other references are kept.
+func filterBranchAllowlist(repo *git.Repository,
+	allowed map[plumbing.ReferenceName]bool) error {
+	return filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+		return !ref.Name().IsBranch() || allowed[ref.Name()]
+	})
+}
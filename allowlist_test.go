@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestReadBranchAllowlist tests reading a branch allowlist file.
+func TestReadBranchAllowlist(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "branches")
+	if err := os.WriteFile(path, []byte("main\n\n# releases\n refs/heads/release/1 \n"),
+		0o644); err != nil {
+		t.Fatalf("failed to write the allowlist: %s", err)
+	}
+
+	allowed, err := readBranchAllowlist(path)
+	if err != nil {
+		t.Fatalf("readBranchAllowlist failed: %s", err)
+	}
+
+	if !cmp.Equal(allowed, map[plumbing.ReferenceName]bool{
+		"refs/heads/main":      true,
+		"refs/heads/release/1": true,
+	}) {
+		t.Fatalf("unexpected allowed branches: %v", allowed)
+	}
+
+	if _, err := readBranchAllowlist(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("reading a missing allowlist passed")
+	}
+
+	// An allowlist without branches would prune all the branches.
+	if err := os.WriteFile(path, []byte("\n# no branches\n"), 0o644); err != nil {
+		t.Fatalf("failed to write the allowlist: %s", err)
+	}
+
+	if _, err := readBranchAllowlist(path); !errors.Is(err, ErrEmptyAllowlist) {
+		t.Fatalf("unexpected error for an empty allowlist: %v", err)
+	}
+}
+
+// TestDoMirrorBranchAllowlist tests that only the allowed branches are
+// mirrored and that the branches removed from the allowlist are pruned.
+func TestDoMirrorBranchAllowlist(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/heads/c",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	allowlistPath := filepath.Join(t.TempDir(), "branches")
+
+	conf := Config{
+		Source:              RepoConf{URL: srcRepoPath},
+		Destination:         RepoConf{URL: dstRepoPath},
+		BranchAllowlistFile: allowlistPath,
+	}
+
+	for _, test := range []struct {
+		allowlist string
+		expected  []string
+	}{
+		{
+			// The branches missing from the source are ignored.
+			allowlist: "master\na\nrefs/heads/b\nmissing\n",
+			expected: []string{"HEAD", "refs/heads/master", "refs/heads/a",
+				"refs/heads/b", "refs/tags/v1"},
+		},
+		{
+			// The branches removed from the allowlist are pruned.
+			allowlist: "master\nb\n",
+			expected:  []string{"HEAD", "refs/heads/master", "refs/heads/b", "refs/tags/v1"},
+		},
+	} {
+		if err := os.WriteFile(allowlistPath, []byte(test.allowlist), 0o644); err != nil {
+			t.Fatalf("failed to write the allowlist: %s", err)
+		}
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, test.expected) {
+			t.Fatalf("unexpected refs in the dst repo for %q: %s", test.allowlist, dstRepoRefs)
+		}
+	}
+}
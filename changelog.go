@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	changelogPerm = 0o644
+	// shortHashLen is the length of the abbreviated hashes of the changelog.
+	shortHashLen = 7
+)
+
+// shortHash abbreviates a hash like 'git log --oneline'.
+func shortHash(hash plumbing.Hash) string {
+	return hash.String()[:shortHashLen]
+}
+
+// refUpdateNote returns a note for a reference update that is not a
+// fast-forward, which rewrote the history, or an empty string. Only the
+// commit updates are checked.
+func refUpdateNote(repo *git.Repository, from, to plumbing.Hash) string {
+	if _, err := repo.CommitObject(to); err != nil {
+		return ""
+	}
+
+	if !isFastForward(repo, from, to) {
+		return " (forced)"
+	}
+
+	return ""
+}
+
+// changelog returns a Markdown changelog of the destination references added,
+// updated and removed from 'before' to 'after'. The updates are given as
+// commit ranges, with the ones rewriting the history marked as forced.
+func changelog(conf Config, repo *git.Repository, before,
+	after []*plumbing.Reference) string {
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(before))
+	for _, ref := range before {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	var added, updated, removed []string
+
+	for _, ref := range after {
+		if !strings.HasPrefix(ref.Name().String(), "refs/") {
+			continue
+		}
+
+		hash, found := hashes[ref.Name()]
+		delete(hashes, ref.Name())
+
+		switch {
+		case !found:
+			added = append(added, fmt.Sprintf("* %s at %s", ref.Name(),
+				shortHash(ref.Hash())))
+		case hash != ref.Hash():
+			updated = append(updated, fmt.Sprintf("* %s: %s..%s%s", ref.Name(),
+				shortHash(hash), shortHash(ref.Hash()),
+				refUpdateNote(repo, hash, ref.Hash())))
+		}
+	}
+
+	for name, hash := range hashes {
+		if strings.HasPrefix(name.String(), "refs/") {
+			removed = append(removed, fmt.Sprintf("* %s (was %s)", name,
+				shortHash(hash)))
+		}
+	}
+
+	var content strings.Builder
+
+	fmt.Fprintf(&content, "# Mirror changelog\n\n"+
+		"* Source: %s\n* Destination: %s\n* Date: %s\n",
+		redactURL(conf.Source.URL), redactURL(conf.Destination.URL),
+		time.Now().UTC().Format(time.RFC3339))
+
+	if len(added) == 0 && len(updated) == 0 && len(removed) == 0 {
+		content.WriteString("\nNo references changed.\n")
+	}
+
+	for _, section := range []struct {
+		title string
+		lines []string
+	}{
+		{"Added", added},
+		{"Updated", updated},
+		{"Removed", removed},
+	} {
+		if len(section.lines) == 0 {
+			continue
+		}
+
+		sort.Strings(section.lines)
+
+		fmt.Fprintf(&content, "\n## %s\n\n%s\n", section.title,
+			strings.Join(section.lines, "\n"))
+	}
+
+	return content.String()
+}
+
+// writeChangelog writes the changelog of the destination references from
+// 'before' to 'after' to conf.Changelog, replacing the one of the previous
+// mirror.
+func writeChangelog(conf Config, repo *git.Repository, before,
+	after []*plumbing.Reference) error {
+	content := changelog(conf, repo, before, after)
+
+	if err := os.WriteFile(conf.Changelog, []byte(content), changelogPerm); err != nil {
+		return fmt.Errorf("failed to write the changelog: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorChangelog tests the changelog of the references changed by a
+// mirror.
+func TestDoMirrorChangelog(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	srcRepo, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	changelogPath := filepath.Join(t.TempDir(), "CHANGES.md")
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		Changelog:   changelogPath,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	content, err := os.ReadFile(changelogPath)
+	if err != nil {
+		t.Fatalf("failed to read the changelog: %s", err)
+	}
+
+	head := shortHash(srcHead)
+
+	if !strings.Contains(string(content), "## Added\n\n"+
+		"* refs/heads/a at "+head+"\n"+
+		"* refs/heads/master at "+head+"\n"+
+		"* refs/tags/v1 at "+head+"\n") {
+		t.Fatalf("unexpected changelog:\n%s", content)
+	}
+
+	// Update a branch with a new commit and remove the tag.
+	headCommit, err := srcRepo.CommitObject(srcHead)
+	if err != nil {
+		t.Fatalf("failed to get the src HEAD commit: %s", err)
+	}
+
+	signature := object.Signature{Name: "Example", Email: "ex@ample.com", When: time.Now()}
+	commit := &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      "new",
+		TreeHash:     headCommit.TreeHash,
+		ParentHashes: []plumbing.Hash{srcHead},
+	}
+
+	obj := srcRepo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		t.Fatalf("failed to encode a commit: %s", err)
+	}
+
+	newHead, err := srcRepo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("failed to store a commit: %s", err)
+	}
+
+	err = srcRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/master",
+		newHead))
+	if err != nil {
+		t.Fatalf("failed to set reference: %s", err)
+	}
+
+	if err := srcRepo.Storer.RemoveReference("refs/tags/v1"); err != nil {
+		t.Fatalf("failed to remove a src repo reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	content, err = os.ReadFile(changelogPath)
+	if err != nil {
+		t.Fatalf("failed to read the changelog: %s", err)
+	}
+
+	if strings.Contains(string(content), "## Added") ||
+		!strings.Contains(string(content), "## Updated\n\n"+
+			"* refs/heads/master: "+head+".."+shortHash(newHead)+"\n") ||
+		!strings.Contains(string(content), "## Removed\n\n"+
+			"* refs/tags/v1 (was "+head+")\n") {
+		t.Fatalf("unexpected changelog:\n%s", content)
+	}
+}
+
+// TestChangelogForced tests that the updates rewriting the history are marked
+// as forced.
+func TestChangelogForced(t *testing.T) {
+	t.Parallel()
+
+	repo, head, err := utils.NewTestRepo(t.TempDir(), []string{})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	unknown := plumbing.NewHash("0123456789012345678901234567890123456789")
+	before := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/master", unknown),
+	}
+	after := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/master", head),
+	}
+
+	content := changelog(Config{}, repo, before, after)
+	if !strings.Contains(content, "* refs/heads/master: 0123456.."+
+		shortHash(head)+" (forced)\n") {
+		t.Fatalf("unexpected changelog:\n%s", content)
+	}
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+var ErrPreMirrorCheck = errors.New("the pre-mirror check failed")
+
+// setupDiskStagingRepo is setupStagingRepo with the staging repository stored
+// on disk, as a bare repository in a temporary directory, so that it can be
+// checked by PreMirrorCheck. The directory is returned, even on error, for
+// the caller to remove it.
+func setupDiskStagingRepo(conf Config, logger Logger) (*git.Repository, string, error) {
+	dir, err := os.MkdirTemp("", "git-mirror-me-staging-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the staging directory: %w", err)
+	}
+
+	tempFiles.add(dir)
+
+	logger.Debug(conf.Debug, "Staging the source in", dir, ".")
+
+	storer := filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault())
+
+	repo, err := setupStagingRepoIn(conf, logger, storer)
+	if err == nil && len(conf.ObjectCache) != 0 {
+		err = writeObjectCacheAlternates(conf, dir)
+	}
+
+	return repo, dir, err
+}
+
+// runPreMirrorCheck runs conf.PreMirrorCheck with 'sh -c' in the on-disk
+// staging repository 'dir'. The command gets GIT_DIR set to the staging
+// repository and GMM_SOURCE and GMM_DESTINATION set to the repository URLs,
+// without credentials. Its output is logged.
+func runPreMirrorCheck(conf Config, logger Logger, dir string) error {
+	logger.Info("Running the pre-mirror check...")
+
+	cmd := exec.Command("sh", "-c", conf.PreMirrorCheck)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_DIR="+dir,
+		"GMM_SOURCE="+redactURL(conf.Source.URL),
+		"GMM_DESTINATION="+redactURL(conf.Destination.URL),
+	)
+
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		logger.Info("Pre-mirror check:", scanner.Text())
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrPreMirrorCheck, err)
+	}
+
+	logger.Info("The pre-mirror check passed.")
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorPreMirrorCheck tests that a failed pre-mirror check aborts the
+// mirror and that the check gets the staged references.
+func TestDoMirrorPreMirrorCheck(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:         RepoConf{URL: srcRepoPath},
+		Destination:    RepoConf{URL: dstRepoPath},
+		PreMirrorCheck: "exit 1",
+	}
+
+	{
+		// Test that a failed check aborts the mirror.
+		if err := DoMirror(conf, logger); !errors.Is(err, ErrPreMirrorCheck) {
+			t.Fatalf("unexpected error for a failed check: %v", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+		}) {
+			t.Fatalf("a failed check changed the dst repo: %s", dstRepoRefs)
+		}
+	}
+	{
+		// Test that the check gets the staging repository.
+		conf.PreMirrorCheck = "git show-ref --verify refs/heads/a && " +
+			"test \"$GMM_DESTINATION\" = " + dstRepoPath
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed with a passing check: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/a",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+	}
+}
@@ -8,22 +8,123 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
+	"os"
 	"path"
+	"strings"
 
 	mirror "github.com/agherzan/git-mirror-me"
 )
 
-// parseArgs returns a configuration structure initialised from parsing the
-// 'arguments' string slice argument.
-func parseArgs(progName string, arguments []string) (*mirror.Config, string, error) {
-	var srcRepo, dstRepo, knownHostsPath string
+// Used for mocking the standard input when running tests.
+var stdin io.Reader = os.Stdin
 
-	var debug bool
+// stringList is a flag.Value for comma-separated lists of strings.
+type stringList []string
 
-	var flagsOutput bytes.Buffer
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = strings.Split(value, ",")
+
+	return nil
+}
+
+// repeatedList is a flag.Value for lists of strings built by repeating a flag.
+// The first value replaces the default list.
+type repeatedList struct {
+	values *[]string
+	set    bool
+}
+
+func (l *repeatedList) String() string {
+	if l == nil || l.values == nil {
+		return ""
+	}
+
+	return strings.Join(*l.values, ",")
+}
+
+func (l *repeatedList) Set(value string) error {
+	if !l.set {
+		*l.values = nil
+		l.set = true
+	}
+
+	*l.values = append(*l.values, value)
 
+	return nil
+}
+
+// groupList is a flag.Value for lists of comma-separated lists of strings
+// built by repeating a flag, one list for each value. The first value
+// replaces the default lists.
+type groupList struct {
+	values *[][]string
+	set    bool
+}
+
+func (l *groupList) String() string {
+	if l == nil || l.values == nil {
+		return ""
+	}
+
+	groups := make([]string, 0, len(*l.values))
+	for _, group := range *l.values {
+		groups = append(groups, strings.Join(group, ","))
+	}
+
+	return strings.Join(groups, " ")
+}
+
+func (l *groupList) Set(value string) error {
+	if !l.set {
+		*l.values = nil
+		l.set = true
+	}
+
+	*l.values = append(*l.values, strings.Split(value, ","))
+
+	return nil
+}
+
+// loadConfigFile loads a configuration file. When path is "-", the
+// configuration is read from the standard input.
+func loadConfigFile(path string) (*mirror.Config, error) {
+	if path == "-" {
+		return mirror.LoadConfig(stdin)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the configuration file: %w", err)
+	}
+	defer file.Close()
+
+	return mirror.LoadConfig(file)
+}
+
+// cliOptions are the CLI flags that are not part of the mirror configuration.
+type cliOptions struct {
+	configPath   string
+	printConfig  bool
+	prunePlan    bool
+	logOutput    string
+	resultOutput string
+}
+
+// newFlagSet returns the CLI flag set. The parsed values are stored in 'conf'
+// and 'opts'. The current values of 'conf' are used as defaults.
+func newFlagSet(progName string, conf *mirror.Config, opts *cliOptions,
+	output io.Writer) *flag.FlagSet {
 	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
-	flags.SetOutput(&flagsOutput)
+	flags.SetOutput(output)
 	flags.Usage = func() {
 		output := flags.Output()
 		fmt.Fprintf(output,
@@ -45,7 +146,7 @@ Environment variables
       * the 'GMM_SRC_REPO' environment variable
       * using the 'GITHUB_SERVER_URL' and 'GITHUB_REPOSITORY' environment
         variables as 'GITHUB_SERVER_URL/GITHUB_REPOSITORY'
-  GMM_DEST_REPO
+  GMM_DST_REPO
     Same as '-destination-repository' but overridden by the CLI argument.
   GMM_SSH_PRIVATE_KEY
     The SSH private key used for SSH authentication during git operations. When
@@ -57,30 +158,411 @@ Environment variables
     http://man.openbsd.org/sshd#SSH_KNOWN_HOSTS_FILE_FORMAT
     for more information.
     This can't be used in conjunction with '-ssh-known-hosts-path'.
+  GMM_SRC_SSH_PRIVATE_KEY
+  GMM_SRC_SSH_KNOWN_HOSTS
+    Same as 'GMM_SSH_PRIVATE_KEY' and 'GMM_SSH_KNOWN_HOSTS' but used for the
+    source repository. The variables above are used for the destination.
+  GMM_SRC_HTTP_PASSWORD
+  GMM_DST_HTTP_PASSWORD
+    The passwords (or access tokens) used for HTTP(S) authentication to the
+    source and destination repositories. See '-source-http-username' and
+    '-destination-http-username'.
+  GMM_CA_CONTENT
+    The PEM encoded CA bundle trusted for HTTPS remotes in addition to the
+    system ones. This can't be used in conjunction with '-ca-file'.
+  GMM_GITHUB_TOKEN
+  GMM_GITLAB_TOKEN
+    The API tokens used with '-sync-metadata' for the GitHub and GitLab
+    repositories. A token is required for the destination provider.
+  GMM_PROVENANCE_SIGN_KEY
+    The unencrypted ASCII armored OpenPGP private key used to sign the tags of
+    '-provenance-tag'.
 `)
 	}
-	flags.StringVar(&srcRepo, "source-repository", "",
+	flags.StringVar(&opts.configPath, "config", opts.configPath,
+		"Path to a JSON configuration file. Use '-' to read it from the "+
+			"standard input.\nCLI flags override the values in the "+
+			"configuration file. Run the 'init'\nsubcommand to write a sample "+
+			"one.")
+	flags.BoolVar(&opts.printConfig, "print-config", opts.printConfig,
+		"Print the effective configuration, after applying the configuration "+
+			"file,\nthe environment variables and the CLI flags, and exit. The "+
+			"secrets are masked.")
+	flags.BoolVar(&opts.prunePlan, "prune-plan", opts.prunePlan,
+		"Print, as JSON on the standard output, the destination references "+
+			"a mirror\nwould prune with the hashes they point to, and exit "+
+			"without changing the\ndestination.")
+	flags.StringVar(&opts.logOutput, "log-output", opts.logOutput,
+		"The stream of the logs: 'stderr' (default) or 'stdout'.")
+	flags.StringVar(&opts.resultOutput, "result-output", opts.resultOutput,
+		"Print the mirror result as JSON to the given stream: 'stdout', "+
+			"'stderr' or\n'fd:N' (e.g. 'fd:3') for an open file descriptor.")
+	flags.StringVar(&conf.Source.URL, "source-repository", conf.Source.URL,
 		"The source repository for the mirroring operation.\nCan also be "+
 			"set via environment variables.")
-	flags.StringVar(&dstRepo, "destination-repository", "",
+	flags.StringVar(&conf.Destination.URL, "destination-repository",
+		conf.Destination.URL,
 		"The destination repository for the mirroring operation.\nCan also "+
 			"be set via environment variables.")
-	flags.StringVar(&knownHostsPath, "ssh-known-hosts-path", "",
+	flags.StringVar(&conf.Destination.SSH.KnownHostsPath, "ssh-known-hosts-path",
+		conf.Destination.SSH.KnownHostsPath,
 		"Defines the path to the 'known_hosts' file.\nThis is an alternative to "+
 			"providing the host public keys via the\n'GMM_SSH_KNOWN_HOSTS' "+
 			"environment variable.")
-	flags.BoolVar(&debug, "debug", false, "Run this tool in debug mode.")
+	flags.StringVar(&conf.Destination.SSH.KeyDir, "ssh-key-dir",
+		conf.Destination.SSH.KeyDir,
+		"Defines a directory with per host SSH private keys named as "+
+			"'<host>.pem'\n(e.g. 'github.com.pem'). Used when "+
+			"'GMM_SSH_PRIVATE_KEY' is not set.")
+	flags.BoolVar(&conf.Destination.SSH.ScanHostKeys, "ssh-scan-host-keys",
+		conf.Destination.SSH.ScanHostKeys,
+		"Fetch the host public key from the destination host, like "+
+			"'ssh-keyscan', when no\nhost public keys are provided. The key is "+
+			"trusted on first use.")
+	flags.StringVar(&conf.Destination.SSH.HostKeyFingerprint,
+		"ssh-host-key-fingerprint", conf.Destination.SSH.HostKeyFingerprint,
+		"The expected 'SHA256:...' fingerprint of the scanned host public key.")
+	flags.StringVar(&conf.Destination.SSH.JumpHost, "ssh-jump-host",
+		conf.Destination.SSH.JumpHost,
+		"A 'user@host[:port]' SSH jump host to connect to the destination "+
+			"through, like\nthe OpenSSH ProxyJump option.")
+	flags.StringVar(&conf.Destination.SSH.Command, "ssh-command",
+		conf.Destination.SSH.Command,
+		"An external SSH command (e.g. 'ssh') used for the destination "+
+			"instead of the\nbuilt-in SSH client, like GIT_SSH_COMMAND.")
+	flags.StringVar(&conf.Destination.HTTP.Username, "destination-http-username",
+		conf.Destination.HTTP.Username,
+		"The username used for HTTP(S) authentication to the destination.\n"+
+			"See 'GMM_DST_HTTP_PASSWORD'.")
+	flags.StringVar(&conf.Source.SSH.KnownHostsPath,
+		"source-ssh-known-hosts-path", conf.Source.SSH.KnownHostsPath,
+		"Same as '-ssh-known-hosts-path' but for the source repository.")
+	flags.StringVar(&conf.Source.SSH.KeyDir, "source-ssh-key-dir",
+		conf.Source.SSH.KeyDir,
+		"Same as '-ssh-key-dir' but for the source repository. Used when\n"+
+			"'GMM_SRC_SSH_PRIVATE_KEY' is not set.")
+	flags.BoolVar(&conf.Source.SSH.ScanHostKeys, "source-ssh-scan-host-keys",
+		conf.Source.SSH.ScanHostKeys,
+		"Same as '-ssh-scan-host-keys' but for the source repository.")
+	flags.StringVar(&conf.Source.SSH.HostKeyFingerprint,
+		"source-ssh-host-key-fingerprint", conf.Source.SSH.HostKeyFingerprint,
+		"Same as '-ssh-host-key-fingerprint' but for the source repository.")
+	flags.StringVar(&conf.Source.SSH.JumpHost, "source-ssh-jump-host",
+		conf.Source.SSH.JumpHost,
+		"Same as '-ssh-jump-host' but for the source repository.")
+	flags.StringVar(&conf.Source.SSH.Command, "source-ssh-command",
+		conf.Source.SSH.Command,
+		"Same as '-ssh-command' but for the source repository.")
+	flags.StringVar(&conf.Source.HTTP.Username, "source-http-username",
+		conf.Source.HTTP.Username,
+		"The username used for HTTP(S) authentication to the source.\nSee "+
+			"'GMM_SRC_HTTP_PASSWORD'.")
+	flags.BoolVar(&conf.InitDestination, "init-destination", conf.InitDestination,
+		"Initialise a bare repository at the destination, when it is a local "+
+			"path,\nunless it already is a repository.")
+	flags.BoolVar(&conf.RepackDestination, "repack-destination",
+		conf.RepackDestination,
+		"Repack the destination, when it is a local path, after a mirror that "+
+			"changed it.")
+	flags.BoolVar(&conf.WriteCommitGraph, "write-commit-graph", conf.WriteCommitGraph,
+		"Write a commit-graph file in the destination, when it is a local "+
+			"path, after a\nmirror that changed it.")
+	flags.StringVar(&conf.ObjectFormat, "object-format", conf.ObjectFormat,
+		"The object format of the repositories. Only 'sha1' (default) is "+
+			"supported, SHA-256\nrepositories are rejected.")
+	flags.StringVar(&conf.StagingBranch, "staging-branch", conf.StagingBranch,
+		"The initial branch of the staging repository, e.g. the source default "+
+			"branch\n(default 'master').")
+	flags.StringVar(&conf.ObjectCache, "object-cache", conf.ObjectCache,
+		"Path to a local bare repository, created if needed, shared by the "+
+			"mirrors as\na cache of the fetched objects. Only the objects "+
+			"it doesn't have are fetched.")
+	flags.StringVar(&conf.SymbolicRefs, "symbolic-refs", conf.SymbolicRefs,
+		"How the source symbolic references other than HEAD are mirrored: "+
+			"'resolve',\n'skip' or 'recreate' (local destination only).")
+	flags.StringVar(&conf.SanitizeRefNames, "sanitize-ref-names", conf.SanitizeRefNames,
+		"How the source references with an invalid name are mirrored: "+
+			"'drop' or\n'rename' (see '-ref-name-replacement').")
+	flags.StringVar(&conf.RefNameReplacement, "ref-name-replacement",
+		conf.RefNameReplacement,
+		"The replacement of the invalid characters and sequences of the "+
+			"renamed\nreference names (default '_').")
+	flags.IntVar(&conf.Retries, "retries", conf.Retries,
+		"The number of times a failed push (including the prune push) is "+
+			"retried.")
+	flags.DurationVar(&conf.RetryBackoff, "retry-backoff", conf.RetryBackoff,
+		"The delay between push retries (default 5s).")
+	flags.DurationVar(&conf.RetryMaxBackoff, "retry-max-backoff", conf.RetryMaxBackoff,
+		"When set, the push retry delay doubles with each attempt, up to this "+
+			"delay, with a\nrandom jitter.")
+	flags.Var((*stringList)(&conf.AllowedDstHosts), "allowed-destination-hosts",
+		"Comma-separated `list` of hosts the destination repository is allowed "+
+			"to be on.\nMirroring to any other host fails.")
+	flags.BoolVar(&conf.VerifyFetch, "verify-fetch", conf.VerifyFetch,
+		"Check that all the fetched objects are present before pushing.")
+	flags.StringVar(&conf.PreMirrorCheck, "pre-mirror-check", conf.PreMirrorCheck,
+		"A shell command run in the staging repository before pushing (e.g. "+
+			"a secret\nscanner). The mirror is aborted when it fails.")
+	flags.BoolVar(&conf.PostCloneVerify, "post-clone-verify",
+		conf.PostCloneVerify,
+		"Clone the destination after mirroring and check that the pushed "+
+			"references\nresolve to the mirrored hashes.")
+	flags.BoolVar(&conf.SkipPruneOnPartialFetch, "skip-prune-on-partial-fetch",
+		conf.SkipPruneOnPartialFetch,
+		"Check that the source fetch is complete and skip the destination "+
+			"prune when\nit isn't.")
+	flags.BoolVar(&conf.SkipUnreadableRefs, "skip-unreadable-refs",
+		conf.SkipUnreadableRefs,
+		"Fetch the source references one by one when the source fetch fails, "+
+			"skipping\nthe unreadable ones. The destination is not pruned "+
+			"when references are\nskipped.")
+	flags.StringVar(&conf.DstRefsCache, "destination-refs-cache",
+		conf.DstRefsCache,
+		"The path of a file caching the destination references between runs. "+
+			"The\ndestination is only listed again after it was changed by a "+
+			"mirror.")
+	flags.IntVar(&conf.PackWindow, "pack-window", conf.PackWindow,
+		"The delta compression window of the pushed packs (default 10).\n"+
+			"Larger values use more CPU to reduce bandwidth, a negative value "+
+			"disables\ndelta compression.")
+	flags.BoolVar(&conf.FastForwardOnly, "fast-forward-only",
+		conf.FastForwardOnly,
+		"Only push fast-forward updates, except for the references matching\n"+
+			"'-force-refs'. The other updates are reported and skipped.")
+	flags.Var((*stringList)(&conf.ForceRefs), "force-refs",
+		"Comma-separated `list` of reference glob patterns (e.g.\n"+
+			"'refs/heads/wip/*') force pushed in '-fast-forward-only' mode.")
+	flags.BoolVar(&conf.ReplacePlaceholder, "replace-placeholder",
+		conf.ReplacePlaceholder,
+		"In '-fast-forward-only' mode, replace a destination only having the "+
+			"initial\ncommit of an unrelated history (e.g. a README added "+
+			"when creating it).")
+	flags.BoolVar(&conf.DetectRewrites, "detect-rewrites", conf.DetectRewrites,
+		"Report the references the mirror would update to a commit that is "+
+			"not a\ndescendant of the destination one (rewritten history).")
+	flags.BoolVar(&conf.FailOnRewrites, "fail-on-rewrites", conf.FailOnRewrites,
+		"Fail, before pushing, when the mirror would rewrite the history of "+
+			"a\ndestination reference (see '-detect-rewrites').")
+	flags.BoolVar(&conf.ConfirmPrune, "confirm-prune", conf.ConfirmPrune,
+		"Ask for a confirmation on the standard input before pruning more "+
+			"than\n'-confirm-prune-threshold' references from the destination.")
+	flags.IntVar(&conf.ConfirmPruneThreshold, "confirm-prune-threshold",
+		conf.ConfirmPruneThreshold,
+		"The number of pruned references not requiring a confirmation with\n"+
+			"'-confirm-prune'.")
+	flags.BoolVar(&conf.AssumeYes, "yes", conf.AssumeYes,
+		"Confirm the prune without asking, required by '-confirm-prune' in "+
+			"non-interactive\nruns.")
+	flags.Var((*stringList)(&conf.DeleteRefs), "delete-refs",
+		"Comma-separated `list` of reference glob patterns that are never "+
+			"pushed and\nalways deleted from the destination.")
+	flags.Var((*stringList)(&conf.PreserveRefs), "preserve-refs",
+		"Comma-separated `list` of reference glob patterns (e.g.\n"+
+			"'refs/heads/archive/*') never pruned from the destination.")
+	flags.Var((*stringList)(&conf.FetchRefSpecs), "fetch-refspecs",
+		"Comma-separated `list` of refspecs used to fetch the source.\n"+
+			"Defaults to 'refs/*:refs/*'.")
+	flags.Var((*stringList)(&conf.PushRefSpecs), "push-refspecs",
+		"Comma-separated `list` of refspecs used to push to the destination.\n"+
+			"Defaults to 'refs/*:refs/*'. Not used in '-fast-forward-only' mode.")
+	flags.Var(&repeatedList{values: &conf.IncludeRefs}, "ref",
+		"A reference (e.g. 'refs/heads/main') to mirror, restricting the "+
+			"fetch, the push\nand the prune to the given references. Can be "+
+			"repeated. Can't be combined\nwith '-fetch-refspecs' and "+
+			"'-push-refspecs'.")
+	flags.StringVar(&conf.IncludeRefsFile, "ref-file", conf.IncludeRefsFile,
+		"Path to a file with one reference to mirror per line, added to the "+
+			"'-ref'\nreferences. Empty lines and lines starting with '#' are "+
+			"ignored.")
+	flags.Var((*stringList)(&conf.ExcludeRefs), "exclude-refs",
+		"Comma-separated `list` of glob patterns (e.g. 'refs/heads/wip-*') of "+
+			"references\nthat are not mirrored.")
+	flags.StringVar(&conf.ExcludeRefsFile, "exclude-refs-file", conf.ExcludeRefsFile,
+		"Path to a file with one excluded references pattern per line, added "+
+			"to the\n'-exclude-refs' patterns. Empty lines and lines starting "+
+			"with '#' are ignored.")
+	flags.StringVar(&conf.BranchAllowlistFile, "branch-allowlist-file",
+		conf.BranchAllowlistFile,
+		"Path to a file with one branch per line. Only the listed source "+
+			"branches are\nmirrored and the other branches are pruned from "+
+			"the destination.")
+	flags.BoolVar(&conf.IndividualPush, "individual-push", conf.IndividualPush,
+		"Push each reference in its own push, logging the progress per "+
+			"reference.\nSlower but the push errors are attributable to a "+
+			"reference and an\ninterrupted push is resumed by the next run.")
+	flags.Int64Var(&conf.MaxPackSize, "max-pack-size", conf.MaxPackSize,
+		"Split the push in several pushes each sending at most about the "+
+			"given number\nof bytes of objects, for destinations rejecting "+
+			"larger packs (default\nunlimited).")
+	flags.Var(&groupList{values: &conf.RefGroups}, "ref-group",
+		"Comma-separated `list` of reference glob patterns pushed atomically "+
+			"together\n(e.g. 'refs/heads/release,refs/tags/v*'). Can be "+
+			"repeated for several groups.")
+	flags.Var((*stringList)(&conf.OnlyReachableFrom), "only-reachable-from",
+		"Comma-separated `list` of references (e.g. 'refs/heads/main'). Only "+
+			"the\nreferences reachable from them are mirrored.")
+	flags.Var((*stringList)(&conf.ExcludeAuthorDomains), "exclude-author-domains",
+		"Comma-separated `list` of email domains. The references whose tip "+
+			"commit\nauthor email is in one of them (or their subdomains) are "+
+			"not mirrored.")
+	flags.BoolVar(&conf.ExcludeEmptyRefs, "exclude-empty-refs", conf.ExcludeEmptyRefs,
+		"Don't mirror the references whose tip commit is empty (an empty "+
+			"tree or the\nsame tree as its parent).")
+	flags.StringVar(&conf.PlaceholderPattern, "placeholder-pattern",
+		conf.PlaceholderPattern,
+		"Regular expression (e.g. '^Initial commit'). The references whose "+
+			"tip commit\nmessage matches it are not mirrored.")
+	flags.IntVar(&conf.TagRetention, "tag-retention", conf.TagRetention,
+		"Only mirror the given number of most recent tags. The older tags "+
+			"are pruned\nfrom the destination.")
+	flags.StringVar(&conf.TagSemverRange, "tag-semver-range", conf.TagSemverRange,
+		"Only mirror the tags named after a semantic version in the given "+
+			"range (e.g.\n'>=2.0.0' or '^1.4.0 || ^2.0.0').")
+	flags.BoolVar(&conf.KeepNonSemverTags, "keep-non-semver-tags",
+		conf.KeepNonSemverTags,
+		"With '-tag-semver-range', keep the tags not named after a semantic "+
+			"version.")
+	flags.IntVar(&conf.MaxDstRefs, "max-destination-refs", conf.MaxDstRefs,
+		"Abort before pushing when the destination would have more than the "+
+			"given\nnumber of references (default unlimited).")
+	flags.IntVar(&conf.MinRefs, "min-refs", conf.MinRefs,
+		"Abort before pushing when the source has fewer than the given number "+
+			"of\nreferences, after the filters (default no minimum).")
+	flags.BoolVar(&conf.OnlyOnNewTag, "only-on-new-tag", conf.OnlyOnNewTag,
+		"Only mirror when the source has tags that are not on the destination.")
+	flags.DurationVar(&conf.DialTimeout, "dial-timeout", conf.DialTimeout,
+		"The timeout of the connection establishment to the remotes, "+
+			"including the\nhost name resolution. The SSH one only applies "+
+			"with a configured SSH key.")
+	flags.DurationVar(&conf.ListTimeout, "list-timeout", conf.ListTimeout,
+		"The timeout of listing the references of a remote, separate from "+
+			"the fetch\nand the push transfers (default 10s).")
+	flags.IntVar(&conf.ListRetries, "list-retries", conf.ListRetries,
+		"The number of times a failed listing of the destination references "+
+			"is\nretried, separately from '-retries'.")
+	flags.IntVar(&conf.MaxConnsPerHost, "max-conns-per-host",
+		conf.MaxConnsPerHost,
+		"The maximum number of simultaneous HTTP(S) connections to a single "+
+			"host\n(default unlimited).")
+	flags.Int64Var(&conf.RateLimit, "rate-limit", conf.RateLimit,
+		"Cap the transfer rate of the fetch and the push to the given number "+
+			"of\nbytes per second (default unlimited).")
+	flags.StringVar(&conf.LocalAddr, "local-addr", conf.LocalAddr,
+		"The local IP address to connect to the remotes from.")
+	flags.StringVar(&conf.UserAgent, "user-agent", conf.UserAgent,
+		"The User-Agent header used for HTTP(S) remotes.")
+	flags.BoolVar(&conf.FailOnRedirect, "fail-on-redirect", conf.FailOnRedirect,
+		"Fail the HTTP(S) requests that are redirected instead of following "+
+			"the\nredirects.")
+	flags.StringVar(&conf.ClientCert, "client-cert", conf.ClientCert,
+		"Path to a PEM encoded TLS client certificate used for HTTPS "+
+			"remotes\nrequiring mutual TLS. Requires '-client-key'.")
+	flags.StringVar(&conf.ClientKey, "client-key", conf.ClientKey,
+		"Path to the PEM encoded key of '-client-cert'.")
+	flags.StringVar(&conf.CAFile, "ca-file", conf.CAFile,
+		"Path to a PEM encoded CA bundle trusted for HTTPS remotes in addition "+
+			"to the\nsystem ones. This is an alternative to the "+
+			"'GMM_CA_CONTENT' environment\nvariable.")
+	flags.StringVar(&conf.SrcCertPin, "source-cert-pin", conf.SrcCertPin,
+		"The hexadecimal SHA-256 fingerprint of the TLS certificate the "+
+			"HTTPS source\nserver must present.")
+	flags.BoolVar(&conf.SyncMetadata, "sync-metadata", conf.SyncMetadata,
+		"Copy the source repository description and default branch to the\n"+
+			"destination using the GitHub or GitLab API. See "+
+			"'GMM_GITHUB_TOKEN' and\n'GMM_GITLAB_TOKEN'.")
+	flags.BoolVar(&conf.CreateDefaultBranch, "create-default-branch",
+		conf.CreateDefaultBranch,
+		"With '-sync-metadata', push the source default branch when it is "+
+			"missing from\nthe destination instead of failing.")
+	flags.BoolVar(&conf.MirrorWiki, "mirror-wiki", conf.MirrorWiki,
+		"Also mirror the source wiki repository (e.g. 'project.wiki.git') to "+
+			"the\ndestination one. Skipped when the source has no wiki.")
+	flags.BoolVar(&conf.ProvenanceTag, "provenance-tag", conf.ProvenanceTag,
+		"Push a 'refs/mirror/synced/<timestamp>' tag recording the mirror "+
+			"when the\ndestination was changed. See 'GMM_PROVENANCE_SIGN_KEY'.")
+	flags.StringVar(&conf.Vault.Address, "vault-address", conf.Vault.Address,
+		"The HashiCorp Vault server URL. When set, the secrets are read from "+
+			"the\n'-vault-secret-path' Vault secret, keyed by the names of the "+
+			"environment\nvariables (e.g. 'GMM_SSH_PRIVATE_KEY').")
+	flags.StringVar(&conf.Vault.SecretPath, "vault-secret-path",
+		conf.Vault.SecretPath,
+		"The API path of the Vault secret, after '/v1/' (e.g. "+
+			"'secret/data/mirror').")
+	flags.StringVar(&conf.Vault.TokenPath, "vault-token-path",
+		conf.Vault.TokenPath,
+		"Path to the file with the Vault token (e.g. a Vault agent sink).")
+	flags.StringVar(&conf.Vault.RoleID, "vault-role-id", conf.Vault.RoleID,
+		"The AppRole role ID used to log in to Vault. See "+
+			"'-vault-secret-id-path'.")
+	flags.StringVar(&conf.Vault.SecretIDPath, "vault-secret-id-path",
+		conf.Vault.SecretIDPath,
+		"Path to the file with the AppRole secret ID used to log in to Vault.")
+	flags.BoolVar(&conf.FailOnNoChange, "fail-on-no-change", conf.FailOnNoChange,
+		fmt.Sprintf("Exit with the %d code when nothing was pushed or pruned.",
+			noChangeExitCode))
+	flags.BoolVar(&conf.DryRun, "dry-run", conf.DryRun,
+		"Log what would be pushed and pruned, and check the destination write "+
+			"access,\nwithout changing the destination.")
+	flags.StringVar(&conf.PlanFile, "plan-file", conf.PlanFile,
+		"Path to the file a dry run writes the refspecs it would push and "+
+			"delete to,\nfor reviewing them before applying them with "+
+			"'-apply-plan'.")
+	flags.StringVar(&conf.ApplyPlan, "apply-plan", conf.ApplyPlan,
+		"Path to a plan file written by a dry run. Only the refspecs of the "+
+			"plan are\npushed and deleted, if the source didn't change since.")
+	flags.StringVar(&conf.RunID, "run-id", conf.RunID,
+		"The ID of the run included in every log message (default a random "+
+			"UUID).")
+	flags.StringVar(&conf.HistoryFile, "history-file", conf.HistoryFile,
+		"Path to the file each mirror run is appended to, as a line of JSON.")
+	flags.StringVar(&conf.Changelog, "changelog", conf.Changelog,
+		"Path to the Markdown file replaced after each mirror with the "+
+			"destination\nreferences added, updated and removed.")
+	flags.StringVar(&conf.StatusFile, "status-file", conf.StatusFile,
+		"Path to the file replaced after each mirror run with its JSON "+
+			"status.")
+	flags.StringVar(&conf.OTLPEndpoint, "otlp-endpoint", conf.OTLPEndpoint,
+		"The OTLP/HTTP endpoint (e.g. 'http://localhost:4318') each mirror "+
+			"run is\ntraced to.")
+	flags.StringVar(&conf.LockFile, "lock-file", conf.LockFile,
+		"Path to a file locked during the mirror run so that the runs "+
+			"sharing it don't\noverlap. See '-lock-timeout'.")
+	flags.DurationVar(&conf.LockTimeout, "lock-timeout", conf.LockTimeout,
+		"How long to wait for a '-lock-file' held by another run before "+
+			"failing\n(default not waiting).")
+	flags.BoolVar(&conf.Debug, "debug", conf.Debug, "Run this tool in debug mode.")
+
+	return flags
+}
+
+// parseArgs returns a configuration structure initialised from parsing the
+// 'arguments' string slice argument. When a configuration file is provided,
+// it is loaded first and the arguments are applied on top of it.
+func parseArgs(progName string, arguments []string) (*mirror.Config, cliOptions, string, error) {
+	var conf mirror.Config
+
+	var opts cliOptions
 
+	var flagsOutput bytes.Buffer
+
+	flags := newFlagSet(progName, &conf, &opts, &flagsOutput)
 	if err := flags.Parse(arguments); err != nil {
-		return nil, flagsOutput.String(), err
+		return nil, opts, flagsOutput.String(), err
+	}
+
+	if len(opts.configPath) != 0 {
+		fileConf, err := loadConfigFile(opts.configPath)
+		if err != nil {
+			return nil, opts, flagsOutput.String(), err
+		}
+
+		flags = newFlagSet(progName, fileConf, &opts, &flagsOutput)
+		if err := flags.Parse(arguments); err != nil {
+			return nil, opts, flagsOutput.String(), err
+		}
+
+		conf = *fileConf
 	}
 
-	return &mirror.Config{
-		SrcRepo: srcRepo,
-		DstRepo: dstRepo,
-		SSH: mirror.SSHConf{
-			KnownHostsPath: knownHostsPath,
-		},
-		Debug: debug,
-	}, flagsOutput.String(), nil
+	return &conf, opts, flagsOutput.String(), nil
 }
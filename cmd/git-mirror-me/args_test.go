@@ -5,7 +5,11 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	mirror "github.com/agherzan/git-mirror-me"
 	"github.com/google/go-cmp/cmp"
@@ -16,43 +20,925 @@ func TestParseArgs(t *testing.T) {
 	t.Parallel()
 	{
 		// Test passing -source-repository.
-		config, _, err := parseArgs("test", []string{"-source-repository=src"})
+		config, _, _, err := parseArgs("test", []string{"-source-repository=src"})
 		if err != nil {
 			t.Fatalf("setting src failed: %s", err)
 		}
-		if !cmp.Equal(*config, mirror.Config{SrcRepo: "src"}) {
+		if !cmp.Equal(*config, mirror.Config{
+			Source: mirror.RepoConf{URL: "src"},
+		}) {
 			t.Fatalf("unexpected src value: %s", config.Pretty())
 		}
 	}
 	{
 		// Test passing -destination-repository.
-		config, _, err := parseArgs("test",
+		config, _, _, err := parseArgs("test",
 			[]string{"-destination-repository=dst"})
 		if err != nil {
 			t.Fatalf("setting dst failed: %s", err)
 		}
-		if !cmp.Equal(*config, mirror.Config{DstRepo: "dst"}) {
+		if !cmp.Equal(*config, mirror.Config{
+			Destination: mirror.RepoConf{URL: "dst"},
+		}) {
 			t.Fatalf("unexpected dst value: %s", config.Pretty())
 		}
 	}
 	{
 		// Test passing -ssh-known-hosts-path.
-		config, _, err := parseArgs("test",
+		config, _, _, err := parseArgs("test",
 			[]string{"-ssh-known-hosts-path=file"})
 		if err != nil {
 			t.Fatalf("setting host key failed: %s", err)
 		}
 		if !cmp.Equal(*config, mirror.Config{
-			SSH: mirror.SSHConf{
-				KnownHostsPath: "file",
+			Destination: mirror.RepoConf{
+				SSH: mirror.SSHConf{
+					KnownHostsPath: "file",
+				},
 			},
 		}) {
 			t.Fatalf("unexpected host key value: %s", config.Pretty())
 		}
 	}
+	{
+		// Test passing -ssh-key-dir.
+		config, _, _, err := parseArgs("test", []string{"-ssh-key-dir=dir"})
+		if err != nil {
+			t.Fatalf("setting key dir failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Destination: mirror.RepoConf{
+				SSH: mirror.SSHConf{
+					KeyDir: "dir",
+				},
+			},
+		}) {
+			t.Fatalf("unexpected key dir value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing the host key scanning options.
+		config, _, _, err := parseArgs("test", []string{
+			"-ssh-scan-host-keys", "-ssh-host-key-fingerprint=SHA256:dst",
+			"-source-ssh-scan-host-keys",
+			"-source-ssh-host-key-fingerprint=SHA256:src",
+		})
+		if err != nil {
+			t.Fatalf("setting host key scanning failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source: mirror.RepoConf{
+				SSH: mirror.SSHConf{
+					ScanHostKeys:       true,
+					HostKeyFingerprint: "SHA256:src",
+				},
+			},
+			Destination: mirror.RepoConf{
+				SSH: mirror.SSHConf{
+					ScanHostKeys:       true,
+					HostKeyFingerprint: "SHA256:dst",
+				},
+			},
+		}) {
+			t.Fatalf("unexpected host key scanning value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing the jump hosts.
+		config, _, _, err := parseArgs("test", []string{
+			"-ssh-jump-host=dst@bastion", "-source-ssh-jump-host=src@bastion:2222",
+		})
+		if err != nil {
+			t.Fatalf("setting jump hosts failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source: mirror.RepoConf{
+				SSH: mirror.SSHConf{JumpHost: "src@bastion:2222"},
+			},
+			Destination: mirror.RepoConf{
+				SSH: mirror.SSHConf{JumpHost: "dst@bastion"},
+			},
+		}) {
+			t.Fatalf("unexpected jump hosts value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing the SSH commands.
+		config, _, _, err := parseArgs("test", []string{
+			"-ssh-command=ssh -F dst_config", "-source-ssh-command=ssh",
+		})
+		if err != nil {
+			t.Fatalf("setting SSH commands failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source: mirror.RepoConf{
+				SSH: mirror.SSHConf{Command: "ssh"},
+			},
+			Destination: mirror.RepoConf{
+				SSH: mirror.SSHConf{Command: "ssh -F dst_config"},
+			},
+		}) {
+			t.Fatalf("unexpected SSH commands value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing the source authentication and the HTTP usernames.
+		config, _, _, err := parseArgs("test", []string{
+			"-source-ssh-known-hosts-path=file", "-source-ssh-key-dir=dir",
+			"-source-http-username=srcuser",
+			"-destination-http-username=dstuser",
+		})
+		if err != nil {
+			t.Fatalf("setting the authentication failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source: mirror.RepoConf{
+				SSH: mirror.SSHConf{
+					KnownHostsPath: "file",
+					KeyDir:         "dir",
+				},
+				HTTP: mirror.HTTPAuthConf{Username: "srcuser"},
+			},
+			Destination: mirror.RepoConf{
+				HTTP: mirror.HTTPAuthConf{Username: "dstuser"},
+			},
+		}) {
+			t.Fatalf("unexpected authentication value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -init-destination.
+		config, _, _, err := parseArgs("test", []string{"-init-destination"})
+		if err != nil {
+			t.Fatalf("setting init destination failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{InitDestination: true}) {
+			t.Fatalf("unexpected init destination value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -repack-destination.
+		config, _, _, err := parseArgs("test", []string{"-repack-destination"})
+		if err != nil {
+			t.Fatalf("setting repack destination failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{RepackDestination: true}) {
+			t.Fatalf("unexpected repack destination value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -write-commit-graph.
+		config, _, _, err := parseArgs("test", []string{"-write-commit-graph"})
+		if err != nil {
+			t.Fatalf("setting write commit-graph failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{WriteCommitGraph: true}) {
+			t.Fatalf("unexpected write commit-graph value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -object-format.
+		config, _, _, err := parseArgs("test", []string{"-object-format=sha1"})
+		if err != nil {
+			t.Fatalf("setting object format failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ObjectFormat: "sha1"}) {
+			t.Fatalf("unexpected object format value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -staging-branch.
+		config, _, _, err := parseArgs("test", []string{"-staging-branch=main"})
+		if err != nil {
+			t.Fatalf("setting staging branch failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{StagingBranch: "main"}) {
+			t.Fatalf("unexpected staging branch value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -object-cache.
+		config, _, _, err := parseArgs("test", []string{"-object-cache=/var/cache/gmm"})
+		if err != nil {
+			t.Fatalf("setting object cache failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ObjectCache: "/var/cache/gmm"}) {
+			t.Fatalf("unexpected object cache value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -symbolic-refs.
+		config, _, _, err := parseArgs("test", []string{"-symbolic-refs=skip"})
+		if err != nil {
+			t.Fatalf("setting symbolic refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{SymbolicRefs: "skip"}) {
+			t.Fatalf("unexpected symbolic refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -sanitize-ref-names and -ref-name-replacement.
+		config, _, _, err := parseArgs("test", []string{
+			"-sanitize-ref-names=rename", "-ref-name-replacement=-",
+		})
+		if err != nil {
+			t.Fatalf("setting ref names sanitization failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			SanitizeRefNames:   "rename",
+			RefNameReplacement: "-",
+		}) {
+			t.Fatalf("unexpected ref names sanitization value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -retries, -retry-backoff and -retry-max-backoff.
+		config, _, _, err := parseArgs("test",
+			[]string{"-retries=3", "-retry-backoff=1s", "-retry-max-backoff=1m"})
+		if err != nil {
+			t.Fatalf("setting retries failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Retries:         3,
+			RetryBackoff:    time.Second,
+			RetryMaxBackoff: time.Minute,
+		}) {
+			t.Fatalf("unexpected retries value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -allowed-destination-hosts.
+		config, _, _, err := parseArgs("test",
+			[]string{"-allowed-destination-hosts=github.com,gitlab.com"})
+		if err != nil {
+			t.Fatalf("setting allowed destination hosts failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			AllowedDstHosts: []string{"github.com", "gitlab.com"},
+		}) {
+			t.Fatalf("unexpected allowed destination hosts value: %s",
+				config.Pretty())
+		}
+	}
+	{
+		// Test passing -verify-fetch.
+		config, _, _, err := parseArgs("test", []string{"-verify-fetch"})
+		if err != nil {
+			t.Fatalf("setting verify fetch failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{VerifyFetch: true}) {
+			t.Fatalf("unexpected verify fetch value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -pre-mirror-check.
+		config, _, _, err := parseArgs("test",
+			[]string{"-pre-mirror-check=gitleaks detect"})
+		if err != nil {
+			t.Fatalf("setting pre-mirror check failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{PreMirrorCheck: "gitleaks detect"}) {
+			t.Fatalf("unexpected pre-mirror check value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -post-clone-verify.
+		config, _, _, err := parseArgs("test", []string{"-post-clone-verify"})
+		if err != nil {
+			t.Fatalf("setting post clone verify failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{PostCloneVerify: true}) {
+			t.Fatalf("unexpected post clone verify value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -skip-prune-on-partial-fetch.
+		config, _, _, err := parseArgs("test",
+			[]string{"-skip-prune-on-partial-fetch"})
+		if err != nil {
+			t.Fatalf("setting skip prune on partial fetch failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{SkipPruneOnPartialFetch: true}) {
+			t.Fatalf("unexpected skip prune on partial fetch value: %s",
+				config.Pretty())
+		}
+	}
+	{
+		// Test passing -skip-unreadable-refs.
+		config, _, _, err := parseArgs("test", []string{"-skip-unreadable-refs"})
+		if err != nil {
+			t.Fatalf("setting skip unreadable refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{SkipUnreadableRefs: true}) {
+			t.Fatalf("unexpected skip unreadable refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -destination-refs-cache.
+		config, _, _, err := parseArgs("test",
+			[]string{"-destination-refs-cache=file"})
+		if err != nil {
+			t.Fatalf("setting destination refs cache failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{DstRefsCache: "file"}) {
+			t.Fatalf("unexpected destination refs cache value: %s",
+				config.Pretty())
+		}
+	}
+	{
+		// Test passing -pack-window.
+		config, _, _, err := parseArgs("test", []string{"-pack-window=-1"})
+		if err != nil {
+			t.Fatalf("setting pack window failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{PackWindow: -1}) {
+			t.Fatalf("unexpected pack window value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -fast-forward-only and -force-refs.
+		config, _, _, err := parseArgs("test", []string{
+			"-fast-forward-only", "-force-refs=refs/heads/a,refs/tags/*",
+		})
+		if err != nil {
+			t.Fatalf("setting fast-forward only failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			FastForwardOnly: true,
+			ForceRefs:       []string{"refs/heads/a", "refs/tags/*"},
+		}) {
+			t.Fatalf("unexpected fast-forward only value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -replace-placeholder.
+		config, _, _, err := parseArgs("test", []string{"-replace-placeholder"})
+		if err != nil {
+			t.Fatalf("setting replace placeholder failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ReplacePlaceholder: true}) {
+			t.Fatalf("unexpected replace placeholder value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -detect-rewrites and -fail-on-rewrites.
+		config, _, _, err := parseArgs("test", []string{
+			"-detect-rewrites", "-fail-on-rewrites",
+		})
+		if err != nil {
+			t.Fatalf("setting rewrite detection failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{DetectRewrites: true, FailOnRewrites: true}) {
+			t.Fatalf("unexpected rewrite detection values: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -confirm-prune, -confirm-prune-threshold and -yes.
+		config, _, _, err := parseArgs("test", []string{
+			"-confirm-prune", "-confirm-prune-threshold=5", "-yes",
+		})
+		if err != nil {
+			t.Fatalf("setting prune confirmation failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			ConfirmPrune:          true,
+			ConfirmPruneThreshold: 5,
+			AssumeYes:             true,
+		}) {
+			t.Fatalf("unexpected prune confirmation value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -preserve-refs.
+		config, _, _, err := parseArgs("test",
+			[]string{"-preserve-refs=refs/heads/archive/*,refs/tags/keep"})
+		if err != nil {
+			t.Fatalf("setting preserve refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			PreserveRefs: []string{"refs/heads/archive/*", "refs/tags/keep"},
+		}) {
+			t.Fatalf("unexpected preserve refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -delete-refs.
+		config, _, _, err := parseArgs("test",
+			[]string{"-delete-refs=refs/heads/leaked,refs/tags/secret-*"})
+		if err != nil {
+			t.Fatalf("setting delete refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			DeleteRefs: []string{"refs/heads/leaked", "refs/tags/secret-*"},
+		}) {
+			t.Fatalf("unexpected delete refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -fetch-refspecs and -push-refspecs.
+		config, _, _, err := parseArgs("test", []string{
+			"-fetch-refspecs=refs/heads/*:refs/heads/*,refs/remotes/*:refs/remotes/*",
+			"-push-refspecs=refs/heads/*:refs/heads/mirror/*",
+		})
+		if err != nil {
+			t.Fatalf("setting refspecs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			FetchRefSpecs: []string{"refs/heads/*:refs/heads/*", "refs/remotes/*:refs/remotes/*"},
+			PushRefSpecs:  []string{"refs/heads/*:refs/heads/mirror/*"},
+		}) {
+			t.Fatalf("unexpected refspecs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -ref multiple times.
+		config, _, _, err := parseArgs("test", []string{
+			"-ref", "refs/heads/main", "-ref", "refs/tags/v1.0",
+		})
+		if err != nil {
+			t.Fatalf("setting refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			IncludeRefs: []string{"refs/heads/main", "refs/tags/v1.0"},
+		}) {
+			t.Fatalf("unexpected refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -ref-file, -exclude-refs and -exclude-refs-file.
+		config, _, _, err := parseArgs("test", []string{
+			"-ref-file=include", "-exclude-refs=refs/heads/wip-*,refs/tmp/*",
+			"-exclude-refs-file=exclude",
+		})
+		if err != nil {
+			t.Fatalf("setting refs files failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			IncludeRefsFile: "include",
+			ExcludeRefs:     []string{"refs/heads/wip-*", "refs/tmp/*"},
+			ExcludeRefsFile: "exclude",
+		}) {
+			t.Fatalf("unexpected refs files value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -branch-allowlist-file.
+		config, _, _, err := parseArgs("test", []string{
+			"-branch-allowlist-file=branches",
+		})
+		if err != nil {
+			t.Fatalf("setting branch allowlist file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{BranchAllowlistFile: "branches"}) {
+			t.Fatalf("unexpected branch allowlist file value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -otlp-endpoint.
+		config, _, _, err := parseArgs("test", []string{
+			"-otlp-endpoint=http://localhost:4318",
+		})
+		if err != nil {
+			t.Fatalf("setting OTLP endpoint failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{OTLPEndpoint: "http://localhost:4318"}) {
+			t.Fatalf("unexpected OTLP endpoint value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -individual-push.
+		config, _, _, err := parseArgs("test", []string{"-individual-push"})
+		if err != nil {
+			t.Fatalf("setting individual push failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{IndividualPush: true}) {
+			t.Fatalf("unexpected individual push value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -max-pack-size.
+		config, _, _, err := parseArgs("test", []string{"-max-pack-size", "1024"})
+		if err != nil {
+			t.Fatalf("setting maximum pack size failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{MaxPackSize: 1024}) {
+			t.Fatalf("unexpected maximum pack size value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -ref-group multiple times.
+		config, _, _, err := parseArgs("test", []string{
+			"-ref-group", "refs/heads/release,refs/tags/v*",
+			"-ref-group", "refs/heads/main",
+		})
+		if err != nil {
+			t.Fatalf("setting reference groups failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			RefGroups: [][]string{
+				{"refs/heads/release", "refs/tags/v*"},
+				{"refs/heads/main"},
+			},
+		}) {
+			t.Fatalf("unexpected reference groups value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -only-reachable-from.
+		config, _, _, err := parseArgs("test",
+			[]string{"-only-reachable-from=refs/heads/main"})
+		if err != nil {
+			t.Fatalf("setting only reachable from failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			OnlyReachableFrom: []string{"refs/heads/main"},
+		}) {
+			t.Fatalf("unexpected only reachable from value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -exclude-author-domains.
+		config, _, _, err := parseArgs("test",
+			[]string{"-exclude-author-domains=corp.example.com,example.org"})
+		if err != nil {
+			t.Fatalf("setting exclude author domains failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			ExcludeAuthorDomains: []string{"corp.example.com", "example.org"},
+		}) {
+			t.Fatalf("unexpected exclude author domains value: %s",
+				config.Pretty())
+		}
+	}
+	{
+		// Test passing -exclude-empty-refs and -placeholder-pattern.
+		config, _, _, err := parseArgs("test", []string{
+			"-exclude-empty-refs", "-placeholder-pattern=^Initial commit",
+		})
+		if err != nil {
+			t.Fatalf("setting placeholder filters failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			ExcludeEmptyRefs:   true,
+			PlaceholderPattern: "^Initial commit",
+		}) {
+			t.Fatalf("unexpected placeholder filters value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -tag-retention.
+		config, _, _, err := parseArgs("test", []string{"-tag-retention=5"})
+		if err != nil {
+			t.Fatalf("setting tag retention failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{TagRetention: 5}) {
+			t.Fatalf("unexpected tag retention value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -tag-semver-range and -keep-non-semver-tags.
+		config, _, _, err := parseArgs("test", []string{
+			"-tag-semver-range=>=2.0.0", "-keep-non-semver-tags",
+		})
+		if err != nil {
+			t.Fatalf("setting tag semver range failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			TagSemverRange:    ">=2.0.0",
+			KeepNonSemverTags: true,
+		}) {
+			t.Fatalf("unexpected tag semver range value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -max-destination-refs.
+		config, _, _, err := parseArgs("test", []string{"-max-destination-refs=100"})
+		if err != nil {
+			t.Fatalf("setting maximum destination refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{MaxDstRefs: 100}) {
+			t.Fatalf("unexpected maximum destination refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -min-refs.
+		config, _, _, err := parseArgs("test", []string{"-min-refs=10"})
+		if err != nil {
+			t.Fatalf("setting minimum refs failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{MinRefs: 10}) {
+			t.Fatalf("unexpected minimum refs value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -only-on-new-tag.
+		config, _, _, err := parseArgs("test", []string{"-only-on-new-tag"})
+		if err != nil {
+			t.Fatalf("setting only on new tag failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{OnlyOnNewTag: true}) {
+			t.Fatalf("unexpected only on new tag value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -dial-timeout.
+		config, _, _, err := parseArgs("test", []string{"-dial-timeout=10s"})
+		if err != nil {
+			t.Fatalf("setting dial timeout failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{DialTimeout: 10 * time.Second}) {
+			t.Fatalf("unexpected dial timeout value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -list-timeout.
+		config, _, _, err := parseArgs("test", []string{"-list-timeout=5s"})
+		if err != nil {
+			t.Fatalf("setting list timeout failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ListTimeout: 5 * time.Second}) {
+			t.Fatalf("unexpected list timeout value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -list-retries.
+		config, _, _, err := parseArgs("test", []string{"-list-retries=3"})
+		if err != nil {
+			t.Fatalf("setting list retries failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ListRetries: 3}) {
+			t.Fatalf("unexpected list retries value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -max-conns-per-host.
+		config, _, _, err := parseArgs("test", []string{"-max-conns-per-host=2"})
+		if err != nil {
+			t.Fatalf("setting maximum connections per host failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{MaxConnsPerHost: 2}) {
+			t.Fatalf("unexpected maximum connections per host value: %s",
+				config.Pretty())
+		}
+	}
+	{
+		// Test passing -rate-limit.
+		config, _, _, err := parseArgs("test", []string{"-rate-limit=1048576"})
+		if err != nil {
+			t.Fatalf("setting rate limit failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{RateLimit: 1048576}) {
+			t.Fatalf("unexpected rate limit value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -local-addr.
+		config, _, _, err := parseArgs("test", []string{"-local-addr=192.0.2.1"})
+		if err != nil {
+			t.Fatalf("setting local address failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{LocalAddr: "192.0.2.1"}) {
+			t.Fatalf("unexpected local address value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -user-agent.
+		config, _, _, err := parseArgs("test", []string{"-user-agent=ua"})
+		if err != nil {
+			t.Fatalf("setting user agent failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{UserAgent: "ua"}) {
+			t.Fatalf("unexpected user agent value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -fail-on-redirect.
+		config, _, _, err := parseArgs("test", []string{"-fail-on-redirect"})
+		if err != nil {
+			t.Fatalf("setting fail on redirect failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{FailOnRedirect: true}) {
+			t.Fatalf("unexpected fail on redirect value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -sync-metadata.
+		config, _, _, err := parseArgs("test", []string{"-sync-metadata"})
+		if err != nil {
+			t.Fatalf("setting sync metadata failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{SyncMetadata: true}) {
+			t.Fatalf("unexpected sync metadata value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -create-default-branch.
+		config, _, _, err := parseArgs("test", []string{"-create-default-branch"})
+		if err != nil {
+			t.Fatalf("setting create default branch failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{CreateDefaultBranch: true}) {
+			t.Fatalf("unexpected create default branch value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -mirror-wiki.
+		config, _, _, err := parseArgs("test", []string{"-mirror-wiki"})
+		if err != nil {
+			t.Fatalf("setting mirror wiki failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{MirrorWiki: true}) {
+			t.Fatalf("unexpected mirror wiki value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -provenance-tag.
+		config, _, _, err := parseArgs("test", []string{"-provenance-tag"})
+		if err != nil {
+			t.Fatalf("setting provenance tag failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{ProvenanceTag: true}) {
+			t.Fatalf("unexpected provenance tag value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing the Vault configuration.
+		config, _, _, err := parseArgs("test", []string{
+			"-vault-address=https://vault", "-vault-secret-path=secret/data/gmm",
+			"-vault-token-path=token", "-vault-role-id=role",
+			"-vault-secret-id-path=secretid",
+		})
+		if err != nil {
+			t.Fatalf("setting the Vault configuration failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Vault: mirror.VaultConf{
+				Address:      "https://vault",
+				TokenPath:    "token",
+				RoleID:       "role",
+				SecretIDPath: "secretid",
+				SecretPath:   "secret/data/gmm",
+			},
+		}) {
+			t.Fatalf("unexpected Vault configuration: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -fail-on-no-change.
+		config, _, _, err := parseArgs("test", []string{"-fail-on-no-change"})
+		if err != nil {
+			t.Fatalf("setting fail on no change failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{FailOnNoChange: true}) {
+			t.Fatalf("unexpected fail on no change value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -client-cert and -client-key.
+		config, _, _, err := parseArgs("test",
+			[]string{"-client-cert=cert.pem", "-client-key=key.pem"})
+		if err != nil {
+			t.Fatalf("setting client certificate failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			ClientCert: "cert.pem",
+			ClientKey:  "key.pem",
+		}) {
+			t.Fatalf("unexpected client certificate value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -ca-file.
+		config, _, _, err := parseArgs("test", []string{"-ca-file=ca.pem"})
+		if err != nil {
+			t.Fatalf("setting CA file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{CAFile: "ca.pem"}) {
+			t.Fatalf("unexpected CA file value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -source-cert-pin.
+		config, _, _, err := parseArgs("test", []string{"-source-cert-pin=ab:cd"})
+		if err != nil {
+			t.Fatalf("setting source certificate pin failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{SrcCertPin: "ab:cd"}) {
+			t.Fatalf("unexpected source certificate pin value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -print-config.
+		config, opts, _, err := parseArgs("test", []string{"-print-config"})
+		if err != nil {
+			t.Fatalf("setting print config failed: %s", err)
+		}
+		if !opts.printConfig || !cmp.Equal(*config, mirror.Config{}) {
+			t.Fatalf("unexpected print config value: %v", opts)
+		}
+	}
+	{
+		// Test passing -prune-plan.
+		config, opts, _, err := parseArgs("test", []string{"-prune-plan"})
+		if err != nil {
+			t.Fatalf("setting prune plan failed: %s", err)
+		}
+		if !opts.prunePlan || !cmp.Equal(*config, mirror.Config{}) {
+			t.Fatalf("unexpected prune plan value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -log-output and -result-output.
+		config, opts, _, err := parseArgs("test", []string{
+			"-log-output=stdout", "-result-output=fd:3",
+		})
+		if err != nil {
+			t.Fatalf("setting outputs failed: %s", err)
+		}
+		if opts.logOutput != "stdout" || opts.resultOutput != "fd:3" ||
+			!cmp.Equal(*config, mirror.Config{}) {
+			t.Fatalf("unexpected outputs values: %v", opts)
+		}
+	}
+	{
+		// Test passing -dry-run.
+		config, _, _, err := parseArgs("test", []string{"-dry-run"})
+		if err != nil {
+			t.Fatalf("setting dry run failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{DryRun: true}) {
+			t.Fatalf("unexpected dry run value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -plan-file and -apply-plan.
+		config, _, _, err := parseArgs("test", []string{
+			"-plan-file", "plan", "-apply-plan", "approved",
+		})
+		if err != nil {
+			t.Fatalf("setting plan files failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{PlanFile: "plan", ApplyPlan: "approved"}) {
+			t.Fatalf("unexpected plan files value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -run-id.
+		config, _, _, err := parseArgs("test", []string{"-run-id=ci-42"})
+		if err != nil {
+			t.Fatalf("setting run ID failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{RunID: "ci-42"}) {
+			t.Fatalf("unexpected run ID value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -history-file.
+		config, _, _, err := parseArgs("test", []string{"-history-file=history.jsonl"})
+		if err != nil {
+			t.Fatalf("setting history file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{HistoryFile: "history.jsonl"}) {
+			t.Fatalf("unexpected history file value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -changelog.
+		config, _, _, err := parseArgs("test", []string{"-changelog=CHANGES.md"})
+		if err != nil {
+			t.Fatalf("setting changelog failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{Changelog: "CHANGES.md"}) {
+			t.Fatalf("unexpected changelog value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -status-file.
+		config, _, _, err := parseArgs("test", []string{"-status-file=status.json"})
+		if err != nil {
+			t.Fatalf("setting status file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{StatusFile: "status.json"}) {
+			t.Fatalf("unexpected status file value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -lock-file and -lock-timeout.
+		config, _, _, err := parseArgs("test",
+			[]string{"-lock-file=mirror.lock", "-lock-timeout=1m"})
+		if err != nil {
+			t.Fatalf("setting lock file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			LockFile:    "mirror.lock",
+			LockTimeout: time.Minute,
+		}) {
+			t.Fatalf("unexpected lock file value: %s", config.Pretty())
+		}
+	}
 	{
 		// Test passing -debug.
-		config, _, err := parseArgs("test",
+		config, _, _, err := parseArgs("test",
 			[]string{"-debug"})
 		if err != nil {
 			t.Fatalf("setting debug failed: %s", err)
@@ -65,9 +951,70 @@ func TestParseArgs(t *testing.T) {
 	}
 	{
 		// Test passing invalid flag.
-		_, _, err := parseArgs("test", []string{"-invalid-flag"})
+		_, _, _, err := parseArgs("test", []string{"-invalid-flag"})
 		if err == nil {
 			t.Fatal("invalid flag succeeded")
 		}
 	}
 }
+
+// TestParseArgsConfig tests loading the configuration from a file and from the
+// standard input.
+func TestParseArgsConfig(t *testing.T) {
+	// Not parallel as it mocks the standard input.
+	confContent := `{"SrcRepo": "src", "DstRepo": "dst", "Debug": true}`
+
+	file, err := ioutil.TempFile("/tmp", "git-mirror-me-test-config-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary config file: %s", err)
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(confContent); err != nil {
+		t.Fatalf("failed to write the config file: %s", err)
+	}
+
+	file.Close()
+
+	{
+		// Test passing -config with a file path. CLI flags take precedence.
+		config, _, _, err := parseArgs("test", []string{
+			"-config", file.Name(), "-destination-repository=dstcli",
+		})
+		if err != nil {
+			t.Fatalf("loading config file failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source:      mirror.RepoConf{URL: "src"},
+			Destination: mirror.RepoConf{URL: "dstcli"},
+			Debug:       true,
+		}) {
+			t.Fatalf("unexpected config file value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -config with the standard input.
+		stdin = strings.NewReader(confContent)
+		defer func() { stdin = os.Stdin }()
+
+		config, _, _, err := parseArgs("test", []string{"-config", "-"})
+		if err != nil {
+			t.Fatalf("loading config from stdin failed: %s", err)
+		}
+		if !cmp.Equal(*config, mirror.Config{
+			Source:      mirror.RepoConf{URL: "src"},
+			Destination: mirror.RepoConf{URL: "dst"},
+			Debug:       true,
+		}) {
+			t.Fatalf("unexpected config stdin value: %s", config.Pretty())
+		}
+	}
+	{
+		// Test passing -config with an invalid path.
+		_, _, _, err := parseArgs("test", []string{"-config", "/invalid"})
+		if err == nil {
+			t.Fatal("invalid config file path succeeded")
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	mirror "github.com/agherzan/git-mirror-me"
+)
+
+// compareCommand is the subcommand listing the source and the destination
+// references side by side.
+const compareCommand = "compare"
+
+// compareMarkers are the diff-style markers of the compared references.
+var compareMarkers = map[mirror.RefStatus]string{
+	mirror.RefMatching:        "=",
+	mirror.RefSourceOnly:      "<",
+	mirror.RefDestinationOnly: ">",
+	mirror.RefDivergent:       "!",
+}
+
+// compareHash returns the short hash of a compared reference, or "-" when it
+// is not on that side.
+func compareHash(hash plumbing.Hash) string {
+	if hash.IsZero() {
+		return "-"
+	}
+
+	return hash.String()[:7]
+}
+
+// printComparison prints the compared references as a table, one reference
+// per line, followed by the number of references of each status.
+func printComparison(comparisons []mirror.RefComparison, output io.Writer) error {
+	table := tabwriter.NewWriter(output, 0, 0, 2, ' ', 0)
+	counts := make(map[mirror.RefStatus]int, len(compareMarkers))
+
+	fmt.Fprintln(table, "\tREFERENCE\tSOURCE\tDESTINATION\tSTATUS")
+
+	for _, comparison := range comparisons {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", compareMarkers[comparison.Status],
+			comparison.Name, compareHash(comparison.Source),
+			compareHash(comparison.Destination), comparison.Status)
+
+		counts[comparison.Status]++
+	}
+
+	if err := table.Flush(); err != nil {
+		return fmt.Errorf("failed to print the comparison: %w", err)
+	}
+
+	fmt.Fprintf(output, "\n%d %s, %d %s, %d %s, %d %s.\n",
+		counts[mirror.RefMatching], mirror.RefMatching,
+		counts[mirror.RefSourceOnly], mirror.RefSourceOnly,
+		counts[mirror.RefDestinationOnly], mirror.RefDestinationOnly,
+		counts[mirror.RefDivergent], mirror.RefDivergent)
+
+	return nil
+}
+
+// runCompare runs the 'compare' subcommand which lists the source and the
+// destination references side by side, to troubleshoot a drift. It takes the
+// same flags and environment variables as a mirror but is read-only. The
+// table is written to 'stdout' and the help to the logger output.
+func runCompare(logger *mirror.StdLogger, env map[string]string, progName string,
+	args []string, stdout io.Writer) error {
+	conf, _, output, err := parseArgs(progName+" "+compareCommand, args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(logger.GetOutput(), output)
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// The logs are discarded so that the output is only the table.
+	quietLogger := mirror.NewLogger(io.Discard)
+
+	conf.ProcessEnv(quietLogger, env)
+
+	if err := conf.Validate(quietLogger); err != nil {
+		return fmt.Errorf("configuration failed: %w", err)
+	}
+
+	comparisons, err := mirror.CompareRefs(*conf, quietLogger)
+	if err != nil {
+		return err
+	}
+
+	return printComparison(comparisons, stdout)
+}
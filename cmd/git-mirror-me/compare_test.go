@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	mirror "github.com/agherzan/git-mirror-me"
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestRunCompare tests the 'compare' subcommand.
+func TestRunCompare(t *testing.T) {
+	t.Parallel()
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	_, dstHead, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/c",
+	}, "dst")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	{
+		// Test comparing the references. The table is written to the
+		// standard output and nothing to the standard error.
+		var output, stderr bytes.Buffer
+
+		err := runCompare(mirror.NewLogger(&stderr), nil, "test", []string{
+			"-source-repository=" + srcRepoPath,
+			"-destination-repository=" + dstRepoPath,
+		}, &output)
+		if err != nil {
+			t.Fatalf("runCompare failed: %s", err)
+		}
+
+		if stderr.Len() != 0 {
+			t.Fatalf("unexpected standard error output: %s", stderr.String())
+		}
+
+		lines := strings.Split(output.String(), "\n")
+		src, dst := srcHead.String()[:7], dstHead.String()[:7]
+
+		for i, expected := range [][]string{
+			{"REFERENCE", "SOURCE", "DESTINATION", "STATUS"},
+			{"<", "refs/heads/a", src, "-", "source-only"},
+			{">", "refs/heads/c", "-", dst, "destination-only"},
+			{"!", "refs/heads/master", src, dst, "divergent"},
+		} {
+			if strings.Join(strings.Fields(lines[i]), " ") != strings.Join(expected, " ") {
+				t.Fatalf("unexpected comparison line %d: %s", i, output.String())
+			}
+		}
+
+		if !strings.Contains(output.String(),
+			"0 matching, 1 source-only, 1 destination-only, 1 divergent.") {
+			t.Fatalf("unexpected comparison summary: %s", output.String())
+		}
+	}
+	{
+		// Test the help.
+		var output bytes.Buffer
+		if err := run(mirror.NewLogger(&output), nil, "test", []string{"compare", "-h"}); err != nil {
+			t.Fatalf("run compare help failed: %s", err)
+		}
+
+		if !strings.Contains(output.String(), "test compare") {
+			t.Fatalf("unexpected help output: %s", output.String())
+		}
+	}
+	{
+		// Test that the repositories are required.
+		var output bytes.Buffer
+		if err := run(mirror.NewLogger(&output), nil, "test", []string{"compare"}); err == nil {
+			t.Fatal("compare without repositories passed")
+		}
+	}
+}
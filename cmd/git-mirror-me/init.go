@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// initCommand is the subcommand writing a sample configuration file.
+const initCommand = "init"
+
+// defaultInitPath is the path of the sample configuration file written by
+// the 'init' subcommand when none is provided.
+const defaultInitPath = "git-mirror-me.json"
+
+var (
+	ErrConfigExists = errors.New("the configuration file already exists " +
+		"(use '-force' to overwrite it)")
+	ErrInitArgs = errors.New("only one configuration file path can be provided")
+)
+
+// sample is the sample configuration file, with all the options, other than
+// the deprecated ones, set to their defaults and described by '//' comments.
+// It is maintained along with the configuration options.
+//
+//go:embed sample.json
+var sample string
+
+// sampleConfig returns the sample configuration file.
+func sampleConfig() string {
+	return sample
+}
+
+// runInit runs the 'init' subcommand which writes a sample configuration
+// file, to be used with '-config'. The file is not overwritten unless forced.
+// When the path is "-", the sample is written to 'stdout'. The help and the
+// messages are written to 'output'.
+func runInit(progName string, args []string, stdout, output io.Writer) error {
+	var force bool
+
+	var flagsOutput bytes.Buffer
+
+	flags := flag.NewFlagSet(progName+" "+initCommand, flag.ContinueOnError)
+	flags.SetOutput(&flagsOutput)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), `Usage: %s %s [flags] [path]
+
+Writes a sample JSON configuration file, with all the options set to their
+defaults and described by '//' comment lines, to 'path' (default '%s').
+Use '-' to write it to the standard output. See '%s -help' for the full
+description of the options.
+
+`, progName, initCommand, defaultInitPath, progName)
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&force, "force", force, "Overwrite an existing file.")
+
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		fmt.Fprint(output, flagsOutput.String())
+
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	path := defaultInitPath
+
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		path = flags.Arg(0)
+	default:
+		return ErrInitArgs
+	}
+
+	if path == "-" {
+		fmt.Fprint(stdout, sampleConfig())
+
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%w: %s", ErrConfigExists, path)
+	}
+
+	if err := os.WriteFile(path, []byte(sampleConfig()), 0o600); err != nil {
+		return fmt.Errorf("failed to write the configuration file: %w", err)
+	}
+
+	fmt.Fprintln(output, "Wrote a sample configuration to", path+".")
+
+	return nil
+}
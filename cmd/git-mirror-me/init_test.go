@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	mirror "github.com/agherzan/git-mirror-me"
+)
+
+// commentsRe matches the comment lines of the sample.
+var commentsRe = regexp.MustCompile(`(?m)^\s*//.*$`)
+
+// jsonKeys returns the sorted keys, including the nested ones, of a JSON
+// object.
+func jsonKeys(object map[string]interface{}, prefix string) []string {
+	var keys []string
+
+	for key, value := range object {
+		keys = append(keys, prefix+key)
+
+		if nested, ok := value.(map[string]interface{}); ok {
+			keys = append(keys, jsonKeys(nested, prefix+key+".")...)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// TestRunInit tests the 'init' subcommand.
+func TestRunInit(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	{
+		// Test writing the sample to a path. It loads as the default
+		// configuration.
+		path := filepath.Join(dir, "conf.json")
+
+		var output bytes.Buffer
+		if err := runInit("test", []string{path}, &output, &output); err != nil {
+			t.Fatalf("runInit failed: %s", err)
+		}
+
+		conf, err := loadConfigFile(path)
+		if err != nil {
+			t.Fatalf("failed to load the sample configuration: %s", err)
+		}
+
+		defaults := mirror.Config{
+			ObjectFormat:       "sha1",
+			RefNameReplacement: "_",
+			RetryBackoff:       5 * time.Second,
+			ListTimeout:        10 * time.Second,
+		}
+		if !cmp.Equal(*conf, defaults, cmpopts.IgnoreFields(mirror.Config{}, "RefFilter")) {
+			t.Fatalf("unexpected sample configuration: %s", conf.Pretty())
+		}
+
+		// An existing file is only overwritten with -force.
+		if err := runInit("test", []string{path}, &output, &output); !errors.Is(err, ErrConfigExists) {
+			t.Fatalf("unexpected error for an existing file: %v", err)
+		}
+
+		if err := runInit("test", []string{"-force", path}, &output, &output); err != nil {
+			t.Fatalf("runInit with -force failed: %s", err)
+		}
+	}
+	{
+		// Test writing the sample to the standard output, and nothing to
+		// the standard error.
+		var stdout, stderr bytes.Buffer
+		if err := runInit("test", []string{"-"}, &stdout, &stderr); err != nil {
+			t.Fatalf("runInit failed: %s", err)
+		}
+
+		if stdout.String() != sampleConfig() || stderr.Len() != 0 {
+			t.Fatalf("unexpected sample output: %s (%s)", stdout.String(),
+				stderr.String())
+		}
+	}
+	{
+		// Test that the sample has all the options but the deprecated ones.
+		sampleKeys := map[string]interface{}{}
+		if err := json.Unmarshal(commentsRe.ReplaceAll([]byte(sampleConfig()), nil),
+			&sampleKeys); err != nil {
+			t.Fatalf("failed to parse the sample: %s", err)
+		}
+
+		data, _ := json.Marshal(mirror.Config{})
+		confKeys := map[string]interface{}{}
+		_ = json.Unmarshal(data, &confKeys)
+
+		for _, deprecated := range []string{"SrcRepo", "DstRepo", "SSH"} {
+			delete(confKeys, deprecated)
+		}
+
+		if diff := cmp.Diff(jsonKeys(confKeys, ""), jsonKeys(sampleKeys, "")); diff != "" {
+			t.Fatalf("unexpected sample options (-want +got):\n%s", diff)
+		}
+	}
+	{
+		// Test passing more than one path.
+		var output bytes.Buffer
+		if err := runInit("test", []string{"a", "b"}, &output, &output); !errors.Is(err, ErrInitArgs) {
+			t.Fatalf("unexpected error for two paths: %v", err)
+		}
+	}
+	{
+		// Test the help.
+		var output bytes.Buffer
+		if err := runInit("test", []string{"-h"}, &output, &output); err != nil {
+			t.Fatalf("runInit help failed: %s", err)
+		}
+
+		if !strings.Contains(output.String(), "Usage: test init") {
+			t.Fatalf("unexpected help output: %s", output.String())
+		}
+	}
+	{
+		// Test running the subcommand.
+		devnull, _ := os.Open(os.DevNull)
+		defer devnull.Close()
+
+		path := filepath.Join(dir, "run.json")
+		if err := run(mirror.NewLogger(devnull), nil, "test", []string{"init", path}); err != nil {
+			t.Fatalf("run init failed: %s", err)
+		}
+
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("the sample configuration was not written: %s", err)
+		}
+	}
+}
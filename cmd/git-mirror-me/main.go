@@ -5,16 +5,109 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 
 	mirror "github.com/agherzan/git-mirror-me"
 )
 
-func run(logger *mirror.Logger, env map[string]string, progName string, args []string) error {
-	conf, output, err := parseArgs(progName, args)
+// noChangeExitCode is the exit code used with '-fail-on-no-change' when the
+// mirror operation didn't change the destination.
+const noChangeExitCode = 100
+
+// prunePlanRef is a destination reference a mirror would prune, as printed
+// by '-prune-plan'.
+type prunePlanRef struct {
+	Ref  string `json:"ref"`
+	Hash string `json:"hash"`
+}
+
+// printPrunePlan prints, as JSON, the destination references a mirror would
+// prune together with the hashes they currently point to.
+func printPrunePlan(conf mirror.Config, logger mirror.Logger, output io.Writer) error {
+	refs, err := mirror.PruneCandidates(conf, logger)
+	if err != nil {
+		return err
+	}
+
+	plan := make([]prunePlanRef, 0, len(refs))
+	for _, ref := range refs {
+		plan = append(plan, prunePlanRef{
+			Ref:  ref.Name().String(),
+			Hash: ref.Hash().String(),
+		})
+	}
+
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Ref < plan[j].Ref
+	})
+
+	out, err := json.MarshalIndent(plan, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the prune plan: %w", err)
+	}
+
+	fmt.Fprintln(output, string(out))
+
+	return nil
+}
+
+// envVars are the environment variables the configuration is read from, see
+// Config.ProcessEnv.
+var envVars = []string{
+	"GMM_SRC_REPO",
+	"GITHUB_SERVER_URL",
+	"GITHUB_REPOSITORY",
+	"GMM_DST_REPO",
+	"GMM_SSH_PRIVATE_KEY",
+	"GMM_SSH_KNOWN_HOSTS",
+	"GMM_SRC_SSH_PRIVATE_KEY",
+	"GMM_SRC_SSH_KNOWN_HOSTS",
+	"GMM_SRC_HTTP_PASSWORD",
+	"GMM_DST_HTTP_PASSWORD",
+	"GMM_CA_CONTENT",
+	"GMM_GITHUB_TOKEN",
+	"GMM_GITLAB_TOKEN",
+	"GMM_PROVENANCE_SIGN_KEY",
+}
+
+// collectEnv returns the set environment variables of envVars, looked up with
+// 'lookup' (e.g. os.LookupEnv).
+func collectEnv(lookup func(string) (string, bool)) map[string]string {
+	env := map[string]string{}
+
+	for _, envVar := range envVars {
+		if val, set := lookup(envVar); set {
+			env[envVar] = val
+		}
+	}
+
+	return env
+}
+
+func run(logger *mirror.StdLogger, env map[string]string, progName string, args []string) error {
+	if len(args) != 0 && args[0] == initCommand {
+		if err := runInit(progName, args[1:], stdout, logger.GetOutput()); err != nil {
+			return fmt.Errorf("init failed: %w", err)
+		}
+
+		return nil
+	}
+
+	if len(args) != 0 && args[0] == compareCommand {
+		if err := runCompare(logger, env, progName, args[1:], stdout); err != nil {
+			return fmt.Errorf("compare failed: %w", err)
+		}
+
+		return nil
+	}
+
+	conf, opts, output, err := parseArgs(progName, args)
 	if errors.Is(err, flag.ErrHelp) {
 		fmt.Fprintf(logger.GetOutput(), output)
 
@@ -23,7 +116,34 @@ func run(logger *mirror.Logger, env map[string]string, progName string, args []s
 		return fmt.Errorf("%w", err)
 	}
 
+	stderr := logger.GetOutput()
+
+	var resultOutput io.Writer
+
+	if len(opts.resultOutput) != 0 {
+		resultOutput, err = openOutput(opts.resultOutput, stderr, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(opts.logOutput) != 0 {
+		logOutput, err := openOutput(opts.logOutput, stderr, false)
+		if err != nil {
+			return err
+		}
+
+		logger = mirror.NewLogger(logOutput)
+	}
+
 	conf.ProcessEnv(logger, env)
+
+	if opts.printConfig {
+		fmt.Fprintln(stdout, conf.Pretty())
+
+		return nil
+	}
+
 	logger.Debug(conf.Debug, conf.Pretty())
 
 	err = conf.Validate(logger)
@@ -31,7 +151,25 @@ func run(logger *mirror.Logger, env map[string]string, progName string, args []s
 		return fmt.Errorf("configuration failed: %w", err)
 	}
 
-	err = mirror.DoMirror(*conf, logger)
+	if opts.prunePlan {
+		if err := printPrunePlan(*conf, logger, stdout); err != nil {
+			return fmt.Errorf("prune plan failed: %w", err)
+		}
+
+		return nil
+	}
+
+	if resultOutput != nil {
+		result := mirror.DoMirrorResult(*conf, logger)
+		if err := printResult(result, resultOutput); err != nil {
+			return err
+		}
+
+		err = result.Err
+	} else {
+		err = mirror.DoMirror(*conf, logger)
+	}
+
 	if err != nil {
 		return fmt.Errorf("mirror operation failed: %w", err)
 	}
@@ -43,24 +181,17 @@ func main() {
 	// Keep the main function minimum as it is not covered by testing.
 	logger := mirror.NewLogger(os.Stderr)
 
-	var env map[string]string
+	env := collectEnv(os.LookupEnv)
 
-	envVars := []string{
-		"GMM_SRC_REPO",
-		"GITHUB_SERVER_URL",
-		"GITHUB_REPOSITORY",
-		"GMM_DEST_REPO",
-		"GMM_SSH_PRIVATE_KEY",
-		"GMM_SSH_KNOWN_HOSTS",
-	}
+	// The temporary files are removed when the process is terminated too.
+	mirror.RemoveTempFilesOnSignal(logger)
 
-	for _, envVar := range envVars {
-		if val, set := os.LookupEnv(envVar); set {
-			env[envVar] = val
+	if err := run(logger, env, os.Args[0], os.Args[1:]); err != nil {
+		if errors.Is(err, mirror.ErrNoChange) {
+			logger.Info("Nothing changed on the destination.")
+			os.Exit(noChangeExitCode)
 		}
-	}
 
-	if err := run(logger, env, os.Args[0], os.Args[1:]); err != nil {
 		logger.Fatal(err)
 	}
 }
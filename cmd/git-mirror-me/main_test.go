@@ -5,18 +5,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	mirror "github.com/agherzan/git-mirror-me"
 	"github.com/agherzan/git-mirror-me/internal/utils"
 )
 
-// TestRun tests the run function.
+// TestRun tests the run function. The standard output is replaced so it is
+// not run in parallel.
 func TestRun(t *testing.T) {
-	t.Parallel()
-
 	// no need for logs
 	devnull, _ := os.Open(os.DevNull)
 	defer devnull.Close()
@@ -70,19 +75,79 @@ func TestRun(t *testing.T) {
 		t.Fatal("invalid argument passed")
 	}
 
+	// Test printing the effective configuration, with the secrets masked, on
+	// the standard output.
+	var output bytes.Buffer
+
+	defaultStdout := stdout
+	stdout = &output
+
+	defer func() { stdout = defaultStdout }()
+
+	args = []string{"-print-config", "-destination-repository=dstcli"}
+	env := map[string]string{
+		"GMM_SRC_REPO":        "srcenv",
+		"GMM_DST_REPO":        "dstenv",
+		"GMM_SSH_PRIVATE_KEY": "key",
+	}
+
+	if err := run(logger, env, "test", args); err != nil {
+		t.Fatalf("print config failed: %s", err)
+	}
+
+	for _, expected := range []string{`"URL": "srcenv"`, `"URL": "dstcli"`} {
+		if !strings.Contains(output.String(), expected) {
+			t.Fatalf("missing %s in the printed configuration: %s", expected,
+				output.String())
+		}
+	}
+
+	if strings.Contains(output.String(), `"key"`) {
+		t.Fatalf("the printed configuration is not masked: %s", output.String())
+	}
+
 	// Fail configuration.
 	if err := run(logger, map[string]string{}, "test", []string{}); err == nil {
 		t.Fatal("invalid configuration passed")
 	}
 
 	// Fail on an invalid destination repository.
-	env := map[string]string{"GMM_SRC_REPO": srcRepoPath}
+	env = map[string]string{"GMM_SRC_REPO": srcRepoPath}
 	args = []string{"--destination-repository", "invalid"}
 
 	if err := run(logger, env, "test", args); err == nil {
 		t.Fatal("run succeeded with an invalid dst repository")
 	}
 
+	// Test printing the prune plan, on the standard output, without
+	// changing the destination.
+	var planOutput bytes.Buffer
+
+	stdout = &planOutput
+
+	env = map[string]string{"GMM_SRC_REPO": srcRepoPath}
+	args = []string{"-prune-plan", "--destination-repository", dstRepoPath}
+
+	if err := run(logger, env, "test", args); err != nil {
+		t.Fatalf("prune plan failed: %s", err)
+	}
+
+	var plan []prunePlanRef
+	if err := json.Unmarshal(planOutput.Bytes(), &plan); err != nil {
+		t.Fatalf("failed to decode the prune plan: %s (%s)", err, planOutput.String())
+	}
+
+	dstHead, err := dstRepo.Head()
+	if err != nil {
+		t.Fatalf("failed to get the dst repo HEAD: %s", err)
+	}
+
+	if !cmp.Equal(plan, []prunePlanRef{
+		{Ref: "refs/heads/c", Hash: dstHead.Hash().String()},
+	}) {
+		t.Fatalf("unexpected prune plan: %v", plan)
+	}
+
 	// Valid run.
 	env = map[string]string{"GMM_SRC_REPO": srcRepoPath}
 	args = []string{"--destination-repository", dstRepoPath}
@@ -115,4 +180,47 @@ func TestRun(t *testing.T) {
 	if !ok {
 		t.Fatal("unexpected hash test result for the dst repo")
 	}
+
+	// A second run doesn't change anything.
+	args = []string{"--destination-repository", dstRepoPath, "-fail-on-no-change"}
+	if err := run(logger, env, "test", args); !errors.Is(err, mirror.ErrNoChange) {
+		t.Fatalf("unexpected no change run result: %s", err)
+	}
+}
+
+// TestCollectEnv tests the collectEnv function.
+func TestCollectEnv(t *testing.T) {
+	t.Parallel()
+
+	vars := map[string]string{
+		"GMM_DST_REPO":          "/dst",
+		"GMM_DST_HTTP_PASSWORD": "secret",
+		"GMM_UNKNOWN":           "foo",
+	}
+
+	env := collectEnv(func(key string) (string, bool) {
+		val, set := vars[key]
+
+		return val, set
+	})
+
+	if !cmp.Equal(env, map[string]string{
+		"GMM_DST_REPO":          "/dst",
+		"GMM_DST_HTTP_PASSWORD": "secret",
+	}) {
+		t.Fatalf("unexpected environment: %v", env)
+	}
+
+	// The collected environment configures the destination.
+	conf := mirror.Config{}
+	conf.ProcessEnv(mirror.NewLogger(ioutil.Discard), env)
+
+	if conf.Destination.URL != "/dst" || conf.Destination.HTTP.Password != "secret" {
+		t.Fatalf("unexpected configuration from the environment: %s", conf.Pretty())
+	}
+
+	if env := collectEnv(func(string) (string, bool) { return "", false }); env == nil ||
+		len(env) != 0 {
+		t.Fatalf("unexpected empty environment: %v", env)
+	}
 }
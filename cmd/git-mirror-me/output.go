@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	mirror "github.com/agherzan/git-mirror-me"
+)
+
+// The output streams of '-log-output' and '-result-output'.
+const (
+	outputStdout   = "stdout"
+	outputStderr   = "stderr"
+	outputFdPrefix = "fd:"
+)
+
+var ErrOutput = errors.New("unsupported output (use 'stdout', 'stderr' or " +
+	"'fd:N')")
+
+// stdout is the standard output, replaced by the tests. The standard error is
+// the output of the logger provided to run.
+var stdout io.Writer = os.Stdout
+
+// parseOutputFd parses a 'fd:N' output to its file descriptor.
+func parseOutputFd(output string) (int, error) {
+	fd, err := strconv.Atoi(strings.TrimPrefix(output, outputFdPrefix))
+	if err != nil || fd < 0 {
+		return 0, fmt.Errorf("%w: %q", ErrOutput, output)
+	}
+
+	return fd, nil
+}
+
+// openOutput returns the writer of an output stream, 'stderr' being the
+// output of the logger provided to run. Only the result can be written to a
+// file descriptor.
+func openOutput(output string, stderr io.Writer, allowFd bool) (io.Writer, error) {
+	switch {
+	case output == outputStdout:
+		return stdout, nil
+	case output == outputStderr:
+		return stderr, nil
+	case allowFd && strings.HasPrefix(output, outputFdPrefix):
+		fd, err := parseOutputFd(output)
+		if err != nil {
+			return nil, err
+		}
+
+		return os.NewFile(uintptr(fd), output), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrOutput, output)
+	}
+}
+
+// printResult prints the result of a mirror as a JSON document on a line of
+// its own.
+func printResult(result mirror.MirrorResult, output io.Writer) error {
+	line, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode the result: %w", err)
+	}
+
+	if _, err := fmt.Fprintln(output, string(line)); err != nil {
+		return fmt.Errorf("failed to print the result: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	mirror "github.com/agherzan/git-mirror-me"
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestParseOutputFd tests the parseOutputFd function.
+func TestParseOutputFd(t *testing.T) {
+	t.Parallel()
+
+	if fd, err := parseOutputFd("fd:3"); err != nil || fd != 3 {
+		t.Fatalf("unexpected file descriptor: %d (%v)", fd, err)
+	}
+
+	for _, invalid := range []string{"fd:", "fd:-1", "fd:three"} {
+		if _, err := parseOutputFd(invalid); !errors.Is(err, ErrOutput) {
+			t.Fatalf("invalid output %q passed", invalid)
+		}
+	}
+}
+
+// TestRunOutputs tests printing the result and the logs to separate streams.
+// Not parallel as it replaces the standard output.
+func TestRunOutputs(t *testing.T) {
+	srcRepoPath := t.TempDir()
+
+	if _, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	}); err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	var stdoutBuffer bytes.Buffer
+
+	defaultStdout := stdout
+	stdout = &stdoutBuffer
+
+	defer func() { stdout = defaultStdout }()
+
+	{
+		// Test the result on the standard error and the logs on the
+		// standard output.
+		var stderr bytes.Buffer
+
+		err := run(mirror.NewLogger(&stderr), nil, "test", []string{
+			"-source-repository=" + srcRepoPath,
+			"-destination-repository=" + dstRepoPath,
+			"-run-id=outputs",
+			"-log-output=stdout",
+			"-result-output=stderr",
+		})
+		if err != nil {
+			t.Fatalf("run failed: %s", err)
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal(stderr.Bytes(), &result); err != nil {
+			t.Fatalf("the standard error is not only the result: %s", stderr.String())
+		}
+
+		if result["run_id"] != "outputs" || result["status"] != "success" ||
+			result["destination"] != dstRepoPath {
+			t.Fatalf("unexpected result: %s", stderr.String())
+		}
+
+		if !strings.Contains(stdoutBuffer.String(), "[INFO ]") {
+			t.Fatalf("the logs are not on the standard output: %s",
+				stdoutBuffer.String())
+		}
+	}
+	{
+		// Test an unsupported output.
+		err := run(mirror.NewLogger(&stdoutBuffer), nil, "test", []string{
+			"-source-repository=" + srcRepoPath,
+			"-destination-repository=" + dstRepoPath,
+			"-result-output=file",
+		})
+		if !errors.Is(err, ErrOutput) {
+			t.Fatalf("unexpected error for an unsupported output: %v", err)
+		}
+
+		err = run(mirror.NewLogger(&stdoutBuffer), nil, "test", []string{
+			"-source-repository=" + srcRepoPath,
+			"-destination-repository=" + dstRepoPath,
+			"-log-output=fd:3",
+		})
+		if !errors.Is(err, ErrOutput) {
+			t.Fatalf("unexpected error for a log file descriptor: %v", err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// RefStatus is how a reference compares between the source and the
+// destination.
+type RefStatus string
+
+// The RefStatus values.
+const (
+	// RefMatching is a reference pointing to the same hash on both sides.
+	RefMatching RefStatus = "matching"
+	// RefSourceOnly is a reference only on the source.
+	RefSourceOnly RefStatus = "source-only"
+	// RefDestinationOnly is a reference only on the destination.
+	RefDestinationOnly RefStatus = "destination-only"
+	// RefDivergent is a reference pointing to different hashes on the two
+	// sides.
+	RefDivergent RefStatus = "divergent"
+)
+
+// RefComparison is a reference compared between the source and the
+// destination. The hash of the side without the reference is the zero hash.
+type RefComparison struct {
+	Name        plumbing.ReferenceName
+	Source      plumbing.Hash
+	Destination plumbing.Hash
+	Status      RefStatus
+}
+
+// listRepoRefs lists the references, under 'refs/', of a repository without
+// fetching it. An empty repository has no references. 'name' identifies the
+// repository in the logs.
+func listRepoRefs(conf Config, logger Logger, repo RepoConf,
+	name string) (map[plumbing.ReferenceName]plumbing.Hash, error) {
+	auth, err := repoAuth(conf, logger, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: name,
+		URLs: []string{repo.URL},
+	})
+
+	refs, err := listRemote(conf, remote, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		refs = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list the %s remote: %w", name, err)
+	}
+
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference &&
+			strings.HasPrefix(ref.Name().String(), "refs/") {
+			hashes[ref.Name()] = ref.Hash()
+		}
+	}
+
+	return hashes, nil
+}
+
+// CompareRefs lists the references of the source and of the destination and
+// compares them, sorted by name. Nothing is fetched nor pushed, so the
+// references are compared as they are, without the mirror filters.
+func CompareRefs(conf Config, logger Logger) ([]RefComparison, error) {
+	conf.mapDeprecated()
+
+	if err := conf.processVault(logger); err != nil {
+		return nil, err
+	}
+
+	conf.mapHostKeys()
+
+	srcRefs, err := listRepoRefs(conf, logger, conf.Source, "source")
+	if err != nil {
+		return nil, err
+	}
+
+	dstRefs, err := listRepoRefs(conf, logger, conf.Destination, "destination")
+	if err != nil {
+		return nil, err
+	}
+
+	comparisons := make([]RefComparison, 0, len(srcRefs))
+
+	for name, srcHash := range srcRefs {
+		comparison := RefComparison{Name: name, Source: srcHash, Status: RefSourceOnly}
+
+		if dstHash, found := dstRefs[name]; found {
+			comparison.Destination = dstHash
+			comparison.Status = RefMatching
+
+			if dstHash != srcHash {
+				comparison.Status = RefDivergent
+			}
+		}
+
+		comparisons = append(comparisons, comparison)
+	}
+
+	for name, dstHash := range dstRefs {
+		if _, found := srcRefs[name]; !found {
+			comparisons = append(comparisons, RefComparison{
+				Name:        name,
+				Destination: dstHash,
+				Status:      RefDestinationOnly,
+			})
+		}
+	}
+
+	sort.Slice(comparisons, func(i, j int) bool {
+		return comparisons[i].Name < comparisons[j].Name
+	})
+
+	return comparisons, nil
+}
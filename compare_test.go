@@ -0,0 +1,82 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestCompareRefs tests comparing the source and the destination references.
+func TestCompareRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, dstHead, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/c",
+	}, "dst")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	// Make the destination drift from the source.
+	for _, ref := range []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/b", dstHead),
+		plumbing.NewHashReference("refs/heads/c", dstHead),
+	} {
+		if err := dstRepo.Storer.SetReference(ref); err != nil {
+			t.Fatalf("failed to set a dst repo reference: %s", err)
+		}
+	}
+
+	if err := dstRepo.Storer.RemoveReference("refs/heads/a"); err != nil {
+		t.Fatalf("failed to remove a dst repo reference: %s", err)
+	}
+
+	comparisons, err := CompareRefs(conf, logger)
+	if err != nil {
+		t.Fatalf("CompareRefs failed: %s", err)
+	}
+
+	expected := []RefComparison{
+		{Name: "refs/heads/a", Source: srcHead, Status: RefSourceOnly},
+		{Name: "refs/heads/b", Source: srcHead, Destination: dstHead, Status: RefDivergent},
+		{Name: "refs/heads/c", Destination: dstHead, Status: RefDestinationOnly},
+		{Name: "refs/heads/master", Source: srcHead, Destination: srcHead, Status: RefMatching},
+	}
+	if !cmp.Equal(comparisons, expected) {
+		t.Fatalf("unexpected comparisons: %s", cmp.Diff(expected, comparisons))
+	}
+}
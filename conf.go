@@ -5,17 +5,87 @@
 package mirror
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 )
 
+// objectFormatSHA1 is the only object format supported by go-git.
+const objectFormatSHA1 = "sha1"
+
 var (
 	ErrNoSrc     = errors.New("no source repository provided")
 	ErrNoDst     = errors.New("no destination repository provided")
+	ErrSameRepo  = errors.New("source and destination are the same repository")
 	ErrNoHostKey = errors.New("SSH authentication requires host public keys")
 	ErrHostKey   = errors.New("host public keys provided via both file path " +
 		"and content")
+	ErrRetries = errors.New("number of retries and retry backoffs can't be " +
+		"negative")
+	ErrDstHostNotAllowed = errors.New("destination host is not in the " +
+		"allowed hosts list")
+	ErrRefSpec      = errors.New("invalid refspec")
+	ErrThreshold    = errors.New("prune confirmation threshold can't be negative")
+	ErrTagRetention = errors.New("tag retention can't be negative")
+	ErrMaxDstRefs   = errors.New("maximum number of destination references " +
+		"can't be negative")
+	ErrMinRefs    = errors.New("minimum number of references can't be negative")
+	ErrClientCert = errors.New("client certificate and key need to be " +
+		"provided together")
+	ErrObjectFormat = errors.New("unsupported object format (only 'sha1' is " +
+		"supported by go-git)")
+	ErrIncludeRefs = errors.New("included references can't be combined " +
+		"with refspecs")
+	ErrDialTimeout     = errors.New("dial timeout can't be negative")
+	ErrListTimeout     = errors.New("list timeout can't be negative")
+	ErrLockTimeout     = errors.New("lock timeout can't be negative")
+	ErrMaxConnsPerHost = errors.New("maximum connections per host can't be " +
+		"negative")
+	ErrMaxPackSize = errors.New("maximum pack size can't be negative")
+	ErrRateLimit   = errors.New("rate limit can't be negative")
+	ErrLocalAddr   = errors.New("invalid local address (expected an IP address)")
+	ErrRefGroups   = errors.New("reference groups can't be combined " +
+		"with individual or chunked pushes")
+	ErrInitDestination = errors.New("only a local destination repository " +
+		"can be initialised")
+	ErrRepackDestination = errors.New("only a local destination repository " +
+		"can be repacked")
+	ErrWriteCommitGraph = errors.New("only a local destination repository " +
+		"can have a commit-graph written")
+	ErrCABundle = errors.New("CA bundle provided via both file path and " +
+		"content")
+	ErrPlaceholderPattern = errors.New("invalid placeholder pattern")
+	ErrSymbolicRefs       = errors.New("unsupported symbolic references " +
+		"handling (one of 'resolve', 'skip' or 'recreate')")
+	ErrRecreateSymbolicRefs = errors.New("only a local destination " +
+		"repository can have symbolic references recreated")
+	ErrSanitizeRefNames = errors.New("unsupported invalid reference names " +
+		"handling (one of 'drop' or 'rename')")
+	ErrRefNameReplacement = errors.New("invalid reference name replacement")
+	ErrJumpHost           = errors.New("invalid SSH jump host (expected " +
+		"'user@host[:port]')")
+	ErrJumpHostAuth = errors.New("connecting through an SSH jump host " +
+		"requires SSH authentication and host public keys (they can't be " +
+		"scanned)")
+	ErrSrcCertPin = errors.New("invalid source certificate pin (expected a " +
+		"hexadecimal SHA-256 fingerprint)")
+	ErrSSHCommand = errors.New("an SSH command handles the SSH " +
+		"authentication on its own (it can't be combined with SSH keys, " +
+		"scanning host public keys or a jump host)")
+	ErrSrcCertPinURL = errors.New("a source certificate pin requires an " +
+		"HTTPS source repository")
 )
 
 // SSHConf structure defines SSH configuration used for git authentication over
@@ -24,51 +94,567 @@ type SSHConf struct {
 	PrivateKey     string
 	KnownHosts     string
 	KnownHostsPath string
+	// KeyDir is a directory with per host private keys named as
+	// '<host>.pem'. It is used when PrivateKey is not provided.
+	KeyDir string
+	// ScanHostKeys fetches the host public key from the host itself, like
+	// ssh-keyscan, when no host public keys are provided. The key is trusted
+	// on first use unless it matches HostKeyFingerprint, when set, in the
+	// 'SHA256:...' format.
+	ScanHostKeys       bool
+	HostKeyFingerprint string
+	// JumpHost, when set, is a 'user@host[:port]' SSH host the repository
+	// host is connected to through, like the OpenSSH ProxyJump option. The
+	// jump host is authenticated with the same private key and host public
+	// keys as the repository host.
+	JumpHost string
+	// Command, when set, is an external SSH command, like GIT_SSH_COMMAND,
+	// the SSH connections are delegated to instead of the built-in SSH
+	// client (e.g. 'ssh' to use the OpenSSH configuration and agent). It is
+	// run by the shell followed by the port option, the host and the remote
+	// git command. The command handles the authentication and the host
+	// public keys.
+	Command string
+}
+
+// HTTPAuthConf structure defines the basic authentication used for git
+// authentication over HTTP(S). The password can be an access token.
+type HTTPAuthConf struct {
+	Username string
+	Password string
+}
+
+// RepoConf structure defines a repository and the authentication used to
+// access it.
+type RepoConf struct {
+	URL  string
+	SSH  SSHConf
+	HTTP HTTPAuthConf
 }
 
 // Config structure provides all the configuration need for the tool to perform
 // its operations. It can be populated via a CLI component.
 type Config struct {
+	// Source is the repository fetched and Destination is the repository
+	// pushed to, each with its own authentication.
+	Source      RepoConf
+	Destination RepoConf
+	// SrcRepo, DstRepo and SSH are mapped to Source.URL, Destination.URL and
+	// Destination.SSH respectively, unless these are set.
+	//
+	// Deprecated: Use Source and Destination instead.
 	SrcRepo string
 	DstRepo string
 	SSH     SSHConf
-	Debug   bool
+	// InitDestination initialises a bare repository at the destination, when
+	// it is a local path, unless it already is a repository.
+	InitDestination bool
+	// RepackDestination repacks the destination, when it is a local path,
+	// after a mirror that changed it. The unreachable loose objects older
+	// than two weeks are removed and the reachable objects are packed in a
+	// single pack.
+	RepackDestination bool
+	// WriteCommitGraph writes a commit-graph file in the destination, when it
+	// is a local path, after a mirror that changed it. It speeds up the
+	// history walks of the clones and fetches from the destination.
+	WriteCommitGraph bool
+	// ObjectFormat is the object format (hash algorithm) of the mirrored
+	// repositories. The staging repository can only use the 'sha1' one
+	// (default) so SHA-256 repositories are rejected before fetching, also
+	// when they advertise their object format.
+	ObjectFormat string
+	// StagingBranch, when set, is the initial branch of the staging
+	// repository (e.g. 'main' or 'refs/heads/main') which HEAD points to. It
+	// defaults to go-git's 'master'.
+	StagingBranch string
+	// ObjectCache, when set, is the path of a local bare repository, created
+	// if needed, used as a shared cache of the fetched objects, like 'git
+	// clone --reference'. The staging repository reads the objects it is
+	// missing from the cache, so that only the objects the cache doesn't have
+	// are fetched from the source, and the fetched ones are added to it.
+	ObjectCache string
+	// SymbolicRefs, when set, is how the source symbolic references other
+	// than HEAD are mirrored: 'resolve' pushes them as regular references to
+	// their target, 'skip' doesn't mirror them and 'recreate' creates them as
+	// symbolic references in a local destination. When not set, they are
+	// mirrored as the source advertises them (usually as regular references).
+	SymbolicRefs string
+	// SanitizeRefNames, when set, is how the source references with a name
+	// invalid for git (see git-check-ref-format) or with non-printable
+	// characters are mirrored, instead of failing the push: 'drop' doesn't
+	// mirror them and 'rename' mirrors them under a name with the invalid
+	// characters and sequences replaced by RefNameReplacement ('_' by
+	// default). Both log a warning.
+	SanitizeRefNames   string
+	RefNameReplacement string
+	// Retries is the number of times a failed push (including the prune
+	// push) is retried. RetryBackoff is the delay between the attempts. When
+	// RetryMaxBackoff is set, the delay doubles with each attempt, up to
+	// RetryMaxBackoff, with a random jitter.
+	Retries         int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// AllowedDstHosts, when not empty, restricts the destination repository
+	// to one of these hosts.
+	AllowedDstHosts []string
+	// VerifyFetch enables checking that all the objects reachable from the
+	// fetched references are present before pushing.
+	VerifyFetch bool
+	// PreMirrorCheck, when set, is a shell command run before pushing, e.g.
+	// a secret scanner or a policy check. The staging repository is then
+	// stored on disk, as a bare repository the command is run in, with
+	// GIT_DIR pointing to it. The mirror is aborted, before changing the
+	// destination, when the command fails.
+	PreMirrorCheck string
+	// SkipPruneOnPartialFetch checks that the source fetch has all the
+	// references advertised by the source and all their objects. When it
+	// doesn't, the destination is not pruned as the prune could delete valid
+	// references.
+	SkipPruneOnPartialFetch bool
+	// SkipUnreadableRefs fetches the source references one by one, when the
+	// source fetch fails, skipping the ones that fail (e.g. dangling or
+	// corrupt references). The destination is not pruned when references
+	// were skipped, as with SkipPruneOnPartialFetch.
+	SkipUnreadableRefs bool
+	// DstRefsCache is the path of a state file caching the destination
+	// references between runs. The cache is used instead of listing the
+	// destination and it is dropped whenever the destination is changed so
+	// the destination is only listed again after a change. It assumes that
+	// the destination is not changed by others.
+	DstRefsCache string
+	// PackWindow sets the delta compression window of the pushed packs. Larger
+	// values trade CPU for bandwidth while a negative value disables delta
+	// compression. Zero uses the go-git default.
+	PackWindow int
+	// PostCloneVerify clones the destination after mirroring and checks that
+	// the pushed references resolve to the mirrored hashes with all their
+	// objects present. This catches server-side corruption at the cost of
+	// fetching the whole destination.
+	PostCloneVerify bool
+	// FastForwardOnly pushes only the fast-forward reference updates, except
+	// for the references matching the ForceRefs glob patterns which are force
+	// pushed. The other updates are reported and skipped.
+	FastForwardOnly bool
+	ForceRefs       []string
+	// ReplacePlaceholder force pushes, in FastForwardOnly mode, over a
+	// destination only having the initial commit of an unrelated history, as
+	// created by hosting providers initialising a repository.
+	ReplacePlaceholder bool
+	// DetectRewrites reports, before pushing, the destination references the
+	// mirror would update to a commit that is not a descendant of their
+	// current one, i.e. a rewrite of the source history. FailOnRewrites
+	// fails the mirror instead, before anything is pushed. The references
+	// matching the ForceRefs glob patterns are not reported.
+	DetectRewrites bool
+	FailOnRewrites bool
+	// ConfirmPrune asks the operator to confirm, on the standard input, a
+	// prune of more than ConfirmPruneThreshold references. Non-interactive runs
+	// fail unless AssumeYes is set.
+	ConfirmPrune          bool
+	ConfirmPruneThreshold int
+	AssumeYes             bool
+	// PreserveRefs is a list of glob patterns of destination references that
+	// are never pruned.
+	PreserveRefs []string
+	// DeleteRefs is a list of glob patterns of references that are never
+	// pushed and always deleted from the destination, regardless of the
+	// source, of PreserveRefs and of the prune being skipped.
+	DeleteRefs []string
+	// FetchRefSpecs and PushRefSpecs override the 'refs/*:refs/*' refspecs
+	// used to fetch the source and push to the destination. PushRefSpecs are
+	// not used in FastForwardOnly mode.
+	FetchRefSpecs []string
+	PushRefSpecs  []string
+	// IncludeRefs, when not empty, restricts the fetch, the push and the prune
+	// to these references (e.g. 'refs/heads/main'). It can't be combined with
+	// FetchRefSpecs and PushRefSpecs.
+	IncludeRefs []string
+	// IncludeRefsFile, when set, is the path of a file with one included
+	// reference per line, added to IncludeRefs. Empty lines and lines
+	// starting with '#' are ignored.
+	IncludeRefsFile string
+	// ExcludeRefs is a list of glob patterns of source references that are
+	// not mirrored (and are pruned from the destination).
+	ExcludeRefs []string
+	// ExcludeRefsFile, when set, is the path of a file with one excluded
+	// references pattern per line, added to ExcludeRefs. Empty lines and lines
+	// starting with '#' are ignored.
+	ExcludeRefsFile string
+	// BranchAllowlistFile, when set, is the path of a file with one branch per
+	// line. Only the source branches listed in it are mirrored and the other
+	// branches are pruned from the destination, so that the published
+	// branches are managed by editing the file. The other references are not
+	// affected. Empty lines and lines starting with '#' are ignored. A file
+	// without branches fails the mirror.
+	BranchAllowlistFile string
+	// IndividualPush pushes each reference in its own push so that the
+	// progress and the push errors are attributable to a reference. This is
+	// slower but the references already on the destination are skipped, which
+	// resumes an interrupted push.
+	IndividualPush bool
+	// MaxPackSize, when not zero, splits the push in several pushes each
+	// sending at most about MaxPackSize bytes of objects, for destinations
+	// rejecting larger packs. The references are pushed in history order
+	// so that each push only sends the objects not pushed before. The sizes
+	// are uncompressed estimates and a single reference is never split.
+	MaxPackSize int64
+	// RefGroups groups the references, by glob patterns matching their
+	// destination names, into atomic pushes: the references of a group (e.g.
+	// a release branch and its tags) are all updated or none is. A reference
+	// belongs to the first group it matches. The references in no group are
+	// pushed together first, as usual. The destination needs to support
+	// atomic pushes.
+	RefGroups [][]string
+	// RefFilter, when set, is called for each fetched reference and the
+	// references for which it returns false are not mirrored. It replaces the
+	// default filtering of the GitHub pull request references (refs/pull/*).
+	// It is only available to library users.
+	RefFilter func(*plumbing.Reference) bool `json:"-"`
+	// OnlyReachableFrom, when not empty, restricts the mirror to the
+	// references reachable from these references.
+	OnlyReachableFrom []string
+	// ExcludeAuthorDomains is a list of email domains (e.g. 'corp.example.com').
+	// The references whose tip commit author email is in one of them, or in
+	// one of their subdomains, are not mirrored.
+	ExcludeAuthorDomains []string
+	// ExcludeEmptyRefs excludes the references whose tip commit is empty: it
+	// has an empty tree or the same tree as its single parent.
+	ExcludeEmptyRefs bool
+	// PlaceholderPattern, when not empty, is a regular expression (e.g.
+	// '^Initial commit') excluding the references whose tip commit message
+	// matches it.
+	PlaceholderPattern string
+	// TagRetention, when not zero, restricts the mirrored tags to the
+	// TagRetention most recent ones. The older tags are pruned from the
+	// destination.
+	TagRetention int
+	// TagSemverRange, when not empty, restricts the mirrored tags to the ones
+	// named after a semantic version (e.g. 'v2.1.0') in the range (e.g.
+	// '>=2.0.0' or '^1.4.0 || ^2.0.0'). The tags not named after a semantic
+	// version are dropped unless KeepNonSemverTags is set. The filtered out
+	// tags are pruned from the destination.
+	TagSemverRange    string
+	KeepNonSemverTags bool
+	// MaxDstRefs, when not zero, is the maximum number of references the
+	// destination can have (e.g. a server-side cap). The mirror is aborted,
+	// before pushing, when the destination would have more.
+	MaxDstRefs int
+	// MinRefs, when not zero, is the minimum number of references the
+	// source needs to have, after the filters. The mirror is aborted, before
+	// pushing and pruning, when it has fewer, e.g. when the source is
+	// temporarily empty after an incident.
+	MinRefs int
+	// OnlyOnNewTag skips the mirror unless the source has tags that are not
+	// on the destination.
+	OnlyOnNewTag bool
+	// DialTimeout, when not zero, bounds the connection establishment to the
+	// HTTP(S) and SSH remotes, including the host name resolution. The SSH
+	// one only applies when an SSH key is configured.
+	DialTimeout time.Duration
+	// ListTimeout, when not zero, bounds listing the references of a remote
+	// (its reference advertisement) instead of the go-git default of 10
+	// seconds. The fetch and the push transfers are not bounded by it.
+	ListTimeout time.Duration
+	// ListRetries is the number of times a failed listing of the destination
+	// references (e.g. before the prune) is retried, separately from the
+	// push Retries. The delay between the attempts is the same.
+	ListRetries int
+	// MaxConnsPerHost, when not zero, caps the simultaneous HTTP(S)
+	// connections to a single host. The git operations themselves are run
+	// sequentially.
+	MaxConnsPerHost int
+	// RateLimit, when not zero, caps the transfer rate of the fetched and the
+	// pushed packs, in bytes per second, e.g. on a shared or metered link.
+	RateLimit int64
+	// LocalAddr, when set, is the local IP address the connections to the
+	// HTTP(S) and SSH remotes, including the host key scans, are made from,
+	// e.g. on a multi-homed host.
+	LocalAddr string
+	// UserAgent overrides the User-Agent header of the HTTP(S) requests.
+	UserAgent string
+	// FailOnRedirect fails the HTTP(S) requests that are redirected, which
+	// are otherwise followed. A redirect can hide an outdated repository URL.
+	FailOnRedirect bool
+	// ClientCert and ClientKey are the paths to the PEM encoded TLS client
+	// certificate and key used for HTTPS remotes requiring mutual TLS.
+	ClientCert string
+	ClientKey  string
+	// CAFile and CAContent provide, by file path or by content, PEM encoded
+	// CA certificates trusted for HTTPS remotes in addition to the system
+	// ones.
+	CAFile    string
+	CAContent string
+	// SrcCertPin, when set, is the SHA-256 fingerprint, in hexadecimal, of
+	// the TLS certificate the HTTPS source server must present. The
+	// connections to the source host fail when the certificate doesn't match,
+	// in addition to the usual certificate validation.
+	SrcCertPin string
+	// SyncMetadata copies the source repository description and default
+	// branch to the destination using the hosting provider API (GitHub or
+	// GitLab) after mirroring. GitHubToken and GitLabToken authenticate the
+	// API requests.
+	SyncMetadata bool
+	GitHubToken  string
+	GitLabToken  string
+	// CreateDefaultBranch pushes the source default branch, when synced with
+	// SyncMetadata, if it is missing from the destination (e.g. excluded by
+	// the filters). Otherwise, a missing default branch fails the metadata
+	// sync rather than leaving the destination HEAD dangling.
+	CreateDefaultBranch bool
+	// MirrorWiki also mirrors the wiki repository of the source (e.g.
+	// 'project.wiki.git' on GitHub and GitLab) to the one of the destination,
+	// after the repository itself. The wiki URLs are derived from the
+	// repository ones. The wiki mirror is skipped when the source has no
+	// wiki. The reference selection options don't apply to the wiki and the
+	// wiki changes are changes of the run.
+	MirrorWiki bool
+	// ProvenanceTag pushes, after a mirror that changed the destination, an
+	// annotated tag of the source HEAD named
+	// 'refs/mirror/synced/<timestamp>' that records when and from where the
+	// destination was mirrored. These tags are never pruned. The tags are
+	// signed with ProvenanceSignKey, an unencrypted ASCII armored OpenPGP
+	// private key, when set.
+	ProvenanceTag     bool
+	ProvenanceSignKey string
+	// Vault, when its address is set, provides the secrets from a HashiCorp
+	// Vault secret, read before mirroring, instead of environment variables.
+	Vault VaultConf
+	// FailOnNoChange makes the mirror operation return ErrNoChange when
+	// nothing was pushed or pruned, e.g. for a watchdog expecting changes.
+	// An up to date destination is a success otherwise.
+	FailOnNoChange bool
+	// DryRun logs the references that would be pushed and pruned, and checks
+	// the destination write access, without changing the destination.
+	DryRun bool
+	// PlanFile, when set, is the path of the file a dry run writes the
+	// refspecs it would push and delete to, for reviewing them.
+	PlanFile string
+	// ApplyPlan, when set, is the path of a plan file written by a dry run.
+	// Only its refspecs are pushed and deleted, instead of mirroring, and only
+	// when the source references still point to the hashes of the plan.
+	ApplyPlan string
+	// RunID identifies a mirror run in every log message, to correlate the
+	// interleaved logs of concurrent runs. DoMirror generates a random UUID
+	// when empty.
+	RunID string
+	// HistoryFile, when set, is the path of the file each mirror run is
+	// appended to, as a line of JSON with its time, source, destination,
+	// status and number of changed destination references.
+	HistoryFile string
+	// Changelog, when set, is the path of the Markdown file replaced after
+	// each mirror, other than a dry run, with the destination references
+	// added, updated (as commit ranges) and removed by the mirror.
+	Changelog string
+	// StatusFile, when set, is the path of the file replaced, atomically,
+	// after each mirror run with a JSON document of the run, with the same
+	// fields as the HistoryFile lines, for monitoring the last run.
+	StatusFile string
+	// OTLPEndpoint, when set, is the OTLP/HTTP endpoint (e.g.
+	// 'http://localhost:4318') each mirror run is traced to, with a span for
+	// the run and child spans for the fetch, the filter, the push and the
+	// prune. The run is a child of the span in the TRACEPARENT environment
+	// variable, when set.
+	OTLPEndpoint string
+	// LockFile, when set, is the path of a file locked for the duration of
+	// a mirror run so that the runs sharing it, e.g. to the same
+	// destination, don't overlap. A run fails with ErrLocked when another run
+	// holds the lock for longer than LockTimeout, which is zero by default.
+	LockFile    string
+	LockTimeout time.Duration
+	Debug       bool
+}
+
+// LoadConfig returns a configuration structure initialised from the JSON
+// document read from 'r'. Unknown fields are rejected. The lines starting
+// with '//' are comments.
+func LoadConfig(r io.Reader) (*Config, error) {
+	var conf Config
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(stripComments(data)))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&conf); err != nil {
+		return nil, fmt.Errorf("failed to parse the configuration: %w", err)
+	}
+
+	conf.mapDeprecated()
+
+	return &conf, nil
+}
+
+// stripComments blanks, with spaces, the lines of a JSON document starting
+// with '//' after whitespace. The offsets in the decoding errors still match
+// the document.
+func stripComments(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+
+	for _, line := range lines {
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("//")) {
+			continue
+		}
+
+		for i := range bytes.TrimRight(line, "\r\n") {
+			line[i] = ' '
+		}
+	}
+
+	return bytes.Join(lines, nil)
+}
+
+// mapDeprecated moves the values of the deprecated fields to the fields
+// replacing them, unless these are already set.
+func (conf *Config) mapDeprecated() {
+	if len(conf.Source.URL) == 0 {
+		conf.Source.URL = conf.SrcRepo
+	}
+
+	if len(conf.Destination.URL) == 0 {
+		conf.Destination.URL = conf.DstRepo
+	}
+
+	if conf.Destination.SSH == (SSHConf{}) {
+		conf.Destination.SSH = conf.SSH
+	}
+
+	conf.SrcRepo = ""
+	conf.DstRepo = ""
+	conf.SSH = SSHConf{}
+}
+
+// mapHostKeys shares the host public keys between the source and the
+// destination: a repository without host public keys uses the ones of the
+// other, so that a single known_hosts content, with entries for both hosts,
+// covers both.
+func (conf *Config) mapHostKeys() {
+	srcSSH := conf.Source.SSH
+
+	conf.Source.SSH.inheritHostKeys(conf.Destination.SSH)
+	conf.Destination.SSH.inheritHostKeys(srcSSH)
+}
+
+// inheritHostKeys sets the host public keys from another SSH configuration
+// unless some are already set or the host keys are scanned.
+func (sshConf *SSHConf) inheritHostKeys(from SSHConf) {
+	if len(sshConf.KnownHosts) != 0 || len(sshConf.KnownHostsPath) != 0 ||
+		sshConf.ScanHostKeys {
+		return
+	}
+
+	sshConf.KnownHosts = from.KnownHosts
+	sshConf.KnownHostsPath = from.KnownHostsPath
+}
+
+// readRefsFile reads a file with one reference or reference pattern per line.
+// Empty lines and lines starting with '#' are ignored.
+func readRefsFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the references file: %w", err)
+	}
+
+	var refs []string
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) != 0 && !strings.HasPrefix(line, "#") {
+			refs = append(refs, line)
+		}
+	}
+
+	return refs, nil
+}
+
+// loadRefsFiles adds the references of IncludeRefsFile and ExcludeRefsFile, when
+// set, to IncludeRefs and ExcludeRefs.
+func (conf *Config) loadRefsFiles() error {
+	if len(conf.IncludeRefsFile) != 0 {
+		refs, err := readRefsFile(conf.IncludeRefsFile)
+		if err != nil {
+			return err
+		}
+
+		conf.IncludeRefs = append(conf.IncludeRefs, refs...)
+	}
+
+	if len(conf.ExcludeRefsFile) != 0 {
+		refs, err := readRefsFile(conf.ExcludeRefsFile)
+		if err != nil {
+			return err
+		}
+
+		conf.ExcludeRefs = append(conf.ExcludeRefs, refs...)
+	}
+
+	return nil
+}
+
+// mapIncludeRefs sets the fetch and push refspecs to the ones of the
+// IncludeRefs references.
+func (conf *Config) mapIncludeRefs() {
+	if len(conf.IncludeRefs) == 0 {
+		return
+	}
+
+	specs := make([]string, 0, len(conf.IncludeRefs))
+	for _, ref := range conf.IncludeRefs {
+		specs = append(specs, "+"+ref+":"+ref)
+	}
+
+	conf.FetchRefSpecs = specs
+	conf.PushRefSpecs = specs
 }
 
-// GetSSHKey is the getter function for the private SSH key from a
+// GetSSHKey is the getter function for the destination private SSH key from a
 // configuration struct.
 func (conf Config) GetSSHKey() string {
-	return conf.SSH.PrivateKey
+	return conf.Destination.SSH.PrivateKey
 }
 
-// SetSSHKey is the setter function for the private SSH key from a
+// SetSSHKey is the setter function for the destination private SSH key from a
 // configuration struct.
 func (conf *Config) SetSSHKey(key string) {
-	conf.SSH.PrivateKey = key
+	conf.Destination.SSH.PrivateKey = key
 }
 
-// GetKnownHosts is the getter function for the public host key from a
-// configuration struct.
+// GetKnownHosts is the getter function for the destination public host key from
+// a configuration struct.
 func (conf Config) GetKnownHosts() string {
-	return conf.SSH.KnownHosts
+	return conf.Destination.SSH.KnownHosts
 }
 
-// GetKnownHosts is the setter function for the public host key from a
-// configuration struct.
+// GetKnownHosts is the setter function for the destination public host key from
+// a configuration struct.
 func (conf *Config) SetKnownHosts(key string) {
-	conf.SSH.KnownHosts = key
+	conf.Destination.SSH.KnownHosts = key
 }
 
-// GetKnownHostsPath is the getter function for the public host key by file
-// path from a configuration struct.
+// GetKnownHostsPath is the getter function for the destination public host key
+// by file path from a configuration struct.
 func (conf Config) GetKnownHostsPath() string {
-	return conf.SSH.KnownHostsPath
+	return conf.Destination.SSH.KnownHostsPath
 }
 
-// GetKnownHostsPath is the setter function for the public host key by file
-// path from a configuration struct.
+// GetKnownHostsPath is the setter function for the destination public host key
+// by file path from a configuration struct.
 func (conf *Config) SetKnownHostsPath(file string) {
-	conf.SSH.KnownHostsPath = file
+	conf.Destination.SSH.KnownHostsPath = file
+}
+
+// GetKeyDir is the getter function for the destination per host SSH private
+// keys directory from a configuration struct.
+func (conf Config) GetKeyDir() string {
+	return conf.Destination.SSH.KeyDir
+}
+
+// SetKeyDir is the setter function for the destination per host SSH private
+// keys directory from a configuration struct.
+func (conf *Config) SetKeyDir(dir string) {
+	conf.Destination.SSH.KeyDir = dir
 }
 
 // Pretty provides a string representation of the configuration structure. It
@@ -77,8 +663,17 @@ func (conf *Config) SetKnownHostsPath(file string) {
 func (conf Config) Pretty() string {
 	// It's important to have a passed by value conf struct to not have the
 	// masking affect the struct's actual values.
-	conf.SetSSHKey(mask(conf.SSH.PrivateKey))
-	conf.SetKnownHosts(mask(conf.SSH.KnownHosts))
+	for _, sshConf := range []*SSHConf{&conf.SSH, &conf.Source.SSH, &conf.Destination.SSH} {
+		sshConf.PrivateKey = mask(sshConf.PrivateKey)
+		sshConf.KnownHosts = mask(sshConf.KnownHosts)
+	}
+
+	conf.Source.HTTP.Password = mask(conf.Source.HTTP.Password)
+	conf.Destination.HTTP.Password = mask(conf.Destination.HTTP.Password)
+	conf.GitHubToken = mask(conf.GitHubToken)
+	conf.GitLabToken = mask(conf.GitLabToken)
+	conf.ProvenanceSignKey = mask(conf.ProvenanceSignKey)
+	conf.Vault.Token = mask(conf.Vault.Token)
 
 	out, err := json.MarshalIndent(conf, "", "\t")
 	if err != nil {
@@ -90,52 +685,418 @@ func (conf Config) Pretty() string {
 
 // ProcessEnv deals with environment configuration. It populates or overrides
 // configuration based on a map that models environment variables.
-func (conf *Config) ProcessEnv(logger *Logger, env map[string]string) {
+func (conf *Config) ProcessEnv(logger Logger, env map[string]string) {
+	conf.mapDeprecated()
+
 	// Fallback to environment variables for the source repository value.
-	if len(conf.SrcRepo) == 0 {
+	if len(conf.Source.URL) == 0 {
 		if src, srcSet := env["GMM_SRC_REPO"]; srcSet {
-			conf.SrcRepo = src
+			conf.Source.URL = src
 		} else {
 			url := env["GITHUB_SERVER_URL"]
 			repo := env["GITHUB_REPOSITORY"]
-			conf.SrcRepo = path.Join(url, repo)
+			conf.Source.URL = path.Join(url, repo)
 		}
 	}
 
 	// Fallback to environment variables for the destination repository value.
-	if len(conf.DstRepo) == 0 {
-		conf.DstRepo = env["GMM_DST_REPO"]
+	if len(conf.Destination.URL) == 0 {
+		conf.Destination.URL = env["GMM_DST_REPO"]
+	}
+
+	if key, keySet := env["GMM_SSH_PRIVATE_KEY"]; keySet {
+		conf.Destination.SSH.PrivateKey = key
+	}
+
+	if knownHosts, knownHostsSet := env["GMM_SSH_KNOWN_HOSTS"]; knownHostsSet {
+		conf.Destination.SSH.KnownHosts = knownHosts
+	}
+
+	if key, keySet := env["GMM_SRC_SSH_PRIVATE_KEY"]; keySet {
+		conf.Source.SSH.PrivateKey = key
+	}
+
+	if knownHosts, knownHostsSet := env["GMM_SRC_SSH_KNOWN_HOSTS"]; knownHostsSet {
+		conf.Source.SSH.KnownHosts = knownHosts
+	}
+
+	if password, passwordSet := env["GMM_SRC_HTTP_PASSWORD"]; passwordSet {
+		conf.Source.HTTP.Password = password
+	}
+
+	if password, passwordSet := env["GMM_DST_HTTP_PASSWORD"]; passwordSet {
+		conf.Destination.HTTP.Password = password
+	}
+
+	if ca, caSet := env["GMM_CA_CONTENT"]; caSet {
+		conf.CAContent = ca
+	}
+
+	if token, tokenSet := env["GMM_GITHUB_TOKEN"]; tokenSet {
+		conf.GitHubToken = token
+	}
+
+	if token, tokenSet := env["GMM_GITLAB_TOKEN"]; tokenSet {
+		conf.GitLabToken = token
 	}
 
-	conf.SSH.PrivateKey = env["GMM_SSH_PRIVATE_KEY"]
-	conf.SSH.KnownHosts = env["GMM_SSH_KNOWN_HOSTS"]
+	if key, keySet := env["GMM_PROVENANCE_SIGN_KEY"]; keySet {
+		conf.ProvenanceSignKey = key
+	}
 }
 
 // Validate provides the logic of validating a configuration.
-func (conf Config) Validate(logger *Logger) error {
-	if len(conf.SrcRepo) == 0 {
+func (conf Config) Validate(logger Logger) error {
+	conf.mapDeprecated()
+	conf.mapHostKeys()
+
+	if len(conf.Source.URL) == 0 {
 		return ErrNoSrc
 	}
 
-	logger.Info("Source repository:", conf.SrcRepo, ".")
+	logger.Info("Source repository:", conf.Source.URL, ".")
 
-	if len(conf.DstRepo) == 0 {
+	if len(conf.Destination.URL) == 0 {
 		return ErrNoDst
 	}
 
-	logger.Info("Destination repository:", conf.DstRepo, ".")
+	logger.Info("Destination repository:", conf.Destination.URL, ".")
+
+	if err := conf.validateDst(); err != nil {
+		return err
+	}
+
+	if conf.Retries < 0 || conf.ListRetries < 0 || conf.RetryBackoff < 0 ||
+		conf.RetryMaxBackoff < 0 {
+		return ErrRetries
+	}
+
+	if conf.ConfirmPruneThreshold < 0 {
+		return ErrThreshold
+	}
+
+	if conf.TagRetention < 0 {
+		return ErrTagRetention
+	}
+
+	if len(conf.TagSemverRange) != 0 {
+		if _, err := parseSemverRange(conf.TagSemverRange); err != nil {
+			return err
+		}
+	}
+
+	if conf.MaxDstRefs < 0 {
+		return ErrMaxDstRefs
+	}
+
+	if conf.MinRefs < 0 {
+		return ErrMinRefs
+	}
+
+	if conf.LockTimeout < 0 {
+		return ErrLockTimeout
+	}
+
+	if (len(conf.ClientCert) == 0) != (len(conf.ClientKey) == 0) {
+		return ErrClientCert
+	}
+
+	if len(conf.ObjectFormat) != 0 && conf.ObjectFormat != objectFormatSHA1 {
+		return fmt.Errorf("%w: %q", ErrObjectFormat, conf.ObjectFormat)
+	}
+
+	if _, err := regexp.Compile(conf.PlaceholderPattern); err != nil {
+		return fmt.Errorf("%w: %s", ErrPlaceholderPattern, err)
+	}
+
+	if conf.DialTimeout < 0 {
+		return ErrDialTimeout
+	}
+
+	if conf.ListTimeout < 0 {
+		return ErrListTimeout
+	}
+
+	if conf.MaxConnsPerHost < 0 {
+		return ErrMaxConnsPerHost
+	}
+
+	if conf.RateLimit < 0 {
+		return ErrRateLimit
+	}
+
+	if len(conf.LocalAddr) != 0 && net.ParseIP(conf.LocalAddr) == nil {
+		return fmt.Errorf("%w: %q", ErrLocalAddr, conf.LocalAddr)
+	}
+
+	if conf.MaxPackSize < 0 {
+		return ErrMaxPackSize
+	}
+
+	if len(conf.RefGroups) != 0 && (conf.IndividualPush || conf.MaxPackSize > 0) {
+		return ErrRefGroups
+	}
+
+	if conf.InitDestination && len(localPath(conf.Destination.URL)) == 0 {
+		return ErrInitDestination
+	}
+
+	if conf.RepackDestination && len(localPath(conf.Destination.URL)) == 0 {
+		return ErrRepackDestination
+	}
+
+	if conf.WriteCommitGraph && len(localPath(conf.Destination.URL)) == 0 {
+		return ErrWriteCommitGraph
+	}
 
-	if len(conf.GetSSHKey()) == 0 {
+	switch conf.SymbolicRefs {
+	case "", symbolicRefsResolve, symbolicRefsSkip:
+	case symbolicRefsRecreate:
+		if len(localPath(conf.Destination.URL)) == 0 {
+			return ErrRecreateSymbolicRefs
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrSymbolicRefs, conf.SymbolicRefs)
+	}
+
+	switch conf.SanitizeRefNames {
+	case "", sanitizeRefNamesDrop, sanitizeRefNamesRename:
+	default:
+		return fmt.Errorf("%w: %q", ErrSanitizeRefNames, conf.SanitizeRefNames)
+	}
+
+	// The replacement is a valid part of a reference name component.
+	if len(conf.RefNameReplacement) != 0 &&
+		(strings.ContainsAny(conf.RefNameReplacement, "./@") ||
+			!validRefName(conf.RefNameReplacement)) {
+		return fmt.Errorf("%w: %q", ErrRefNameReplacement, conf.RefNameReplacement)
+	}
+
+	if (len(conf.PlanFile) != 0 && !conf.DryRun) ||
+		(len(conf.ApplyPlan) != 0 && conf.DryRun) {
+		return ErrPlanFile
+	}
+
+	if len(conf.CAFile) != 0 && len(conf.CAContent) != 0 {
+		return ErrCABundle
+	}
+
+	if err := conf.validateSrcCertPin(); err != nil {
+		return err
+	}
+
+	if err := validateOTLPEndpoint(conf.OTLPEndpoint); err != nil {
+		return err
+	}
+
+	if err := conf.validateMetadata(); err != nil {
+		return err
+	}
+
+	if len(conf.ProvenanceSignKey) != 0 {
+		if _, err := provenanceSignKey(conf.ProvenanceSignKey); err != nil {
+			return err
+		}
+	}
+
+	if err := conf.Vault.validate(); err != nil {
+		return err
+	}
+
+	if err := conf.loadRefsFiles(); err != nil {
+		return err
+	}
+
+	if err := conf.validateIncludeRefs(); err != nil {
+		return err
+	}
+
+	if len(conf.BranchAllowlistFile) != 0 {
+		if _, err := readBranchAllowlist(conf.BranchAllowlistFile); err != nil {
+			return err
+		}
+	}
+
+	if err := validateRefSpecs(conf.FetchRefSpecs); err != nil {
+		return err
+	}
+
+	if err := validateRefSpecs(conf.PushRefSpecs); err != nil {
+		return err
+	}
+
+	// With Vault, the SSH keys and host public keys can be secrets read from
+	// it, so the SSH configurations are only checked, by DoMirror, once they
+	// are read.
+	if len(conf.Vault.Address) != 0 {
+		return nil
+	}
+
+	if !conf.Source.hasAuth() && !conf.Destination.hasAuth() {
 		logger.Warn("Tool configured with no authentication.")
-	} else {
-		if len(conf.GetKnownHosts()) != 0 &&
-			len(conf.GetKnownHostsPath()) != 0 {
-			return ErrHostKey
-		} else if len(conf.GetKnownHosts()) == 0 &&
-			len(conf.GetKnownHostsPath()) == 0 {
-			return ErrNoHostKey
+	}
+
+	return conf.validateSSH()
+}
+
+// validateSSH checks the SSH configurations of the source and the
+// destination.
+func (conf Config) validateSSH() error {
+	if err := conf.Source.SSH.validate(); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+
+	if err := conf.Destination.SSH.validate(); err != nil {
+		return fmt.Errorf("destination: %w", err)
+	}
+
+	return nil
+}
+
+// hasAuth checks if authentication is configured for a repository.
+func (repo RepoConf) hasAuth() bool {
+	return len(repo.SSH.PrivateKey) != 0 || len(repo.SSH.KeyDir) != 0 ||
+		len(repo.SSH.Command) != 0 || len(repo.HTTP.Username) != 0 ||
+		len(repo.HTTP.Password) != 0
+}
+
+// validate checks that the host public keys are provided, only once, when SSH
+// authentication is configured, that a jump host is used with SSH
+// authentication and that an SSH command is used on its own.
+func (sshConf SSHConf) validate() error {
+	if len(sshConf.Command) != 0 {
+		if len(sshConf.PrivateKey) != 0 || len(sshConf.KeyDir) != 0 ||
+			sshConf.ScanHostKeys || len(sshConf.JumpHost) != 0 {
+			return ErrSSHCommand
+		}
+
+		return nil
+	}
+
+	if len(sshConf.JumpHost) != 0 {
+		if _, _, err := parseJumpHost(sshConf.JumpHost); err != nil {
+			return err
+		}
+
+		if (len(sshConf.PrivateKey) == 0 && len(sshConf.KeyDir) == 0) ||
+			sshConf.ScanHostKeys {
+			return ErrJumpHostAuth
 		}
 	}
 
+	if len(sshConf.PrivateKey) == 0 && len(sshConf.KeyDir) == 0 {
+		return nil
+	}
+
+	if len(sshConf.KnownHosts) != 0 && len(sshConf.KnownHostsPath) != 0 {
+		return ErrHostKey
+	} else if len(sshConf.KnownHosts) == 0 && len(sshConf.KnownHostsPath) == 0 &&
+		!sshConf.ScanHostKeys {
+		return ErrNoHostKey
+	}
+
 	return nil
 }
+
+// validateRefSpecs checks that all the refspecs parse.
+func validateRefSpecs(specs []string) error {
+	for _, spec := range specs {
+		if err := config.RefSpec(spec).Validate(); err != nil {
+			return fmt.Errorf("%w %q: %s", ErrRefSpec, spec, err)
+		}
+	}
+
+	return nil
+}
+
+// validateIncludeRefs checks that the included references are full reference
+// names and that they are not combined with refspecs.
+func (conf Config) validateIncludeRefs() error {
+	if len(conf.IncludeRefs) == 0 {
+		return nil
+	}
+
+	if len(conf.FetchRefSpecs) != 0 || len(conf.PushRefSpecs) != 0 {
+		return ErrIncludeRefs
+	}
+
+	for _, ref := range conf.IncludeRefs {
+		if !strings.HasPrefix(ref, "refs/") || strings.ContainsAny(ref, "*:") {
+			return fmt.Errorf("%w: %q is not a full reference name", ErrRefSpec, ref)
+		}
+	}
+
+	return nil
+}
+
+// validateMetadata checks that the repositories are supported for syncing the
+// metadata, when enabled, and that the destination API token is provided.
+func (conf Config) validateMetadata() error {
+	if !conf.SyncMetadata {
+		return nil
+	}
+
+	for _, repo := range []string{conf.Source.URL, conf.Destination.URL} {
+		if _, _, err := newMetadataProvider(conf, repo); err != nil {
+			return err
+		}
+	}
+
+	if len(metadataToken(conf, conf.Destination.URL)) == 0 {
+		return ErrNoMetadataToken
+	}
+
+	return nil
+}
+
+// validateSrcCertPin checks that the source certificate pin, when set, is a
+// SHA-256 fingerprint and that the source repository is fetched over HTTPS.
+func (conf Config) validateSrcCertPin() error {
+	if len(conf.SrcCertPin) == 0 {
+		return nil
+	}
+
+	if _, err := parseCertPin(conf.SrcCertPin); err != nil {
+		return err
+	}
+
+	endpoint, err := transport.NewEndpoint(conf.Source.URL)
+	if err != nil || endpoint.Protocol != "https" {
+		return ErrSrcCertPinURL
+	}
+
+	return nil
+}
+
+// validateDst checks that the source and the destination are different
+// repositories and that the destination host is allowed. Besides Validate,
+// every mirror run checks it so that the library runs, the destinations of
+// DoMirrors and the wiki mirrors are covered too.
+func (conf Config) validateDst() error {
+	if normalizedURL(conf.Source.URL) == normalizedURL(conf.Destination.URL) {
+		return ErrSameRepo
+	}
+
+	return conf.validateDstHost()
+}
+
+// validateDstHost checks that the destination repository host is allowed by
+// the configuration.
+func (conf Config) validateDstHost() error {
+	if len(conf.AllowedDstHosts) == 0 {
+		return nil
+	}
+
+	endpoint, err := transport.NewEndpoint(conf.Destination.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse the destination repository: %w", err)
+	}
+
+	for _, host := range conf.AllowedDstHosts {
+		if strings.EqualFold(host, endpoint.Host) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q", ErrDstHostNotAllowed, endpoint.Host)
+}
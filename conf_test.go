@@ -5,8 +5,14 @@
 package mirror
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 const (
@@ -14,19 +20,105 @@ const (
 	testKnownHostsPath = "setkeypath"
 )
 
+// TestLoadConfig tests loading a configuration from a JSON document.
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	{
+		conf, err := LoadConfig(strings.NewReader(`{
+			"Source": {"URL": "src", "HTTP": {"Username": "user"}},
+			"Destination": {"URL": "dst", "SSH": {"KnownHostsPath": "khpath"}},
+			"Retries": 2
+		}`))
+		if err != nil {
+			t.Fatalf("failed to load configuration: %s", err)
+		}
+		if conf.Source.URL != "src" || conf.Source.HTTP.Username != "user" ||
+			conf.Destination.URL != "dst" ||
+			conf.Destination.SSH.KnownHostsPath != "khpath" || conf.Retries != 2 {
+			t.Fatalf("unexpected configuration: %s", conf.Pretty())
+		}
+	}
+	{
+		// The deprecated fields are mapped to the source and destination.
+		conf, err := LoadConfig(strings.NewReader(`{
+			"SrcRepo": "src",
+			"DstRepo": "dst",
+			"SSH": {"KnownHostsPath": "khpath"},
+			"Retries": 2
+		}`))
+		if err != nil {
+			t.Fatalf("failed to load configuration: %s", err)
+		}
+		if conf.Source.URL != "src" || conf.Destination.URL != "dst" ||
+			conf.Destination.SSH.KnownHostsPath != "khpath" || conf.Retries != 2 ||
+			conf.SrcRepo != "" || conf.DstRepo != "" || conf.SSH != (SSHConf{}) {
+			t.Fatalf("unexpected configuration: %s", conf.Pretty())
+		}
+	}
+	{
+		// The deprecated fields don't override the new ones.
+		conf, err := LoadConfig(strings.NewReader(`{
+			"Source": {"URL": "src"},
+			"SrcRepo": "oldsrc"
+		}`))
+		if err != nil {
+			t.Fatalf("failed to load configuration: %s", err)
+		}
+		if conf.Source.URL != "src" {
+			t.Fatalf("deprecated field overrode the source: %s", conf.Pretty())
+		}
+	}
+	{
+		// The lines starting with '//' are comments, the other ones are not.
+		conf, err := LoadConfig(strings.NewReader(`{
+			// The source.
+			"Source": {"URL": "http://host//src"},
+		    	// "Retries": 2,
+			"Destination": {"URL": "dst"}
+		}`))
+		if err != nil {
+			t.Fatalf("failed to load configuration: %s", err)
+		}
+		if conf.Source.URL != "http://host//src" || conf.Destination.URL != "dst" ||
+			conf.Retries != 0 {
+			t.Fatalf("unexpected configuration: %s", conf.Pretty())
+		}
+
+		if _, err := LoadConfig(strings.NewReader(`{"Retries": 2 // comment
+		}`)); err == nil {
+			t.Fatal("trailing comment was allowed")
+		}
+	}
+	{
+		// Unknown fields are rejected.
+		if _, err := LoadConfig(strings.NewReader(`{"Foo": "bar"}`)); err == nil {
+			t.Fatal("unknown configuration field was allowed")
+		}
+	}
+	{
+		// Invalid documents are rejected.
+		if _, err := LoadConfig(strings.NewReader(`{`)); err == nil {
+			t.Fatal("invalid configuration was allowed")
+		}
+	}
+}
+
 // TestSetGetSSHKey tests the getter and setter for the SSH private key.
 func TestSetGetSSHKey(t *testing.T) {
 	t.Parallel()
 
 	config := Config{
-		SSH: SSHConf{
-			PrivateKey: "key",
+		Destination: RepoConf{
+			SSH: SSHConf{
+				PrivateKey: "key",
+			},
 		},
 	}
 
 	config.SetSSHKey(testPrivateKey)
 
-	if config.SSH.PrivateKey != testPrivateKey {
+	if config.Destination.SSH.PrivateKey != testPrivateKey {
 		t.Fatal("ssh key setter failed")
 	}
 
@@ -40,14 +132,16 @@ func TestSetGetKnownHosts(t *testing.T) {
 	t.Parallel()
 
 	config := Config{
-		SSH: SSHConf{
-			KnownHosts: "key",
+		Destination: RepoConf{
+			SSH: SSHConf{
+				KnownHosts: "key",
+			},
 		},
 	}
 
 	config.SetKnownHosts(testPrivateKey)
 
-	if config.SSH.KnownHosts != testPrivateKey {
+	if config.Destination.SSH.KnownHosts != testPrivateKey {
 		t.Fatal("host key (by value) setter failed")
 	}
 
@@ -62,14 +156,16 @@ func TestSetGetKnownHostsPath(t *testing.T) {
 	t.Parallel()
 
 	config := Config{
-		SSH: SSHConf{
-			KnownHostsPath: "keypath",
+		Destination: RepoConf{
+			SSH: SSHConf{
+				KnownHostsPath: "keypath",
+			},
 		},
 	}
 
 	config.SetKnownHostsPath(testKnownHostsPath)
 
-	if config.SSH.KnownHostsPath != testKnownHostsPath {
+	if config.Destination.SSH.KnownHostsPath != testKnownHostsPath {
 		t.Fatal("host key (by file path) setter failed")
 	}
 
@@ -78,29 +174,227 @@ func TestSetGetKnownHostsPath(t *testing.T) {
 	}
 }
 
+// TestSetGetKeyDir tests the getter and setter for the per host SSH private
+// keys directory.
+func TestSetGetKeyDir(t *testing.T) {
+	t.Parallel()
+
+	config := Config{
+		Destination: RepoConf{
+			SSH: SSHConf{
+				KeyDir: "dir",
+			},
+		},
+	}
+
+	config.SetKeyDir("setdir")
+
+	if config.Destination.SSH.KeyDir != "setdir" {
+		t.Fatal("key directory setter failed")
+	}
+
+	if config.GetKeyDir() != "setdir" {
+		t.Fatal("key directory getter failed")
+	}
+}
+
+// TestLoadRefsFiles tests reading the included and excluded references from
+// files.
+func TestLoadRefsFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	includePath := filepath.Join(dir, "include")
+	excludePath := filepath.Join(dir, "exclude")
+
+	if err := os.WriteFile(includePath, []byte("refs/heads/main\n\n"+
+		"# release branches\n  refs/heads/release  \n"), 0o644); err != nil {
+		t.Fatalf("failed to write the include file: %s", err)
+	}
+
+	if err := os.WriteFile(excludePath, []byte("refs/heads/wip-*\n"), 0o644); err != nil {
+		t.Fatalf("failed to write the exclude file: %s", err)
+	}
+
+	conf := Config{
+		IncludeRefs:     []string{"refs/tags/v1"},
+		IncludeRefsFile: includePath,
+		ExcludeRefs:     []string{"refs/tmp/*"},
+		ExcludeRefsFile: excludePath,
+	}
+
+	if err := conf.loadRefsFiles(); err != nil {
+		t.Fatalf("loadRefsFiles failed: %s", err)
+	}
+
+	if !cmp.Equal(conf.IncludeRefs, []string{
+		"refs/tags/v1", "refs/heads/main", "refs/heads/release",
+	}) || !cmp.Equal(conf.ExcludeRefs, []string{"refs/tmp/*", "refs/heads/wip-*"}) {
+		t.Fatalf("unexpected references: %s", conf.Pretty())
+	}
+
+	conf = Config{ExcludeRefsFile: filepath.Join(dir, "missing")}
+	if err := conf.loadRefsFiles(); err == nil {
+		t.Fatal("loading a missing references file passed")
+	}
+}
+
 // TestPretty tests the pretty output of a configuration structure.
 func TestPretty(t *testing.T) {
 	t.Parallel()
 
 	// This also verifies that the sensitive fields are masked.
 	out := Config{
-		SrcRepo: "src",
-		DstRepo: "dst",
-		SSH: SSHConf{
-			PrivateKey:     "key",
-			KnownHosts:     "khkey",
-			KnownHostsPath: "khpath",
+		Source: RepoConf{
+			URL:  "src",
+			HTTP: HTTPAuthConf{Username: "user", Password: "password"},
+		},
+		Destination: RepoConf{
+			URL: "dst",
+			SSH: SSHConf{
+				PrivateKey:     "key",
+				KnownHosts:     "khkey",
+				KnownHostsPath: "khpath",
+			},
 		},
 		Debug: true,
 	}.Pretty()
 	expectedOut := `{
-	"SrcRepo": "src",
-	"DstRepo": "dst",
+	"Source": {
+		"URL": "src",
+		"SSH": {
+			"PrivateKey": "",
+			"KnownHosts": "",
+			"KnownHostsPath": "",
+			"KeyDir": "",
+			"ScanHostKeys": false,
+			"HostKeyFingerprint": "",
+			"JumpHost": "",
+			"Command": ""
+		},
+		"HTTP": {
+			"Username": "user",
+			"Password": "5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8"
+		}
+	},
+	"Destination": {
+		"URL": "dst",
+		"SSH": {
+			"PrivateKey": "2c70e12b7a0646f92279f427c7b38e7334d8e5389cff167a1dc30e73f826b683",
+			"KnownHosts": "b3f1ba1ea27e621a8cab09c9e601097fd84c3c438dee43d9ee7b0efe8cfd0ecd",
+			"KnownHostsPath": "khpath",
+			"KeyDir": "",
+			"ScanHostKeys": false,
+			"HostKeyFingerprint": "",
+			"JumpHost": "",
+			"Command": ""
+		},
+		"HTTP": {
+			"Username": "",
+			"Password": ""
+		}
+	},
+	"SrcRepo": "",
+	"DstRepo": "",
 	"SSH": {
-		"PrivateKey": "2c70e12b7a0646f92279f427c7b38e7334d8e5389cff167a1dc30e73f826b683",
-		"KnownHosts": "b3f1ba1ea27e621a8cab09c9e601097fd84c3c438dee43d9ee7b0efe8cfd0ecd",
-		"KnownHostsPath": "khpath"
+		"PrivateKey": "",
+		"KnownHosts": "",
+		"KnownHostsPath": "",
+		"KeyDir": "",
+		"ScanHostKeys": false,
+		"HostKeyFingerprint": "",
+		"JumpHost": "",
+		"Command": ""
+	},
+	"InitDestination": false,
+	"RepackDestination": false,
+	"WriteCommitGraph": false,
+	"ObjectFormat": "",
+	"StagingBranch": "",
+	"ObjectCache": "",
+	"SymbolicRefs": "",
+	"SanitizeRefNames": "",
+	"RefNameReplacement": "",
+	"Retries": 0,
+	"RetryBackoff": 0,
+	"RetryMaxBackoff": 0,
+	"AllowedDstHosts": null,
+	"VerifyFetch": false,
+	"PreMirrorCheck": "",
+	"SkipPruneOnPartialFetch": false,
+	"SkipUnreadableRefs": false,
+	"DstRefsCache": "",
+	"PackWindow": 0,
+	"PostCloneVerify": false,
+	"FastForwardOnly": false,
+	"ForceRefs": null,
+	"ReplacePlaceholder": false,
+	"DetectRewrites": false,
+	"FailOnRewrites": false,
+	"ConfirmPrune": false,
+	"ConfirmPruneThreshold": 0,
+	"AssumeYes": false,
+	"PreserveRefs": null,
+	"DeleteRefs": null,
+	"FetchRefSpecs": null,
+	"PushRefSpecs": null,
+	"IncludeRefs": null,
+	"IncludeRefsFile": "",
+	"ExcludeRefs": null,
+	"ExcludeRefsFile": "",
+	"BranchAllowlistFile": "",
+	"IndividualPush": false,
+	"MaxPackSize": 0,
+	"RefGroups": null,
+	"OnlyReachableFrom": null,
+	"ExcludeAuthorDomains": null,
+	"ExcludeEmptyRefs": false,
+	"PlaceholderPattern": "",
+	"TagRetention": 0,
+	"TagSemverRange": "",
+	"KeepNonSemverTags": false,
+	"MaxDstRefs": 0,
+	"MinRefs": 0,
+	"OnlyOnNewTag": false,
+	"DialTimeout": 0,
+	"ListTimeout": 0,
+	"ListRetries": 0,
+	"MaxConnsPerHost": 0,
+	"RateLimit": 0,
+	"LocalAddr": "",
+	"UserAgent": "",
+	"FailOnRedirect": false,
+	"ClientCert": "",
+	"ClientKey": "",
+	"CAFile": "",
+	"CAContent": "",
+	"SrcCertPin": "",
+	"SyncMetadata": false,
+	"GitHubToken": "",
+	"GitLabToken": "",
+	"CreateDefaultBranch": false,
+	"MirrorWiki": false,
+	"ProvenanceTag": false,
+	"ProvenanceSignKey": "",
+	"Vault": {
+		"Address": "",
+		"Token": "",
+		"TokenPath": "",
+		"RoleID": "",
+		"SecretIDPath": "",
+		"SecretPath": ""
 	},
+	"FailOnNoChange": false,
+	"DryRun": false,
+	"PlanFile": "",
+	"ApplyPlan": "",
+	"RunID": "",
+	"HistoryFile": "",
+	"Changelog": "",
+	"StatusFile": "",
+	"OTLPEndpoint": "",
+	"LockFile": "",
+	"LockTimeout": 0,
 	"Debug": true
 }`
 
@@ -125,7 +419,7 @@ func TestProcessEnv(t *testing.T) {
 			"GMM_SRC_REPO": "srcenv",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SrcRepo != "srcenv" {
+		if conf.Source.URL != "srcenv" {
 			t.Fatal("failed setting source repository from an env variable")
 		}
 	}
@@ -137,20 +431,20 @@ func TestProcessEnv(t *testing.T) {
 			"GITHUB_REPOSITORY": "bar",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SrcRepo != "foo/bar" {
+		if conf.Source.URL != "foo/bar" {
 			t.Fatal("failed setting source repository from GitHub env variables")
 		}
 	}
 	{
 		// Environment variables don't override existing source configuration.
-		conf := Config{SrcRepo: "src"}
+		conf := Config{Source: RepoConf{URL: "src"}}
 		env := map[string]string{
 			"GMM_SRC_REPO":      "srcenv",
 			"GITHUB_SERVER_URL": "foo",
 			"GITHUB_REPOSITORY": "bar",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SrcRepo != "src" {
+		if conf.Source.URL != "src" {
 			t.Fatal("env variables override existing configuration for the src " +
 				"repo")
 		}
@@ -165,7 +459,7 @@ func TestProcessEnv(t *testing.T) {
 			"GITHUB_REPOSITORY": "bar",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SrcRepo != "srcenv" {
+		if conf.Source.URL != "srcenv" {
 			t.Fatal("source repository priority for env variables failed")
 		}
 	}
@@ -176,19 +470,19 @@ func TestProcessEnv(t *testing.T) {
 			"GMM_DST_REPO": "dstenv",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.DstRepo != "dstenv" {
+		if conf.Destination.URL != "dstenv" {
 			t.Fatal("failed setting destination repository from an env " +
 				"variable")
 		}
 	}
 	{
 		// Environment variables don't override existing source configuration.
-		conf := Config{DstRepo: "dst"}
+		conf := Config{Destination: RepoConf{URL: "dst"}}
 		env := map[string]string{
 			"GMM_DST_REPO": "dstenv",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.DstRepo != "dst" {
+		if conf.Destination.URL != "dst" {
 			t.Fatal("env variables override existing configuration for the " +
 				"destination repository")
 		}
@@ -200,7 +494,7 @@ func TestProcessEnv(t *testing.T) {
 			"GMM_SSH_PRIVATE_KEY": "keyenv",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SSH.PrivateKey != "keyenv" {
+		if conf.Destination.SSH.PrivateKey != "keyenv" {
 			t.Fatal("failed setting SSH private key from an env variable")
 		}
 	}
@@ -211,10 +505,74 @@ func TestProcessEnv(t *testing.T) {
 			"GMM_SSH_KNOWN_HOSTS": "khkeyenv",
 		}
 		conf.ProcessEnv(logger, env)
-		if conf.SSH.KnownHosts != "khkeyenv" {
+		if conf.Destination.SSH.KnownHosts != "khkeyenv" {
 			t.Fatal("failed setting host key from an env variable")
 		}
 	}
+	{
+		// Populating the source authentication and the HTTP passwords from
+		// environment variables.
+		conf := Config{}
+		env := map[string]string{
+			"GMM_SRC_SSH_PRIVATE_KEY": "srckeyenv",
+			"GMM_SRC_SSH_KNOWN_HOSTS": "srckhkeyenv",
+			"GMM_SRC_HTTP_PASSWORD":   "srcpassenv",
+			"GMM_DST_HTTP_PASSWORD":   "dstpassenv",
+		}
+		conf.ProcessEnv(logger, env)
+		if conf.Source.SSH.PrivateKey != "srckeyenv" ||
+			conf.Source.SSH.KnownHosts != "srckhkeyenv" ||
+			conf.Source.HTTP.Password != "srcpassenv" ||
+			conf.Destination.HTTP.Password != "dstpassenv" {
+			t.Fatal("failed setting the source authentication and the HTTP " +
+				"passwords from env variables")
+		}
+	}
+	{
+		// Populating the CA bundle from an environment variable.
+		conf := Config{}
+		env := map[string]string{
+			"GMM_CA_CONTENT": "caenv",
+		}
+		conf.ProcessEnv(logger, env)
+		if conf.CAContent != "caenv" {
+			t.Fatal("failed setting the CA bundle from an env variable")
+		}
+	}
+	{
+		// Populating the API tokens from environment variables.
+		conf := Config{}
+		env := map[string]string{
+			"GMM_GITHUB_TOKEN": "ghtoken",
+			"GMM_GITLAB_TOKEN": "gltoken",
+		}
+		conf.ProcessEnv(logger, env)
+		if conf.GitHubToken != "ghtoken" || conf.GitLabToken != "gltoken" {
+			t.Fatal("failed setting the API tokens from env variables")
+		}
+	}
+	{
+		// Test setting the provenance sign key via environment variables.
+		conf := Config{}
+		conf.ProcessEnv(logger, map[string]string{
+			"GMM_PROVENANCE_SIGN_KEY": "signkey",
+		})
+		if conf.ProvenanceSignKey != "signkey" {
+			t.Fatal("failed setting the provenance sign key from env variables")
+		}
+	}
+	{
+		// Unset SSH environment variables don't reset existing configuration.
+		conf := Config{
+			Destination: RepoConf{
+				SSH: SSHConf{PrivateKey: "key", KnownHosts: "khkey"},
+			},
+		}
+		conf.ProcessEnv(logger, map[string]string{})
+		if conf.Destination.SSH.PrivateKey != "key" || conf.Destination.SSH.KnownHosts != "khkey" {
+			t.Fatal("unset env variables reset the SSH configuration")
+		}
+	}
 }
 
 // TestValidate tests various valid/invalid configurations.
@@ -232,13 +590,13 @@ func TestValidate(t *testing.T) {
 		if err := conf.Validate(logger); err == nil {
 			t.Fatal("source repository was not required")
 		}
-		conf.SrcRepo = "src"
+		conf.Source.URL = "src"
 		if err := conf.Validate(logger); err == nil {
 			t.Fatal("destination repository was not required")
 		}
 		conf = Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
 		}
 		if err := conf.Validate(logger); err != nil {
 			// This also tests that no authentication is allowed.
@@ -248,10 +606,12 @@ func TestValidate(t *testing.T) {
 	{
 		// SSH private key configration requires host key configuration.
 		conf := Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
-			SSH: SSHConf{
-				PrivateKey: "key",
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey: "key",
+				},
 			},
 		}
 		if err := conf.Validate(logger); err == nil {
@@ -259,15 +619,67 @@ func TestValidate(t *testing.T) {
 				"configuration")
 		}
 	}
+	{
+		// SSH keys directory configuration requires host key configuration.
+		conf := Config{
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					KeyDir: "dir",
+				},
+			},
+		}
+		if err := conf.Validate(logger); err == nil {
+			t.Fatal("SSH keys directory configuration didn't require host key " +
+				"configuration")
+		}
+	}
+	{
+		// Scanning the host keys replaces the host key configuration.
+		conf := Config{
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey:   "key",
+					ScanHostKeys: true,
+				},
+			},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("SSH key configuration with host key scanning failed: %s",
+				err)
+		}
+	}
+	{
+		// The source uses the destination host public keys when it has none.
+		conf := Config{
+			Source: RepoConf{
+				URL: "src",
+				SSH: SSHConf{PrivateKey: "srckey"},
+			},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{KnownHosts: "hosts"},
+			},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("source SSH key with the destination host keys failed: %s",
+				err)
+		}
+	}
 	{
 		// Test that Validate works when both SSH key and host public key are
 		// provided.
 		conf := Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
-			SSH: SSHConf{
-				PrivateKey: "key",
-				KnownHosts: "khkey",
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey: "key",
+					KnownHosts: "khkey",
+				},
 			},
 		}
 		if err := conf.Validate(logger); err != nil {
@@ -278,12 +690,14 @@ func TestValidate(t *testing.T) {
 		// Host key configurations as value and file path are mutually
 		// exclusive.
 		conf := Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
-			SSH: SSHConf{
-				PrivateKey:     "key",
-				KnownHosts:     "khkey",
-				KnownHostsPath: "khpath",
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey:     "key",
+					KnownHosts:     "khkey",
+					KnownHostsPath: "khpath",
+				},
 			},
 		}
 		if err := conf.Validate(logger); err == nil {
@@ -293,29 +707,563 @@ func TestValidate(t *testing.T) {
 	{
 		// Allow host key provided by value.
 		conf := Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
-			SSH: SSHConf{
-				PrivateKey: "key",
-				KnownHosts: "khkey",
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey: "key",
+					KnownHosts: "khkey",
+				},
 			},
 		}
 		if err := conf.Validate(logger); err != nil {
 			t.Fatal("host key provided by value was not allowed")
 		}
 	}
+	{
+		// A jump host needs to be valid and used with SSH authentication and
+		// provided host keys.
+		conf := Config{
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey: "key",
+					KnownHosts: "khkey",
+					JumpHost:   "user@bastion",
+				},
+			},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("valid jump host failed: %s", err)
+		}
+		conf.Destination.SSH.JumpHost = "bastion"
+		if err := conf.Validate(logger); !errors.Is(err, ErrJumpHost) {
+			t.Fatalf("unexpected error for a jump host without user: %v", err)
+		}
+		conf.Destination.SSH = SSHConf{JumpHost: "user@bastion"}
+		if err := conf.Validate(logger); !errors.Is(err, ErrJumpHostAuth) {
+			t.Fatalf("unexpected error for a jump host without a key: %v", err)
+		}
+		conf.Destination.SSH = SSHConf{
+			PrivateKey:   "key",
+			ScanHostKeys: true,
+			JumpHost:     "user@bastion",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrJumpHostAuth) {
+			t.Fatalf("unexpected error for a jump host with scanned keys: %v", err)
+		}
+	}
+	{
+		// An SSH command can't be combined with the built-in SSH settings.
+		conf := Config{
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "git@example.com:foo/bar.git",
+				SSH: SSHConf{Command: "ssh -F ssh_config"},
+			},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("valid SSH command failed: %s", err)
+		}
+		conf.Destination.SSH.PrivateKey = "key"
+		if err := conf.Validate(logger); !errors.Is(err, ErrSSHCommand) {
+			t.Fatalf("unexpected error for an SSH command with a key: %v", err)
+		}
+	}
+	{
+		// The invalid reference names handling needs to be supported.
+		conf := Config{
+			Source:           RepoConf{URL: "src"},
+			Destination:      RepoConf{URL: "dst"},
+			SanitizeRefNames: "fix",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrSanitizeRefNames) {
+			t.Fatalf("unexpected error for ref names sanitization: %v", err)
+		}
+		conf.SanitizeRefNames = sanitizeRefNamesRename
+		for _, replacement := range []string{".", "/", "a b", "@"} {
+			conf.RefNameReplacement = replacement
+			if err := conf.Validate(logger); !errors.Is(err, ErrRefNameReplacement) {
+				t.Fatalf("unexpected error for replacement %q: %v", replacement, err)
+			}
+		}
+		conf.RefNameReplacement = "-"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("unexpected error for a valid replacement: %v", err)
+		}
+	}
+	{
+		// The symbolic references handling needs to be supported.
+		conf := Config{
+			Source:       RepoConf{URL: "src"},
+			Destination:  RepoConf{URL: "dst"},
+			SymbolicRefs: "follow",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrSymbolicRefs) {
+			t.Fatalf("unexpected error for symbolic refs handling: %v", err)
+		}
+		conf.SymbolicRefs = symbolicRefsRecreate
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("recreating symbolic refs in a local destination failed: %s", err)
+		}
+		conf.Destination.URL = "https://example.com/dst.git"
+		if err := conf.Validate(logger); !errors.Is(err, ErrRecreateSymbolicRefs) {
+			t.Fatalf("unexpected error for a remote destination: %v", err)
+		}
+	}
+	{
+		// Retries and retry backoffs can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			Retries:     -1,
+		}
+		if err := conf.Validate(logger); err == nil {
+			t.Fatal("negative retries were allowed")
+		}
+		conf = Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			ListRetries: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRetries) {
+			t.Fatal("negative list retries were allowed")
+		}
+		conf = Config{
+			Source:       RepoConf{URL: "src"},
+			Destination:  RepoConf{URL: "dst"},
+			RetryBackoff: -1,
+		}
+		if err := conf.Validate(logger); err == nil {
+			t.Fatal("negative retry backoff was allowed")
+		}
+		conf = Config{
+			Source:          RepoConf{URL: "src"},
+			Destination:     RepoConf{URL: "dst"},
+			RetryMaxBackoff: -1,
+		}
+		if err := conf.Validate(logger); err == nil {
+			t.Fatal("negative retry max backoff was allowed")
+		}
+	}
+	{
+		// The source and the destination can't be the same repository.
+		conf := Config{
+			Source:      RepoConf{URL: "https://GitHub.com/foo/bar.git/"},
+			Destination: RepoConf{URL: "git@github.com:foo/bar"},
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrSameRepo) {
+			t.Fatal("same source and destination repository passed")
+		}
+
+		// The repositories on different ports of a host are different.
+		conf.Source.URL = "http://example.com:3000/foo/bar"
+		conf.Destination.URL = "http://example.com:3001/foo/bar"
+
+		if err := conf.Validate(logger); errors.Is(err, ErrSameRepo) {
+			t.Fatal("repositories on different ports were the same")
+		}
+	}
+	{
+		// The destination host needs to be in the allowed hosts, when set.
+		conf := Config{
+			Source:          RepoConf{URL: "src"},
+			Destination:     RepoConf{URL: "git@github.com:foo/bar.git"},
+			AllowedDstHosts: []string{"gitlab.com", "GitHub.com"},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("allowed destination host failed: %s", err)
+		}
+		conf.Destination.URL = "https://example.com/foo/bar.git"
+		if err := conf.Validate(logger); !errors.Is(err, ErrDstHostNotAllowed) {
+			t.Fatal("destination host not in the allowed hosts passed")
+		}
+	}
 	{
 		// Allow host key provided by file path.
 		conf := Config{
-			SrcRepo: "src",
-			DstRepo: "dst",
-			SSH: SSHConf{
-				PrivateKey:     "key",
-				KnownHostsPath: "khpath",
+			Source: RepoConf{URL: "src"},
+			Destination: RepoConf{
+				URL: "dst",
+				SSH: SSHConf{
+					PrivateKey:     "key",
+					KnownHostsPath: "khpath",
+				},
 			},
 		}
 		if err := conf.Validate(logger); err != nil {
 			t.Fatal("host key provided by file path was not allowed")
 		}
 	}
+	{
+		// Test that the prune confirmation threshold can't be negative.
+		conf := Config{
+			Source:                RepoConf{URL: "src"},
+			Destination:           RepoConf{URL: "dst"},
+			ConfirmPruneThreshold: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrThreshold) {
+			t.Fatal("negative prune confirmation threshold passed")
+		}
+	}
+	{
+		// Test that the tag retention can't be negative.
+		conf := Config{
+			Source:       RepoConf{URL: "src"},
+			Destination:  RepoConf{URL: "dst"},
+			TagRetention: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrTagRetention) {
+			t.Fatal("negative tag retention passed")
+		}
+	}
+	{
+		// Test that the tag semver range needs to be valid.
+		conf := Config{
+			Source:         RepoConf{URL: "src"},
+			Destination:    RepoConf{URL: "dst"},
+			TagSemverRange: ">=2.0",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrTagSemverRange) {
+			t.Fatal("invalid tag semver range passed")
+		}
+	}
+	{
+		// Test that the maximum number of destination references can't be
+		// negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			MaxDstRefs:  -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrMaxDstRefs) {
+			t.Fatal("negative maximum number of destination references passed")
+		}
+	}
+	{
+		// Test that the minimum number of references can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			MinRefs:     -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrMinRefs) {
+			t.Fatal("negative minimum number of references passed")
+		}
+	}
+	{
+		// Test that the lock timeout can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			LockFile:    "lock",
+			LockTimeout: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrLockTimeout) {
+			t.Fatal("negative lock timeout passed")
+		}
+	}
+	{
+		// Test that the client certificate and key are provided together.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			ClientCert:  "cert.pem",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrClientCert) {
+			t.Fatal("client certificate without a key passed")
+		}
+		conf.ClientKey = "key.pem"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("client certificate and key failed: %s", err)
+		}
+	}
+	{
+		// Test that the included references are full reference names that
+		// are not combined with refspecs.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			IncludeRefs: []string{"refs/heads/main", "refs/tags/v1"},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("valid included references failed: %s", err)
+		}
+		conf.FetchRefSpecs = []string{"refs/heads/*:refs/heads/*"}
+		if err := conf.Validate(logger); !errors.Is(err, ErrIncludeRefs) {
+			t.Fatal("included references with refspecs passed")
+		}
+		conf.FetchRefSpecs = nil
+		for _, ref := range []string{"main", "refs/heads/*", "refs/a:refs/b"} {
+			conf.IncludeRefs = []string{ref}
+			if err := conf.Validate(logger); !errors.Is(err, ErrRefSpec) {
+				t.Fatalf("invalid included reference %q passed", ref)
+			}
+		}
+	}
+	{
+		// Test that only the SHA-1 object format is supported.
+		conf := Config{
+			Source:       RepoConf{URL: "src"},
+			Destination:  RepoConf{URL: "dst"},
+			ObjectFormat: "sha1",
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("SHA-1 object format failed: %s", err)
+		}
+		conf.ObjectFormat = "sha256"
+		if err := conf.Validate(logger); !errors.Is(err, ErrObjectFormat) {
+			t.Fatal("SHA-256 object format passed")
+		}
+	}
+	{
+		// Test that the dial timeout can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			DialTimeout: -time.Second,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrDialTimeout) {
+			t.Fatal("negative dial timeout passed")
+		}
+	}
+	{
+		// Test that the placeholder pattern is a valid regular expression.
+		conf := Config{
+			Source:             RepoConf{URL: "src"},
+			Destination:        RepoConf{URL: "dst"},
+			PlaceholderPattern: "(",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrPlaceholderPattern) {
+			t.Fatal("invalid placeholder pattern passed")
+		}
+	}
+	{
+		// Test that the list timeout can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			ListTimeout: -time.Second,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrListTimeout) {
+			t.Fatal("negative list timeout passed")
+		}
+	}
+	{
+		// Test that the maximum connections per host can't be negative.
+		conf := Config{
+			Source:          RepoConf{URL: "src"},
+			Destination:     RepoConf{URL: "dst"},
+			MaxConnsPerHost: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrMaxConnsPerHost) {
+			t.Fatal("negative maximum connections per host passed")
+		}
+	}
+	{
+		// Test that the rate limit can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			RateLimit:   -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRateLimit) {
+			t.Fatal("negative rate limit passed")
+		}
+	}
+	{
+		// Test that the OTLP endpoint needs to be an HTTP(S) URL.
+		conf := Config{
+			Source:       RepoConf{URL: "src"},
+			Destination:  RepoConf{URL: "dst"},
+			OTLPEndpoint: "localhost:4318",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrOTLPEndpoint) {
+			t.Fatal("invalid OTLP endpoint passed")
+		}
+	}
+	{
+		// Test that the local address needs to be an IP address.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			LocalAddr:   "eth0",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrLocalAddr) {
+			t.Fatal("invalid local address passed")
+		}
+	}
+	{
+		// Test that the maximum pack size can't be negative.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			MaxPackSize: -1,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrMaxPackSize) {
+			t.Fatal("negative maximum pack size passed")
+		}
+	}
+	{
+		// Test that the reference groups can't be combined with individual
+		// pushes.
+		conf := Config{
+			Source:         RepoConf{URL: "src"},
+			Destination:    RepoConf{URL: "dst"},
+			RefGroups:      [][]string{{"refs/heads/main", "refs/tags/*"}},
+			IndividualPush: true,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRefGroups) {
+			t.Fatal("reference groups with individual pushes passed")
+		}
+	}
+	{
+		// Test that only a local destination can be initialised.
+		conf := Config{
+			Source:          RepoConf{URL: "src"},
+			Destination:     RepoConf{URL: "https://example.com/foo/bar.git"},
+			InitDestination: true,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrInitDestination) {
+			t.Fatal("initialising a remote destination passed")
+		}
+		conf.Destination.URL = "/tmp/foo/bar.git"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("initialising a local destination failed: %s", err)
+		}
+	}
+	{
+		// Test that only a local destination can be repacked.
+		conf := Config{
+			Source:            RepoConf{URL: "src"},
+			Destination:       RepoConf{URL: "git@github.com:foo/bar.git"},
+			RepackDestination: true,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRepackDestination) {
+			t.Fatal("repacking a remote destination passed")
+		}
+		conf.Destination.URL = "file:///tmp/foo/bar.git"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("repacking a local destination failed: %s", err)
+		}
+	}
+	{
+		// Test that only a local destination can have a commit-graph.
+		conf := Config{
+			Source:           RepoConf{URL: "src"},
+			Destination:      RepoConf{URL: "git@github.com:foo/bar.git"},
+			WriteCommitGraph: true,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrWriteCommitGraph) {
+			t.Fatal("writing the commit-graph of a remote destination passed")
+		}
+		conf.Destination.URL = "/tmp/foo/bar.git"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("writing the commit-graph of a local destination failed: %s", err)
+		}
+	}
+	{
+		// Test that a plan is written by a dry run and applied otherwise.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			PlanFile:    "plan",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrPlanFile) {
+			t.Fatal("writing a plan without a dry run passed")
+		}
+		conf.PlanFile, conf.ApplyPlan, conf.DryRun = "", "plan", true
+		if err := conf.Validate(logger); !errors.Is(err, ErrPlanFile) {
+			t.Fatal("applying a plan in a dry run passed")
+		}
+		conf.DryRun = false
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("applying a plan failed: %s", err)
+		}
+	}
+	{
+		// Test that the CA bundle can't be provided by both path and content.
+		conf := Config{
+			Source:      RepoConf{URL: "src"},
+			Destination: RepoConf{URL: "dst"},
+			CAFile:      "ca.pem",
+			CAContent:   "ca",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrCABundle) {
+			t.Fatal("CA bundle by both path and content passed")
+		}
+	}
+	{
+		// Test the source certificate pin validation.
+		pin := strings.Repeat("ab:", 31) + "ab"
+		conf := Config{
+			Source:      RepoConf{URL: "https://example.com/src.git"},
+			Destination: RepoConf{URL: "dst"},
+			SrcCertPin:  pin,
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("valid source certificate pin failed: %s", err)
+		}
+		conf.SrcCertPin = "abcd"
+		if err := conf.Validate(logger); !errors.Is(err, ErrSrcCertPin) {
+			t.Fatal("short source certificate pin passed")
+		}
+		conf.SrcCertPin, conf.Source.URL = pin, "git@example.com:src.git"
+		if err := conf.Validate(logger); !errors.Is(err, ErrSrcCertPinURL) {
+			t.Fatal("source certificate pin with an SSH source passed")
+		}
+	}
+	{
+		// Test that the provenance sign key needs to be a private key.
+		conf := Config{
+			Source:            RepoConf{URL: "src"},
+			Destination:       RepoConf{URL: "dst"},
+			ProvenanceTag:     true,
+			ProvenanceSignKey: "key",
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrProvenanceSignKey) {
+			t.Fatal("invalid provenance sign key passed")
+		}
+	}
+	{
+		// Test the repository metadata sync requirements.
+		conf := Config{
+			Source:       RepoConf{URL: "https://github.com/foo/bar"},
+			Destination:  RepoConf{URL: "https://example.com/foo/bar"},
+			SyncMetadata: true,
+		}
+		if err := conf.Validate(logger); !errors.Is(err, ErrMetadataProvider) {
+			t.Fatal("unsupported metadata provider passed")
+		}
+		conf.Destination.URL = "https://gitlab.com/foo/bar"
+		if err := conf.Validate(logger); !errors.Is(err, ErrNoMetadataToken) {
+			t.Fatal("missing destination API token passed")
+		}
+		conf.GitLabToken = "token"
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("metadata sync configuration failed: %s", err)
+		}
+	}
+	{
+		// Test that the refspecs need to parse.
+		conf := Config{
+			Source:        RepoConf{URL: "src"},
+			Destination:   RepoConf{URL: "dst"},
+			FetchRefSpecs: []string{"+refs/remotes/*:refs/remotes/*"},
+			PushRefSpecs:  []string{"refs/heads/*:refs/heads/mirror/*"},
+		}
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("valid refspecs failed: %s", err)
+		}
+		conf.FetchRefSpecs = []string{"refs/heads/*"}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRefSpec) {
+			t.Fatal("invalid fetch refspec passed")
+		}
+		conf.FetchRefSpecs = nil
+		conf.PushRefSpecs = []string{"refs/heads/*:refs/heads/foo"}
+		if err := conf.Validate(logger); !errors.Is(err, ErrRefSpec) {
+			t.Fatal("invalid push refspec passed")
+		}
+	}
 }
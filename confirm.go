@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var ErrPruneNotConfirmed = errors.New("the destination prune was not confirmed")
+
+// Used for mocking the operator interaction when running tests.
+var (
+	promptInput   io.Reader = os.Stdin
+	promptOutput  io.Writer = os.Stderr
+	isInteractive           = func() bool {
+		info, err := os.Stdin.Stat()
+
+		return err == nil && info.Mode()&os.ModeCharDevice != 0
+	}
+)
+
+// confirmPrune asks the operator to confirm pruning 'count' references from
+// the destination when conf.ConfirmPrune is set and the count is over
+// conf.ConfirmPruneThreshold. Non-interactive runs fail unless conf.AssumeYes
+// is set.
+func confirmPrune(conf Config, count int) error {
+	if !conf.ConfirmPrune || conf.AssumeYes || count <= conf.ConfirmPruneThreshold {
+		return nil
+	}
+
+	if !isInteractive() {
+		return fmt.Errorf("%w: %d reference(s) to prune in a non-interactive run",
+			ErrPruneNotConfirmed, count)
+	}
+
+	fmt.Fprintf(promptOutput, "Prune %d reference(s) from %s? [y/N] ", count,
+		conf.Destination.URL)
+
+	answer, err := bufio.NewReader(promptInput).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read the confirmation: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return fmt.Errorf("%w: canceled by the operator", ErrPruneNotConfirmed)
+	}
+}
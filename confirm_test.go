@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestConfirmPrune tests confirmPrune function.
+func TestConfirmPrune(t *testing.T) {
+	// Not parallel as the operator interaction mocks are global.
+	origPromptInput, origPromptOutput, origIsInteractive := promptInput,
+		promptOutput, isInteractive
+
+	defer func() {
+		promptInput, promptOutput, isInteractive = origPromptInput,
+			origPromptOutput, origIsInteractive
+	}()
+
+	promptOutput = io.Discard
+	interactive := true
+	isInteractive = func() bool { return interactive }
+
+	conf := Config{ConfirmPrune: true, ConfirmPruneThreshold: 2}
+
+	for _, test := range []struct {
+		conf        Config
+		count       int
+		interactive bool
+		answer      string
+		confirmed   bool
+	}{
+		{Config{}, 10, false, "", true},
+		{conf, 2, false, "", true},
+		{conf, 3, false, "", false},
+		{conf, 3, true, "y\n", true},
+		{conf, 3, true, "Yes\n", true},
+		{conf, 3, true, "n\n", false},
+		{conf, 3, true, "", false},
+		{Config{ConfirmPrune: true, AssumeYes: true}, 3, false, "", true},
+	} {
+		interactive = test.interactive
+		promptInput = strings.NewReader(test.answer)
+
+		err := confirmPrune(test.conf, test.count)
+		if test.confirmed && err != nil {
+			t.Fatalf("unexpected error for %+v: %s", test, err)
+		} else if !test.confirmed && !errors.Is(err, ErrPruneNotConfirmed) {
+			t.Fatalf("prune not canceled for %+v: %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// destinationEmpty checks if the destination has no references yet, i.e. the
+// mirror is the first one. The cached destination references are not used
+// as they could be outdated.
+func destinationEmpty(conf Config, remote *git.Remote, auth transport.AuthMethod) (bool, error) {
+	refs, err := listRemote(conf, remote, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name().String(), "refs/") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// sourceHeadBranch returns the branch the source HEAD points to, or an empty name
+// when the source doesn't advertise it. A local source repository is read
+// directly.
+func sourceHeadBranch(conf Config, logger Logger, repo *git.Repository) (plumbing.ReferenceName, error) {
+	if path := localPath(conf.Source.URL); len(path) != 0 {
+		src, err := git.PlainOpen(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to open the source repository: %w", err)
+		}
+
+		head, err := src.Reference(plumbing.HEAD, false)
+		if err != nil || head.Type() != plumbing.SymbolicReference {
+			return "", nil
+		}
+
+		return head.Target(), nil
+	}
+
+	auth, err := repoAuth(conf, logger, conf.Source, "source")
+	if err != nil {
+		return "", err
+	}
+
+	src, err := repo.Remote(srcRemoteName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the source remote: %w", err)
+	}
+
+	refs, err := listRemote(conf, src, auth)
+	if err != nil {
+		return "", fmt.Errorf("failed to list the source remote: %w", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+
+	return "", nil
+}
+
+// firstMirrorHead returns the branch the HEAD of a destination mirrored for
+// the first time should point to: the source HEAD one, or the staging HEAD
+// one when the source doesn't advertise its HEAD. It is empty when the
+// branch is not mirrored.
+func firstMirrorHead(conf Config, logger Logger, repo *git.Repository) (plumbing.ReferenceName,
+	error) {
+	branch, err := sourceHeadBranch(conf, logger, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if len(branch) == 0 {
+		head, err := repo.Storer.Reference(plumbing.HEAD)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the staging HEAD: %w", err)
+		}
+
+		branch = head.Target()
+	}
+
+	if _, err := repo.Storer.Reference(branch); err != nil {
+		return "", nil
+	}
+
+	return branch, nil
+}
+
+// setFirstMirrorHead makes sure the HEAD of a destination mirrored for the
+// first time points to the mirrored default branch. A push can't set HEAD so
+// a local destination repository is written directly. The HEAD of a remote
+// destination is set by its server, usually to the first pushed branch, so
+// it is only checked.
+func setFirstMirrorHead(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod, repo *git.Repository) error {
+	branch, err := firstMirrorHead(conf, logger, repo)
+	if err != nil {
+		return err
+	}
+
+	if len(branch) == 0 {
+		logger.Warn("The default branch is not mirrored, leaving the " +
+			"destination HEAD as it is.")
+
+		return nil
+	}
+
+	if path := localPath(conf.Destination.URL); len(path) != 0 {
+		dst, err := git.PlainOpen(path)
+		if err != nil {
+			return fmt.Errorf("failed to open the destination repository: %w", err)
+		}
+
+		logger.Info("Pointing the destination HEAD to", branch, "...")
+
+		head := plumbing.NewSymbolicReference(plumbing.HEAD, branch)
+		if err := dst.Storer.SetReference(head); err != nil {
+			return fmt.Errorf("failed to set the destination HEAD: %w", err)
+		}
+
+		return nil
+	}
+
+	refs, err := listRemote(conf, remote, auth)
+	if err != nil {
+		return fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference &&
+			ref.Target() == branch {
+			return nil
+		}
+	}
+
+	logger.Warn("The destination HEAD doesn't point to", branch+". A push "+
+		"can't set it so set the default branch on the destination server.")
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorFirstMirror tests mirroring to an empty destination.
+func TestDoMirrorFirstMirror(t *testing.T) {
+	t.Parallel()
+
+	srcRepoPath := t.TempDir()
+
+	srcRepo, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/main",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	err = srcRepo.Storer.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+	if err != nil {
+		t.Fatalf("failed to set the src repo HEAD: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}
+
+	{
+		// Test that the prune is skipped and the HEAD set to the source one.
+		var logs bytes.Buffer
+		if err := DoMirror(conf, NewLogger(&logs)); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		if !strings.Contains(logs.String(), "The destination is empty") {
+			t.Fatalf("the empty destination was not detected: %s", logs.String())
+		}
+
+		head, err := dstRepo.Storer.Reference(plumbing.HEAD)
+		if err != nil || head.Target() != "refs/heads/main" {
+			t.Fatalf("unexpected dst repo HEAD: %v (%v)", head, err)
+		}
+	}
+	{
+		// Test that the HEAD of an established destination is left as it is.
+		err = srcRepo.Storer.SetReference(
+			plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/a"))
+		if err != nil {
+			t.Fatalf("failed to set the src repo HEAD: %s", err)
+		}
+
+		var logs bytes.Buffer
+		if err := DoMirror(conf, NewLogger(&logs)); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		if strings.Contains(logs.String(), "The destination is empty") {
+			t.Fatalf("the destination was detected as empty: %s", logs.String())
+		}
+
+		head, err := dstRepo.Storer.Reference(plumbing.HEAD)
+		if err != nil || head.Target() != "refs/heads/main" {
+			t.Fatalf("unexpected dst repo HEAD: %v (%v)", head, err)
+		}
+	}
+}
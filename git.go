@@ -5,18 +5,39 @@
 package mirror
 
 import (
+	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math/big"
+	"net"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const (
@@ -25,6 +46,50 @@ const (
 	dstRemoteName          = "dst"
 	tmpKnownHostPathPrefix = "git-mirror-me-known_hosts-"
 	knownHostsPerm         = 0o600
+	defaultRetryBackoff    = 5 * time.Second
+	defaultListTimeout     = 10 * time.Second
+	sshKeyDirSuffix        = ".pem"
+	// dryRunRefSpec matches no references so pushing it only checks that the
+	// destination accepts a push.
+	dryRunRefSpec = "refs/git-mirror-me-dry-run/*:refs/git-mirror-me-dry-run/*"
+	// mirrorRefSpec is the default fetch and push refspec.
+	mirrorRefSpec = "refs/*:refs/*"
+	// pruneGracePeriod is the age, as with 'git gc', of the unreachable loose
+	// objects removed from a repacked destination. The newer ones can be
+	// written by a concurrent operation not referencing them yet.
+	pruneGracePeriod = 14 * 24 * time.Hour
+)
+
+// defaultPorts are the default ports of the remote protocols, ignored when
+// comparing repository URLs.
+var defaultPorts = map[string]int{
+	"http":  80,
+	"https": 443,
+	"git":   9418,
+	"ssh":   22,
+}
+
+var (
+	ErrMissingObjects   = errors.New("objects missing from the fetched source")
+	ErrPermissionDenied = errors.New("permission denied by the destination " +
+		"(missing write access?)")
+	ErrNoChange = errors.New("the mirror operation didn't change the " +
+		"destination")
+	ErrNotCommit   = errors.New("object is not a commit")
+	ErrDstNotEmpty = errors.New("the destination directory is not empty and " +
+		"not a git repository")
+	ErrHostKeyFingerprint = errors.New("the scanned host key doesn't match " +
+		"the fingerprint")
+	// errHostKeyScanned aborts the SSH handshake of a host key scan.
+	errHostKeyScanned  = errors.New("host key scanned")
+	ErrPostCloneVerify = errors.New("the destination clone doesn't match " +
+		"the mirrored references")
+	ErrNoReadableRefs = errors.New("none of the source references could be " +
+		"fetched")
+	ErrRemoteHungUp = errors.New("the destination hung up unexpectedly " +
+		"(likely a server-side timeout or pack size limit, consider " +
+		"retrying or pushing fewer references at once)")
+	ErrIncompleteHistory = errors.New("the destination history is incomplete")
 )
 
 // FilterOutRefs takes a repository and removes references based on a slice of
@@ -59,6 +124,149 @@ func filterOutRefs(repo *git.Repository, prefixes []string) error {
 	return nil
 }
 
+// peelToCommit returns the commit an object hash points to, following tags.
+func peelToCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	obj, err := repo.Object(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object %s: %w", hash, err)
+	}
+
+	switch obj := obj.(type) {
+	case *object.Commit:
+		return obj, nil
+	case *object.Tag:
+		commit, err := obj.Commit()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the commit of tag %s: %w",
+				obj.Name, err)
+		}
+
+		return commit, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrNotCommit, hash)
+	}
+}
+
+// filterUnreachableRefs removes the references of a repository that are not
+// reachable from the 'from' references.
+func filterUnreachableRefs(repo *git.Repository, from []string) error {
+	reachable := make(map[plumbing.Hash]bool)
+
+	for _, name := range from {
+		ref, err := repo.Reference(plumbing.ReferenceName(name), true)
+		if err != nil {
+			return fmt.Errorf("failed to get reference %s: %w", name, err)
+		}
+
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			return err
+		}
+
+		err = object.NewCommitPreorderIter(commit, reachable, nil).ForEach(
+			func(c *object.Commit) error {
+				reachable[c.Hash] = true
+
+				return nil
+			})
+		if err != nil {
+			return fmt.Errorf("failed to walk the history of %s: %w", name, err)
+		}
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	return refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err == nil && reachable[commit.Hash] {
+			return nil
+		}
+
+		if err := repo.Storer.RemoveReference(ref.Name()); err != nil {
+			return fmt.Errorf("failed to remove reference: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// tagTime returns the date of a tag: the tagger date for annotated tags and the
+// committer date for lightweight tags.
+func tagTime(repo *git.Repository, ref *plumbing.Reference) (time.Time, error) {
+	tag, err := repo.TagObject(ref.Hash())
+	if err == nil {
+		return tag.Tagger.When, nil
+	} else if !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return time.Time{}, fmt.Errorf("failed to get tag %s: %w", ref.Name(), err)
+	}
+
+	commit, err := peelToCommit(repo, ref.Hash())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return commit.Committer.When, nil
+}
+
+// filterOldTags removes the tags of a repository except for the 'keep' most
+// recent ones. The lightweight tags of a tree or a blob (e.g. a public key)
+// have no date so they are always kept.
+func filterOldTags(repo *git.Repository, keep int) error {
+	type datedTag struct {
+		name plumbing.ReferenceName
+		when time.Time
+	}
+
+	refs, err := repo.Tags()
+	if err != nil {
+		return fmt.Errorf("failed to get tags: %w", err)
+	}
+
+	var tags []datedTag
+
+	if err := refs.ForEach(func(ref *plumbing.Reference) error {
+		when, err := tagTime(repo, ref)
+		if errors.Is(err, ErrNotCommit) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		tags = append(tags, datedTag{name: ref.Name(), when: when})
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to get the tag dates: %w", err)
+	}
+
+	if len(tags) <= keep {
+		return nil
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].when.Equal(tags[j].when) {
+			return tags[i].name > tags[j].name
+		}
+
+		return tags[i].when.After(tags[j].when)
+	})
+
+	for _, tag := range tags[keep:] {
+		if err := repo.Storer.RemoveReference(tag.name); err != nil {
+			return fmt.Errorf("failed to remove reference: %w", err)
+		}
+	}
+
+	return nil
+}
+
 // refsToDeleteSpecs returns a slice of delete refspecs for a slice of
 // references.
 func refsToDeleteSpecs(refs []*plumbing.Reference) []config.RefSpec {
@@ -99,6 +307,101 @@ func extraRefs(repo *git.Repository, refs []*plumbing.Reference) ([]*plumbing.Re
 	return retRefs, nil
 }
 
+// unmappedRefs returns a slice of references that are in refs, match the
+// destination side of the push refspecs and are not the destination of any
+// repository reference.
+func unmappedRefs(repo *git.Repository, specs []config.RefSpec,
+	refs []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	var retRefs []*plumbing.Reference
+
+	for _, ref := range refs {
+		matched := false
+		found := false
+
+		for _, spec := range specs {
+			if spec.IsDelete() {
+				continue
+			}
+
+			// Reverse doesn't handle the force update prefix.
+			reverse := config.RefSpec(strings.TrimPrefix(spec.String(), "+")).Reverse()
+			if !reverse.Match(ref.Name()) {
+				continue
+			}
+
+			matched = true
+
+			_, err := repo.Reference(reverse.Dst(ref.Name()), false)
+			if err == nil {
+				found = true
+
+				break
+			} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+				return nil, fmt.Errorf("failed to get reference: %w", err)
+			}
+		}
+
+		if matched && !found {
+			retRefs = append(retRefs, ref)
+		}
+	}
+
+	return retRefs, nil
+}
+
+// pruneRefs returns the destination references to prune. When custom push
+// refspecs are configured, only the references in the destination side of the
+// refspecs are pruned. The references matching conf.PreserveRefs, and the
+// provenance tags when conf.ProvenanceTag is set, are never pruned.
+func pruneRefs(conf Config, repo *git.Repository,
+	refs []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	var (
+		prune []*plumbing.Reference
+		err   error
+	)
+
+	preserve := conf.PreserveRefs
+	if conf.ProvenanceTag {
+		preserve = append(preserve[:len(preserve):len(preserve)],
+			provenanceRefPrefix+"*")
+	}
+
+	if (len(conf.PushRefSpecs) == 0 || conf.FastForwardOnly) && len(conf.IncludeRefs) == 0 {
+		prune, err = extraRefs(repo, refs)
+	} else {
+		prune, err = unmappedRefs(repo, refSpecs(conf.PushRefSpecs), refs)
+	}
+
+	if err != nil || len(preserve) == 0 {
+		return prune, err
+	}
+
+	retRefs := make([]*plumbing.Reference, 0, len(prune))
+
+	for _, ref := range prune {
+		if !matchesAny(preserve, ref.Name().String()) {
+			retRefs = append(retRefs, ref)
+		}
+	}
+
+	return retRefs, nil
+}
+
+// refSpecs converts a slice of strings to refspecs. An empty slice results in
+// the default mirror refspec.
+func refSpecs(specs []string) []config.RefSpec {
+	if len(specs) == 0 {
+		return []config.RefSpec{mirrorRefSpec}
+	}
+
+	retSpecs := make([]config.RefSpec, 0, len(specs))
+	for _, spec := range specs {
+		retSpecs = append(retSpecs, config.RefSpec(spec))
+	}
+
+	return retSpecs
+}
+
 // extraSpecs takes a repository and a slice of refs and returns the refs
 // that are not in the repository as a slice of delete refspecs.
 func extraSpecs(repo *git.Repository, refs []*plumbing.Reference) ([]config.RefSpec, error) {
@@ -110,181 +413,2703 @@ func extraSpecs(repo *git.Repository, refs []*plumbing.Reference) ([]config.RefS
 	return refsToDeleteSpecs(diffRefs), nil
 }
 
-// pruneRemote removes all the references in a remote that are not available in
-// the repo.
-func pruneRemote(remote *git.Remote, auth transport.AuthMethod, repo *git.Repository) error {
-	refs, err := remote.List(&git.ListOptions{
+// listRemote lists the references of a remote. The listing is bounded by
+// conf.ListTimeout, when set, instead of the go-git default of 10 seconds.
+func listRemote(conf Config, remote *git.Remote,
+	auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), listTimeout(conf))
+	defer cancel()
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
 		Auth: auth,
 	})
-	if err != nil {
-		return fmt.Errorf("failed to list the destination remote: %w", err)
-	}
 
-	deleteSpecs, err := extraSpecs(repo, refs)
-	if err != nil {
-		return fmt.Errorf("failed to get the prune specs: %w", err)
+	// go-git fails to resolve a detached HEAD matching none of the references
+	// of a remote without the 'symref' capability (e.g. a local repository).
+	var unexpected *plumbing.UnexpectedError
+	if errors.As(err, &unexpected) && errors.Is(unexpected.Err, plumbing.ErrReferenceNotFound) {
+		return listAdvertised(ctx, remote, auth)
 	}
 
-	if len(deleteSpecs) > 0 {
-		err := remote.Push(&git.PushOptions{
-			RemoteName: remote.Config().Name,
-			Auth:       auth,
-			RefSpecs:   deleteSpecs,
-		})
-		if err != nil && errors.Is(err, git.NoErrAlreadyUpToDate) {
-			return fmt.Errorf("failed to prune destination: %w", err)
-		}
+	return refs, err
+}
+
+// listTimeout returns the timeout of a references listing: conf.ListTimeout,
+// when set, or the go-git default of 10 seconds.
+func listTimeout(conf Config) time.Duration {
+	if conf.ListTimeout == 0 {
+		return defaultListTimeout
 	}
 
-	return nil
+	return conf.ListTimeout
 }
 
-// setupStagingRepo initialises an in-memory git repositry populated with the
-// source's references.
-func setupStagingRepo(conf Config, logger *Logger) (*git.Repository, error) {
-	// Setup a working repository.
-	logger.Info("Setting up a staging git repository.")
+// listAdvertised lists the references advertised by a remote without
+// resolving its HEAD, listed as a hash reference instead.
+func listAdvertised(ctx context.Context, remote *git.Remote,
+	auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	endpoint, err := transport.NewEndpoint(remote.Config().URLs[0])
+	if err != nil {
+		return nil, err
+	}
 
-	repo, err := git.Init(memory.NewStorage(), nil)
+	cli, err := client.NewClient(endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed initialising staging git repository: %w",
-			err)
+		return nil, err
 	}
 
-	// Set up the source remote.
-	src, err := repo.CreateRemote(&config.RemoteConfig{
-		Name: srcRemoteName,
-		URLs: []string{conf.SrcRepo},
-	})
+	session, err := cli.NewUploadPackSession(endpoint, auth)
 	if err != nil {
-		return nil, fmt.Errorf("failed configuring source remote: %w", err)
+		return nil, err
 	}
+	defer session.Close()
 
-	// Fetch the source.
-	logger.Info("Fetching all refs from", conf.SrcRepo, "...")
+	advRefs, err := session.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	if err := src.Fetch(&git.FetchOptions{
-		RemoteName: srcRemoteName,
-		RefSpecs:   []config.RefSpec{"refs/*:refs/*"},
-	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
-		return nil, fmt.Errorf("failed to fetch source remote: %w", err)
+	refs := make([]*plumbing.Reference, 0, len(advRefs.References)+1)
+	for name, hash := range advRefs.References {
+		refs = append(refs, plumbing.NewHashReference(plumbing.ReferenceName(name), hash))
 	}
 
-	return repo, nil
+	if advRefs.Head != nil {
+		refs = append(refs, plumbing.NewHashReference(plumbing.HEAD, *advRefs.Head))
+	}
+
+	return refs, nil
 }
 
-// pushWithAuth sets authentication based on configuration and pushes all
-// references to the configured destination repository (as a mirror).
-func pushWithAuth(conf Config, logger *Logger, stagingRepo *git.Repository) error {
-	var auth transport.AuthMethod
+// changedRefs returns the references in the repository that are missing from
+// refs or that point to a different hash.
+func changedRefs(repo *git.Repository, refs []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	var retRefs []*plumbing.Reference
 
-	// Set up the public host key.
-	//
-	// The host public keys can be provided via both content and path. When
-	// it is provided via content, we need to use a temporary known_hosts
-	// file.
-	knownHostsPath := conf.GetKnownHostsPath()
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		hashes[ref.Name()] = ref.Hash()
+	}
 
-	if len(conf.SSH.KnownHosts) != 0 {
-		knownHostsFile, err := ioutil.TempFile("/tmp", tmpKnownHostPathPrefix)
-		if err != nil {
-			return fmt.Errorf("error creating known_hosts tmp file: %w", err)
+	repoRefs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
+
+	_ = repoRefs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference ||
+			!strings.HasPrefix(ref.Name().String(), "refs/") {
+			return nil
 		}
 
-		defer func() {
-			knownHostsFile.Close()
-			os.Remove(knownHostsFile.Name())
-		}()
+		if hash, found := hashes[ref.Name()]; !found || hash != ref.Hash() {
+			retRefs = append(retRefs, ref)
+		}
 
-		knownHostsPath = knownHostsFile.Name()
+		return nil
+	})
 
-		err = os.WriteFile(knownHostsPath, []byte(conf.SSH.KnownHosts), knownHostsPerm)
-		if err != nil {
-			return fmt.Errorf("error writing known_hosts tmp file: %w", err)
-		}
+	return retRefs, nil
+}
+
+// newTags returns the tags in the repository that are missing from refs.
+func newTags(repo *git.Repository, refs []*plumbing.Reference) ([]*plumbing.Reference, error) {
+	var retRefs []*plumbing.Reference
+
+	names := make(map[plumbing.ReferenceName]bool, len(refs))
+	for _, ref := range refs {
+		names[ref.Name()] = true
 	}
 
-	// Set up SSH authentication.
-	if len(conf.SSH.PrivateKey) > 0 {
-		logger.Debug(conf.Debug, "Using SSH authentication.")
+	repoRefs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
 
-		sshKeys, err := ssh.NewPublicKeys("git", []byte(conf.SSH.PrivateKey), "")
-		if err != nil {
-			return fmt.Errorf("failed to setup the SSH key: %w", err)
+	_ = repoRefs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsTag() && !names[ref.Name()] {
+			retRefs = append(retRefs, ref)
 		}
 
-		hostKeyCallback, err := ssh.NewKnownHostsCallback(knownHostsPath)
-		if err != nil {
-			return fmt.Errorf("failed to set up host keys: %w", err)
-		}
+		return nil
+	})
 
-		hostKeyCallbackHelper := ssh.HostKeyCallbackHelper{
-			HostKeyCallback: hostKeyCallback,
-		}
-		sshKeys.HostKeyCallbackHelper = hostKeyCallbackHelper
-		auth = sshKeys
+	return retRefs, nil
+}
+
+// hasNewTags checks if the repository has tags that are not in the remote.
+func hasNewTags(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository) (bool, error) {
+	refs, err := listRemote(conf, remote, auth)
+	if err != nil {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
 	}
 
-	// Set up the destination remote.
-	dst, err := stagingRepo.CreateRemote(&config.RemoteConfig{
-		Name: dstRemoteName,
-		URLs: []string{conf.DstRepo},
-	})
+	tags, err := newTags(repo, refs)
 	if err != nil {
-		return fmt.Errorf("failed configuring destination remote: %w", err)
+		return false, fmt.Errorf("failed to get the new tags: %w", err)
 	}
 
-	logger.Info("Pushing to destination...")
+	for _, tag := range tags {
+		logger.Debug(conf.Debug, "New tag:", tag.Name())
+	}
 
-	err = dst.Push(&git.PushOptions{
-		RemoteName: dstRemoteName,
-		Auth:       auth,
-		RefSpecs:   []config.RefSpec{"refs/*:refs/*"},
-		Force:      true,
-		Prune:      false, // https://github.com/go-git/go-git/issues/520
-	})
+	return len(tags) > 0, nil
+}
+
+// repoHost returns the host of a repository URL. The URL itself is returned
+// when it can't be parsed.
+func repoHost(url string) string {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil || len(endpoint.Host) == 0 {
+		return url
+	}
+
+	return endpoint.Host
+}
+
+// pushError wraps an error returned by a push to the 'url' repository so that
+// a destination denying write access is reported as ErrPermissionDenied and a
+// connection closed mid-transfer as ErrRemoteHungUp.
+func pushError(url string, err error) error {
+	msg := strings.ToLower(err.Error())
+
+	switch {
+	case errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrAuthenticationRequired) ||
+		(strings.Contains(msg, "permission") && strings.Contains(msg, "denied")):
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, err)
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(msg, "unexpected eof") || strings.Contains(msg, "hung up"):
+		return fmt.Errorf("%w: %s: %s", ErrRemoteHungUp, repoHost(url), err)
+	}
+
+	return err
+}
+
+// advertisesPushCert checks if a remote repository advertises support for
+// signed pushes (push certificates). Any failure is considered as no support.
+func advertisesPushCert(url string, auth transport.AuthMethod) bool {
+	endpoint, err := transport.NewEndpoint(url)
 	if err != nil {
-		switch {
-		case errors.Is(err, git.NoErrAlreadyUpToDate):
-			logger.Info("Destination already up to date.")
-		default:
-			return fmt.Errorf("failed to push to destination: %w", err)
-		}
-	} else {
-		logger.Info("Successfully mirrored pushed to destination repository.")
+		return false
 	}
 
-	// We can not use prune in git.Push due to an existing bug
-	// https://github.com/go-git/go-git/issues/520 so we workaround it dealing
-	// with the prunning with a separate push.
-	logger.Info("Pruning the destination...")
+	cli, err := client.NewClient(endpoint)
+	if err != nil {
+		return false
+	}
 
-	err = pruneRemote(dst, auth, stagingRepo)
+	session, err := cli.NewReceivePackSession(endpoint, auth)
 	if err != nil {
-		return nil
+		return false
 	}
+	defer session.Close()
 
-	return nil
+	advRefs, err := session.AdvertisedReferences()
+	if err != nil {
+		return false
+	}
+
+	return advRefs.Capabilities.Supports(capability.PushCert)
 }
 
-// DoMirror mirrors the source to the destination git repository based on the
-// provided configuration. Special references (for example GitHub's
-// refs/pull/*) are ignored.
-func DoMirror(conf Config, logger *Logger) error {
-	repo, err := setupStagingRepo(conf, logger)
-	if err != nil {
-		return err
+// retryDelay returns the delay before a retry attempt (starting at 1). Without
+// conf.RetryMaxBackoff, the delay is always conf.RetryBackoff. Otherwise, it
+// doubles with each attempt, up to conf.RetryMaxBackoff, and a random jitter
+// of up to half of it is subtracted so that concurrent mirrors don't retry in
+// lockstep.
+func retryDelay(conf Config, attempt int) time.Duration {
+	backoff := conf.RetryBackoff
+	if backoff == 0 {
+		backoff = defaultRetryBackoff
 	}
 
-	// Do not push GitHub special references used for dealing with pull
-	// requests.
-	if err := filterOutRefs(repo, []string{refsFilterPrefix}); err != nil {
-		return fmt.Errorf("failed to filter out the refs: %w", err)
+	if conf.RetryMaxBackoff == 0 {
+		return backoff
 	}
 
-	if err := pushWithAuth(conf, logger, repo); err != nil {
-		return err
+	for i := 1; i < attempt && backoff < conf.RetryMaxBackoff; i++ {
+		backoff *= 2
 	}
 
-	return nil
+	if backoff > conf.RetryMaxBackoff {
+		backoff = conf.RetryMaxBackoff
+	}
+
+	if half := int64(backoff / 2); half > 0 {
+		// crypto/rand.Int doesn't fail on the supported platforms.
+		if jitter, err := rand.Int(rand.Reader, big.NewInt(half+1)); err == nil {
+			backoff -= time.Duration(jitter.Int64())
+		}
+	}
+
+	return backoff
+}
+
+// withRetries runs a git operation and retries it, as configured, when it
+// fails. git.NoErrAlreadyUpToDate is not considered a failure.
+func withRetries(conf Config, logger Logger, operation string, fn func() error) error {
+	return retryOperation(conf, logger, conf.Retries, operation, fn)
+}
+
+// retryOperation runs a git operation and retries it up to 'retries' times
+// when it fails. git.NoErrAlreadyUpToDate and
+// transport.ErrEmptyRemoteRepository are not considered failures.
+func retryOperation(conf Config, logger Logger, retries int, operation string,
+	fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt <= retries; attempt++ {
+		if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) ||
+			errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			break
+		}
+
+		backoff := retryDelay(conf, attempt)
+
+		logger.Warn(operation, "failed:", err)
+		logger.Info("Retrying in", backoff, "...")
+		time.Sleep(backoff)
+
+		err = fn()
+	}
+
+	return err
+}
+
+// pruneRemote removes all the references in a remote that are not available in
+// the repo. Each pruned reference is logged in debug mode. It returns true when
+// references were pruned.
+func pruneRemote(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository) (bool, error) {
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if err != nil {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	prune, err := pruneRefs(conf, repo, refs)
+	if err != nil {
+		return false, fmt.Errorf("failed to get the prune specs: %w", err)
+	}
+
+	deleteSpecs := refsToDeleteSpecs(prune)
+
+	if len(deleteSpecs) > 0 {
+		if err := confirmPrune(conf, len(deleteSpecs)); err != nil {
+			return false, err
+		}
+
+		for _, spec := range deleteSpecs {
+			logger.Debug(conf.Debug, "Pruning", spec.Dst(""), "...")
+		}
+
+		err := withRetries(conf, logger, "Pruning", func() error {
+			return remote.Push(&git.PushOptions{
+				RemoteName: remote.Config().Name,
+				Auth:       auth,
+				RefSpecs:   deleteSpecs,
+			})
+		})
+		if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			invalidateRefsCache(conf, logger)
+		}
+
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+			return false, nil
+		case err != nil:
+			return false, fmt.Errorf("failed to prune destination: %w",
+				pushError(conf.Destination.URL, err))
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// matchingRefs returns the references matching any of the glob patterns.
+func matchingRefs(refs []*plumbing.Reference, patterns []string) []*plumbing.Reference {
+	var retRefs []*plumbing.Reference
+
+	for _, ref := range refs {
+		if matchesAny(patterns, ref.Name().String()) {
+			retRefs = append(retRefs, ref)
+		}
+	}
+
+	return retRefs
+}
+
+// deleteRefs deletes the references matching conf.DeleteRefs from the remote.
+// Each deleted reference is logged. It returns true when references were
+// deleted.
+func deleteRefs(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod) (bool, error) {
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	deleteRefs := matchingRefs(refs, conf.DeleteRefs)
+	if len(deleteRefs) == 0 {
+		return false, nil
+	}
+
+	for _, ref := range deleteRefs {
+		logger.Info("Deleting", ref.Name(), "...")
+	}
+
+	err = withRetries(conf, logger, "Deleting", func() error {
+		return remote.Push(&git.PushOptions{
+			RemoteName: remote.Config().Name,
+			Auth:       auth,
+			RefSpecs:   refsToDeleteSpecs(deleteRefs),
+		})
+	})
+	if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		invalidateRefsCache(conf, logger)
+	}
+
+	switch {
+	case errors.Is(err, git.NoErrAlreadyUpToDate):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to delete the destination references: %w",
+			pushError(conf.Destination.URL, err))
+	}
+
+	return true, nil
+}
+
+// setPackWindow sets the delta compression window used when building the
+// packs pushed from a repository. A negative window disables delta
+// compression.
+func setPackWindow(repo *git.Repository, window int) error {
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to get the repository configuration: %w", err)
+	}
+
+	cfg.Pack.Window = 0
+	if window > 0 {
+		cfg.Pack.Window = uint(window)
+	}
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to set the repository configuration: %w", err)
+	}
+
+	return nil
+}
+
+// filterRefsFunc removes the references of a repository for which keep
+// returns false.
+func filterRefsFunc(repo *git.Repository, keep func(*plumbing.Reference) bool) error {
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	if err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if keep(ref) {
+			return nil
+		}
+
+		if err := repo.Storer.RemoveReference(ref.Name()); err != nil {
+			return fmt.Errorf("failed to remove reference: %w", err)
+		}
+
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed remove references: %w", err)
+	}
+
+	return nil
+}
+
+// inDomains checks if an email address is in one of the domains, including
+// their subdomains. The comparison is case insensitive.
+func inDomains(email string, domains []string) bool {
+	at := strings.LastIndex(email, "@")
+	if at == -1 {
+		return false
+	}
+
+	emailDomain := strings.ToLower(email[at+1:])
+
+	for _, domain := range domains {
+		domain = strings.ToLower(domain)
+		if emailDomain == domain || strings.HasSuffix(emailDomain, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterAuthorDomains removes the references of a repository whose tip commit
+// author email is in one of the domains. References not pointing to a commit
+// are kept.
+func filterAuthorDomains(repo *git.Repository, domains []string) error {
+	return filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+		if ref.Type() != plumbing.HashReference {
+			return true
+		}
+
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			return true
+		}
+
+		return !inDomains(commit.Author.Email, domains)
+	})
+}
+
+// isEmptyCommit checks if a commit has an empty tree or, with a single parent,
+// the same tree as its parent (i.e. it doesn't change anything).
+func isEmptyCommit(commit *object.Commit) bool {
+	tree, err := commit.Tree()
+	if err == nil && len(tree.Entries) == 0 {
+		return true
+	}
+
+	if commit.NumParents() != 1 {
+		return false
+	}
+
+	parent, err := commit.Parent(0)
+
+	return err == nil && parent.TreeHash == commit.TreeHash
+}
+
+// filterPlaceholderRefs removes the references of a repository whose tip
+// commit is empty, when excludeEmpty is set, or whose tip commit message
+// matches the placeholder pattern, when not nil. References not pointing to a
+// commit are kept.
+func filterPlaceholderRefs(repo *git.Repository, excludeEmpty bool,
+	placeholder *regexp.Regexp) error {
+	return filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+		if ref.Type() != plumbing.HashReference {
+			return true
+		}
+
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			return true
+		}
+
+		if excludeEmpty && isEmptyCommit(commit) {
+			return false
+		}
+
+		return placeholder == nil || !placeholder.MatchString(commit.Message)
+	})
+}
+
+// filterRefs removes the staging repository references that are not
+// mirrored. Unless conf.RefFilter is set, GitHub special references used for
+// dealing with pull requests are never pushed. As the filtered references are
+// not in the staging repository, their destination copies (e.g. left by a
+// prior run without filters) are pruned, unless matching conf.PreserveRefs.
+func filterRefs(conf Config, repo *git.Repository) error {
+	if conf.RefFilter != nil {
+		if err := filterRefsFunc(repo, conf.RefFilter); err != nil {
+			return fmt.Errorf("failed to filter out the refs: %w", err)
+		}
+	} else if err := filterOutRefs(repo, []string{refsFilterPrefix}); err != nil {
+		return fmt.Errorf("failed to filter out the refs: %w", err)
+	}
+
+	if len(conf.DeleteRefs) != 0 {
+		err := filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+			return !matchesAny(conf.DeleteRefs, ref.Name().String())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to filter out the deleted refs: %w", err)
+		}
+	}
+
+	if len(conf.ExcludeRefs) != 0 {
+		err := filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+			return !matchesAny(conf.ExcludeRefs, ref.Name().String())
+		})
+		if err != nil {
+			return fmt.Errorf("failed to filter out the excluded refs: %w", err)
+		}
+	}
+
+	if len(conf.BranchAllowlistFile) != 0 {
+		allowed, err := readBranchAllowlist(conf.BranchAllowlistFile)
+		if err != nil {
+			return err
+		}
+
+		if err := filterBranchAllowlist(repo, allowed); err != nil {
+			return fmt.Errorf("failed to filter out the branches not allowed: %w", err)
+		}
+	}
+
+	if len(conf.OnlyReachableFrom) != 0 {
+		if err := filterUnreachableRefs(repo, conf.OnlyReachableFrom); err != nil {
+			return fmt.Errorf("failed to filter out the unreachable refs: %w", err)
+		}
+	}
+
+	if len(conf.ExcludeAuthorDomains) != 0 {
+		if err := filterAuthorDomains(repo, conf.ExcludeAuthorDomains); err != nil {
+			return fmt.Errorf("failed to filter out the refs by author: %w", err)
+		}
+	}
+
+	if conf.ExcludeEmptyRefs || len(conf.PlaceholderPattern) != 0 {
+		var placeholder *regexp.Regexp
+
+		if len(conf.PlaceholderPattern) != 0 {
+			compiled, err := regexp.Compile(conf.PlaceholderPattern)
+			if err != nil {
+				return fmt.Errorf("%w: %s", ErrPlaceholderPattern, err)
+			}
+
+			placeholder = compiled
+		}
+
+		if err := filterPlaceholderRefs(repo, conf.ExcludeEmptyRefs, placeholder); err != nil {
+			return fmt.Errorf("failed to filter out the placeholder refs: %w", err)
+		}
+	}
+
+	if len(conf.TagSemverRange) != 0 {
+		semRange, err := parseSemverRange(conf.TagSemverRange)
+		if err != nil {
+			return err
+		}
+
+		if err := filterSemverTags(repo, semRange, conf.KeepNonSemverTags); err != nil {
+			return fmt.Errorf("failed to filter out the tags by version: %w", err)
+		}
+	}
+
+	if conf.TagRetention > 0 {
+		if err := filterOldTags(repo, conf.TagRetention); err != nil {
+			return fmt.Errorf("failed to filter out the old tags: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// setStagingBranch points the HEAD of a repository to a branch, provided by
+// its full or short name.
+func setStagingBranch(repo *git.Repository, branch string) error {
+	name := plumbing.ReferenceName(branch)
+	if !strings.HasPrefix(branch, "refs/") {
+		name = plumbing.NewBranchReferenceName(branch)
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, name)
+	if err := repo.Storer.SetReference(head); err != nil {
+		return fmt.Errorf("failed to set the staging branch: %w", err)
+	}
+
+	return nil
+}
+
+// setupStagingRepo initialises an in-memory git repositry populated with the
+// source's references.
+func setupStagingRepo(conf Config, logger Logger) (*git.Repository, error) {
+	return setupStagingRepoIn(conf, logger, memory.NewStorage())
+}
+
+// setupStagingRepoIn is setupStagingRepo with the staging repository stored in
+// 'storer'.
+func setupStagingRepoIn(conf Config, logger Logger,
+	storer storage.Storer) (*git.Repository, error) {
+	// Setup a working repository.
+	logger.Info("Setting up a staging git repository.")
+
+	var (
+		cache        *git.Repository
+		cachedStorer objectCacheStorer
+	)
+
+	if len(conf.ObjectCache) != 0 {
+		var err error
+
+		cache, err = openObjectCache(conf.ObjectCache)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Debug(conf.Debug, "Using the object cache", conf.ObjectCache, ".")
+
+		cachedStorer = objectCacheStorer{Storer: storer, cache: cache.Storer}
+		storer = cachedStorer
+	}
+
+	repo, err := git.Init(storer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed initialising staging git repository: %w",
+			err)
+	}
+
+	if cache != nil {
+		if err := setObjectCacheHaves(conf, logger, repo, cache); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(conf.StagingBranch) != 0 {
+		if err := setStagingBranch(repo, conf.StagingBranch); err != nil {
+			return nil, err
+		}
+	}
+
+	if conf.PackWindow != 0 {
+		if err := setPackWindow(repo, conf.PackWindow); err != nil {
+			return nil, err
+		}
+	}
+
+	auth, err := repoAuth(conf, logger, conf.Source, "source")
+	if err != nil {
+		return nil, err
+	}
+
+	// Set up the source remote.
+	src, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: srcRemoteName,
+		URLs: []string{conf.Source.URL},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed configuring source remote: %w", err)
+	}
+
+	// Fetch the source.
+	logger.Info("Fetching all refs from", conf.Source.URL, "...")
+
+	if err := src.Fetch(&git.FetchOptions{
+		RemoteName: srcRemoteName,
+		Auth:       auth,
+		RefSpecs:   refSpecs(conf.FetchRefSpecs),
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		if !conf.SkipUnreadableRefs {
+			return nil, fmt.Errorf("failed to fetch source remote: %w", err)
+		}
+
+		logger.Warn("Failed to fetch the source, fetching the references one "+
+			"by one:", err)
+
+		if err := fetchReadableRefs(conf, logger, src, auth); err != nil {
+			return nil, err
+		}
+	}
+
+	if cache != nil {
+		if err := removeObjectCacheHaves(repo); err != nil {
+			return nil, err
+		}
+
+		// The mirror doesn't depend on the cache being updated.
+		if err := updateObjectCache(conf, logger, repo, cachedStorer, cache); err != nil {
+			logger.Warn("Failed to update the object cache:", err)
+		}
+	}
+
+	return repo, nil
+}
+
+// fetchReadableRefs fetches the source references, matching the fetch
+// refspecs, one by one. The references that fail to fetch (e.g. dangling or
+// corrupt) are logged and skipped. It fails when no reference was fetched.
+func fetchReadableRefs(conf Config, logger Logger, src *git.Remote,
+	auth transport.AuthMethod) error {
+	srcRefs, err := listRemote(conf, src, auth)
+	if err != nil {
+		return fmt.Errorf("failed to list the source remote: %w", err)
+	}
+
+	specs := refSpecs(conf.FetchRefSpecs)
+	fetched, skipped := 0, 0
+
+	for _, ref := range srcRefs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		for _, spec := range specs {
+			if spec.IsDelete() || !spec.Match(ref.Name()) {
+				continue
+			}
+
+			refSpec := config.RefSpec(ref.Name().String() + ":" +
+				spec.Dst(ref.Name()).String())
+			if spec.IsForceUpdate() {
+				refSpec = "+" + refSpec
+			}
+
+			err := src.Fetch(&git.FetchOptions{
+				RemoteName: srcRemoteName,
+				Auth:       auth,
+				RefSpecs:   []config.RefSpec{refSpec},
+			})
+			if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+				logger.Warn("Skipping the unreadable source reference",
+					ref.Name(), ":", err)
+
+				skipped++
+
+				continue
+			}
+
+			fetched++
+		}
+	}
+
+	if fetched == 0 {
+		return ErrNoReadableRefs
+	}
+
+	if skipped != 0 {
+		logger.Warn("Skipped", skipped, "unreadable source reference(s).")
+	}
+
+	return nil
+}
+
+// fetchIncomplete checks if the source fetch missed references advertised by
+// the source, matching the fetch refspecs, or objects reachable from the
+// fetched references. Pruning the destination based on such a fetch could
+// delete valid references.
+func fetchIncomplete(conf Config, logger Logger, repo *git.Repository) (bool, error) {
+	if err := verifyObjects(repo); err != nil {
+		logger.Warn("The source fetch is missing objects:", err)
+
+		return true, nil
+	}
+
+	auth, err := repoAuth(conf, logger, conf.Source, "source")
+	if err != nil {
+		return false, err
+	}
+
+	src, err := repo.Remote(srcRemoteName)
+	if err != nil {
+		return false, fmt.Errorf("failed to get the source remote: %w", err)
+	}
+
+	srcRefs, err := listRemote(conf, src, auth)
+	if err != nil {
+		return false, fmt.Errorf("failed to list the source remote: %w", err)
+	}
+
+	specs := refSpecs(conf.FetchRefSpecs)
+	incomplete := false
+
+	for _, ref := range srcRefs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		for _, spec := range specs {
+			if spec.IsDelete() || !spec.Match(ref.Name()) {
+				continue
+			}
+
+			if _, err := repo.Reference(spec.Dst(ref.Name()), false); err != nil {
+				logger.Warn("The source fetch is missing", ref.Name())
+
+				incomplete = true
+			}
+		}
+	}
+
+	return incomplete, nil
+}
+
+// refHashes returns the hashes of the hash references in refs.
+func refHashes(refs []*plumbing.Reference) []plumbing.Hash {
+	var hashes []plumbing.Hash
+
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			hashes = append(hashes, ref.Hash())
+		}
+	}
+
+	return hashes
+}
+
+// repoRefHashes returns the hashes of the hash references of a repository.
+func repoRefHashes(repo *git.Repository) ([]plumbing.Hash, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
+
+	var hashes []plumbing.Hash
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference {
+			hashes = append(hashes, ref.Hash())
+		}
+
+		return nil
+	})
+
+	return hashes, nil
+}
+
+// objectsSize returns the number and the total size of the objects reachable
+// from the references of the repository but not from the 'ignore' hashes.
+// Ignored hashes that are not in the repository are skipped. The sizes are
+// uncompressed so this is only an estimate of the size of a transfer.
+func objectsSize(repo *git.Repository, ignore []plumbing.Hash) (int, int64, error) {
+	from, err := repoRefHashes(repo)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return hashesObjectsSize(repo, from, ignore)
+}
+
+// hashesObjectsSize is objectsSize for the objects reachable from the 'from'
+// hashes.
+func hashesObjectsSize(repo *git.Repository, from, ignore []plumbing.Hash) (int, int64, error) {
+	haves := make([]plumbing.Hash, 0, len(ignore))
+
+	for _, hash := range ignore {
+		if err := repo.Storer.HasEncodedObject(hash); err == nil {
+			haves = append(haves, hash)
+		}
+	}
+
+	hashes, err := revlist.Objects(repo.Storer, from, haves)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var size int64
+
+	for _, hash := range hashes {
+		obj, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get object %s: %w", hash, err)
+		}
+
+		size += obj.Size()
+	}
+
+	return len(hashes), size, nil
+}
+
+// logObjectsSize logs, in debug mode, the number and the size of the objects
+// reachable from the references of the repository but not from the 'ignore'
+// hashes, as the estimate of the 'transfer' (e.g. "fetched"). All the objects
+// are read so this is skipped otherwise.
+func logObjectsSize(conf Config, logger Logger, repo *git.Repository,
+	ignore []plumbing.Hash, transfer string) {
+	if !conf.Debug {
+		return
+	}
+
+	count, size, err := objectsSize(repo, ignore)
+	if err != nil {
+		logger.Warn("Failed to estimate the", transfer, "size:", err)
+
+		return
+	}
+
+	logger.Debug(conf.Debug, "About", count, "object(s),", size,
+		"bytes (uncompressed),", transfer+".")
+}
+
+// logRefObjects logs, in debug mode, the number and the size of the objects
+// reachable from each reference of the repository but not from the 'ignore'
+// hashes. Objects shared by references are counted for each of them so this
+// is only an indication of which references carry the bulk of a transfer.
+func logRefObjects(conf Config, logger Logger, repo *git.Repository, ignore []plumbing.Hash) {
+	if !conf.Debug {
+		return
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		logger.Warn("Failed to get the references:", err)
+
+		return
+	}
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		count, size, err := hashesObjectsSize(repo, []plumbing.Hash{ref.Hash()}, ignore)
+		if err != nil {
+			logger.Warn("Failed to estimate the objects of", ref.Name(), ":", err)
+
+			return nil
+		}
+
+		if count != 0 {
+			logger.Debug(conf.Debug, ref.Name(), "has about", count, "object(s),",
+				size, "bytes (uncompressed).")
+		}
+
+		return nil
+	})
+}
+
+// verifyObjects checks that all the objects reachable from the references of
+// a repository are present in its storage.
+func verifyObjects(repo *git.Repository) error {
+	hashes, err := repoRefHashes(repo)
+	if err != nil {
+		return err
+	}
+
+	// Commits, trees and tags are read while walking the history so a missing
+	// one fails the walk. Blobs are only listed so we check them separately.
+	objects, err := revlist.Objects(repo.Storer, hashes, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMissingObjects, err)
+	}
+
+	for _, hash := range objects {
+		if err := repo.Storer.HasEncodedObject(hash); err != nil {
+			return fmt.Errorf("%w: %s: %s", ErrMissingObjects, hash, err)
+		}
+	}
+
+	return nil
+}
+
+// postCloneVerify clones the destination in memory and checks that all the
+// objects reachable from its references are present. Unless
+// conf.FastForwardOnly is set, where updates are expected to be skipped, it
+// also checks that the references pushed from the staging repository resolve
+// to the same hashes in the clone.
+func postCloneVerify(conf Config, logger Logger, auth transport.AuthMethod,
+	stagingRepo *git.Repository) error {
+	cloneRepo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create the verification repository: %w", err)
+	}
+
+	remote, err := cloneRepo.CreateRemote(&config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{conf.Destination.URL},
+	})
+	if err != nil {
+		return fmt.Errorf("failed configuring the verification remote: %w", err)
+	}
+
+	err = remote.Fetch(&git.FetchOptions{
+		RemoteName: dstRemoteName,
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{mirrorRefSpec},
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) &&
+		!errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fmt.Errorf("failed to clone the destination: %w", err)
+	}
+
+	if err := verifyObjects(cloneRepo); err != nil {
+		return fmt.Errorf("%w: %s", ErrPostCloneVerify, err)
+	}
+
+	if conf.FastForwardOnly {
+		logger.Debug(conf.Debug, "Fast-forward only mode, not comparing the "+
+			"destination clone references.")
+
+		return nil
+	}
+
+	specs, err := individualSpecs(stagingRepo, refSpecs(conf.PushRefSpecs))
+	if err != nil {
+		return err
+	}
+
+	var mismatches []string
+
+	verified := 0
+
+	for _, spec := range specs {
+		src := plumbing.ReferenceName(spec.Src())
+
+		// The push updates the destination remote-tracking references of the
+		// staging repository, which are not mirrored.
+		if strings.HasPrefix(src.String(), "refs/remotes/"+dstRemoteName+"/") {
+			continue
+		}
+
+		verified++
+
+		ref, err := stagingRepo.Reference(src, false)
+		if err != nil {
+			return fmt.Errorf("failed to get reference %s: %w", src, err)
+		}
+
+		cloneRef, err := cloneRepo.Reference(spec.Dst(src), false)
+		if err != nil {
+			mismatches = append(mismatches, spec.Dst(src).String()+" (missing)")
+
+			continue
+		}
+
+		if cloneRef.Hash() != ref.Hash() {
+			mismatches = append(mismatches, fmt.Sprintf("%s (%s instead of %s)",
+				spec.Dst(src), cloneRef.Hash(), ref.Hash()))
+		}
+	}
+
+	if len(mismatches) != 0 {
+		return fmt.Errorf("%w: %s", ErrPostCloneVerify, strings.Join(mismatches, ", "))
+	}
+
+	logger.Info("Verified", verified, "reference(s) in a clone of the destination.")
+
+	return nil
+}
+
+// dryRun logs the references a mirror would push and prune without changing
+// the destination. The destination write access is checked with a push that
+// doesn't update any reference. The changes are also written to
+// conf.PlanFile, when set.
+func dryRun(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository) error {
+	logger.Info("Dry run: the destination will not be changed.")
+
+	refs, err := listRemote(conf, remote, auth)
+	if err != nil {
+		return fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	pushRefs, err := changedRefs(repo, refs)
+	if err != nil {
+		return fmt.Errorf("failed to get the push references: %w", err)
+	}
+
+	for _, ref := range pushRefs {
+		logger.Info("Would push", ref.Name(), "at", ref.Hash())
+	}
+
+	prune, err := pruneRefs(conf, repo, refs)
+	if err != nil {
+		return fmt.Errorf("failed to get the prune references: %w", err)
+	}
+
+	for _, ref := range prune {
+		logger.Info("Would prune", ref.Name(), "at", ref.Hash())
+	}
+
+	deletes := matchingRefs(refs, conf.DeleteRefs)
+	for _, ref := range deletes {
+		logger.Info("Would delete", ref.Name(), "at", ref.Hash())
+	}
+
+	err = remote.Push(&git.PushOptions{
+		RemoteName: remote.Config().Name,
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{dryRunRefSpec},
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("destination push check failed: %w",
+			pushError(conf.Destination.URL, err))
+	}
+
+	logger.Info("Dry run:", len(pushRefs), "reference(s) to push,",
+		len(prune), "reference(s) to prune.")
+
+	if len(conf.PlanFile) != 0 {
+		if err := writePlan(conf, conf.PlanFile, refs, pushRefs,
+			append(prune, deletes...)); err != nil {
+			return err
+		}
+
+		logger.Info("Wrote the plan to", conf.PlanFile+".")
+	}
+
+	return nil
+}
+
+// isFastForward checks if updating a reference from the 'from' commit to the
+// 'to' commit is a fast-forward. Commits that are not in the repository are
+// considered not to be a fast-forward.
+func isFastForward(repo *git.Repository, from, to plumbing.Hash) bool {
+	fromCommit, err := repo.CommitObject(from)
+	if err != nil {
+		return false
+	}
+
+	toCommit, err := repo.CommitObject(to)
+	if err != nil {
+		return false
+	}
+
+	ok, err := fromCommit.IsAncestor(toCommit)
+
+	return err == nil && ok
+}
+
+// fastForwardSpecs returns the push refspecs for the references that changed
+// compared to the remote references. References matching conf.ForceRefs are
+// force pushed while the other ones are only pushed when the update is a
+// fast-forward.
+func fastForwardSpecs(conf Config, logger Logger, repo *git.Repository,
+	refs []*plumbing.Reference) ([]config.RefSpec, error) {
+	pushRefs, err := changedRefs(repo, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the push references: %w", err)
+	}
+
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	specs := make([]config.RefSpec, 0, len(pushRefs))
+
+	for _, ref := range pushRefs {
+		name := ref.Name().String()
+		spec := name + ":" + name
+
+		if matchesAny(conf.ForceRefs, name) {
+			spec = "+" + spec
+		} else if hash, found := hashes[ref.Name()]; found &&
+			!isFastForward(repo, hash, ref.Hash()) {
+			logger.Warn("Not pushing", name, "as it is not a fast-forward update.")
+
+			continue
+		}
+
+		specs = append(specs, config.RefSpec(spec))
+	}
+
+	return specs, nil
+}
+
+// isPlaceholder checks if the remote references only point to the initial
+// commit of an unrelated history, as created by hosting providers initialising
+// a repository (e.g. with a README). The commit is fetched in a separate
+// repository to be inspected.
+func isPlaceholder(remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository, refs []*plumbing.Reference) (bool, error) {
+	var placeholder *plumbing.Reference
+
+	for _, ref := range refs {
+		if ref.Type() != plumbing.HashReference ||
+			!strings.HasPrefix(ref.Name().String(), "refs/") {
+			continue
+		}
+
+		if placeholder != nil && placeholder.Hash() != ref.Hash() {
+			return false, nil
+		}
+
+		placeholder = ref
+	}
+
+	if placeholder == nil {
+		return false, nil
+	}
+
+	if err := repo.Storer.HasEncodedObject(placeholder.Hash()); err == nil {
+		return false, nil
+	}
+
+	probeRepo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed initialising probe git repository: %w", err)
+	}
+
+	probe, err := probeRepo.CreateRemote(&config.RemoteConfig{
+		Name: remote.Config().Name,
+		URLs: remote.Config().URLs,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed configuring probe remote: %w", err)
+	}
+
+	name := placeholder.Name().String()
+	fetch := &git.FetchOptions{
+		RemoteName: remote.Config().Name,
+		Auth:       auth,
+		RefSpecs:   []config.RefSpec{config.RefSpec(name + ":" + name)},
+		Depth:      1,
+	}
+
+	// Not all the servers support shallow fetches.
+	if err := probe.Fetch(fetch); err != nil {
+		fetch.Depth = 0
+		if err := probe.Fetch(fetch); err != nil {
+			return false, fmt.Errorf("failed to fetch %s from the destination: %w",
+				name, err)
+		}
+	}
+
+	// A reference to anything but a commit is not a placeholder.
+	commit, err := probeRepo.CommitObject(placeholder.Hash())
+
+	return err == nil && commit.NumParents() == 0, nil
+}
+
+// pushSpecs pushes refspecs to the remote, with retries. All the references
+// are force pushed unless conf.FastForwardOnly is set.
+func pushSpecs(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	specs []config.RefSpec) error {
+	return withRetries(conf, logger, "Pushing", func() error {
+		return remote.Push(&git.PushOptions{
+			RemoteName: remote.Config().Name,
+			Auth:       auth,
+			RefSpecs:   specs,
+			Force:      !conf.FastForwardOnly,
+			Prune:      false, // https://github.com/go-git/go-git/issues/520
+		})
+	})
+}
+
+// individualSpecs expands refspecs into one refspec for each repository
+// reference they match.
+func individualSpecs(repo *git.Repository, specs []config.RefSpec) ([]config.RefSpec, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get references: %w", err)
+	}
+
+	var retSpecs []config.RefSpec
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		for _, spec := range specs {
+			if spec.IsDelete() || !spec.Match(ref.Name()) {
+				continue
+			}
+
+			retSpec := ref.Name().String() + ":" + spec.Dst(ref.Name()).String()
+			if spec.IsForceUpdate() {
+				retSpec = "+" + retSpec
+			}
+
+			retSpecs = append(retSpecs, config.RefSpec(retSpec))
+		}
+
+		return nil
+	})
+
+	sort.Slice(retSpecs, func(i, j int) bool {
+		return retSpecs[i].String() < retSpecs[j].String()
+	})
+
+	return retSpecs, nil
+}
+
+// pendingSpecs returns the individual refspecs whose destination reference
+// doesn't already point to the source reference hash. These are the references
+// left to push, for example, after an interrupted individual push.
+func pendingSpecs(repo *git.Repository, specs []config.RefSpec,
+	dstRefs []*plumbing.Reference) ([]config.RefSpec, error) {
+	dstHashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(dstRefs))
+	for _, ref := range dstRefs {
+		dstHashes[ref.Name()] = ref.Hash()
+	}
+
+	var retSpecs []config.RefSpec
+
+	for _, spec := range specs {
+		src := plumbing.ReferenceName(spec.Src())
+
+		ref, err := repo.Reference(src, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reference %s: %w", src, err)
+		}
+
+		if hash, found := dstHashes[spec.Dst(src)]; found && hash == ref.Hash() {
+			continue
+		}
+
+		retSpecs = append(retSpecs, spec)
+	}
+
+	return retSpecs, nil
+}
+
+// pushIndividually pushes each reference matched by the refspecs in its own
+// push so that the progress and the errors are attributable to a reference.
+// The references already on the destination, as listed in dstRefs, are skipped
+// so that an interrupted push is resumed rather than restarted. It stops at the
+// first failed reference and returns git.NoErrAlreadyUpToDate when no
+// reference was updated.
+func pushIndividually(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository, specs []config.RefSpec, dstRefs []*plumbing.Reference) error {
+	specs, err := individualSpecs(repo, specs)
+	if err != nil {
+		return err
+	}
+
+	total := len(specs)
+
+	specs, err = pendingSpecs(repo, specs, dstRefs)
+	if err != nil {
+		return err
+	}
+
+	if skipped := total - len(specs); skipped != 0 {
+		logger.Info(skipped, "of", total, "reference(s) already on the destination, "+
+			"pushing the remaining ones.")
+	}
+
+	updated := false
+
+	for i, spec := range specs {
+		logger.Info(fmt.Sprintf("Pushing %s (%d/%d)...", spec.Src(), i+1, len(specs)))
+
+		err := pushSpecs(conf, logger, remote, auth, []config.RefSpec{spec})
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+			logger.Debug(conf.Debug, spec.Src(), "already up to date.")
+		case err != nil:
+			return fmt.Errorf("failed to push %s: %w", spec.Src(), err)
+		default:
+			updated = true
+		}
+	}
+
+	if !updated {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	return nil
+}
+
+// packSizer accumulates the objects reachable from hashes of a repository to
+// estimate the size of the objects each hash adds to a pack.
+type packSizer struct {
+	repo *git.Repository
+	seen map[plumbing.Hash]bool
+}
+
+// add marks the objects reachable from a hash as seen and returns the total
+// uncompressed size of the ones not seen before. Objects that are not in the
+// repository are skipped.
+func (sizer *packSizer) add(hash plumbing.Hash) (int64, error) {
+	var size int64
+
+	pending := []plumbing.Hash{hash}
+
+	for len(pending) != 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if sizer.seen[hash] {
+			continue
+		}
+
+		sizer.seen[hash] = true
+
+		obj, err := sizer.repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to get object %s: %w", hash, err)
+		}
+
+		size += obj.Size()
+
+		switch obj.Type() {
+		case plumbing.CommitObject:
+			commit, err := object.DecodeCommit(sizer.repo.Storer, obj)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decode commit %s: %w", hash, err)
+			}
+
+			pending = append(pending, commit.TreeHash)
+			pending = append(pending, commit.ParentHashes...)
+		case plumbing.TreeObject:
+			tree, err := object.DecodeTree(sizer.repo.Storer, obj)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decode tree %s: %w", hash, err)
+			}
+
+			for _, entry := range tree.Entries {
+				if entry.Mode != filemode.Submodule {
+					pending = append(pending, entry.Hash)
+				}
+			}
+		case plumbing.TagObject:
+			tag, err := object.DecodeTag(sizer.repo.Storer, obj)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decode tag %s: %w", hash, err)
+			}
+
+			pending = append(pending, tag.Target)
+		}
+	}
+
+	return size, nil
+}
+
+// commitGeneration returns the generation of a commit: 1 for a root commit
+// and one more than the highest generation of its parents otherwise, so a
+// commit always has a higher generation than its ancestors. Parents missing
+// from the repository (e.g. of a shallow fetch) have the generation 0. The
+// computed generations are kept in 'generations'.
+func commitGeneration(repo *git.Repository, hash plumbing.Hash,
+	generations map[plumbing.Hash]int) (int, error) {
+	pending := []plumbing.Hash{hash}
+
+	for len(pending) != 0 {
+		current := pending[len(pending)-1]
+
+		if _, done := generations[current]; done {
+			pending = pending[:len(pending)-1]
+
+			continue
+		}
+
+		commit, err := object.GetCommit(repo.Storer, current)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			generations[current] = 0
+
+			continue
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to get commit %s: %w", current, err)
+		}
+
+		generation, ready := 1, true
+
+		for _, parent := range commit.ParentHashes {
+			parentGeneration, done := generations[parent]
+			if !done {
+				ready = false
+
+				pending = append(pending, parent)
+			} else if parentGeneration >= generation {
+				generation = parentGeneration + 1
+			}
+		}
+
+		if ready {
+			generations[current] = generation
+			pending = pending[:len(pending)-1]
+		}
+	}
+
+	return generations[hash], nil
+}
+
+// chunkSpecs groups individual refspecs so that the objects each group adds to
+// the ones of the previous groups and of the destination (the 'ignore' hashes)
+// are at most maxSize bytes. The references are sorted so that a commit is
+// pushed after its ancestors, then by commit date. A reference exceeding
+// maxSize on its own is in a group of its own.
+func chunkSpecs(logger Logger, repo *git.Repository, specs []config.RefSpec,
+	ignore []plumbing.Hash, maxSize int64) ([][]config.RefSpec, error) {
+	hashes := make(map[config.RefSpec]plumbing.Hash, len(specs))
+	orders := make(map[config.RefSpec]int, len(specs))
+	dates := make(map[config.RefSpec]time.Time, len(specs))
+	generations := make(map[plumbing.Hash]int)
+
+	for _, spec := range specs {
+		ref, err := repo.Reference(plumbing.ReferenceName(spec.Src()), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get reference %s: %w", spec.Src(), err)
+		}
+
+		hashes[spec] = ref.Hash()
+
+		// References to anything but a commit (e.g. a tag of a tree) go
+		// first.
+		commit, err := peelToCommit(repo, ref.Hash())
+		if err != nil {
+			continue
+		}
+
+		orders[spec], err = commitGeneration(repo, commit.Hash, generations)
+		if err != nil {
+			return nil, err
+		}
+
+		dates[spec] = commit.Committer.When
+	}
+
+	sorted := make([]config.RefSpec, len(specs))
+	copy(sorted, specs)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if orders[sorted[i]] != orders[sorted[j]] {
+			return orders[sorted[i]] < orders[sorted[j]]
+		}
+
+		return dates[sorted[i]].Before(dates[sorted[j]])
+	})
+
+	sizer := packSizer{repo: repo, seen: make(map[plumbing.Hash]bool)}
+
+	for _, hash := range ignore {
+		if _, err := sizer.add(hash); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		chunks    [][]config.RefSpec
+		chunk     []config.RefSpec
+		chunkSize int64
+	)
+
+	for _, spec := range sorted {
+		size, err := sizer.add(hashes[spec])
+		if err != nil {
+			return nil, err
+		}
+
+		if size > maxSize {
+			logger.Warn(fmt.Sprintf("%s adds about %d bytes, more than the "+
+				"maximum pack size. Pushing it on its own.", spec.Src(), size))
+		}
+
+		// A reference adding no objects fits in any group.
+		if len(chunk) != 0 && size != 0 && chunkSize+size > maxSize {
+			chunks = append(chunks, chunk)
+			chunk, chunkSize = nil, 0
+		}
+
+		chunk = append(chunk, spec)
+		chunkSize += size
+	}
+
+	if len(chunk) != 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// pushChunked pushes the references matched by the refspecs in several
+// pushes, each sending at most about conf.MaxPackSize bytes of objects. The
+// references already on the destination, as listed in dstRefs, are skipped.
+// It stops at the first failed push and returns git.NoErrAlreadyUpToDate when
+// no reference was updated.
+func pushChunked(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository, specs []config.RefSpec, dstRefs []*plumbing.Reference) error {
+	specs, err := individualSpecs(repo, specs)
+	if err != nil {
+		return err
+	}
+
+	specs, err = pendingSpecs(repo, specs, dstRefs)
+	if err != nil {
+		return err
+	}
+
+	chunks, err := chunkSpecs(logger, repo, specs, refHashes(dstRefs), conf.MaxPackSize)
+	if err != nil {
+		return err
+	}
+
+	updated := false
+
+	for i, chunk := range chunks {
+		logger.Info(fmt.Sprintf("Pushing %d reference(s) (%d/%d)...", len(chunk),
+			i+1, len(chunks)))
+
+		err := pushSpecs(conf, logger, remote, auth, chunk)
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+		case err != nil:
+			return fmt.Errorf("failed to push %d/%d: %w", i+1, len(chunks), err)
+		default:
+			updated = true
+		}
+	}
+
+	if !updated {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	return nil
+}
+
+// pushRefs pushes the references of the repository to the remote. All the
+// references are force pushed unless conf.FastForwardOnly is set. It returns
+// true when references were updated.
+func pushRefs(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository) (bool, error) {
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	if conf.FastForwardOnly && conf.ReplacePlaceholder {
+		placeholder, err := isPlaceholder(remote, auth, repo, refs)
+		if err != nil {
+			return false, err
+		}
+
+		if placeholder {
+			logger.Info("The destination only has placeholder content, replacing it.")
+
+			conf.FastForwardOnly = false
+		}
+	}
+
+	specs := refSpecs(conf.PushRefSpecs)
+
+	if conf.FastForwardOnly {
+		specs, err = fastForwardSpecs(conf, logger, repo, refs)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(specs) != 0 {
+		logRefObjects(conf, logger, repo, refHashes(refs))
+	}
+
+	err = git.NoErrAlreadyUpToDate
+
+	switch {
+	case len(specs) == 0:
+	case len(conf.RefGroups) != 0:
+		err = pushGroups(conf, logger, remote, auth, repo, specs, refs)
+	case conf.IndividualPush:
+		err = pushIndividually(conf, logger, remote, auth, repo, specs, refs)
+	case conf.MaxPackSize > 0:
+		err = pushChunked(conf, logger, remote, auth, repo, specs, refs)
+	default:
+		err = pushSpecs(conf, logger, remote, auth, specs)
+	}
+
+	// The cached destination references are outdated after a push, even a
+	// partially failed one.
+	if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		invalidateRefsCache(conf, logger)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+			logger.Info("Destination already up to date.")
+
+			return false, nil
+		default:
+			// go-git can't sign pushes so a destination requiring them
+			// fails with an error that doesn't say so.
+			if advertisesPushCert(conf.Destination.URL, auth) {
+				logger.Warn("The destination supports signed pushes which are " +
+					"not supported by this tool. The push fails if they are " +
+					"required.")
+			}
+
+			return false, fmt.Errorf("failed to push to destination: %w",
+				pushError(conf.Destination.URL, err))
+		}
+	}
+
+	logger.Info("Successfully mirrored pushed to destination repository.")
+
+	logObjectsSize(conf, logger, repo, refHashes(refs), "pushed")
+
+	return true, nil
+}
+
+// sshKey returns the SSH private key used for a repository URL. The key
+// provided by content takes precedence over the per host keys from
+// sshConf.KeyDir. An empty key is returned when no key is configured for the
+// URL.
+func sshKey(conf Config, logger Logger, sshConf SSHConf, url string) ([]byte, error) {
+	if len(sshConf.PrivateKey) != 0 {
+		return []byte(sshConf.PrivateKey), nil
+	}
+
+	if len(sshConf.KeyDir) == 0 {
+		return nil, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the repository URL: %w", err)
+	}
+
+	if endpoint.Protocol != "ssh" {
+		return nil, nil
+	}
+
+	keyPath := filepath.Join(sshConf.KeyDir, endpoint.Host+sshKeyDirSuffix)
+
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Debug(conf.Debug, "No SSH key for", endpoint.Host, "in",
+			sshConf.KeyDir)
+
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the SSH key: %w", err)
+	}
+
+	logger.Debug(conf.Debug, "Using the SSH key", keyPath)
+
+	return key, nil
+}
+
+// sshAuth returns the SSH authentication for a repository URL or nil when no
+// SSH key is configured for it.
+func sshAuth(conf Config, logger Logger, sshConf SSHConf,
+	url, knownHostsPath string) (transport.AuthMethod, error) {
+	key, err := sshKey(conf, logger, sshConf, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(key) == 0 {
+		return anonymousSSHAuth(conf, logger, url)
+	}
+
+	logger.Debug(conf.Debug, "Using SSH authentication.")
+
+	sshKeys, err := ssh.NewPublicKeys("git", key, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup the SSH key: %w", err)
+	}
+
+	hostKeyCallback, err := ssh.NewKnownHostsCallback(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up host keys: %w", err)
+	}
+
+	sshKeys.HostKeyCallbackHelper = ssh.HostKeyCallbackHelper{
+		HostKeyCallback: hostKeyCallback,
+	}
+
+	var auth ssh.AuthMethod = sshKeys
+
+	if conf.DialTimeout != 0 {
+		auth = dialTimeoutAuth{AuthMethod: auth, timeout: conf.DialTimeout}
+	}
+
+	if len(sshConf.JumpHost) != 0 {
+		user, addr, err := parseJumpHost(sshConf.JumpHost)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.Debug(conf.Debug, "Connecting through the jump host", addr+".")
+
+		auth = jumpAuth{
+			AuthMethod: auth, user: user, addr: addr, localAddr: localTCPAddr(conf),
+		}
+	} else if len(conf.LocalAddr) != 0 {
+		logger.Debug(conf.Debug, "Connecting from the local address", conf.LocalAddr+".")
+
+		auth = localAddrAuth{AuthMethod: auth, localAddr: localTCPAddr(conf)}
+	}
+
+	return auth, nil
+}
+
+// anonymousSSHAuth returns the authentication of an SSH repository without
+// SSH key. go-git uses its default SSH authentication (the SSH agent) for
+// these, unless conf.LocalAddr is set: the default authentication is then
+// set up here to connect from the local address. It returns nil otherwise.
+func anonymousSSHAuth(conf Config, logger Logger, url string) (transport.AuthMethod, error) {
+	if len(conf.LocalAddr) == 0 || !isSSHURL(url) {
+		return nil, nil
+	}
+
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the repository URL: %w", err)
+	}
+
+	auth, err := ssh.DefaultAuthBuilder(endpoint.User)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up the default SSH authentication: %w", err)
+	}
+
+	logger.Debug(conf.Debug, "Connecting from the local address", conf.LocalAddr+".")
+
+	return localAddrAuth{AuthMethod: auth, localAddr: localTCPAddr(conf)}, nil
+}
+
+// dialTimeoutAuth is an SSH authentication setting the timeout of the SSH
+// connection establishment, including the host name resolution.
+type dialTimeoutAuth struct {
+	ssh.AuthMethod
+	timeout time.Duration
+}
+
+// ClientConfig implements ssh.AuthMethod.
+func (a dialTimeoutAuth) ClientConfig() (*gossh.ClientConfig, error) {
+	config, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config.Timeout = a.timeout
+
+	return config, nil
+}
+
+// isSSHURL checks if a repository URL uses the SSH transport.
+func isSSHURL(url string) bool {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return false
+	}
+
+	return endpoint.Protocol == "ssh"
+}
+
+// normalizedURL returns a repository URL normalized so that the URLs of the
+// same repository compare equal: the protocol, the user and the default port
+// of the protocol are ignored, the host is lowercased and the path is stripped
+// of the slashes and of the '.git' suffix. Local paths are made absolute.
+func normalizedURL(url string) string {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return url
+	}
+
+	if endpoint.Protocol == "file" {
+		path, err := filepath.Abs(endpoint.Path)
+		if err != nil {
+			path = endpoint.Path
+		}
+
+		return strings.TrimSuffix(filepath.Clean(path), ".git")
+	}
+
+	path := strings.Trim(endpoint.Path, "/")
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".git"), "/")
+
+	host := strings.ToLower(endpoint.Host)
+	if endpoint.Port != 0 && endpoint.Port != defaultPorts[endpoint.Protocol] {
+		host = net.JoinHostPort(host, strconv.Itoa(endpoint.Port))
+	}
+
+	return host + "/" + path
+}
+
+// localPath returns the local path of a repository URL or an empty string when
+// the URL is not local.
+func localPath(url string) string {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil || endpoint.Protocol != "file" {
+		return ""
+	}
+
+	return endpoint.Path
+}
+
+// initDestination initialises a bare repository at the local destination path
+// unless it already is a repository. The directory is created when missing.
+func initDestination(conf Config, logger Logger) error {
+	dstPath := localPath(conf.Destination.URL)
+
+	if _, err := git.PlainOpen(dstPath); err == nil {
+		logger.Debug(conf.Debug, "The destination repository already exists.")
+
+		return nil
+	}
+
+	entries, err := os.ReadDir(dstPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read the destination directory: %w", err)
+	}
+
+	if len(entries) != 0 {
+		return fmt.Errorf("%w: %s", ErrDstNotEmpty, dstPath)
+	}
+
+	logger.Info("Initialising a bare destination repository in", dstPath, "...")
+
+	if _, err := git.PlainInit(dstPath, true); err != nil {
+		return fmt.Errorf("failed to initialise the destination repository: %w", err)
+	}
+
+	return nil
+}
+
+// repackDestination removes the unreachable loose objects, older than
+// pruneGracePeriod, of the local destination repository and packs all its
+// reachable objects in a single pack.
+func repackDestination(conf Config, logger Logger) error {
+	dstPath := localPath(conf.Destination.URL)
+
+	repo, err := git.PlainOpen(dstPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the destination repository: %w", err)
+	}
+
+	logger.Info("Repacking the destination repository in", dstPath, "...")
+
+	err = repo.Prune(git.PruneOptions{
+		OnlyObjectsOlderThan: time.Now().Add(-pruneGracePeriod),
+		Handler:              repo.DeleteObject,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune the destination objects: %w", err)
+	}
+
+	if err := repo.RepackObjects(&git.RepackConfig{}); err != nil {
+		return fmt.Errorf("failed to repack the destination: %w", err)
+	}
+
+	return nil
+}
+
+// writeCommitGraph writes a commit-graph file, of all the commits, in the
+// destination repository when it is a local path. It replaces any existing
+// commit-graph file.
+func writeCommitGraph(conf Config, logger Logger) error {
+	dstPath := localPath(conf.Destination.URL)
+
+	repo, err := git.PlainOpen(dstPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the destination repository: %w", err)
+	}
+
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrWriteCommitGraph, dstPath)
+	}
+
+	logger.Info("Writing the commit-graph of the destination repository in",
+		dstPath, "...")
+
+	commits, err := repo.CommitObjects()
+	if err != nil {
+		return fmt.Errorf("failed to get the destination commits: %w", err)
+	}
+
+	index := commitgraph.NewMemoryIndex()
+	generations := make(map[plumbing.Hash]int)
+
+	err = commits.ForEach(func(commit *object.Commit) error {
+		generation, err := commitGeneration(repo, commit.Hash, generations)
+		if err != nil {
+			return err
+		}
+
+		for _, parent := range commit.ParentHashes {
+			if generations[parent] == 0 {
+				return fmt.Errorf("%w: commit %s is missing", ErrIncompleteHistory,
+					parent)
+			}
+		}
+
+		index.Add(commit.Hash, &commitgraph.CommitData{
+			TreeHash:     commit.TreeHash,
+			ParentHashes: commit.ParentHashes,
+			Generation:   generation,
+			When:         commit.Committer.When,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index the destination commits: %w", err)
+	}
+
+	fs := storage.Filesystem()
+	infoPath := fs.Join("objects", "info")
+
+	if err := fs.MkdirAll(infoPath, 0o755); err != nil {
+		return fmt.Errorf("failed to create the objects info directory: %w", err)
+	}
+
+	// The commit-graph is written to a temporary file first so that readers
+	// never see a partial one.
+	file, err := fs.TempFile(infoPath, "tmp_commit-graph_")
+	if err != nil {
+		return fmt.Errorf("failed to create the commit-graph: %w", err)
+	}
+
+	if err := commitgraph.NewEncoder(file).Encode(index); err != nil {
+		file.Close()
+		_ = fs.Remove(file.Name())
+
+		return fmt.Errorf("failed to write the commit-graph: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		_ = fs.Remove(file.Name())
+
+		return fmt.Errorf("failed to write the commit-graph: %w", err)
+	}
+
+	if err := fs.Rename(file.Name(), fs.Join(infoPath, "commit-graph")); err != nil {
+		_ = fs.Remove(file.Name())
+
+		return fmt.Errorf("failed to write the commit-graph: %w", err)
+	}
+
+	return nil
+}
+
+// scanHostKey connects to the SSH host of a repository URL, like ssh-keyscan,
+// and returns its public key as a known_hosts line. The key needs to match
+// sshConf.HostKeyFingerprint, when set.
+func scanHostKey(conf Config, logger Logger, sshConf SSHConf, url string) (string, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse the repository URL: %w", err)
+	}
+
+	port := endpoint.Port
+	if port <= 0 {
+		port = ssh.DefaultPort
+	}
+
+	addr := net.JoinHostPort(endpoint.Host, strconv.Itoa(port))
+
+	var hostKey gossh.PublicKey
+
+	// The scan connects like the repository connections, from the local
+	// address.
+	dialer := &net.Dialer{Timeout: conf.DialTimeout, LocalAddr: localTCPAddr(conf)}
+
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to scan the host key of %s: %w", addr, err)
+	}
+
+	// The handshake is aborted once the host key is received.
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, &gossh.ClientConfig{
+		User: endpoint.User,
+		HostKeyCallback: func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			hostKey = key
+
+			return errHostKeyScanned
+		},
+	})
+	if err == nil {
+		gossh.NewClient(sshConn, chans, reqs).Close()
+	} else {
+		conn.Close()
+	}
+
+	if hostKey == nil {
+		return "", fmt.Errorf("failed to scan the host key of %s: %w", addr, err)
+	}
+
+	fingerprint := gossh.FingerprintSHA256(hostKey)
+
+	if len(sshConf.HostKeyFingerprint) == 0 {
+		logger.Warn("Trusting the scanned host key of", addr, "on first use:",
+			fingerprint)
+	} else if fingerprint != sshConf.HostKeyFingerprint {
+		return "", fmt.Errorf("%w: %s has %s", ErrHostKeyFingerprint, addr, fingerprint)
+	}
+
+	return knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey), nil
+}
+
+// repoSSHAuth sets up the SSH authentication for a repository.
+func repoSSHAuth(conf Config, logger Logger, repo RepoConf) (transport.AuthMethod, error) {
+	if len(repo.SSH.Command) != 0 {
+		logger.Debug(conf.Debug, "Using the SSH command", repo.SSH.Command+".")
+
+		return sshCommandAuth{command: repo.SSH.Command}, nil
+	}
+
+	// Set up the public host key.
+	//
+	// The host public keys can be provided via both content and path. When
+	// it is provided via content, we need to use a temporary known_hosts
+	// file. The file is only needed while setting up the host key callback.
+	knownHostsPath := repo.SSH.KnownHostsPath
+	knownHosts := repo.SSH.KnownHosts
+
+	if repo.SSH.ScanHostKeys && len(knownHosts) == 0 && len(knownHostsPath) == 0 {
+		var err error
+
+		knownHosts, err = scanHostKey(conf, logger, repo.SSH, repo.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(knownHosts) != 0 {
+		knownHostsFile, err := ioutil.TempFile("/tmp", tmpKnownHostPathPrefix)
+		if err != nil {
+			return nil, fmt.Errorf("error creating known_hosts tmp file: %w", err)
+		}
+
+		tempFiles.add(knownHostsFile.Name())
+
+		defer func() {
+			knownHostsFile.Close()
+			tempFiles.remove(knownHostsFile.Name())
+		}()
+
+		knownHostsPath = knownHostsFile.Name()
+
+		err = os.WriteFile(knownHostsPath, []byte(knownHosts), knownHostsPerm)
+		if err != nil {
+			return nil, fmt.Errorf("error writing known_hosts tmp file: %w", err)
+		}
+	}
+
+	return sshAuth(conf, logger, repo.SSH, repo.URL, knownHostsPath)
+}
+
+// repoAuth returns the authentication for a repository, with the transport
+// settings of the run. 'name' identifies the repository in the logs.
+func repoAuth(conf Config, logger Logger, repo RepoConf, name string) (transport.AuthMethod, error) {
+	auth, err := repoCredentials(conf, logger, repo, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newRunAuth(conf, auth)
+}
+
+// repoCredentials returns the authentication of a repository, SSH or HTTP
+// basic authentication based on its URL, or nil when none is configured.
+// 'name' identifies the repository in the logs.
+func repoCredentials(conf Config, logger Logger, repo RepoConf,
+	name string) (transport.AuthMethod, error) {
+	// Anonymous repositories (e.g. a public source or a local destination)
+	// need neither the SSH key and host public keys setup nor the HTTP one.
+	if !repo.hasAuth() {
+		logger.Debug(conf.Debug, "No authentication configured for the", name+
+			", skipping the authentication setup.")
+
+		return anonymousSSHAuth(conf, logger, repo.URL)
+	}
+
+	// The SSH and known_hosts setup is only needed for SSH repositories.
+	if isSSHURL(repo.URL) {
+		return repoSSHAuth(conf, logger, repo)
+	}
+
+	logger.Debug(conf.Debug, "The", name, "is not using SSH, skipping the SSH setup.")
+
+	if len(repo.HTTP.Username) == 0 && len(repo.HTTP.Password) == 0 {
+		return nil, nil
+	}
+
+	logger.Debug(conf.Debug, "Using HTTP basic authentication for the", name+".")
+
+	return &githttp.BasicAuth{
+		Username: repo.HTTP.Username,
+		Password: repo.HTTP.Password,
+	}, nil
+}
+
+// setupDstRemote sets up the destination remote of the staging repository
+// together with its authentication.
+func setupDstRemote(conf Config, logger Logger, stagingRepo *git.Repository) (*git.Remote,
+	transport.AuthMethod, error) {
+	auth, err := repoAuth(conf, logger, conf.Destination, "destination")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dst, err := stagingRepo.CreateRemote(&config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{conf.Destination.URL},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed configuring destination remote: %w", err)
+	}
+
+	return dst, auth, nil
+}
+
+// pushWithAuth sets authentication based on configuration and pushes all
+// references to the configured destination repository (as a mirror). The
+// destination is only pruned when prune is set. The run statistics are
+// collected in 'stats', when not nil.
+func pushWithAuth(conf Config, logger Logger, stagingRepo *git.Repository, prune bool,
+	stats *runStats, span *traceSpan) error {
+	dst, auth, err := setupDstRemote(conf, logger, stagingRepo)
+	if err != nil {
+		return err
+	}
+
+	if conf.OnlyOnNewTag {
+		found, err := hasNewTags(conf, logger, dst, auth, stagingRepo)
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			logger.Info("No new tags in the source, skipping the mirror.")
+
+			if conf.FailOnNoChange {
+				return ErrNoChange
+			}
+
+			return nil
+		}
+	}
+
+	if conf.MaxDstRefs != 0 {
+		if err := checkDstRefLimit(conf, logger, dst, auth, stagingRepo); err != nil {
+			return err
+		}
+	}
+
+	if conf.DetectRewrites || conf.FailOnRewrites {
+		if err := detectRewrites(conf, logger, dst, auth, stagingRepo); err != nil {
+			return err
+		}
+	}
+
+	if conf.DryRun {
+		return dryRun(conf, logger, dst, auth, stagingRepo)
+	}
+
+	// A first mirror has nothing to prune but its HEAD to set. A plan has
+	// its own prunes.
+	firstMirror := false
+
+	if len(conf.ApplyPlan) == 0 {
+		firstMirror, err = destinationEmpty(conf, dst, auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	if firstMirror {
+		logger.Info("The destination is empty, skipping the prune.")
+
+		prune = false
+	}
+
+	if (stats != nil && stats.countChanged) || len(conf.Changelog) != 0 {
+		before, err := listHistoryRefs(conf, dst, auth)
+		if err != nil {
+			return fmt.Errorf("failed to list the destination remote: %w", err)
+		}
+
+		// Counted, and written to the changelog, even when the mirror fails,
+		// as it can still change some references.
+		defer func() {
+			after, err := listHistoryRefs(conf, dst, auth)
+			if err != nil {
+				logger.Warn("Failed to list the changed references:", err)
+
+				return
+			}
+
+			if stats != nil {
+				stats.changed = refsChanged(before, after)
+			}
+
+			if len(conf.Changelog) != 0 {
+				if err := writeChangelog(conf, stagingRepo, before, after); err != nil {
+					logger.Warn("Failed to write the changelog:", err)
+				} else {
+					logger.Info("Wrote the changelog to", conf.Changelog+".")
+				}
+			}
+		}()
+	}
+
+	logger.Info("Pushing to destination...")
+
+	start := time.Now()
+	pushSpan := span.child("push")
+
+	var pushed bool
+
+	if len(conf.ApplyPlan) != 0 {
+		// The plan has all the changes, including the deleted and the pruned
+		// references.
+		pushed, err = applyPlan(conf, logger, dst, auth, stagingRepo)
+		conf.DeleteRefs = nil
+		prune = false
+	} else {
+		pushed, err = pushRefs(conf, logger, dst, auth, stagingRepo)
+	}
+
+	pushSpan.setAttribute("mirror.pushed", pushed)
+	pushSpan.finish(err)
+
+	if err != nil {
+		return err
+	}
+
+	logDuration(logger, stats, "push", start)
+
+	// We can not use prune in git.Push due to an existing bug
+	// https://github.com/go-git/go-git/issues/520 so we workaround it dealing
+	// with the prunning with a separate push.
+	pruned := false
+
+	if len(conf.DeleteRefs) != 0 {
+		pruned, err = deleteRefs(conf, logger, dst, auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	if prune {
+		logger.Info("Pruning the destination...")
+
+		start = time.Now()
+		pruneSpan := span.child("prune")
+
+		prunedRefs, err := pruneRemote(conf, logger, dst, auth, stagingRepo)
+		pruneSpan.setAttribute("mirror.pruned", prunedRefs)
+		pruneSpan.finish(err)
+
+		if err != nil {
+			return err
+		}
+
+		pruned = pruned || prunedRefs
+
+		logDuration(logger, stats, "prune", start)
+	}
+
+	if conf.SymbolicRefs == symbolicRefsRecreate {
+		if err := recreateSymbolicRefs(conf, logger, stagingRepo); err != nil {
+			return err
+		}
+	}
+
+	if firstMirror && pushed {
+		if err := setFirstMirrorHead(conf, logger, dst, auth, stagingRepo); err != nil {
+			return err
+		}
+	}
+
+	if conf.ProvenanceTag && (pushed || pruned) {
+		if err := pushProvenanceTag(conf, logger, dst, auth, stagingRepo); err != nil {
+			return err
+		}
+	}
+
+	if conf.RepackDestination && (pushed || pruned) {
+		start = time.Now()
+
+		if err := repackDestination(conf, logger); err != nil {
+			return err
+		}
+
+		logDuration(logger, stats, "repack", start)
+	}
+
+	if conf.WriteCommitGraph && (pushed || pruned) {
+		start = time.Now()
+
+		if err := writeCommitGraph(conf, logger); err != nil {
+			return err
+		}
+
+		logDuration(logger, stats, "commit-graph", start)
+	}
+
+	if conf.PostCloneVerify {
+		logger.Info("Verifying a clone of the destination...")
+
+		start = time.Now()
+
+		if err := postCloneVerify(conf, logger, auth, stagingRepo); err != nil {
+			return err
+		}
+
+		logDuration(logger, stats, "verify", start)
+	}
+
+	if conf.SyncMetadata {
+		logger.Info("Syncing the repository metadata...")
+
+		err := syncMetadata(conf, logger, func(branch string) error {
+			return ensureDefaultBranch(conf, logger, dst, auth, stagingRepo, branch)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to sync the repository metadata: %w", err)
+		}
+	}
+
+	if !pushed && !pruned && conf.FailOnNoChange {
+		return ErrNoChange
+	}
+
+	return nil
+}
+
+// DoMirror mirrors the source to the destination git repository based on the
+// provided configuration. Special references (for example GitHub's
+// refs/pull/*) are ignored. Every log message includes the run ID. The run is
+// appended to conf.HistoryFile, when set, and traced to conf.OTLPEndpoint,
+// when set.
+func DoMirror(conf Config, logger Logger) error {
+	return runMirror(conf, logger, nil)
+}
+
+// runMirror runs DoMirror collecting the run statistics in 'stats', when not
+// nil.
+func runMirror(conf Config, logger Logger, stats *runStats) error {
+	// The history, the status and the trace have the URLs of the repositories.
+	conf.mapDeprecated()
+
+	if len(conf.RunID) == 0 {
+		conf.RunID = newRunID()
+	}
+
+	logger = withRunID(logger, conf.RunID)
+
+	counter := startTransferCount(conf.RunID)
+	defer stopTransferCount(conf.RunID)
+
+	trace := startTrace(conf, os.Getenv(traceparentEnv))
+
+	if len(conf.HistoryFile) == 0 && len(conf.StatusFile) == 0 && trace == nil {
+		err := doMirror(conf, logger, stats, nil)
+		logTransferred(logger, stats, counter)
+
+		return err
+	}
+
+	start := time.Now()
+
+	if stats == nil {
+		stats = newRunStats(false)
+	}
+
+	// The history, the status and the trace have the changed references.
+	stats.countChanged = true
+
+	err := doMirror(conf, logger, stats, trace)
+	changed := stats.changed
+
+	logTransferred(logger, stats, counter)
+
+	if trace != nil {
+		trace.setAttribute("mirror.refs_changed", changed)
+
+		if traceErr := exportTrace(conf, trace, err); traceErr != nil {
+			logger.Warn("Failed to export the trace:", traceErr)
+		}
+	}
+
+	if len(conf.HistoryFile) != 0 {
+		if histErr := appendHistory(conf, start, changed, err); histErr != nil {
+			logger.Warn("Failed to record the run in the history:", histErr)
+		}
+	}
+
+	if len(conf.StatusFile) != 0 {
+		if statusErr := writeStatus(conf, start, changed, err); statusErr != nil {
+			logger.Warn("Failed to write the status file:", statusErr)
+		}
+	}
+
+	return err
+}
+
+// doMirror runs a mirror for DoMirror. The run statistics are collected in
+// 'stats', when not nil. The steps are traced as children of 'span', when not
+// nil.
+func doMirror(conf Config, logger Logger, stats *runStats, span *traceSpan) error {
+	if len(conf.LockFile) != 0 {
+		lock, err := acquireLock(conf, logger)
+		if err != nil {
+			return err
+		}
+		defer lock.Close()
+	}
+
+	conf.mapDeprecated()
+
+	if err := conf.processVault(logger); err != nil {
+		return err
+	}
+
+	conf.mapHostKeys()
+
+	// The Vault secrets can be SSH keys and host public keys.
+	if len(conf.Vault.Address) != 0 {
+		if err := conf.validateSSH(); err != nil {
+			return err
+		}
+	}
+
+	if err := conf.loadRefsFiles(); err != nil {
+		return err
+	}
+
+	conf.mapIncludeRefs()
+
+	if err := conf.validateDst(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	fetchSpan := span.child("fetch")
+
+	var (
+		repo       *git.Repository
+		stagingDir string
+		err        error
+	)
+
+	if len(conf.PreMirrorCheck) != 0 {
+		repo, stagingDir, err = setupDiskStagingRepo(conf, logger)
+		if len(stagingDir) != 0 {
+			defer tempFiles.remove(stagingDir)
+		}
+	} else {
+		repo, err = setupStagingRepo(conf, logger)
+	}
+
+	if err != nil {
+		fetchSpan.finish(err)
+
+		return err
+	}
+
+	fetchSpan.setRefCount(repo)
+	fetchSpan.finish(nil)
+	logDuration(logger, stats, "fetch", start)
+
+	if err := stageSymbolicRefs(conf, logger, repo); err != nil {
+		return err
+	}
+
+	logObjectsSize(conf, logger, repo, nil, "fetched")
+	logRefObjects(conf, logger, repo, nil)
+
+	if conf.VerifyFetch {
+		logger.Info("Verifying the fetched objects...")
+
+		if err := verifyObjects(repo); err != nil {
+			return err
+		}
+	}
+
+	if conf.InitDestination && !conf.DryRun {
+		if err := initDestination(conf, logger); err != nil {
+			return err
+		}
+	}
+
+	prune := true
+
+	if conf.SkipPruneOnPartialFetch || conf.SkipUnreadableRefs {
+		incomplete, err := fetchIncomplete(conf, logger, repo)
+		if err != nil {
+			return err
+		}
+
+		if incomplete {
+			logger.Warn("The source fetch is incomplete, skipping the " +
+				"destination prune.")
+
+			prune = false
+		}
+	}
+
+	start = time.Now()
+	filterSpan := span.child("filter")
+
+	if err := filterRefs(conf, repo); err != nil {
+		filterSpan.finish(err)
+
+		return err
+	}
+
+	if err := sanitizeRefs(conf, logger, repo); err != nil {
+		filterSpan.finish(err)
+
+		return err
+	}
+
+	filterSpan.setRefCount(repo)
+	filterSpan.finish(nil)
+	logDuration(logger, stats, "filter", start)
+
+	if conf.MinRefs != 0 {
+		if err := checkMinRefs(conf, logger, repo); err != nil {
+			return err
+		}
+	}
+
+	if len(conf.PreMirrorCheck) != 0 {
+		if err := runPreMirrorCheck(conf, logger, stagingDir); err != nil {
+			return err
+		}
+	}
+
+	err = pushWithAuth(conf, logger, repo, prune, stats, span)
+	if !conf.MirrorWiki || (err != nil && !errors.Is(err, ErrNoChange)) {
+		return err
+	}
+
+	// The run changed the destination when the wiki changed, even if the
+	// repository didn't.
+	wikiErr := mirrorWiki(conf, logger, stats, span)
+	if wikiErr == nil || !errors.Is(wikiErr, ErrNoChange) {
+		return wikiErr
+	}
+
+	return err
+}
+
+// PruneCandidates returns the destination references a mirror operation would
+// prune based on the provided configuration. The source is fetched and the
+// destination is listed but nothing is pushed.
+func PruneCandidates(conf Config, logger Logger) ([]*plumbing.Reference, error) {
+	conf.mapDeprecated()
+
+	if err := conf.processVault(logger); err != nil {
+		return nil, err
+	}
+
+	conf.mapHostKeys()
+
+	if err := conf.loadRefsFiles(); err != nil {
+		return nil, err
+	}
+
+	conf.mapIncludeRefs()
+
+	repo, err := setupStagingRepo(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stageSymbolicRefs(conf, logger, repo); err != nil {
+		return nil, err
+	}
+
+	if err := filterRefs(conf, repo); err != nil {
+		return nil, err
+	}
+
+	if err := sanitizeRefs(conf, logger, repo); err != nil {
+		return nil, err
+	}
+
+	dst, auth, err := setupDstRemote(conf, logger, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := listRemote(conf, dst, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	prune, err := pruneRefs(conf, repo, refs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the prune references: %w", err)
+	}
+
+	return prune, nil
 }
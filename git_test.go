@@ -5,12 +5,37 @@
 package mirror
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/agherzan/git-mirror-me/internal/utils"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/go-cmp/cmp"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 const (
@@ -432,6 +457,40 @@ func TestExtraSpecs(t *testing.T) {
 	}
 }
 
+// TestUnmappedRefs tests unmappedRefs function.
+func TestUnmappedRefs(t *testing.T) {
+	t.Parallel()
+
+	repo, _, err := utils.NewTestRepo(t.TempDir(), []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	// The forced push refspecs map the destination references back like the
+	// other ones.
+	for _, spec := range []config.RefSpec{
+		"refs/heads/*:refs/heads/mirror/*",
+		"+refs/heads/*:refs/heads/mirror/*",
+	} {
+		refs, err := unmappedRefs(repo, []config.RefSpec{spec}, []*plumbing.Reference{
+			plumbing.NewReferenceFromStrings("refs/heads/mirror/a", ""),
+			plumbing.NewReferenceFromStrings("refs/heads/mirror/stale", ""),
+			plumbing.NewReferenceFromStrings("refs/heads/other", ""),
+		})
+		if err != nil {
+			t.Fatalf("failed to get unmapped refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(utils.RefsToStrings(refs), []string{
+			"refs/heads/mirror/stale",
+		}) {
+			t.Fatalf("unexpected unmapped refs for %s: %s", spec, utils.RefsToStrings(refs))
+		}
+	}
+}
+
 // TestSetupStagingRepo tests setupStagingRepo function.
 func TestSetupStagingRepo(t *testing.T) {
 	t.Parallel()
@@ -462,14 +521,14 @@ func TestSetupStagingRepo(t *testing.T) {
 
 	// First test that it fails with an invalid source.
 	_, err = setupStagingRepo(Config{
-		SrcRepo: "/invalid",
+		Source: RepoConf{URL: "/invalid"},
 	}, logger)
 	if err == nil {
 		t.Fatal("setupStagingRepo with an invalid source")
 	}
 
 	stagingRepo, err := setupStagingRepo(Config{
-		SrcRepo: srcRepoPath,
+		Source: RepoConf{URL: srcRepoPath},
 	}, logger)
 	if err != nil {
 		t.Fatalf("failed to setup the staging repo: %s", err)
@@ -550,11 +609,13 @@ func TestDoMirror(t *testing.T) {
 
 	// Define the configuration and run the function under test.
 	conf := Config{
-		SrcRepo: srcRepoPath,
-		DstRepo: dstRepoPath,
-		SSH: SSHConf{
-			PrivateKey: testSSHKey,
-			KnownHosts: testKnownHost,
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: dstRepoPath,
+			SSH: SSHConf{
+				PrivateKey: testSSHKey,
+				KnownHosts: testKnownHost,
+			},
 		},
 	}
 
@@ -588,3 +649,2804 @@ func TestDoMirror(t *testing.T) {
 		t.Fatal("unexpected hash test result for the dst repo")
 	}
 }
+
+// TestPruneRemote tests pruneRemote function.
+func TestPruneRemote(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	// Create a source repository and a destination repository with an extra
+	// reference.
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/c",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		Debug:       true,
+	}
+
+	stagingRepo, err := setupStagingRepo(conf, logger)
+	if err != nil {
+		t.Fatalf("failed to setup the staging repo: %s", err)
+	}
+
+	dst, err := stagingRepo.CreateRemote(&config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{dstRepoPath},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the dst remote: %s", err)
+	}
+
+	pruned, err := pruneRemote(conf, logger, dst, nil, stagingRepo)
+	if err != nil {
+		t.Fatalf("pruneRemote failed: %s", err)
+	}
+
+	if !pruned {
+		t.Fatal("pruneRemote didn't report the pruning")
+	}
+
+	// The pruned reference is logged and removed from the destination.
+	if !strings.Contains(logs.String(), "Pruning refs/heads/c") {
+		t.Fatalf("pruned reference not logged: %s", logs.String())
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestPruneRefsPreserve tests that pruneRefs doesn't prune the preserved
+// references.
+func TestPruneRefsPreserve(t *testing.T) {
+	t.Parallel()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to create a repo: %s", err)
+	}
+
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/archive/a", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/heads/archive/b/c", plumbing.ZeroHash),
+		plumbing.NewHashReference("refs/heads/stale", plumbing.ZeroHash),
+	}
+
+	prune, err := pruneRefs(Config{
+		PreserveRefs: []string{"refs/heads/archive/*"},
+	}, repo, refs)
+	if err != nil {
+		t.Fatalf("pruneRefs failed: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(utils.RefsToStrings(prune), []string{
+		"refs/heads/archive/b/c",
+		"refs/heads/stale",
+	}) {
+		t.Fatalf("unexpected prune refs: %s", utils.RefsToStrings(prune))
+	}
+}
+
+// TestPruneCandidates tests PruneCandidates function.
+func TestPruneCandidates(t *testing.T) {
+	t.Parallel()
+
+	// No need for logs.
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/pull/1/head",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/c",
+		"refs/pull/1/head",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	prune, err := PruneCandidates(Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}, logger)
+	if err != nil {
+		t.Fatalf("PruneCandidates failed: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(utils.RefsToStrings(prune), []string{
+		"refs/heads/c",
+		"refs/pull/1/head",
+	}) {
+		t.Fatalf("unexpected prune candidates: %s", utils.RefsToStrings(prune))
+	}
+
+	// Nothing is pruned.
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if len(dstRepoRefs) != 5 {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestWithRetries tests withRetries function.
+func TestWithRetries(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	conf := Config{
+		Retries:      2,
+		RetryBackoff: time.Millisecond,
+	}
+	errTest := errors.New("test error")
+
+	{
+		// Succeeds after failing less times than the number of retries.
+		calls := 0
+		err := withRetries(conf, logger, "test", func() error {
+			calls++
+			if calls < 3 {
+				return errTest
+			}
+
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Fatalf("unexpected result: %s (%d calls)", err, calls)
+		}
+	}
+	{
+		// Fails when all the retries fail.
+		calls := 0
+		err := withRetries(conf, logger, "test", func() error {
+			calls++
+
+			return errTest
+		})
+		if !errors.Is(err, errTest) || calls != 3 {
+			t.Fatalf("unexpected result: %s (%d calls)", err, calls)
+		}
+	}
+	{
+		// git.NoErrAlreadyUpToDate is not retried.
+		calls := 0
+		err := withRetries(conf, logger, "test", func() error {
+			calls++
+
+			return git.NoErrAlreadyUpToDate
+		})
+		if !errors.Is(err, git.NoErrAlreadyUpToDate) || calls != 1 {
+			t.Fatalf("unexpected result: %s (%d calls)", err, calls)
+		}
+	}
+	{
+		// transport.ErrEmptyRemoteRepository is not retried.
+		calls := 0
+		err := withRetries(conf, logger, "test", func() error {
+			calls++
+
+			return transport.ErrEmptyRemoteRepository
+		})
+		if !errors.Is(err, transport.ErrEmptyRemoteRepository) || calls != 1 {
+			t.Fatalf("unexpected result: %s (%d calls)", err, calls)
+		}
+	}
+}
+
+// TestRetryDelay tests retryDelay function.
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	{
+		// Test a fixed backoff.
+		conf := Config{RetryBackoff: time.Second}
+		for attempt := 1; attempt <= 3; attempt++ {
+			if delay := retryDelay(conf, attempt); delay != time.Second {
+				t.Fatalf("unexpected delay for attempt %d: %s", attempt, delay)
+			}
+		}
+	}
+	{
+		// Test a capped exponential backoff with jitter.
+		conf := Config{RetryBackoff: time.Second, RetryMaxBackoff: 5 * time.Second}
+		for attempt, limit := range map[int]time.Duration{
+			1:  time.Second,
+			2:  2 * time.Second,
+			3:  4 * time.Second,
+			4:  5 * time.Second,
+			64: 5 * time.Second,
+		} {
+			if delay := retryDelay(conf, attempt); delay < limit/2 || delay > limit {
+				t.Fatalf("unexpected delay for attempt %d: %s", attempt, delay)
+			}
+		}
+	}
+}
+
+// TestVerifyObjects tests verifyObjects function.
+func TestVerifyObjects(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	stagingRepo, err := setupStagingRepo(Config{
+		Source: RepoConf{URL: srcRepoPath},
+	}, logger)
+	if err != nil {
+		t.Fatalf("failed to setup the staging repo: %s", err)
+	}
+
+	if err := verifyObjects(stagingRepo); err != nil {
+		t.Fatalf("complete fetch failed verification: %s", err)
+	}
+
+	// A reference to an object that is not in the storage fails the check.
+	err = stagingRepo.Storer.SetReference(plumbing.NewReferenceFromStrings(
+		"refs/heads/broken", "0123456789012345678901234567890123456789"))
+	if err != nil {
+		t.Fatalf("failed to set reference: %s", err)
+	}
+
+	if err := verifyObjects(stagingRepo); !errors.Is(err, ErrMissingObjects) {
+		t.Fatalf("missing objects passed verification: %s", err)
+	}
+}
+
+// TestDoMirrorInitDestination tests DoMirror initialising a local destination
+// repository.
+func TestDoMirrorInitDestination(t *testing.T) {
+	t.Parallel()
+
+	// No need for logs.
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst directory: %s", err)
+	}
+
+	defer os.RemoveAll(dstPath)
+
+	conf := Config{
+		Source:          RepoConf{URL: srcRepoPath},
+		Destination:     RepoConf{URL: filepath.Join(dstPath, "mirror.git")},
+		InitDestination: true,
+	}
+
+	// The missing destination is created.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepo, err := git.PlainOpen(conf.Destination.URL)
+	if err != nil {
+		t.Fatalf("the destination was not initialised: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the destination was not mirrored: %s", err)
+	}
+
+	// An existing destination repository is reused.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed on an existing destination: %s", err)
+	}
+
+	// A directory with other content is not initialised.
+	conf.Destination.URL = dstPath
+	if err := os.WriteFile(filepath.Join(dstPath, "file"), nil, 0o600); err != nil {
+		t.Fatalf("failed to write a file: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); !errors.Is(err, ErrDstNotEmpty) {
+		t.Fatalf("unexpected error for a non-empty destination: %s", err)
+	}
+}
+
+// TestSetupStagingRepoBranch tests setting the staging repository initial
+// branch.
+func TestSetupStagingRepoBranch(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/main",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	for _, branch := range []string{"main", "refs/heads/main"} {
+		stagingRepo, err := setupStagingRepo(Config{
+			Source:        RepoConf{URL: srcRepoPath},
+			StagingBranch: branch,
+		}, logger)
+		if err != nil {
+			t.Fatalf("failed to setup the staging repo: %s", err)
+		}
+
+		head, err := stagingRepo.Head()
+		if err != nil || head.Name() != "refs/heads/main" || head.Hash() != srcHead {
+			t.Fatalf("unexpected staging repo HEAD: %v (%v)", head, err)
+		}
+	}
+}
+
+// TestDoMirrorDeleteRefs tests that the references matching DeleteRefs are
+// deleted from the destination and not pushed.
+func TestDoMirrorDeleteRefs(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/leaked",
+		"refs/tags/secret-1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/c",
+		"refs/heads/leaked",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// The deleted references are deleted even when they are preserved.
+	conf := Config{
+		Source:       RepoConf{URL: srcRepoPath},
+		Destination:  RepoConf{URL: dstRepoPath},
+		PreserveRefs: []string{"refs/heads/*"},
+		DeleteRefs:   []string{"refs/heads/leaked", "refs/tags/secret-*"},
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/heads/c",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	if !strings.Contains(logs.String(), "Deleting refs/heads/leaked") {
+		t.Fatalf("the deleted reference was not logged: %s", logs.String())
+	}
+}
+
+// TestFetchIncomplete tests fetchIncomplete function and that an incomplete
+// fetch doesn't prune the destination.
+func TestFetchIncomplete(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:                  RepoConf{URL: srcRepoPath},
+		Destination:             RepoConf{URL: dstRepoPath},
+		SkipPruneOnPartialFetch: true,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	stagingRepo, err := setupStagingRepo(conf, logger)
+	if err != nil {
+		t.Fatalf("failed to setup the staging repo: %s", err)
+	}
+
+	incomplete, err := fetchIncomplete(conf, logger, stagingRepo)
+	if err != nil || incomplete {
+		t.Fatalf("complete fetch reported as incomplete: %s", err)
+	}
+
+	// A fetch missing a source reference is incomplete and doesn't prune the
+	// reference from the destination.
+	if err := stagingRepo.Storer.RemoveReference("refs/heads/a"); err != nil {
+		t.Fatalf("failed to remove a reference: %s", err)
+	}
+
+	incomplete, err = fetchIncomplete(conf, logger, stagingRepo)
+	if err != nil || !incomplete {
+		t.Fatalf("partial fetch not reported as incomplete: %s", err)
+	}
+
+	if !strings.Contains(logs.String(), "missing refs/heads/a") {
+		t.Fatalf("missing reference not logged: %s", logs.String())
+	}
+
+	if err := pushWithAuth(conf, logger, stagingRepo, false, nil, nil); err != nil {
+		t.Fatalf("pushWithAuth failed: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the destination was pruned: %s", err)
+	}
+}
+
+// TestRepackDestination tests that a changed local destination is repacked.
+func TestRepackDestination(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepoWithContent(srcRepoPath, []string{
+		"refs/heads/a",
+	}, "src")
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/b",
+	}, "dst")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// Add unreachable loose objects to the destination, an old one and a
+	// recent one.
+	var unreachable []plumbing.Hash
+
+	for _, content := range []string{"old", "recent"} {
+		blob := dstRepo.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+
+		writer, err := blob.Writer()
+		if err != nil {
+			t.Fatalf("failed to write a blob: %s", err)
+		}
+
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write a blob: %s", err)
+		}
+
+		hash, err := dstRepo.Storer.SetEncodedObject(blob)
+		if err != nil {
+			t.Fatalf("failed to store a blob: %s", err)
+		}
+
+		unreachable = append(unreachable, hash)
+	}
+
+	old := time.Now().Add(-pruneGracePeriod - time.Hour)
+	oldPath := filepath.Join(dstRepoPath, "objects", unreachable[0].String()[:2],
+		unreachable[0].String()[2:])
+
+	if err := os.Chtimes(oldPath, old, old); err != nil {
+		t.Fatalf("failed to age a blob: %s", err)
+	}
+
+	conf := Config{
+		Source:            RepoConf{URL: srcRepoPath},
+		Destination:       RepoConf{URL: dstRepoPath},
+		RepackDestination: true,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepo, err = git.PlainOpen(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to open the dst repo: %s", err)
+	}
+
+	if dstRepo.Storer.HasEncodedObject(unreachable[0]) == nil {
+		t.Fatal("the old unreachable object was not removed")
+	}
+
+	if err := dstRepo.Storer.HasEncodedObject(unreachable[1]); err != nil {
+		t.Fatalf("the recent unreachable object was removed: %s", err)
+	}
+
+	packs, err := filepath.Glob(filepath.Join(dstRepoPath, "objects", "pack", "*.pack"))
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("unexpected destination packs: %v", packs)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the reference was not mirrored: %s", err)
+	}
+}
+
+// TestWriteCommitGraph tests that a commit-graph is written in a changed local
+// destination.
+func TestWriteCommitGraph(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:           RepoConf{URL: srcRepoPath},
+		Destination:      RepoConf{URL: dstRepoPath},
+		WriteCommitGraph: true,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	file, err := os.Open(filepath.Join(dstRepoPath, "objects", "info", "commit-graph"))
+	if err != nil {
+		t.Fatalf("the commit-graph was not written: %s", err)
+	}
+	defer file.Close()
+
+	index, err := commitgraph.OpenFileIndex(file)
+	if err != nil {
+		t.Fatalf("failed to read the commit-graph: %s", err)
+	}
+
+	i, err := index.GetIndexByHash(srcHead)
+	if err != nil {
+		t.Fatalf("the source HEAD is not in the commit-graph: %s", err)
+	}
+
+	data, err := index.GetCommitDataByIndex(i)
+	if err != nil || data.Generation != 1 {
+		t.Fatalf("unexpected commit-graph data: %v (%v)", data, err)
+	}
+}
+
+// TestSkipUnreadableRefs tests that an unreadable source reference is skipped
+// and doesn't prune the destination.
+func TestSkipUnreadableRefs(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	srcRepoPath := t.TempDir()
+
+	srcRepo, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/broken",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// A dangling reference fails the whole fetch.
+	err = srcRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/broken",
+		plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")))
+	if err != nil {
+		t.Fatalf("failed to create a dangling reference: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}
+
+	if _, err := setupStagingRepo(conf, logger); err == nil {
+		t.Fatal("setupStagingRepo with a dangling source reference didn't fail")
+	}
+
+	conf.SkipUnreadableRefs = true
+
+	stagingRepo, err := setupStagingRepo(conf, logger)
+	if err != nil {
+		t.Fatalf("setupStagingRepo failed: %s", err)
+	}
+
+	if _, err := stagingRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the readable reference was not fetched: %s", err)
+	}
+
+	if !strings.Contains(logs.String(), "unreadable source reference refs/heads/broken") ||
+		!strings.Contains(logs.String(), "Skipped 1 unreadable") {
+		t.Fatalf("the unreadable reference was not logged: %s", logs.String())
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the readable reference was not mirrored: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/broken", false); err != nil {
+		t.Fatalf("the destination was pruned: %s", err)
+	}
+
+	// Nothing skipped is not logged.
+	if err := srcRepo.Storer.RemoveReference("refs/heads/broken"); err != nil {
+		t.Fatalf("failed to remove the dangling reference: %s", err)
+	}
+
+	logs.Reset()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to create a staging repo: %s", err)
+	}
+
+	src, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: srcRemoteName,
+		URLs: []string{srcRepoPath},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the src remote: %s", err)
+	}
+
+	if err := fetchReadableRefs(conf, logger, src, nil); err != nil {
+		t.Fatalf("fetchReadableRefs failed: %s", err)
+	}
+
+	if strings.Contains(logs.String(), "Skipped") {
+		t.Fatalf("unexpected skipped references: %s", logs.String())
+	}
+}
+
+// TestPostCloneVerify tests postCloneVerify function.
+func TestPostCloneVerify(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:          RepoConf{URL: srcRepoPath},
+		Destination:     RepoConf{URL: dstRepoPath},
+		PostCloneVerify: true,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if !strings.Contains(logs.String(), "Verified 3 reference(s)") {
+		t.Fatalf("the destination clone was not verified: %s", logs.String())
+	}
+
+	// A reference resolving differently on the destination fails the check.
+	stagingRepo, err := setupStagingRepo(conf, logger)
+	if err != nil {
+		t.Fatalf("failed to setup the staging repo: %s", err)
+	}
+
+	master, err := stagingRepo.Reference("refs/heads/master", false)
+	if err != nil {
+		t.Fatalf("failed to get a reference: %s", err)
+	}
+
+	err = stagingRepo.Storer.SetReference(plumbing.NewHashReference(
+		"refs/tags/v2", master.Hash()))
+	if err != nil {
+		t.Fatalf("failed to set reference: %s", err)
+	}
+
+	err = postCloneVerify(conf, logger, nil, stagingRepo)
+	if !errors.Is(err, ErrPostCloneVerify) ||
+		!strings.Contains(err.Error(), "refs/tags/v2 (missing)") {
+		t.Fatalf("unexpected error for a missing reference: %s", err)
+	}
+
+	// Skipped updates are expected in fast-forward only mode.
+	conf.FastForwardOnly = true
+	if err := postCloneVerify(conf, logger, nil, stagingRepo); err != nil {
+		t.Fatalf("fast-forward only verification failed: %s", err)
+	}
+}
+
+// TestDoMirrorDryRun tests that DoMirror doesn't change the destination in dry
+// run mode.
+func TestDoMirrorDryRun(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	// Create a source repository.
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	// Create a destination repository.
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, dstHead, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/c",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	err = DoMirror(Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		DryRun:      true,
+	}, logger)
+	if err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	// The changes are logged but the destination is not changed.
+	for _, msg := range []string{
+		"Would push refs/heads/a",
+		"Would push refs/heads/b",
+		"Would prune refs/heads/c",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Fatalf("%q not logged: %s", msg, logs.String())
+		}
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/c",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	ok, err := utils.RepoRefsCheckHash(dstRepo, dstHead, "refs/")
+	if err != nil {
+		t.Fatalf("dst repo hash check failed: %s", err)
+	}
+
+	if !ok {
+		t.Fatal("unexpected hash test result for the dst repo")
+	}
+}
+
+// TestPushError tests pushError function.
+func TestPushError(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []error{
+		transport.ErrAuthorizationFailed,
+		transport.ErrAuthenticationRequired,
+		errors.New("ERROR: Permission to foo/bar.git denied to baz."),
+	} {
+		if !errors.Is(pushError("dst", err), ErrPermissionDenied) {
+			t.Fatalf("%q not reported as permission denied", err)
+		}
+	}
+
+	for _, err := range []error{
+		io.EOF,
+		fmt.Errorf("wrapped: %w", io.ErrUnexpectedEOF),
+		errors.New("fatal: the remote end hung up unexpectedly"),
+	} {
+		hungUpErr := pushError("https://example.com/foo/bar.git", err)
+		if !errors.Is(hungUpErr, ErrRemoteHungUp) {
+			t.Fatalf("%q not reported as remote hung up", err)
+		}
+		if !strings.Contains(hungUpErr.Error(), "example.com") {
+			t.Fatalf("destination host not reported: %s", hungUpErr)
+		}
+	}
+
+	errTest := errors.New("test error")
+	if err := pushError("dst", errTest); err != errTest {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// TestSetPackWindow tests setPackWindow function.
+func TestSetPackWindow(t *testing.T) {
+	t.Parallel()
+
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to init a repo: %s", err)
+	}
+
+	for window, expected := range map[int]uint{
+		50: 50,
+		-1: 0,
+	} {
+		if err := setPackWindow(repo, window); err != nil {
+			t.Fatalf("failed to set the pack window: %s", err)
+		}
+
+		cfg, err := repo.Config()
+		if err != nil {
+			t.Fatalf("failed to get the repo config: %s", err)
+		}
+
+		if cfg.Pack.Window != expected {
+			t.Fatalf("unexpected pack window for %d: %d", window,
+				cfg.Pack.Window)
+		}
+	}
+}
+
+// TestDoMirrorOnlyOnNewTag tests DoMirror function with OnlyOnNewTag.
+func TestDoMirrorOnlyOnNewTag(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	// Create a source repository.
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	srcRepo, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	// Create a destination repository which already has the source tag.
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:       RepoConf{URL: srcRepoPath},
+		Destination:  RepoConf{URL: dstRepoPath},
+		OnlyOnNewTag: true,
+	}
+
+	// No new tag so the branch is not mirrored.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	// A new tag triggers the mirror.
+	head, err := srcRepo.Head()
+	if err != nil {
+		t.Fatalf("failed to get the src HEAD: %s", err)
+	}
+
+	err = srcRepo.Storer.SetReference(plumbing.NewHashReference("refs/tags/v2",
+		head.Hash()))
+	if err != nil {
+		t.Fatalf("failed to set reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err = utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/tags/v1",
+		"refs/tags/v2",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestDoMirrorFastForwardOnly tests DoMirror function with FastForwardOnly.
+func TestDoMirrorFastForwardOnly(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	// Create a source repository.
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/heads/c",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	// Create a destination repository with an unrelated history.
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, dstHead, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+	}, "unrelated")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	err = DoMirror(Config{
+		Source:          RepoConf{URL: srcRepoPath},
+		Destination:     RepoConf{URL: dstRepoPath},
+		FastForwardOnly: true,
+		ForceRefs:       []string{"refs/heads/b"},
+	}, logger)
+	if err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	// Only the forced and the new references are updated.
+	for name, hash := range map[string]plumbing.Hash{
+		"refs/heads/master": dstHead,
+		"refs/heads/a":      dstHead,
+		"refs/heads/b":      srcHead,
+		"refs/heads/c":      srcHead,
+	} {
+		ref, err := dstRepo.Reference(plumbing.ReferenceName(name), false)
+		if err != nil {
+			t.Fatalf("failed to get %s in the dst repo: %s", name, err)
+		}
+
+		if ref.Hash() != hash {
+			t.Fatalf("unexpected hash for %s in the dst repo", name)
+		}
+	}
+}
+
+// TestDoMirrorReplacePlaceholder tests DoMirror function replacing a
+// placeholder destination in FastForwardOnly mode.
+func TestDoMirrorReplacePlaceholder(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{"refs/heads/a"})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	// Create a destination repository with a single unrelated commit.
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepoWithContent(dstRepoPath,
+		[]string{"refs/heads/main"}, "README")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	err = DoMirror(Config{
+		Source:             RepoConf{URL: srcRepoPath},
+		Destination:        RepoConf{URL: dstRepoPath},
+		FastForwardOnly:    true,
+		ReplacePlaceholder: true,
+	}, logger)
+	if err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	ok, err := utils.RepoRefsCheckHash(dstRepo, srcHead, "refs/heads/")
+	if err != nil {
+		t.Fatalf("failed to check the dst repo refs: %s", err)
+	}
+
+	if !ok {
+		t.Fatal("the placeholder content was not replaced")
+	}
+}
+
+// TestAdvertisesPushCert tests advertisesPushCert function.
+func TestAdvertisesPushCert(t *testing.T) {
+	t.Parallel()
+
+	path, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(path)
+
+	if _, _, err := utils.NewTestRepo(path, []string{}); err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	// go-git's file transport doesn't advertise push certificates support.
+	if advertisesPushCert(path, nil) {
+		t.Fatal("unexpected push certificates support")
+	}
+
+	if advertisesPushCert("/invalid", nil) {
+		t.Fatal("unexpected push certificates support for an invalid repo")
+	}
+}
+
+// TestSSHKey tests sshKey function.
+func TestSSHKey(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	keyDir, err := ioutil.TempDir("/tmp", "git-mirror-me-test-keys-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary keys dir: %s", err)
+	}
+
+	defer os.RemoveAll(keyDir)
+
+	err = os.WriteFile(keyDir+"/github.com.pem", []byte("githubkey"), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write a test key: %s", err)
+	}
+
+	sshConf := SSHConf{KeyDir: keyDir}
+
+	for url, expected := range map[string]string{
+		"git@github.com:foo/bar.git":       "githubkey",
+		"ssh://git@github.com/foo/bar.git": "githubkey",
+		"git@gitlab.com:foo/bar.git":       "",
+		"https://github.com/foo/bar.git":   "",
+	} {
+		key, err := sshKey(Config{}, logger, sshConf, url)
+		if err != nil {
+			t.Fatalf("sshKey failed for %s: %s", url, err)
+		}
+
+		if string(key) != expected {
+			t.Fatalf("unexpected key for %s: %s", url, key)
+		}
+	}
+
+	// The key provided by content takes precedence.
+	sshConf.PrivateKey = "key"
+
+	key, err := sshKey(Config{}, logger, sshConf, "git@github.com:foo/bar.git")
+	if err != nil || string(key) != "key" {
+		t.Fatalf("unexpected key: %s (%s)", key, err)
+	}
+}
+
+// TestSSHAuthDialTimeout tests that the dial timeout is set in the SSH client
+// configuration.
+func TestSSHAuthDialTimeout(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, []byte(testKnownHost), 0o600); err != nil {
+		t.Fatalf("failed to write the known hosts: %s", err)
+	}
+
+	for _, timeout := range []time.Duration{0, time.Second} {
+		auth, err := sshAuth(Config{DialTimeout: timeout}, logger,
+			SSHConf{PrivateKey: testSSHKey}, "git@github.com:foo/bar.git",
+			knownHostsPath)
+		if err != nil {
+			t.Fatalf("sshAuth failed: %s", err)
+		}
+
+		sshMethod, ok := auth.(ssh.AuthMethod)
+		if !ok {
+			t.Fatalf("unexpected SSH authentication: %T", auth)
+		}
+
+		config, err := sshMethod.ClientConfig()
+		if err != nil {
+			t.Fatalf("failed to get the SSH client configuration: %s", err)
+		}
+
+		if config.Timeout != timeout || config.HostKeyCallback == nil {
+			t.Fatalf("unexpected SSH client configuration: %v", config)
+		}
+	}
+}
+
+// TestRepoAuthAnonymous tests that no authentication is set up for the
+// repositories without credentials, without setting up the SSH host keys.
+func TestRepoAuthAnonymous(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	for _, repo := range []RepoConf{
+		{URL: "https://github.com/foo/bar.git"},
+		{URL: "/tmp/foo/bar.git"},
+		{
+			// Scanning would fail to connect to the host.
+			URL: "ssh://git@127.0.0.1:1/foo/bar.git",
+			SSH: SSHConf{ScanHostKeys: true},
+		},
+	} {
+		auth, err := repoAuth(Config{}, logger, repo, "source")
+		if err != nil || auth.(runAuth).auth != nil {
+			t.Fatalf("unexpected authentication for %s: %v (%v)", repo.URL, auth, err)
+		}
+	}
+}
+
+// TestListRemoteTimeout tests that listing an unresponsive remote is bounded
+// by the list timeout.
+func TestListRemoteTimeout(t *testing.T) {
+	t.Parallel()
+
+	unblock := make(chan struct{})
+	defer close(unblock)
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-unblock:
+			}
+		}))
+	defer server.Close()
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{server.URL + "/foo.git"},
+	})
+
+	start := time.Now()
+
+	if _, err := listRemote(Config{ListTimeout: 100 * time.Millisecond}, remote,
+		nil); err == nil {
+		t.Fatal("listing an unresponsive remote succeeded")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the list timeout was not applied: %s", elapsed)
+	}
+}
+
+// TestListRemoteDetachedHead tests listing a remote whose detached HEAD
+// matches none of its references.
+func TestListRemoteDetachedHead(t *testing.T) {
+	t.Parallel()
+
+	repoPath := t.TempDir()
+
+	repo, _, err := utils.NewTestRepo(repoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	head := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+
+	err = repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, head))
+	if err != nil {
+		t.Fatalf("failed to detach HEAD: %s", err)
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{repoPath},
+	})
+
+	refs, err := listRemote(Config{}, remote, nil)
+	if err != nil {
+		t.Fatalf("listing the remote failed: %s", err)
+	}
+
+	expected := []string{"HEAD", "refs/heads/a", "refs/heads/master"}
+	if names := utils.RefsToStrings(refs); !utils.SlicesAreEqual(names, expected) {
+		t.Fatalf("unexpected refs: %v", names)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Hash() != head {
+			t.Fatalf("unexpected HEAD: %s", ref)
+		}
+	}
+}
+
+// TestRepoSSHAuthMultiHost tests that the host public keys provided by
+// content can have entries for multiple hosts.
+func TestRepoSSHAuthMultiHost(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	hostKeys := make([]gossh.PublicKey, 3)
+
+	for i := range hostKeys {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("failed to generate a host key: %s", err)
+		}
+
+		hostKeys[i], err = gossh.NewPublicKey(public)
+		if err != nil {
+			t.Fatalf("failed to create a host public key: %s", err)
+		}
+	}
+
+	hosts := []string{"127.0.0.1:22", "127.0.0.2:2222"}
+	knownHosts := "# Comments and empty lines are ignored.\n\n" +
+		knownhosts.Line([]string{knownhosts.Normalize(hosts[0])}, hostKeys[0]) + "\n" +
+		knownhosts.Line([]string{knownhosts.Normalize(hosts[1])}, hostKeys[1]) + "\n"
+
+	for i, host := range hosts {
+		auth, err := repoSSHAuth(Config{}, logger, RepoConf{
+			URL: "ssh://git@" + host + "/foo/bar.git",
+			SSH: SSHConf{PrivateKey: testSSHKey, KnownHosts: knownHosts},
+		})
+		if err != nil {
+			t.Fatalf("repoSSHAuth failed: %s", err)
+		}
+
+		config, err := auth.(ssh.AuthMethod).ClientConfig()
+		if err != nil {
+			t.Fatalf("failed to get the SSH client configuration: %s", err)
+		}
+
+		tcpAddr, err := net.ResolveTCPAddr("tcp", host)
+		if err != nil {
+			t.Fatalf("failed to resolve the host address: %s", err)
+		}
+
+		if err := config.HostKeyCallback(host, tcpAddr, hostKeys[i]); err != nil {
+			t.Fatalf("the host key of %s is not trusted: %s", host, err)
+		}
+
+		if err := config.HostKeyCallback(host, tcpAddr, hostKeys[2]); err == nil {
+			t.Fatalf("an unknown host key of %s is trusted", host)
+		}
+	}
+}
+
+// newTestSSHServer starts an SSH server, only doing the handshake, and returns
+// its address and host public key.
+func newTestSSHServer(t *testing.T) (string, gossh.PublicKey) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the host key: %s", err)
+	}
+
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("failed to create the host key signer: %s", err)
+	}
+
+	serverConfig := &gossh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, _, _, _ = gossh.NewServerConn(conn, serverConfig)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), signer.PublicKey()
+}
+
+// TestScanHostKey tests scanHostKey function.
+func TestScanHostKey(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	addr, hostKey := newTestSSHServer(t)
+	url := "ssh://git@" + addr + "/foo/bar.git"
+
+	knownHosts, err := scanHostKey(Config{}, logger, SSHConf{}, url)
+	if err != nil {
+		t.Fatalf("scanHostKey failed: %s", err)
+	}
+
+	if knownHosts != knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey) {
+		t.Fatalf("unexpected known hosts: %s", knownHosts)
+	}
+
+	// The scanned key needs to match the fingerprint.
+	_, err = scanHostKey(Config{}, logger, SSHConf{
+		HostKeyFingerprint: gossh.FingerprintSHA256(hostKey),
+	}, url)
+	if err != nil {
+		t.Fatalf("scanHostKey failed with the matching fingerprint: %s", err)
+	}
+
+	_, err = scanHostKey(Config{}, logger, SSHConf{
+		HostKeyFingerprint: "SHA256:invalid",
+	}, url)
+	if !errors.Is(err, ErrHostKeyFingerprint) {
+		t.Fatalf("unexpected error for a fingerprint mismatch: %s", err)
+	}
+
+	// The scan connects from the local address.
+	if _, err := scanHostKey(Config{LocalAddr: "127.0.0.1"}, logger, SSHConf{}, url); err != nil {
+		t.Fatalf("scanHostKey failed from a local address: %s", err)
+	}
+
+	if _, err := scanHostKey(Config{LocalAddr: unassignedAddr}, logger, SSHConf{}, url); err == nil {
+		t.Fatal("scanHostKey connected from an unassigned local address")
+	}
+
+	// The scanned key is used as the host public key.
+	auth, err := repoSSHAuth(Config{}, logger, RepoConf{
+		URL: url,
+		SSH: SSHConf{PrivateKey: testSSHKey, ScanHostKeys: true},
+	})
+	if err != nil || auth == nil {
+		t.Fatalf("repoSSHAuth failed with a scanned host key: %s", err)
+	}
+
+	config, err := auth.(ssh.AuthMethod).ClientConfig()
+	if err != nil {
+		t.Fatalf("failed to get the SSH client configuration: %s", err)
+	}
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to resolve the server address: %s", err)
+	}
+
+	if err := config.HostKeyCallback(addr, tcpAddr, hostKey); err != nil {
+		t.Fatalf("the scanned host key is not trusted: %s", err)
+	}
+}
+
+// newTestHistoryRepo returns an in-memory repository with a history of two
+// commits and the hashes of the two commits (parent first).
+func newTestHistoryRepo(t *testing.T) (*git.Repository, plumbing.Hash, plumbing.Hash) {
+	t.Helper()
+
+	fs := memfs.New()
+
+	repo, err := git.Init(memory.NewStorage(), fs)
+	if err != nil {
+		t.Fatalf("failed to init a repo: %s", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("failed to get worktree: %s", err)
+	}
+
+	hashes := make([]plumbing.Hash, 0, 2)
+
+	for _, content := range []string{"first", "second"} {
+		if err := util.WriteFile(fs, "testfile.txt", []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write test file: %s", err)
+		}
+
+		if _, err := worktree.Add("testfile.txt"); err != nil {
+			t.Fatalf("failed add to index: %s", err)
+		}
+
+		hash, err := worktree.Commit(content, &git.CommitOptions{
+			Author: &object.Signature{
+				Name:  "Example",
+				Email: "ex@ample.com",
+				When:  time.Now(),
+			},
+		})
+		if err != nil {
+			t.Fatalf("failed test commit: %s", err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	return repo, hashes[0], hashes[1]
+}
+
+// TestFilterRefsFunc tests that filterRefs uses the configured filter function
+// instead of the default filtering.
+func TestFilterRefsFunc(t *testing.T) {
+	t.Parallel()
+
+	path, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(path)
+
+	repo, _, err := utils.NewTestRepo(path, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/pull/1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	err = filterRefs(Config{
+		RefFilter: func(ref *plumbing.Reference) bool {
+			return ref.Name() != "refs/heads/b"
+		},
+	}, repo)
+	if err != nil {
+		t.Fatalf("failed to filter refs: %s", err)
+	}
+
+	refs, err := utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get repo's refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/pull/1",
+	}) {
+		t.Fatalf("unexpected refs in repo: %s", refs)
+	}
+}
+
+// TestFilterAuthorDomains tests filterAuthorDomains function.
+func TestFilterAuthorDomains(t *testing.T) {
+	t.Parallel()
+
+	for email, expected := range map[string]bool{
+		"dev@corp.example.com":     true,
+		"dev@git.Corp.Example.com": true,
+		"dev@example.com":          false,
+		"dev@notcorp.example.com":  false,
+		"invalid":                  false,
+	} {
+		if inDomains(email, []string{"corp.example.com"}) != expected {
+			t.Fatalf("unexpected domain match for %s", email)
+		}
+	}
+
+	repo, first, _ := newTestHistoryRepo(t)
+
+	firstCommit, err := repo.CommitObject(first)
+	if err != nil {
+		t.Fatalf("failed to get a commit: %s", err)
+	}
+
+	// Add a commit authored from an excluded domain.
+	internal := &object.Commit{
+		Author: object.Signature{
+			Name:  "Internal",
+			Email: "dev@git.corp.example.com",
+			When:  time.Now(),
+		},
+		Message:      "internal",
+		TreeHash:     firstCommit.TreeHash,
+		ParentHashes: []plumbing.Hash{first},
+	}
+	internal.Committer = internal.Author
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := internal.Encode(obj); err != nil {
+		t.Fatalf("failed to encode a commit: %s", err)
+	}
+
+	internalHash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		t.Fatalf("failed to store a commit: %s", err)
+	}
+
+	for name, hash := range map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/first":    first,
+		"refs/heads/internal": internalHash,
+	} {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			t.Fatalf("failed to set reference: %s", err)
+		}
+	}
+
+	if err := filterAuthorDomains(repo, []string{"corp.example.com"}); err != nil {
+		t.Fatalf("filterAuthorDomains failed: %s", err)
+	}
+
+	refs, err := utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get repo's refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/first",
+	}) {
+		t.Fatalf("unexpected refs in repo: %s", refs)
+	}
+}
+
+// TestDoMirrorExcludeRefs tests that the excluded references are not mirrored.
+func TestDoMirrorExcludeRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/wip-1",
+		"refs/tmp/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	excludePath := filepath.Join(t.TempDir(), "exclude")
+	if err := os.WriteFile(excludePath, []byte("refs/heads/wip-*\n"), 0o644); err != nil {
+		t.Fatalf("failed to write the exclude file: %s", err)
+	}
+
+	err = DoMirror(Config{
+		Source:          RepoConf{URL: srcRepoPath},
+		Destination:     RepoConf{URL: dstRepoPath},
+		ExcludeRefs:     []string{"refs/tmp/*"},
+		ExcludeRefsFile: excludePath,
+	}, logger)
+	if err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestDoMirrorPruneFilteredRefs tests that the destination copies of the
+// filtered out source references are pruned, unless preserved.
+func TestDoMirrorPruneFilteredRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/pull/1/head",
+		"refs/tmp/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	{
+		// Test the stale copies of a prior run without filters.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+			"refs/heads/a",
+			"refs/pull/1/head",
+			"refs/tmp/b",
+		})
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		err = DoMirror(Config{
+			Source:      RepoConf{URL: srcRepoPath},
+			Destination: RepoConf{URL: dstRepoPath},
+			ExcludeRefs: []string{"refs/tmp/*"},
+		}, logger)
+		if err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/a",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+	}
+	{
+		// Test keeping the stale copies with preserved references.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+			"refs/heads/a",
+			"refs/pull/1/head",
+			"refs/tmp/b",
+		})
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		err = DoMirror(Config{
+			Source:       RepoConf{URL: srcRepoPath},
+			Destination:  RepoConf{URL: dstRepoPath},
+			ExcludeRefs:  []string{"refs/tmp/*"},
+			PreserveRefs: []string{"refs/pull/*/head"},
+		}, logger)
+		if err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/a",
+			"refs/pull/1/head",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+	}
+}
+
+// TestFilterPlaceholderRefs tests filterPlaceholderRefs function.
+func TestFilterPlaceholderRefs(t *testing.T) {
+	t.Parallel()
+
+	repo, first, _ := newTestHistoryRepo(t)
+
+	firstCommit, err := repo.CommitObject(first)
+	if err != nil {
+		t.Fatalf("failed to get a commit: %s", err)
+	}
+
+	// Add an empty commit and a commit with an empty tree.
+	emptyTree := repo.Storer.NewEncodedObject()
+	if err := (&object.Tree{}).Encode(emptyTree); err != nil {
+		t.Fatalf("failed to encode a tree: %s", err)
+	}
+
+	emptyTreeHash, err := repo.Storer.SetEncodedObject(emptyTree)
+	if err != nil {
+		t.Fatalf("failed to store a tree: %s", err)
+	}
+
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash)
+
+	for name, tree := range map[plumbing.ReferenceName]plumbing.Hash{
+		"refs/heads/empty":      firstCommit.TreeHash,
+		"refs/heads/empty-tree": emptyTreeHash,
+	} {
+		commit := &object.Commit{
+			Author:       firstCommit.Author,
+			Committer:    firstCommit.Committer,
+			Message:      "empty",
+			TreeHash:     tree,
+			ParentHashes: []plumbing.Hash{first},
+		}
+
+		obj := repo.Storer.NewEncodedObject()
+		if err := commit.Encode(obj); err != nil {
+			t.Fatalf("failed to encode a commit: %s", err)
+		}
+
+		hashes[name], err = repo.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatalf("failed to store a commit: %s", err)
+		}
+	}
+
+	hashes["refs/heads/first"] = first
+
+	for name, hash := range hashes {
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			t.Fatalf("failed to set reference: %s", err)
+		}
+	}
+
+	if err := filterPlaceholderRefs(repo, true, nil); err != nil {
+		t.Fatalf("filterPlaceholderRefs failed: %s", err)
+	}
+
+	refs, err := utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get repo's refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/first",
+	}) {
+		t.Fatalf("unexpected refs in repo: %s", refs)
+	}
+
+	// The tip commit of master is "second".
+	if err := filterPlaceholderRefs(repo, false, regexp.MustCompile("^sec")); err != nil {
+		t.Fatalf("filterPlaceholderRefs failed: %s", err)
+	}
+
+	refs, err = utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get repo's refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/first",
+	}) {
+		t.Fatalf("unexpected refs in repo: %s", refs)
+	}
+}
+
+// TestFilterOldTags tests filterOldTags function.
+func TestFilterOldTags(t *testing.T) {
+	t.Parallel()
+
+	repo, first, second := newTestHistoryRepo(t)
+
+	// Annotated tags are dated by the tagger.
+	for name, year := range map[string]int{"v1": 2020, "v2": 2021} {
+		_, err := repo.CreateTag(name, first, &git.CreateTagOptions{
+			Tagger: &object.Signature{
+				Name:  "Example",
+				Email: "ex@ample.com",
+				When:  time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC),
+			},
+			Message: name,
+		})
+		if err != nil {
+			t.Fatalf("failed to create tag: %s", err)
+		}
+	}
+
+	// Lightweight tags are dated by the commit.
+	if _, err := repo.CreateTag("v3", second, nil); err != nil {
+		t.Fatalf("failed to create tag: %s", err)
+	}
+
+	// The tags of a tree or a blob are kept.
+	commit, err := repo.CommitObject(first)
+	if err != nil {
+		t.Fatalf("failed to get the commit: %s", err)
+	}
+
+	if _, err := repo.CreateTag("tree", commit.TreeHash, nil); err != nil {
+		t.Fatalf("failed to create tag: %s", err)
+	}
+
+	if err := filterOldTags(repo, 2); err != nil {
+		t.Fatalf("failed to filter tags: %s", err)
+	}
+
+	refs, err := utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get the repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/tags/tree",
+		"refs/tags/v2",
+		"refs/tags/v3",
+	}) {
+		t.Fatalf("unexpected refs: %s", refs)
+	}
+}
+
+// TestFilterUnreachableRefs tests filterUnreachableRefs function.
+func TestFilterUnreachableRefs(t *testing.T) {
+	t.Parallel()
+
+	repo, first, second := newTestHistoryRepo(t)
+
+	for name, hash := range map[string]plumbing.Hash{
+		"refs/heads/main":    first,
+		"refs/tags/v1":       first,
+		"refs/heads/feature": second,
+	} {
+		err := repo.Storer.SetReference(plumbing.NewHashReference(
+			plumbing.ReferenceName(name), hash))
+		if err != nil {
+			t.Fatalf("failed to set reference: %s", err)
+		}
+	}
+
+	// Unknown references fail.
+	if err := filterUnreachableRefs(repo, []string{"refs/heads/invalid"}); err == nil {
+		t.Fatal("unknown reference passed")
+	}
+
+	if err := filterUnreachableRefs(repo, []string{"refs/heads/main"}); err != nil {
+		t.Fatalf("failed to filter refs: %s", err)
+	}
+
+	refs, err := utils.RepoRefsSlice(repo)
+	if err != nil {
+		t.Fatalf("failed to get repo's refs: %s", err)
+	}
+
+	// HEAD is a symbolic reference to the removed master branch.
+	if !utils.SlicesAreEqual(refs, []string{
+		"HEAD",
+		"refs/heads/main",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in repo: %s", refs)
+	}
+}
+
+// TestIsSSHURL tests isSSHURL function.
+func TestIsSSHURL(t *testing.T) {
+	t.Parallel()
+
+	for url, expected := range map[string]bool{
+		"ssh://git@github.com/foo/bar.git":               true,
+		"git@github.com:foo/bar.git":                     true,
+		"https://github.com/foo/bar.git":                 false,
+		"http://github.com/foo/bar.git":                  false,
+		"/tmp/foo/bar":                                   false,
+		"ssh://git@example.com:2222/gateway/foo/bar.git": true,
+		"https://example.com:8443/gateway/foo/bar.git":   false,
+	} {
+		if isSSHURL(url) != expected {
+			t.Fatalf("unexpected SSH detection for %s", url)
+		}
+	}
+}
+
+// TestRepoHost tests repoHost function, including URLs with custom ports and
+// paths.
+func TestRepoHost(t *testing.T) {
+	t.Parallel()
+
+	for url, expected := range map[string]string{
+		"git@github.com:foo/bar.git":                     "github.com",
+		"ssh://git@example.com:2222/gateway/foo/bar.git": "example.com",
+		"https://example.com:8443/gateway/foo/bar.git":   "example.com",
+		"/tmp/foo/bar": "/tmp/foo/bar",
+	} {
+		if host := repoHost(url); host != expected {
+			t.Fatalf("unexpected host for %s: %s", url, host)
+		}
+	}
+}
+
+// TestNormalizedURL tests normalizedURL function.
+func TestNormalizedURL(t *testing.T) {
+	t.Parallel()
+
+	for url, expected := range map[string]string{
+		"git@github.com:foo/bar.git":                     "github.com/foo/bar",
+		"https://GitHub.com/foo/bar/":                    "github.com/foo/bar",
+		"ssh://git@example.com:2222/gateway/foo/bar.git": "example.com:2222/gateway/foo/bar",
+		"ssh://git@example.com:22/foo/bar.git":           "example.com/foo/bar",
+		"https://example.com:443/foo/bar":                "example.com/foo/bar",
+		"http://example.com:3000/foo/bar":                "example.com:3000/foo/bar",
+		"/tmp/foo/../bar.git":                            "/tmp/bar",
+		"file:///tmp/bar":                                "/tmp/bar",
+	} {
+		if normalized := normalizedURL(url); normalized != expected {
+			t.Fatalf("unexpected normalized URL for %s: %s", url, normalized)
+		}
+	}
+}
+
+// TestDoMirrorNoSSH tests that the SSH setup is skipped for destinations not
+// using SSH.
+func TestDoMirrorNoSSH(t *testing.T) {
+	t.Parallel()
+
+	// No need for logs.
+	devnull, _ := os.Open(os.DevNull)
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	if _, _, err = utils.NewTestRepo(srcRepoPath, []string{}); err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	if _, err = utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// A missing known_hosts file fails only when SSH is set up.
+	conf := Config{
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: dstRepoPath,
+			SSH: SSHConf{
+				PrivateKey:     testSSHKey,
+				KnownHostsPath: filepath.Join(dstRepoPath, "missing_known_hosts"),
+			},
+		},
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+}
+
+// TestDoMirrorRefSpecs tests DoMirror with custom fetch and push refspecs.
+func TestDoMirrorRefSpecs(t *testing.T) {
+	t.Parallel()
+
+	// No need for logs.
+	devnull, _ := os.Open(os.DevNull)
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/meta/foo",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/other",
+		"refs/heads/mirror/stale",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:        RepoConf{URL: srcRepoPath},
+		Destination:   RepoConf{URL: dstRepoPath},
+		FetchRefSpecs: []string{"refs/heads/*:refs/heads/*"},
+		PushRefSpecs:  []string{"refs/heads/*:refs/heads/mirror/*"},
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	// Only the fetched branches are pushed under the mapped names and only
+	// the mapped namespace is pruned.
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/other",
+		"refs/heads/mirror/master",
+		"refs/heads/mirror/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestDoMirrorIncludeRefs tests DoMirror restricted to a set of references.
+func TestDoMirrorIncludeRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/c",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	for _, fastForwardOnly := range []bool{false, true} {
+		conf := Config{
+			Source:          RepoConf{URL: srcRepoPath},
+			Destination:     RepoConf{URL: dstRepoPath},
+			IncludeRefs:     []string{"refs/heads/a", "refs/tags/v1"},
+			FastForwardOnly: fastForwardOnly,
+		}
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		// Only the included references are pushed and the other destination
+		// references are not pruned.
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/c",
+			"refs/heads/a",
+			"refs/tags/v1",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+	}
+}
+
+// TestIndividualSpecs tests individualSpecs function.
+func TestIndividualSpecs(t *testing.T) {
+	t.Parallel()
+
+	repoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(repoPath)
+
+	repo, _, err := utils.NewTestRepo(repoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	specs, err := individualSpecs(repo, []config.RefSpec{
+		"+refs/heads/*:refs/heads/mirror/*",
+		"refs/tags/v1:refs/tags/v1",
+		":refs/heads/deleted",
+	})
+	if err != nil {
+		t.Fatalf("individualSpecs failed: %s", err)
+	}
+
+	if !cmp.Equal(utils.SpecsToStrings(specs), []string{
+		"+refs/heads/a:refs/heads/mirror/a",
+		"+refs/heads/master:refs/heads/mirror/master",
+		"refs/tags/v1:refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected specs: %s", specs)
+	}
+}
+
+// TestDoMirrorIndividualPush tests DoMirror pushing each reference
+// individually.
+func TestDoMirrorIndividualPush(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := NewLogger(&logs)
+
+	srcRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-src-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary src repo: %s", err)
+	}
+
+	defer os.RemoveAll(srcRepoPath)
+
+	_, _, err = utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-dst-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary dst repo: %s", err)
+	}
+
+	defer os.RemoveAll(dstRepoPath)
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:         RepoConf{URL: srcRepoPath},
+		Destination:    RepoConf{URL: dstRepoPath},
+		IndividualPush: true,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	for _, msg := range []string{
+		"Pushing refs/heads/a (1/3)...",
+		"Pushing refs/heads/master (2/3)...",
+		"Pushing refs/tags/v1 (3/3)...",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Fatalf("missing %q in logs: %s", msg, logs.String())
+		}
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	// A second run has nothing to push.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed on an up to date destination: %s", err)
+	}
+
+	// A run interrupted before pushing the tag is resumed by pushing only the
+	// tag.
+	if err := dstRepo.Storer.RemoveReference("refs/tags/v1"); err != nil {
+		t.Fatalf("failed to remove the tag from the dst repo: %s", err)
+	}
+
+	logs.Reset()
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed resuming the push: %s", err)
+	}
+
+	for _, msg := range []string{
+		"2 of 3 reference(s) already on the destination",
+		"Pushing refs/tags/v1 (1/1)...",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Fatalf("missing %q in logs: %s", msg, logs.String())
+		}
+	}
+
+	if _, err := dstRepo.Reference("refs/tags/v1", false); err != nil {
+		t.Fatalf("the tag was not pushed when resuming: %s", err)
+	}
+}
+
+// TestChunkSpecs tests chunkSpecs function.
+func TestChunkSpecs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	repo, first, second := newTestHistoryRepo(t)
+
+	for name, hash := range map[string]plumbing.Hash{
+		"refs/heads/b": second,
+		"refs/heads/a": first,
+		"refs/tags/a":  first,
+	} {
+		err := repo.Storer.SetReference(plumbing.NewHashReference(
+			plumbing.ReferenceName(name), hash))
+		if err != nil {
+			t.Fatalf("failed to create reference %s: %s", name, err)
+		}
+	}
+
+	specs := []config.RefSpec{
+		"refs/heads/b:refs/heads/b",
+		"refs/heads/a:refs/heads/a",
+		"refs/tags/a:refs/tags/a",
+	}
+
+	chunksStrings := func(chunks [][]config.RefSpec) [][]string {
+		var ret [][]string
+		for _, chunk := range chunks {
+			ret = append(ret, utils.SpecsToStrings(chunk))
+		}
+
+		return ret
+	}
+
+	{
+		// Test that everything fits in a single push.
+		chunks, err := chunkSpecs(logger, repo, specs, nil, 1<<20)
+		if err != nil {
+			t.Fatalf("chunkSpecs failed: %s", err)
+		}
+		if len(chunks) != 1 || len(chunks[0]) != 3 {
+			t.Fatalf("unexpected chunks: %v", chunksStrings(chunks))
+		}
+	}
+	{
+		// Test that the history is pushed oldest first and that a
+		// reference adding no objects joins the previous push.
+		chunks, err := chunkSpecs(logger, repo, specs, nil, 1)
+		if err != nil {
+			t.Fatalf("chunkSpecs failed: %s", err)
+		}
+		if !cmp.Equal(chunksStrings(chunks), [][]string{
+			{"refs/heads/a:refs/heads/a", "refs/tags/a:refs/tags/a"},
+			{"refs/heads/b:refs/heads/b"},
+		}) {
+			t.Fatalf("unexpected chunks: %v", chunksStrings(chunks))
+		}
+	}
+	{
+		// Test that the objects on the destination are not counted.
+		chunks, err := chunkSpecs(logger, repo, specs, []plumbing.Hash{second}, 1)
+		if err != nil {
+			t.Fatalf("chunkSpecs failed: %s", err)
+		}
+		if len(chunks) != 1 || len(chunks[0]) != 3 {
+			t.Fatalf("unexpected chunks: %v", chunksStrings(chunks))
+		}
+	}
+}
+
+// TestDoMirrorMaxPackSize tests DoMirror splitting the push.
+func TestDoMirrorMaxPackSize(t *testing.T) {
+	t.Parallel()
+
+	var logs bytes.Buffer
+	logger := NewLogger(&logs)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		MaxPackSize: 1,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if !strings.Contains(logs.String(), "Pushing 3 reference(s) (1/1)...") {
+		t.Fatalf("missing the chunked push in logs: %s", logs.String())
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestPendingSpecs tests pendingSpecs function.
+func TestPendingSpecs(t *testing.T) {
+	t.Parallel()
+
+	repoPath, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(repoPath)
+
+	repo, _, err := utils.NewTestRepo(repoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	a, err := repo.Reference("refs/heads/a", false)
+	if err != nil {
+		t.Fatalf("failed to get a reference: %s", err)
+	}
+
+	specs, err := pendingSpecs(repo, []config.RefSpec{
+		"+refs/heads/a:refs/heads/mirror/a",
+		"+refs/heads/b:refs/heads/mirror/b",
+		"+refs/heads/master:refs/heads/mirror/master",
+	}, []*plumbing.Reference{
+		// Already pushed.
+		plumbing.NewHashReference("refs/heads/mirror/a", a.Hash()),
+		// Outdated.
+		plumbing.NewHashReference("refs/heads/mirror/b", plumbing.ZeroHash),
+	})
+	if err != nil {
+		t.Fatalf("pendingSpecs failed: %s", err)
+	}
+
+	if !cmp.Equal(utils.SpecsToStrings(specs), []string{
+		"+refs/heads/b:refs/heads/mirror/b",
+		"+refs/heads/master:refs/heads/mirror/master",
+	}) {
+		t.Fatalf("unexpected specs: %s", specs)
+	}
+}
+
+// TestObjectsSize tests objectsSize function.
+func TestObjectsSize(t *testing.T) {
+	t.Parallel()
+
+	repo, first, _ := newTestHistoryRepo(t)
+
+	// Two commits, each with a tree and a blob.
+	count, size, err := objectsSize(repo, nil)
+	if err != nil {
+		t.Fatalf("objectsSize failed: %s", err)
+	}
+
+	if count != 6 || size <= 0 {
+		t.Fatalf("unexpected objects size: %d objects, %d bytes", count, size)
+	}
+
+	// Objects reachable from the first commit are ignored.
+	ignoredCount, ignoredSize, err := objectsSize(repo, []plumbing.Hash{first})
+	if err != nil {
+		t.Fatalf("objectsSize failed: %s", err)
+	}
+
+	if ignoredCount != 3 || ignoredSize <= 0 || ignoredSize >= size {
+		t.Fatalf("unexpected objects size: %d objects, %d bytes", ignoredCount,
+			ignoredSize)
+	}
+
+	// Hashes not in the repository are skipped.
+	unknownCount, _, err := objectsSize(repo, []plumbing.Hash{
+		plumbing.NewHash("0123456789012345678901234567890123456789"),
+	})
+	if err != nil {
+		t.Fatalf("objectsSize failed: %s", err)
+	}
+
+	if unknownCount != count {
+		t.Fatalf("unexpected objects count: %d", unknownCount)
+	}
+}
+
+// TestLogRefObjects tests logRefObjects function.
+func TestLogRefObjects(t *testing.T) {
+	t.Parallel()
+
+	repo, first, _ := newTestHistoryRepo(t)
+
+	err := repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/first", first))
+	if err != nil {
+		t.Fatalf("failed to set reference: %s", err)
+	}
+
+	var logs bytes.Buffer
+
+	logger := NewLogger(&logs)
+
+	// Nothing is logged outside debug mode.
+	logRefObjects(Config{}, logger, repo, nil)
+
+	if logs.Len() != 0 {
+		t.Fatalf("unexpected logs: %s", logs.String())
+	}
+
+	logRefObjects(Config{Debug: true}, logger, repo, nil)
+
+	for _, msg := range []string{
+		"refs/heads/master has about 6 object(s)",
+		"refs/heads/first has about 3 object(s)",
+	} {
+		if !strings.Contains(logs.String(), msg) {
+			t.Fatalf("missing %q in logs: %s", msg, logs.String())
+		}
+	}
+
+	// References without objects left after the ignored hashes are not
+	// logged.
+	logs.Reset()
+	logRefObjects(Config{Debug: true}, logger, repo, []plumbing.Hash{first})
+
+	if !strings.Contains(logs.String(), "refs/heads/master has about 3 object(s)") ||
+		strings.Contains(logs.String(), "refs/heads/first") {
+		t.Fatalf("unexpected logs: %s", logs.String())
+	}
+}
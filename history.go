@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	historyPerm          = 0o644
+	historyStatusSuccess = "success"
+	historyStatusFailure = "failure"
+)
+
+// historyEntry is a mirror run recorded in the history file or the status
+// file.
+type historyEntry struct {
+	Time        time.Time `json:"time"`
+	RunID       string    `json:"run_id"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	DryRun      bool      `json:"dry_run"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	RefsChanged int       `json:"refs_changed"`
+	Duration    float64   `json:"duration_seconds"`
+}
+
+// newHistoryEntry returns the entry of a mirror run started at 'start'.
+func newHistoryEntry(conf Config, start time.Time, refsChanged int,
+	mirrorErr error) historyEntry {
+	entry := historyEntry{
+		Time:        start.UTC(),
+		RunID:       conf.RunID,
+		Source:      redactURL(conf.Source.URL),
+		Destination: redactURL(conf.Destination.URL),
+		DryRun:      conf.DryRun,
+		Status:      historyStatusSuccess,
+		RefsChanged: refsChanged,
+		Duration:    time.Since(start).Seconds(),
+	}
+
+	if mirrorErr != nil {
+		entry.Status = historyStatusFailure
+		entry.Error = mirrorErr.Error()
+	}
+
+	return entry
+}
+
+// appendHistory appends a mirror run, started at 'start', to conf.HistoryFile
+// as a JSON document on a line of its own. The file is created if needed.
+func appendHistory(conf Config, start time.Time, refsChanged int, mirrorErr error) error {
+	line, err := json.Marshal(newHistoryEntry(conf, start, refsChanged, mirrorErr))
+	if err != nil {
+		return fmt.Errorf("failed to encode the history entry: %w", err)
+	}
+
+	file, err := os.OpenFile(conf.HistoryFile,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, historyPerm)
+	if err != nil {
+		return fmt.Errorf("failed to open the history file: %w", err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to write the history file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write the history file: %w", err)
+	}
+
+	return nil
+}
+
+// refsChanged returns the number of references created, updated or deleted
+// in 'after' compared to 'before'.
+func refsChanged(before, after []*plumbing.Reference) int {
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(before))
+	for _, ref := range before {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	changed := 0
+
+	for _, ref := range after {
+		hash, found := hashes[ref.Name()]
+		if !found || hash != ref.Hash() {
+			changed++
+		}
+
+		delete(hashes, ref.Name())
+	}
+
+	return changed + len(hashes)
+}
+
+// listHistoryRefs lists the destination references for counting the
+// references a mirror changed. An empty destination has no references.
+func listHistoryRefs(conf Config, remote *git.Remote,
+	auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	refs, err := listRemote(conf, remote, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil
+	}
+
+	return refs, err
+}
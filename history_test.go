@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestRefsChanged tests refsChanged function.
+func TestRefsChanged(t *testing.T) {
+	t.Parallel()
+
+	hashA := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	hashB := plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+
+	before := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/same", hashA),
+		plumbing.NewHashReference("refs/heads/updated", hashA),
+		plumbing.NewHashReference("refs/heads/deleted", hashA),
+	}
+	after := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/same", hashA),
+		plumbing.NewHashReference("refs/heads/updated", hashB),
+		plumbing.NewHashReference("refs/heads/created", hashB),
+	}
+
+	if changed := refsChanged(before, after); changed != 3 {
+		t.Fatalf("unexpected number of changed refs: %d", changed)
+	}
+
+	if changed := refsChanged(nil, after); changed != 3 {
+		t.Fatalf("unexpected number of changed refs: %d", changed)
+	}
+
+	if changed := refsChanged(before, before); changed != 0 {
+		t.Fatalf("unexpected number of changed refs: %d", changed)
+	}
+}
+
+// TestDoMirrorHistory tests recording the runs of DoMirror in the history.
+func TestDoMirrorHistory(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		RunID:       "run",
+		HistoryFile: historyPath,
+	}
+
+	// The second mirror changes nothing and the third one fails.
+	for i := 0; i < 2; i++ {
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+	}
+
+	conf.Source.URL = filepath.Join(srcRepoPath, "missing")
+	if err := DoMirror(conf, logger); err == nil {
+		t.Fatal("DoMirror with a missing source succeeded")
+	}
+
+	file, err := os.Open(historyPath)
+	if err != nil {
+		t.Fatalf("failed to open the history: %s", err)
+	}
+	defer file.Close()
+
+	var entries []historyEntry
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to decode the history line %q: %s", scanner.Text(), err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 3 {
+		t.Fatalf("unexpected history entries: %v", entries)
+	}
+
+	for i, expected := range []struct {
+		status  string
+		changed int
+	}{
+		{historyStatusSuccess, 3},
+		{historyStatusSuccess, 0},
+		{historyStatusFailure, 0},
+	} {
+		entry := entries[i]
+		if entry.RunID != "run" || entry.Destination != dstRepoPath ||
+			entry.Status != expected.status || entry.RefsChanged != expected.changed ||
+			(entry.Status == historyStatusFailure) != (len(entry.Error) != 0) {
+			t.Fatalf("unexpected history entry %d: %v", i, entry)
+		}
+	}
+}
+
+// TestDoMirrorHistoryDeprecated tests that the runs of a configuration with
+// the deprecated fields are recorded with the URLs of the repositories.
+func TestDoMirrorHistoryDeprecated(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+	statusPath := filepath.Join(t.TempDir(), "status.json")
+
+	if err := DoMirror(Config{
+		SrcRepo:     srcRepoPath,
+		DstRepo:     dstRepoPath,
+		HistoryFile: historyPath,
+		StatusFile:  statusPath,
+	}, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	content, err := os.ReadFile(historyPath)
+	if err != nil {
+		t.Fatalf("failed to read the history: %s", err)
+	}
+
+	var entry historyEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		t.Fatalf("failed to decode the history %q: %s", content, err)
+	}
+
+	if entry.Source != srcRepoPath || entry.Destination != dstRepoPath {
+		t.Fatalf("unexpected history entry: %v", entry)
+	}
+
+	status := readStatus(t, statusPath)
+	if status.Source != srcRepoPath || status.Destination != dstRepoPath {
+		t.Fatalf("unexpected status: %v", status)
+	}
+}
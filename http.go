@@ -0,0 +1,255 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	ErrNoCACerts = errors.New("no PEM encoded certificates in the CA bundle")
+	ErrRedirect  = errors.New("HTTP redirect (is the repository URL outdated?)")
+	ErrCertPin   = errors.New("the source server certificate doesn't match " +
+		"the pinned fingerprint")
+)
+
+// dialKeepAlive is the keep-alive period of the connections dialed with a
+// timeout, same as the one of http.DefaultTransport.
+const dialKeepAlive = 30 * time.Second
+
+// userAgentTransport is an http.RoundTripper that sets the User-Agent header
+// of all the requests.
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	return t.next.RoundTrip(req)
+}
+
+// needsHTTPClient checks if the configuration requires a custom HTTP client.
+func needsHTTPClient(conf Config) bool {
+	return len(conf.UserAgent) != 0 || len(conf.ClientCert) != 0 ||
+		len(conf.CAFile) != 0 || len(conf.CAContent) != 0 || conf.FailOnRedirect ||
+		conf.DialTimeout != 0 || conf.MaxConnsPerHost != 0 || len(conf.SrcCertPin) != 0 ||
+		len(conf.LocalAddr) != 0
+}
+
+// failOnRedirect is an http.Client CheckRedirect function that fails all the
+// redirects.
+func failOnRedirect(req *http.Request, via []*http.Request) error {
+	return fmt.Errorf("%w: %s to %s", ErrRedirect, via[0].URL.Redacted(),
+		req.URL.Redacted())
+}
+
+// caCertPool returns the system certificate pool extended with the CA bundle
+// provided by content or by file path.
+func caCertPool(conf Config) (*x509.CertPool, error) {
+	bundle := []byte(conf.CAContent)
+
+	if len(conf.CAFile) != 0 {
+		var err error
+
+		bundle, err = os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the CA bundle: %w", err)
+		}
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, ErrNoCACerts
+	}
+
+	return pool, nil
+}
+
+// newTLSConfig returns the TLS configuration used for HTTPS remotes or nil when
+// the default one is to be used.
+func newTLSConfig(conf Config) (*tls.Config, error) {
+	if len(conf.ClientCert) == 0 && len(conf.CAFile) == 0 &&
+		len(conf.CAContent) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(conf.ClientCert) != 0 {
+		cert, err := tls.LoadX509KeyPair(conf.ClientCert, conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(conf.CAFile) != 0 || len(conf.CAContent) != 0 {
+		pool, err := caCertPool(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// parseCertPin parses a SHA-256 certificate fingerprint in hexadecimal,
+// optionally with colon separated bytes as printed by
+// 'openssl x509 -fingerprint -sha256'.
+func parseCertPin(pin string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("%w: %q", ErrSrcCertPin, pin)
+	}
+
+	return fingerprint, nil
+}
+
+// verifyCertPin returns a tls.Config VerifyConnection function failing the
+// connections of which server certificate doesn't have the SHA-256
+// 'fingerprint'. It runs after, not instead of, the usual chain validation.
+func verifyCertPin(fingerprint []byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return ErrCertPin
+		}
+
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		if !bytes.Equal(sum[:], fingerprint) {
+			return fmt.Errorf("%w: got %s", ErrCertPin, hex.EncodeToString(sum[:]))
+		}
+
+		return nil
+	}
+}
+
+// pinnedHostTransport is an http.RoundTripper that sends the requests to
+// 'host' through 'pinned' and the other ones through 'next'. The HTTP client
+// of a run is shared by the source and the destination so the certificate
+// pin is only applied to the source host this way.
+type pinnedHostTransport struct {
+	host   string
+	pinned http.RoundTripper
+	next   http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t pinnedHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.EqualFold(req.URL.Hostname(), t.host) {
+		return t.pinned.RoundTrip(req)
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// newPinnedTransport returns an http.RoundTripper verifying the certificate
+// of the source host against conf.SrcCertPin. 'next' is used for the other
+// hosts and is the base of the pinned transport.
+func newPinnedTransport(conf Config, next http.RoundTripper) (http.RoundTripper, error) {
+	fingerprint, err := parseCertPin(conf.SrcCertPin)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := transport.NewEndpoint(conf.Source.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the source repository: %w", err)
+	}
+
+	pinned := http.DefaultTransport.(*http.Transport).Clone()
+	if base, ok := next.(*http.Transport); ok {
+		pinned = base.Clone()
+	}
+
+	if pinned.TLSClientConfig == nil {
+		pinned.TLSClientConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	pinned.TLSClientConfig.VerifyConnection = verifyCertPin(fingerprint)
+
+	return pinnedHostTransport{
+		host:   endpoint.Host,
+		pinned: pinned,
+		next:   next,
+	}, nil
+}
+
+// newHTTPClient returns an HTTP client set up based on configuration.
+func newHTTPClient(conf Config) (*http.Client, error) {
+	var transport http.RoundTripper = http.DefaultTransport
+
+	tlsConfig, err := newTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConfig != nil || conf.DialTimeout != 0 || conf.MaxConnsPerHost != 0 ||
+		len(conf.LocalAddr) != 0 {
+		customTransport := http.DefaultTransport.(*http.Transport).Clone()
+		customTransport.TLSClientConfig = tlsConfig
+		customTransport.MaxConnsPerHost = conf.MaxConnsPerHost
+
+		// The dialer resolves the host name so the timeout covers it too.
+		if conf.DialTimeout != 0 || len(conf.LocalAddr) != 0 {
+			customTransport.DialContext = (&net.Dialer{
+				Timeout:   conf.DialTimeout,
+				KeepAlive: dialKeepAlive,
+				LocalAddr: localTCPAddr(conf),
+			}).DialContext
+		}
+
+		transport = customTransport
+	}
+
+	if len(conf.SrcCertPin) != 0 {
+		transport, err = newPinnedTransport(conf, transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(conf.UserAgent) != 0 {
+		transport = userAgentTransport{
+			userAgent: conf.UserAgent,
+			next:      transport,
+		}
+	}
+
+	httpClient := &http.Client{Transport: transport}
+
+	if conf.FailOnRedirect {
+		httpClient.CheckRedirect = failOnRedirect
+	}
+
+	return httpClient, nil
+}
@@ -0,0 +1,371 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// listHTTPRemote lists a remote with the run authentication of a
+// configuration, ignoring the references, so that the HTTP transport of the
+// run is exercised.
+func listHTTPRemote(t *testing.T, conf Config, url string) error {
+	t.Helper()
+
+	auth, err := newRunAuth(conf, nil)
+	if err != nil {
+		t.Fatalf("failed to set up the run authentication: %s", err)
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "test",
+		URLs: []string{url},
+	})
+	_, err = remote.List(&git.ListOptions{Auth: auth})
+
+	return err
+}
+
+// TestRunAuthUserAgent tests that the configured user agent is used for the
+// HTTP requests.
+func TestRunAuthUserAgent(t *testing.T) {
+	t.Parallel()
+
+	var userAgent string
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			userAgent = r.Header.Get("User-Agent")
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	defer server.Close()
+
+	_ = listHTTPRemote(t, Config{UserAgent: "git-mirror-me-test"}, server.URL)
+
+	if userAgent != "git-mirror-me-test" {
+		t.Fatalf("unexpected user agent: %s", userAgent)
+	}
+
+	// The runs without a user agent use the default transport.
+	_ = listHTTPRemote(t, Config{}, server.URL)
+
+	if userAgent == "git-mirror-me-test" {
+		t.Fatal("the user agent was used by another run")
+	}
+}
+
+// TestRunAuthRedirect tests that the redirects are followed unless configured
+// to fail.
+func TestRunAuthRedirect(t *testing.T) {
+	t.Parallel()
+
+	redirected := false
+
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/old") {
+				http.Redirect(w, r, strings.Replace(r.URL.String(), "/old",
+					"/new", 1), http.StatusMovedPermanently)
+
+				return
+			}
+
+			redirected = true
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	defer server.Close()
+
+	// Redirects are followed by default.
+	_ = listHTTPRemote(t, Config{}, server.URL+"/old")
+
+	if !redirected {
+		t.Fatal("the redirect was not followed")
+	}
+
+	redirected = false
+
+	err := listHTTPRemote(t, Config{FailOnRedirect: true}, server.URL+"/old")
+	if err == nil || !strings.Contains(err.Error(), ErrRedirect.Error()) {
+		t.Fatalf("unexpected error for a redirect: %s", err)
+	}
+
+	if redirected {
+		t.Fatal("the redirect was followed")
+	}
+}
+
+// TestNewHTTPClientDialTimeout tests the HTTP client set up with a dial
+// timeout.
+func TestNewHTTPClientDialTimeout(t *testing.T) {
+	t.Parallel()
+
+	conf := Config{DialTimeout: time.Second}
+	if !needsHTTPClient(conf) {
+		t.Fatal("dial timeout didn't require a custom HTTP client")
+	}
+
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		t.Fatalf("failed to create the HTTP client: %s", err)
+	}
+
+	httpTransport, ok := httpClient.Transport.(*http.Transport)
+	if !ok || httpTransport.DialContext == nil || httpTransport.TLSClientConfig != nil {
+		t.Fatalf("unexpected HTTP transport: %v", httpClient.Transport)
+	}
+}
+
+// TestNewHTTPClientMaxConnsPerHost tests the HTTP client set up with a
+// maximum number of connections per host.
+func TestNewHTTPClientMaxConnsPerHost(t *testing.T) {
+	t.Parallel()
+
+	conf := Config{MaxConnsPerHost: 2}
+	if !needsHTTPClient(conf) {
+		t.Fatal("maximum connections per host didn't require a custom HTTP client")
+	}
+
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		t.Fatalf("failed to create the HTTP client: %s", err)
+	}
+
+	httpTransport, ok := httpClient.Transport.(*http.Transport)
+	if !ok || httpTransport.MaxConnsPerHost != 2 {
+		t.Fatalf("unexpected HTTP transport: %v", httpClient.Transport)
+	}
+}
+
+// writeClientCert writes a self-signed PEM encoded certificate and its key in
+// a directory and returns their paths.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "git-mirror-me-test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template,
+		&key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %s", err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %s", err)
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "key.pem")
+
+	err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write certificate: %s", err)
+	}
+
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{
+		Type:  "EC PRIVATE KEY",
+		Bytes: keyDer,
+	}), 0o600)
+	if err != nil {
+		t.Fatalf("failed to write key: %s", err)
+	}
+
+	return certPath, keyPath
+}
+
+// TestNewTLSConfig tests the TLS configuration set up with a client
+// certificate.
+func TestNewTLSConfig(t *testing.T) {
+	t.Parallel()
+	{
+		// Test that no TLS configuration is needed by default.
+		tlsConfig, err := newTLSConfig(Config{})
+		if err != nil || tlsConfig != nil {
+			t.Fatalf("unexpected TLS configuration: %v %s", tlsConfig, err)
+		}
+	}
+	{
+		// Test that the client certificate is loaded.
+		certPath, keyPath := writeClientCert(t, t.TempDir())
+
+		tlsConfig, err := newTLSConfig(Config{
+			ClientCert: certPath,
+			ClientKey:  keyPath,
+		})
+		if err != nil {
+			t.Fatalf("failed to load the client certificate: %s", err)
+		}
+		if len(tlsConfig.Certificates) != 1 {
+			t.Fatalf("unexpected certificates: %d", len(tlsConfig.Certificates))
+		}
+	}
+	{
+		// Test that a missing client certificate fails.
+		dir := t.TempDir()
+
+		_, err := newTLSConfig(Config{
+			ClientCert: filepath.Join(dir, "cert.pem"),
+			ClientKey:  filepath.Join(dir, "key.pem"),
+		})
+		if err == nil {
+			t.Fatal("missing client certificate didn't fail")
+		}
+	}
+	{
+		// Test that the CA bundle is loaded by content and by file path.
+		certPath, _ := writeClientCert(t, t.TempDir())
+
+		ca, err := os.ReadFile(certPath)
+		if err != nil {
+			t.Fatalf("failed to read the certificate: %s", err)
+		}
+
+		for _, conf := range []Config{{CAContent: string(ca)}, {CAFile: certPath}} {
+			tlsConfig, err := newTLSConfig(conf)
+			if err != nil {
+				t.Fatalf("failed to load the CA bundle: %s", err)
+			}
+			if tlsConfig.RootCAs == nil || len(tlsConfig.Certificates) != 0 {
+				t.Fatalf("unexpected TLS configuration: %v", tlsConfig)
+			}
+		}
+	}
+	{
+		// Test that a CA bundle without certificates fails.
+		_, err := newTLSConfig(Config{CAContent: "invalid"})
+		if !errors.Is(err, ErrNoCACerts) {
+			t.Fatalf("invalid CA bundle didn't fail: %s", err)
+		}
+	}
+}
+
+// TestRunAuthCA tests that the configured CA bundle is trusted for the HTTPS
+// requests.
+func TestRunAuthCA(t *testing.T) {
+	t.Parallel()
+
+	requested := false
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	// The rejected handshake is expected.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+
+	defer server.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	})
+
+	// The server certificate is not trusted by default.
+	_ = listHTTPRemote(t, Config{}, server.URL)
+
+	if requested {
+		t.Fatal("untrusted server certificate was accepted")
+	}
+
+	_ = listHTTPRemote(t, Config{CAContent: string(ca)}, server.URL)
+
+	if !requested {
+		t.Fatal("the CA bundle was not trusted")
+	}
+}
+
+// TestRunAuthCertPin tests that the source server certificate is verified
+// against the pinned fingerprint.
+func TestRunAuthCertPin(t *testing.T) {
+	t.Parallel()
+
+	requested := false
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+			w.WriteHeader(http.StatusNotFound)
+		}))
+	// The rejected handshakes are expected.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+
+	defer server.Close()
+
+	ca := string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: server.Certificate().Raw,
+	}))
+	sum := sha256.Sum256(server.Certificate().Raw)
+	pin := hex.EncodeToString(sum[:])
+	otherPin := strings.Repeat("00", sha256.Size)
+
+	for _, test := range []struct {
+		source    string
+		pin       string
+		requested bool
+	}{
+		{source: server.URL, pin: otherPin, requested: false},
+		{source: server.URL, pin: pin, requested: true},
+		{source: server.URL, pin: strings.ToUpper(pin), requested: true},
+		// The pin only applies to the source host.
+		{source: "https://example.com/src.git", pin: otherPin, requested: true},
+	} {
+		requested = false
+
+		_ = listHTTPRemote(t, Config{
+			Source:     RepoConf{URL: test.source},
+			CAContent:  ca,
+			SrcCertPin: test.pin,
+		}, server.URL)
+
+		if requested != test.requested {
+			t.Fatalf("unexpected request with the source %s and the pin %s: %t",
+				test.source, test.pin, requested)
+		}
+	}
+
+	if _, err := newRunAuth(Config{SrcCertPin: "zz"}, nil); !errors.Is(err, ErrSrcCertPin) {
+		t.Fatalf("unexpected error for an invalid pin: %v", err)
+	}
+}
@@ -21,6 +21,10 @@ import (
 
 var ErrInvalidRepoPath = errors.New("invalid repo path")
 
+// testCommitTime is the time of the test commits so that the repositories
+// created with the same content have the same commit, whenever created.
+var testCommitTime = time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 // SortSlice gets a slice and returns a sorted copy of it.
 func SortSlice(slice []string) []string {
 	if sort.StringsAreSorted(slice) {
@@ -70,6 +74,14 @@ func NewBareRepo(path string) (*git.Repository, error) {
 // NewTestRepo creates an new bare repo at a specific path initialised with a
 // test commit and a set of refs pointing to the HEAD's reference.
 func NewTestRepo(path string, refs []string) (*git.Repository, plumbing.Hash, error) {
+	return NewTestRepoWithContent(path, refs, "test")
+}
+
+// NewTestRepoWithContent is the same as NewTestRepo but the test commit adds a
+// file with the provided content. Repositories created with different content
+// have unrelated histories.
+func NewTestRepoWithContent(path string, refs []string, content string) (*git.Repository,
+	plumbing.Hash, error) {
 	var headHash plumbing.Hash
 
 	// Create a bare repository.
@@ -99,7 +111,7 @@ func NewTestRepo(path string, refs []string) (*git.Repository, plumbing.Hash, er
 
 	defer testFile.Close()
 
-	_, err = testFile.Write([]byte("test"))
+	_, err = testFile.Write([]byte(content))
 	if err != nil {
 		return nil, headHash, fmt.Errorf("failed to write test file: %w", err)
 	}
@@ -113,7 +125,7 @@ func NewTestRepo(path string, refs []string) (*git.Repository, plumbing.Hash, er
 		Author: &object.Signature{
 			Name:  "Example",
 			Email: "ex@ample.com",
-			When:  time.Now(),
+			When:  testCommitTime,
 		},
 	})
 	if err != nil {
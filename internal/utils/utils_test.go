@@ -179,6 +179,39 @@ func TestNewTestRepo(t *testing.T) {
 	}
 }
 
+// TestNewTestRepoWithContent tests the NewTestRepoWithContent function.
+func TestNewTestRepoWithContent(t *testing.T) {
+	t.Parallel()
+
+	pathA, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(pathA)
+
+	pathB, err := ioutil.TempDir("/tmp", "git-mirror-me-test-")
+	if err != nil {
+		t.Fatalf("failed to create a temporary repo: %s", err)
+	}
+
+	defer os.RemoveAll(pathB)
+
+	_, hashA, err := NewTestRepoWithContent(pathA, []string{}, "a")
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	_, hashB, err := NewTestRepoWithContent(pathB, []string{}, "b")
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	if hashA == hashB {
+		t.Fatal("different content resulted in the same commit")
+	}
+}
+
 // TestRepoRefsSlice tests the RepoRefsSlice function.
 func TestRepoRefsSlice(t *testing.T) {
 	t.Parallel()
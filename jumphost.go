@@ -0,0 +1,300 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// parseJumpHost parses a 'user@host[:port]' jump host and returns its user
+// and its 'host:port' address.
+func parseJumpHost(jumpHost string) (string, string, error) {
+	at := strings.LastIndex(jumpHost, "@")
+	if at <= 0 || at == len(jumpHost)-1 {
+		return "", "", fmt.Errorf("%w: %q", ErrJumpHost, jumpHost)
+	}
+
+	user, host := jumpHost[:at], jumpHost[at+1:]
+
+	if _, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.Atoi(port); err != nil {
+			return "", "", fmt.Errorf("%w: %q", ErrJumpHost, jumpHost)
+		}
+
+		return user, host, nil
+	}
+
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	return user, net.JoinHostPort(host, strconv.Itoa(ssh.DefaultPort)), nil
+}
+
+// tunnelAuth is an SSH authentication connecting to the repository host
+// through a tunnel it opens. The connection goes through the tunnel only with
+// tunnelTransport.
+type tunnelAuth interface {
+	transport.AuthMethod
+	// openTunnel returns a tunnel to the endpoint host, with the endpoint and
+	// the authentication to use through it.
+	openTunnel(endpoint *transport.Endpoint) (*sshTunnel, *transport.Endpoint,
+		transport.AuthMethod, error)
+}
+
+// jumpAuth is an SSH authentication connecting to the repository host
+// through a jump host, like the OpenSSH ProxyJump option. The jump host is
+// authenticated with the same key and host public keys as the repository
+// host. The connection to the jump host is made from localAddr when set.
+type jumpAuth struct {
+	ssh.AuthMethod
+	user      string
+	addr      string
+	localAddr net.Addr
+}
+
+// tunneledAuth is an SSH authentication for a connection tunneled to
+// 'target'. The host public key is checked for the target instead of the
+// local end of the tunnel.
+type tunneledAuth struct {
+	ssh.AuthMethod
+	target string
+}
+
+// ClientConfig implements ssh.AuthMethod.
+func (a tunneledAuth) ClientConfig() (*gossh.ClientConfig, error) {
+	config, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback := config.HostKeyCallback
+	config.HostKeyCallback = func(_ string, remote net.Addr, key gossh.PublicKey) error {
+		return hostKeyCallback(a.target, remote, key)
+	}
+
+	return config, nil
+}
+
+// sshTunnel forwards a single connection to a local listener to a target
+// connection, e.g. dialed through an SSH connection to a jump host. go-git
+// makes a single connection per session so the listener is closed once it is
+// accepted and no other local connection can go through the tunnel.
+type sshTunnel struct {
+	closer   io.Closer
+	listener net.Listener
+	remote   net.Conn
+}
+
+// endpointTarget returns the 'host:port' address of an SSH endpoint.
+func endpointTarget(endpoint *transport.Endpoint) string {
+	port := endpoint.Port
+	if port <= 0 {
+		port = ssh.DefaultPort
+	}
+
+	return net.JoinHostPort(endpoint.Host, strconv.Itoa(port))
+}
+
+// openSSHTunnel returns a tunnel to the endpoint host dialed with 'dial',
+// with the endpoint and the authentication to use through it. 'closer', when
+// not nil, is closed with the tunnel. The target connection is dialed upfront
+// so that its failure is returned instead of the SSH handshake one.
+func openSSHTunnel(endpoint *transport.Endpoint, auth ssh.AuthMethod,
+	dial func(network, addr string) (net.Conn, error),
+	closer io.Closer) (*sshTunnel, *transport.Endpoint, transport.AuthMethod, error) {
+	target := endpointTarget(endpoint)
+
+	closeCloser := func() {
+		if closer != nil {
+			closer.Close()
+		}
+	}
+
+	remote, err := dial("tcp", target)
+	if err != nil {
+		closeCloser()
+
+		return nil, nil, nil, fmt.Errorf("failed to connect to %s: %w", target, err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		remote.Close()
+		closeCloser()
+
+		return nil, nil, nil, fmt.Errorf("failed to listen for the SSH tunnel: %w", err)
+	}
+
+	tunnel := &sshTunnel{closer: closer, listener: listener, remote: remote}
+	go tunnel.forward()
+
+	tunnelEndpoint := *endpoint
+	tunnelEndpoint.Host = "127.0.0.1"
+	tunnelEndpoint.Port = listener.Addr().(*net.TCPAddr).Port
+
+	return tunnel, &tunnelEndpoint, tunneledAuth{AuthMethod: auth, target: target}, nil
+}
+
+// openTunnel implements tunnelAuth.
+func (a jumpAuth) openTunnel(endpoint *transport.Endpoint) (*sshTunnel,
+	*transport.Endpoint, transport.AuthMethod, error) {
+	config, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	config.User = a.user
+
+	dialer := &net.Dialer{Timeout: config.Timeout, LocalAddr: a.localAddr}
+
+	conn, err := dialer.Dial("tcp", a.addr)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to connect to the jump host %s: %w",
+			a.addr, err)
+	}
+
+	clientConn, chans, reqs, err := gossh.NewClientConn(conn, a.addr, config)
+	if err != nil {
+		conn.Close()
+
+		return nil, nil, nil, fmt.Errorf("failed to connect to the jump host %s: %w",
+			a.addr, err)
+	}
+
+	jumpClient := gossh.NewClient(clientConn, chans, reqs)
+
+	return openSSHTunnel(endpoint, a.AuthMethod, jumpClient.Dial, jumpClient)
+}
+
+// forward accepts a single connection, closing the listener, and forwards
+// it to the target connection until either end closes.
+func (t *sshTunnel) forward() {
+	defer t.remote.Close()
+
+	conn, err := t.listener.Accept()
+	t.listener.Close()
+
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{}, 2)
+
+	go func() {
+		_, _ = io.Copy(t.remote, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, t.remote)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
+
+// Close closes the tunnel and its closer.
+func (t *sshTunnel) Close() error {
+	t.listener.Close()
+
+	if t.closer != nil {
+		return t.closer.Close()
+	}
+
+	return nil
+}
+
+// tunnelTransport is a go-git SSH transport opening the tunnels of the
+// tunnelAuth authentications. The other authentications connect directly.
+type tunnelTransport struct {
+	transport.Transport
+}
+
+// cleanupUploadPackSession is an upload-pack session running a cleanup, e.g.
+// closing a tunnel, once closed.
+type cleanupUploadPackSession struct {
+	transport.UploadPackSession
+	cleanup func()
+}
+
+// Close implements transport.UploadPackSession.
+func (s cleanupUploadPackSession) Close() error {
+	defer s.cleanup()
+
+	return s.UploadPackSession.Close()
+}
+
+// cleanupReceivePackSession is a receive-pack session running a cleanup once
+// closed.
+type cleanupReceivePackSession struct {
+	transport.ReceivePackSession
+	cleanup func()
+}
+
+// Close implements transport.ReceivePackSession.
+func (s cleanupReceivePackSession) Close() error {
+	defer s.cleanup()
+
+	return s.ReceivePackSession.Close()
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t tunnelTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	tunneled, ok := auth.(tunnelAuth)
+	if !ok {
+		return t.Transport.NewUploadPackSession(endpoint, auth)
+	}
+
+	tunnel, endpoint, auth, err := tunneled.openTunnel(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := t.Transport.NewUploadPackSession(endpoint, auth)
+	if err != nil {
+		tunnel.Close()
+
+		return nil, err
+	}
+
+	return cleanupUploadPackSession{
+		UploadPackSession: session,
+		cleanup:           func() { tunnel.Close() },
+	}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t tunnelTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	tunneled, ok := auth.(tunnelAuth)
+	if !ok {
+		return t.Transport.NewReceivePackSession(endpoint, auth)
+	}
+
+	tunnel, endpoint, auth, err := tunneled.openTunnel(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := t.Transport.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		tunnel.Close()
+
+		return nil, err
+	}
+
+	return cleanupReceivePackSession{
+		ReceivePackSession: session,
+		cleanup:            func() { tunnel.Close() },
+	}, nil
+}
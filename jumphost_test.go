@@ -0,0 +1,320 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// newTestJumpSSHServer starts an SSH server which is both a jump host,
+// forwarding all the connections to itself, and a git server running the
+// git commands on local paths. It returns the server address, its host public
+// key and the number of forwarded connections.
+func newTestJumpSSHServer(t *testing.T) (string, gossh.PublicKey, *int32) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the host key: %s", err)
+	}
+
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("failed to create the host key signer: %s", err)
+	}
+
+	serverConfig := &gossh.ServerConfig{
+		PublicKeyCallback: func(gossh.ConnMetadata, gossh.PublicKey) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	addr := listener.Addr().String()
+
+	var forwarded int32
+
+	handleChannel := func(newChannel gossh.NewChannel) {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+
+			go gossh.DiscardRequests(reqs)
+			atomic.AddInt32(&forwarded, 1)
+
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				channel.Close()
+
+				return
+			}
+
+			go func() {
+				_, _ = io.Copy(conn, channel)
+				conn.Close()
+			}()
+
+			_, _ = io.Copy(channel, conn)
+			channel.Close()
+		case "session":
+			channel, reqs, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+
+			for req := range reqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+
+					continue
+				}
+
+				_ = req.Reply(true, nil)
+
+				// The payload is the length prefixed command, e.g.
+				// "git-upload-pack '/path'".
+				command := string(req.Payload[4:])
+				name, path, _ := strings.Cut(command, " ")
+
+				cmd := exec.Command(name, strings.Trim(path, "'"))
+				cmd.Stdin = channel
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+
+				status := make([]byte, 4)
+				if err := cmd.Run(); err != nil {
+					binary.BigEndian.PutUint32(status, 1)
+				}
+
+				_, _ = channel.SendRequest("exit-status", false, status)
+				channel.Close()
+
+				return
+			}
+		default:
+			_ = newChannel.Reject(gossh.UnknownChannelType, "unsupported")
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_, chans, reqs, err := gossh.NewServerConn(conn, serverConfig)
+				if err != nil {
+					return
+				}
+
+				go gossh.DiscardRequests(reqs)
+
+				for newChannel := range chans {
+					go handleChannel(newChannel)
+				}
+			}()
+		}
+	}()
+
+	return addr, signer.PublicKey(), &forwarded
+}
+
+// TestParseJumpHost tests parseJumpHost function.
+func TestParseJumpHost(t *testing.T) {
+	t.Parallel()
+
+	for jumpHost, expected := range map[string][2]string{
+		"user@bastion":           {"user", "bastion:22"},
+		"user@bastion:2222":      {"user", "bastion:2222"},
+		"user@10.0.0.1":          {"user", "10.0.0.1:22"},
+		"user@[2001:db8::1]":     {"user", "[2001:db8::1]:22"},
+		"user@[2001:db8::1]:222": {"user", "[2001:db8::1]:222"},
+	} {
+		user, addr, err := parseJumpHost(jumpHost)
+		if err != nil || user != expected[0] || addr != expected[1] {
+			t.Fatalf("unexpected jump host for %s: %s %s (%v)", jumpHost, user, addr, err)
+		}
+	}
+
+	for _, jumpHost := range []string{"bastion", "@bastion", "user@", "user@bastion:ssh"} {
+		if _, _, err := parseJumpHost(jumpHost); !errors.Is(err, ErrJumpHost) {
+			t.Fatalf("unexpected error for %s: %v", jumpHost, err)
+		}
+	}
+}
+
+// TestOpenSSHTunnel tests that a tunnel forwards a single local connection.
+func TestOpenSSHTunnel(t *testing.T) {
+	t.Parallel()
+
+	// The target echoes the data of its connections.
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	defer target.Close()
+
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	endpoint, err := transport.NewEndpoint("ssh://git@" + target.Addr().String() + "/foo.git")
+	if err != nil {
+		t.Fatalf("failed to parse the endpoint: %s", err)
+	}
+
+	tunnel, tunnelEndpoint, _, err := openSSHTunnel(endpoint, nil, net.Dial, nil)
+	if err != nil {
+		t.Fatalf("failed to open the tunnel: %s", err)
+	}
+	defer tunnel.Close()
+
+	addr := net.JoinHostPort(tunnelEndpoint.Host, strconv.Itoa(tunnelEndpoint.Port))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect to the tunnel: %s", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("failed to write to the tunnel: %s", err)
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "ping" {
+		t.Fatalf("unexpected reply through the tunnel: %q (%v)", reply, err)
+	}
+
+	// The listener is closed once the connection is accepted.
+	if other, err := net.Dial("tcp", addr); err == nil {
+		other.Close()
+		t.Fatal("the tunnel accepted a second connection")
+	}
+}
+
+// TestDoMirrorJumpHost tests mirroring to a destination through a jump host.
+func TestDoMirrorJumpHost(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	addr, hostKey, forwarded := newTestJumpSSHServer(t)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// The destination host name only resolves through the jump host.
+	err = DoMirror(Config{
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: "ssh://git@git.internal" + dstRepoPath,
+			SSH: SSHConf{
+				PrivateKey: testSSHKey,
+				KnownHosts: knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey) +
+					"\n" + knownhosts.Line([]string{"git.internal"}, hostKey),
+				JumpHost: "jump@" + addr,
+			},
+		},
+	}, logger)
+	if err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if atomic.LoadInt32(forwarded) == 0 {
+		t.Fatal("no connection went through the jump host")
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	ok, err := utils.RepoRefsCheckHash(dstRepo, srcHead, "refs/")
+	if err != nil || !ok {
+		t.Fatalf("unexpected hashes in the dst repo: %v", err)
+	}
+
+	// The jump host key needs to be known.
+	err = DoMirror(Config{
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: "ssh://git@git.internal" + dstRepoPath,
+			SSH: SSHConf{
+				PrivateKey: testSSHKey,
+				KnownHosts: knownhosts.Line([]string{"git.internal"}, hostKey),
+				JumpHost:   "jump@" + addr,
+			},
+		},
+	}, logger)
+	if err == nil || !strings.Contains(err.Error(), "jump host") {
+		t.Fatalf("unexpected error for an unknown jump host key: %v", err)
+	}
+}
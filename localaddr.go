@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"net"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// localTCPAddr returns the TCP address to bind the connections to for the
+// configured local address, or nil when not configured.
+func localTCPAddr(conf Config) net.Addr {
+	if len(conf.LocalAddr) == 0 {
+		return nil
+	}
+
+	return &net.TCPAddr{IP: net.ParseIP(conf.LocalAddr)}
+}
+
+// localAddrAuth is an SSH authentication connecting to the repository host
+// from a local address. go-git dials the SSH connections itself so they go
+// through a local tunnel dialed from the local address.
+type localAddrAuth struct {
+	ssh.AuthMethod
+	localAddr net.Addr
+}
+
+// openTunnel implements tunnelAuth.
+func (a localAddrAuth) openTunnel(endpoint *transport.Endpoint) (*sshTunnel,
+	*transport.Endpoint, transport.AuthMethod, error) {
+	config, err := a.AuthMethod.ClientConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: config.Timeout, LocalAddr: a.localAddr}
+
+	return openSSHTunnel(endpoint, a.AuthMethod, dialer.Dial, nil)
+}
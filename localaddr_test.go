@@ -0,0 +1,216 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// unassignedAddr is an IP address (TEST-NET-1) that isn't assigned to a
+// local interface so binding to it fails.
+const unassignedAddr = "192.0.2.1"
+
+// TestNewHTTPClientLocalAddr tests the HTTP client set up with a local
+// address.
+func TestNewHTTPClientLocalAddr(t *testing.T) {
+	t.Parallel()
+
+	var remoteAddr string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+	defer server.Close()
+
+	conf := Config{LocalAddr: "127.0.0.1"}
+	if !needsHTTPClient(conf) {
+		t.Fatal("local address didn't require a custom HTTP client")
+	}
+
+	{
+		// Test connecting from the local address.
+		httpClient, err := newHTTPClient(conf)
+		if err != nil {
+			t.Fatalf("failed to create the HTTP client: %s", err)
+		}
+
+		resp, err := httpClient.Get(server.URL)
+		if err != nil {
+			t.Fatalf("failed to connect from the local address: %s", err)
+		}
+		resp.Body.Close()
+
+		if host, _, _ := net.SplitHostPort(remoteAddr); host != "127.0.0.1" {
+			t.Fatalf("unexpected remote address: %s", remoteAddr)
+		}
+	}
+	{
+		// Test that the connections are bound to the local address.
+		httpClient, err := newHTTPClient(Config{LocalAddr: unassignedAddr})
+		if err != nil {
+			t.Fatalf("failed to create the HTTP client: %s", err)
+		}
+
+		if resp, err := httpClient.Get(server.URL); err == nil {
+			resp.Body.Close()
+			t.Fatal("connected from an unassigned local address")
+		}
+	}
+}
+
+// TestDoMirrorLocalAddr tests mirroring to an SSH destination from a local
+// address.
+func TestDoMirrorLocalAddr(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	addr, hostKey, _ := newTestJumpSSHServer(t)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: "ssh://git@" + addr + dstRepoPath,
+			SSH: SSHConf{
+				PrivateKey: testSSHKey,
+				KnownHosts: knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey),
+			},
+		},
+		LocalAddr: "127.0.0.1",
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	ok, err := utils.RepoRefsCheckHash(dstRepo, srcHead, "refs/")
+	if err != nil || !ok {
+		t.Fatalf("unexpected hashes in the dst repo: %v", err)
+	}
+
+	// The connection is bound to the local address.
+	conf.LocalAddr = unassignedAddr
+
+	err = DoMirror(conf, logger)
+	if err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Fatalf("unexpected error for an unassigned local address: %v", err)
+	}
+}
+
+// TestAnonymousSSHAuth tests that the SSH repositories without SSH key
+// connect from the local address. It isn't parallel as it sets the SSH agent
+// environment variable.
+func TestAnonymousSSHAuth(t *testing.T) {
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	// An empty SSH agent for the default SSH authentication.
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("failed to listen for the SSH agent: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				_ = agent.ServeAgent(agent.NewKeyring(), conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	url := "ssh://git@example.com/foo/bar.git"
+
+	{
+		// Test the default authentication without a local address.
+		auth, err := repoCredentials(Config{}, logger, RepoConf{URL: url}, "test")
+		if err != nil || auth != nil {
+			t.Fatalf("unexpected authentication: %v (%v)", auth, err)
+		}
+	}
+	{
+		// Test the default authentication from a local address, with and
+		// without SSH key directory match.
+		conf := Config{LocalAddr: "127.0.0.1"}
+
+		for _, repo := range []RepoConf{
+			{URL: url},
+			{URL: url, SSH: SSHConf{KeyDir: t.TempDir()}},
+		} {
+			auth, err := repoCredentials(conf, logger, repo, "test")
+			if err != nil {
+				t.Fatalf("repoCredentials failed: %s", err)
+			}
+
+			if _, ok := auth.(localAddrAuth); !ok {
+				t.Fatalf("unexpected authentication: %v", auth)
+			}
+		}
+	}
+	{
+		// Test that the other repositories are not affected.
+		auth, err := repoCredentials(Config{LocalAddr: "127.0.0.1"}, logger,
+			RepoConf{URL: "https://example.com/foo/bar.git"}, "test")
+		if err != nil || auth != nil {
+			t.Fatalf("unexpected authentication: %v (%v)", auth, err)
+		}
+	}
+}
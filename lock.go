@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var ErrLocked = errors.New("the lock file is held by another mirror run")
+
+const (
+	lockFilePerm = 0o644
+	// lockPollInterval is the period of the lock attempts while waiting for
+	// the lock file.
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// acquireLock takes the exclusive lock of conf.LockFile, which is created if
+// needed, so that the runs sharing a lock file don't overlap. When the lock is
+// held by another run, it waits up to conf.LockTimeout before failing with
+// ErrLocked. The lock is released by closing the returned file and, as it is
+// an advisory lock of the operating system, when the process exits.
+func acquireLock(conf Config, logger Logger) (*os.File, error) {
+	return lockFile(conf.LockFile, conf.LockTimeout, logger)
+}
+
+// lockFile takes the exclusive lock of the file at 'path', which is created if
+// needed, waiting up to 'timeout' before failing with ErrLocked when it is
+// held by someone else. The lock is released by closing the returned file.
+func lockFile(path string, timeout time.Duration, logger Logger) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, lockFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	waiting := false
+
+	for {
+		err := tryLockFile(file)
+		if err == nil {
+			return file, nil
+		}
+
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			file.Close()
+
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if !waiting {
+			logger.Info("Waiting up to", timeout, "for the lock file", path, "...")
+
+			waiting = true
+		}
+
+		time.Sleep(lockPollInterval)
+	}
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes, without blocking, the exclusive flock(2) lock of 'file'.
+// It returns ErrLocked when another open file description holds it.
+func tryLockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	} else if err != nil {
+		return fmt.Errorf("failed to lock the lock file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package mirror
+
+import (
+	"errors"
+	"os"
+)
+
+var errLockUnsupported = errors.New("lock files are not supported on this platform")
+
+// tryLockFile fails as the lock files are only supported with flock(2) and
+// LockFileEx.
+func tryLockFile(file *os.File) error {
+	return errLockUnsupported
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !windows
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestAcquireLock tests that a lock file is only held by one run at a time.
+func TestAcquireLock(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	conf := Config{LockFile: filepath.Join(t.TempDir(), "lock")}
+
+	lock, err := acquireLock(conf, logger)
+	if err != nil {
+		t.Fatalf("failed to acquire the lock: %s", err)
+	}
+
+	{
+		// Test that a held lock isn't acquired.
+		if _, err := acquireLock(conf, logger); !errors.Is(err, ErrLocked) {
+			t.Fatalf("unexpected error for a held lock: %v", err)
+		}
+	}
+	{
+		// Test waiting for a held lock to be released.
+		conf.LockTimeout = time.Minute
+
+		go func() {
+			time.Sleep(2 * lockPollInterval)
+			lock.Close()
+		}()
+
+		lock, err = acquireLock(conf, logger)
+		if err != nil {
+			t.Fatalf("failed to wait for the lock: %s", err)
+		}
+	}
+	{
+		// Test that a held lock fails the mirror.
+		conf.Source.URL, conf.Destination.URL = t.TempDir(), t.TempDir()
+		conf.LockTimeout = 0
+
+		if err := DoMirror(conf, logger); !errors.Is(err, ErrLocked) {
+			t.Fatalf("unexpected mirror error for a held lock: %v", err)
+		}
+	}
+
+	lock.Close()
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build windows
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLockFile takes, without blocking, the exclusive LockFileEx lock of the
+// first byte of 'file'. It returns ErrLocked when another handle holds it.
+func tryLockFile(file *os.File) error {
+	err := windows.LockFileEx(windows.Handle(file.Fd()),
+		windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0,
+		&windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	} else if err != nil {
+		return fmt.Errorf("failed to lock the lock file: %w", err)
+	}
+
+	return nil
+}
@@ -5,16 +5,88 @@
 package mirror
 
 import (
+	"crypto/rand"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"time"
 )
 
 // Used for moking exit function when running tests.
 var osExit = os.Exit
 
-// Logger structure provides per log level log.Logger.
-type Logger struct {
+// Logger is the interface used by the package for logging. It can be
+// implemented by consumers to route the log messages to their own logging
+// stack. StdLogger is the built-in implementation.
+type Logger interface {
+	// Debug logs a message only when debugMode is enabled.
+	Debug(debugMode bool, v ...any)
+	Info(v ...any)
+	Warn(v ...any)
+	Error(v ...any)
+}
+
+// RunIDLogger is implemented by the loggers that record the ID of a mirror
+// run themselves (e.g. as a structured attribute). The other loggers get the
+// run ID as a prefix of the messages.
+type RunIDLogger interface {
+	// WithRunID returns a logger including the run ID in every message.
+	WithRunID(runID string) Logger
+}
+
+// runLogger is a Logger prefixing the messages with the ID of a mirror run so
+// that the interleaved logs of concurrent runs can be told apart.
+type runLogger struct {
+	logger Logger
+	prefix string
+}
+
+// withRunID returns a logger including the run ID in every message.
+func withRunID(logger Logger, runID string) Logger {
+	if runIDLogger, ok := logger.(RunIDLogger); ok {
+		return runIDLogger.WithRunID(runID)
+	}
+
+	return runLogger{logger: logger, prefix: "run=" + runID}
+}
+
+// Debug is logging a prefixed message when debug mode is enabled.
+func (l runLogger) Debug(debugMode bool, v ...any) {
+	l.logger.Debug(debugMode, append([]any{l.prefix}, v...)...)
+}
+
+// Info is logging a prefixed message at the info level.
+func (l runLogger) Info(v ...any) {
+	l.logger.Info(append([]any{l.prefix}, v...)...)
+}
+
+// Warn is logging a prefixed message at the warn level.
+func (l runLogger) Warn(v ...any) {
+	l.logger.Warn(append([]any{l.prefix}, v...)...)
+}
+
+// Error is logging a prefixed message at the error level.
+func (l runLogger) Error(v ...any) {
+	l.logger.Error(append([]any{l.prefix}, v...)...)
+}
+
+// newRunID returns a random (version 4) UUID identifying a mirror run.
+func newRunID() string {
+	var uuid [16]byte
+
+	// crypto/rand.Read doesn't fail on the supported platforms.
+	_, _ = rand.Read(uuid[:])
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8],
+		uuid[8:10], uuid[10:16])
+}
+
+// StdLogger structure provides per log level log.Logger.
+type StdLogger struct {
 	debug   *log.Logger
 	info    *log.Logger
 	warning *log.Logger
@@ -23,9 +95,9 @@ type Logger struct {
 	output  io.Writer
 }
 
-// NewLogger returns a new Logger that will use the passed io.Writer.
-func NewLogger(out io.Writer) *Logger {
-	var logger Logger
+// NewLogger returns a new StdLogger that will use the passed io.Writer.
+func NewLogger(out io.Writer) *StdLogger {
+	var logger StdLogger
 	logger.debug = log.New(out, "[DEBUG]: ", 0)
 	logger.info = log.New(out, "[INFO ]: ", 0)
 	logger.warning = log.New(out, "[WARN ]: ", 0)
@@ -38,38 +110,83 @@ func NewLogger(out io.Writer) *Logger {
 
 // GetOutput returns the output the logger is using for all the logging
 // operations.
-func (l Logger) GetOutput() io.Writer {
+func (l StdLogger) GetOutput() io.Writer {
 	return l.output
 }
 
 // Debug is printing a log message using the debug logger when debug mode is
 // enabled.
-func (l Logger) Debug(debugMode bool, v ...any) {
+func (l StdLogger) Debug(debugMode bool, v ...any) {
 	if debugMode {
 		l.debug.Println(v...)
 	}
 }
 
 // Info is printing a log message using the info logger.
-func (l Logger) Info(v ...any) {
+func (l StdLogger) Info(v ...any) {
 	l.info.Println(v...)
 }
 
 // Warn is printing a log message using the warning logger.
-func (l Logger) Warn(v ...any) {
+func (l StdLogger) Warn(v ...any) {
 	l.warning.Println(v...)
 }
 
 // Error is printing a log message using the err logger.
-func (l Logger) Error(v ...any) {
+func (l StdLogger) Error(v ...any) {
 	l.err.Println(v...)
 }
 
 // Fatal is printing a log message using the fatal logger followed by an
 // os.Exit(1).
-func (l Logger) Fatal(v ...any) {
+func (l StdLogger) Fatal(v ...any) {
 	// We avoid Fatalln because we want to have the ability of mocking the exit
 	// function for testing purposes.
 	l.fatal.Println(v...)
 	osExit(1)
 }
+
+// runStats are the statistics of a mirror run, collected when not nil.
+type runStats struct {
+	// countChanged enables counting, in changed, the destination references
+	// the mirror changed. The destination is then listed before and after
+	// the push.
+	countChanged bool
+	changed      int
+	// durations are the durations of the run phases, as logged.
+	durations map[string]time.Duration
+	// transferred is the number of bytes of the packs fetched and pushed by
+	// the run, the wiki included.
+	transferred int64
+}
+
+// newRunStats returns the statistics of a mirror run, counting the changed
+// destination references when countChanged is set.
+func newRunStats(countChanged bool) *runStats {
+	return &runStats{
+		countChanged: countChanged,
+		durations:    map[string]time.Duration{},
+	}
+}
+
+// add adds the statistics of another run, e.g. of the wiki, with the phases
+// prefixed by 'prefix'.
+func (s *runStats) add(other *runStats, prefix string) {
+	s.changed += other.changed
+
+	for phase, duration := range other.durations {
+		s.durations[prefix+phase] = duration
+	}
+}
+
+// logDuration is logging, as info, how long an operation phase took since
+// 'start'. The duration is recorded in 'stats', when not nil.
+func logDuration(logger Logger, stats *runStats, phase string, start time.Time) {
+	duration := time.Since(start).Round(time.Millisecond)
+
+	if stats != nil {
+		stats.durations[phase] = duration
+	}
+
+	logger.Info(fmt.Sprintf("phase=%s duration=%s", phase, duration))
+}
@@ -7,11 +7,38 @@ package mirror
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
+// recordingLogger is a Logger implementation recording the logged messages.
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debug(debugMode bool, v ...any) {
+	if debugMode {
+		l.messages = append(l.messages, "debug: "+fmt.Sprint(v...))
+	}
+}
+
+func (l *recordingLogger) Info(v ...any) {
+	l.messages = append(l.messages, "info: "+fmt.Sprint(v...))
+}
+
+func (l *recordingLogger) Warn(v ...any) {
+	l.messages = append(l.messages, "warn: "+fmt.Sprint(v...))
+}
+
+func (l *recordingLogger) Error(v ...any) {
+	l.messages = append(l.messages, "error: "+fmt.Sprint(v...))
+}
+
 // osExitMock is used as a mock function for is.Exit(int).
 func osExitMock(exitCode int) {
 	return
@@ -26,6 +53,55 @@ func TestNewLogger(t *testing.T) {
 	}
 }
 
+// TestCustomLogger tests that a custom Logger implementation can be used.
+func TestCustomLogger(t *testing.T) {
+	t.Parallel()
+
+	var logger recordingLogger
+
+	conf := Config{Source: RepoConf{URL: "src"}, Destination: RepoConf{URL: "dst"}}
+	if err := conf.Validate(&logger); err != nil {
+		t.Fatalf("validation failed: %s", err)
+	}
+
+	if len(logger.messages) == 0 ||
+		!strings.HasPrefix(logger.messages[0], "info: Source repository:") {
+		t.Fatalf("unexpected messages: %q", logger.messages)
+	}
+}
+
+// TestRunLogger tests that the run ID prefixes the messages of a logger.
+func TestRunLogger(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+
+	logger := withRunID(NewLogger(&b), "id")
+
+	logger.Debug(true, "debug")
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	if output := b.String(); output != "[DEBUG]: run=id debug\n"+
+		"[INFO ]: run=id info\n[WARN ]: run=id warn\n[ERROR]: run=id error\n" {
+		t.Fatalf("unexpected run logger output: %s", output)
+	}
+}
+
+// TestNewRunID tests that newRunID returns distinct version 4 UUIDs.
+func TestNewRunID(t *testing.T) {
+	t.Parallel()
+
+	uuid := regexp.MustCompile(
+		`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	first, second := newRunID(), newRunID()
+	if !uuid.MatchString(first) || !uuid.MatchString(second) || first == second {
+		t.Fatalf("unexpected run IDs: %s, %s", first, second)
+	}
+}
+
 // TestGetOutput tests the GetOutput function.
 func TestGetOutput(t *testing.T) {
 	t.Parallel()
@@ -74,6 +150,30 @@ func TestInfo(t *testing.T) {
 	}
 }
 
+// TestLogDuration checks phase duration logging.
+func TestLogDuration(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	logger := NewLogger(&b)
+
+	stats := newRunStats(false)
+
+	logDuration(logger, stats, "test", time.Now().Add(-1500*time.Millisecond))
+
+	if output := b.String(); !strings.HasPrefix(output,
+		"[INFO ]: phase=test duration=1.5") {
+		t.Fatalf("unexpected duration output: %s", output)
+	}
+
+	if duration := stats.durations["test"]; duration < 1500*time.Millisecond {
+		t.Fatalf("unexpected recorded duration: %s", duration)
+	}
+
+	// The duration is only logged without statistics.
+	logDuration(logger, nil, "test", time.Now())
+}
+
 // TestWarn checks warn logging.
 func TestWarn(t *testing.T) {
 	t.Parallel()
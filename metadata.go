@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	githubHost      = "github.com"
+	gitlabHost      = "gitlab.com"
+	metadataTimeout = 30 * time.Second
+)
+
+// The provider API URLs. Variables so that they can be mocked when running
+// tests.
+var (
+	githubAPIURL = "https://api.github.com"
+	gitlabAPIURL = "https://gitlab.com/api/v4"
+)
+
+var (
+	ErrMetadataProvider = errors.New("repository metadata can only be " +
+		"synced with GitHub and GitLab repositories")
+	ErrNoMetadataToken = errors.New("syncing the repository metadata requires " +
+		"an API token for the destination provider")
+	ErrMetadataAPI          = errors.New("provider API request failed")
+	ErrDefaultBranchMissing = errors.New("the source default branch is not " +
+		"on the destination")
+)
+
+// repoMetadata is the repository metadata synced from the source to the
+// destination.
+type repoMetadata struct {
+	Description   string `json:"description"`
+	DefaultBranch string `json:"default_branch,omitempty"`
+}
+
+// metadataProvider models the REST API of a hosting provider.
+type metadataProvider struct {
+	// url returns the API URL of a repository path.
+	url func(repoPath string) string
+	// authorize sets the authentication of an API request.
+	authorize func(req *http.Request)
+	// updateMethod is the HTTP method updating a repository.
+	updateMethod string
+}
+
+// newMetadataProvider returns the provider API of a repository URL together
+// with the repository path (e.g. 'owner/repo').
+func newMetadataProvider(conf Config, repoURL string) (*metadataProvider, string, error) {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse the repository URL: %w", err)
+	}
+
+	repoPath := strings.TrimSuffix(strings.Trim(endpoint.Path, "/"), ".git")
+
+	switch strings.ToLower(endpoint.Host) {
+	case githubHost:
+		return &metadataProvider{
+			url: func(repoPath string) string {
+				return githubAPIURL + "/repos/" + repoPath
+			},
+			authorize: func(req *http.Request) {
+				req.Header.Set("Accept", "application/vnd.github+json")
+
+				if len(conf.GitHubToken) != 0 {
+					req.Header.Set("Authorization", "Bearer "+conf.GitHubToken)
+				}
+			},
+			updateMethod: http.MethodPatch,
+		}, repoPath, nil
+	case gitlabHost:
+		return &metadataProvider{
+			url: func(repoPath string) string {
+				return gitlabAPIURL + "/projects/" + url.PathEscape(repoPath)
+			},
+			authorize: func(req *http.Request) {
+				if len(conf.GitLabToken) != 0 {
+					req.Header.Set("PRIVATE-TOKEN", conf.GitLabToken)
+				}
+			},
+			updateMethod: http.MethodPut,
+		}, repoPath, nil
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrMetadataProvider, endpoint.Host)
+	}
+}
+
+// metadataToken returns the configured API token for the provider of a
+// repository URL.
+func metadataToken(conf Config, repoURL string) string {
+	endpoint, err := transport.NewEndpoint(repoURL)
+	if err != nil {
+		return ""
+	}
+
+	switch strings.ToLower(endpoint.Host) {
+	case githubHost:
+		return conf.GitHubToken
+	case gitlabHost:
+		return conf.GitLabToken
+	default:
+		return ""
+	}
+}
+
+// do sends an API request, with an optional JSON body, and decodes the JSON
+// response in 'out' when not nil.
+func (p metadataProvider) do(httpClient *http.Client, method, repoPath string,
+	body, out any) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode the request: %w", err)
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, p.url(repoPath), reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create the request: %w", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	p.authorize(req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrMetadataAPI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s %s: %s", ErrMetadataAPI, method, repoPath,
+			resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response: %w", err)
+	}
+
+	return nil
+}
+
+// syncMetadata copies the description and the default branch of the source
+// repository to the destination repository using the provider APIs. When not
+// nil, ensureBranch is called with the default branch before setting it so
+// that the destination HEAD isn't left dangling.
+func syncMetadata(conf Config, logger Logger, ensureBranch func(branch string) error) error {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return err
+	}
+
+	httpClient.Timeout = metadataTimeout
+
+	src, srcPath, err := newMetadataProvider(conf, conf.Source.URL)
+	if err != nil {
+		return err
+	}
+
+	dst, dstPath, err := newMetadataProvider(conf, conf.Destination.URL)
+	if err != nil {
+		return err
+	}
+
+	var metadata repoMetadata
+	if err := src.do(httpClient, http.MethodGet, srcPath, nil, &metadata); err != nil {
+		return fmt.Errorf("failed to get the source metadata: %w", err)
+	}
+
+	logger.Debug(conf.Debug, "Source description:", metadata.Description)
+	logger.Debug(conf.Debug, "Source default branch:", metadata.DefaultBranch)
+
+	if ensureBranch != nil && len(metadata.DefaultBranch) != 0 {
+		if err := ensureBranch(metadata.DefaultBranch); err != nil {
+			return err
+		}
+	}
+
+	if err := dst.do(httpClient, dst.updateMethod, dstPath, metadata, nil); err != nil {
+		return fmt.Errorf("failed to update the destination metadata: %w", err)
+	}
+
+	return nil
+}
+
+// ensureDefaultBranch checks that the destination has the branch set as its
+// default one. A missing branch (e.g. not mirrored because of the refspecs or
+// the filters) is pushed from the source when conf.CreateDefaultBranch is set.
+// Otherwise, it fails with ErrDefaultBranchMissing.
+func ensureDefaultBranch(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod, repo *git.Repository, branch string) error {
+	name := plumbing.NewBranchReferenceName(branch)
+
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return nil
+		}
+	}
+
+	if !conf.CreateDefaultBranch {
+		return fmt.Errorf("%w: %s", ErrDefaultBranchMissing, branch)
+	}
+
+	logger.Info("Creating the default branch", branch, "on the destination...")
+
+	spec := config.RefSpec(name.String() + ":" + name.String())
+
+	// The branch is fetched again as it was not mirrored.
+	if _, err := repo.Reference(name, false); err != nil {
+		srcAuth, err := repoAuth(conf, logger, conf.Source, "source")
+		if err != nil {
+			return err
+		}
+
+		src, err := repo.Remote(srcRemoteName)
+		if err != nil {
+			return fmt.Errorf("failed to get the source remote: %w", err)
+		}
+
+		err = src.Fetch(&git.FetchOptions{
+			RemoteName: srcRemoteName,
+			Auth:       srcAuth,
+			RefSpecs:   []config.RefSpec{spec},
+		})
+		if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return fmt.Errorf("%w: failed to fetch %s: %s", ErrDefaultBranchMissing,
+				branch, err)
+		}
+	}
+
+	err = pushSpecs(conf, logger, remote, auth, []config.RefSpec{spec})
+
+	invalidateRefsCache(conf, logger)
+
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to push the default branch: %w",
+			pushError(conf.Destination.URL, err))
+	}
+
+	return nil
+}
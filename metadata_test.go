@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestSyncMetadata tests syncing the metadata from a GitHub repository to a
+// GitLab one.
+func TestSyncMetadata(t *testing.T) {
+	// Not parallel as the provider API URLs are global.
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	github := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || r.URL.Path != "/repos/foo/bar" ||
+				r.Header.Get("Authorization") != "Bearer ghtoken" {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(`{"description": "desc", "default_branch": "main"}`))
+		}))
+	defer github.Close()
+
+	var updated repoMetadata
+
+	gitlab := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPut ||
+				r.URL.EscapedPath() != "/projects/group%2Fbar" ||
+				r.Header.Get("PRIVATE-TOKEN") != "gltoken" {
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			_ = json.NewDecoder(r.Body).Decode(&updated)
+		}))
+	defer gitlab.Close()
+
+	origGithubAPIURL, origGitlabAPIURL := githubAPIURL, gitlabAPIURL
+	githubAPIURL, gitlabAPIURL = github.URL, gitlab.URL
+
+	defer func() {
+		githubAPIURL, gitlabAPIURL = origGithubAPIURL, origGitlabAPIURL
+	}()
+
+	conf := Config{
+		Source:      RepoConf{URL: "https://github.com/foo/bar.git"},
+		Destination: RepoConf{URL: "git@gitlab.com:group/bar.git"},
+		GitHubToken: "ghtoken",
+		GitLabToken: "gltoken",
+	}
+
+	if err := syncMetadata(conf, logger, nil); err != nil {
+		t.Fatalf("syncMetadata failed: %s", err)
+	}
+
+	if updated.Description != "desc" || updated.DefaultBranch != "main" {
+		t.Fatalf("unexpected destination metadata: %+v", updated)
+	}
+
+	// API errors are reported.
+	conf.GitLabToken = "wrong"
+	if err := syncMetadata(conf, logger, nil); !errors.Is(err, ErrMetadataAPI) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// TestNewMetadataProvider tests newMetadataProvider function.
+func TestNewMetadataProvider(t *testing.T) {
+	t.Parallel()
+
+	for repo, expected := range map[string]string{
+		"https://github.com/foo/bar.git": "foo/bar",
+		"git@github.com:foo/bar.git":     "foo/bar",
+		"https://gitlab.com/a/b/c":       "a/b/c",
+	} {
+		_, repoPath, err := newMetadataProvider(Config{}, repo)
+		if err != nil {
+			t.Fatalf("failed to get the provider of %s: %s", repo, err)
+		}
+
+		if repoPath != expected {
+			t.Fatalf("unexpected repository path for %s: %s", repo, repoPath)
+		}
+	}
+
+	_, _, err := newMetadataProvider(Config{}, "https://example.com/foo/bar")
+	if !errors.Is(err, ErrMetadataProvider) {
+		t.Fatalf("unexpected error for an unsupported provider: %v", err)
+	}
+}
+
+// TestEnsureDefaultBranch tests ensureDefaultBranch function.
+func TestEnsureDefaultBranch(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	if _, _, err := utils.NewTestRepo(srcRepoPath, []string{"refs/heads/a"}); err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}
+
+	// A staging repository without the source references, as filtered out.
+	stagingRepo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to init the staging repo: %s", err)
+	}
+
+	_, err = stagingRepo.CreateRemote(&config.RemoteConfig{
+		Name: srcRemoteName,
+		URLs: []string{srcRepoPath},
+	})
+	if err != nil {
+		t.Fatalf("failed to create the source remote: %s", err)
+	}
+
+	dst, auth, err := setupDstRemote(conf, logger, stagingRepo)
+	if err != nil {
+		t.Fatalf("failed to set up the destination remote: %s", err)
+	}
+
+	// The destination has the branch.
+	if err := ensureDefaultBranch(conf, logger, dst, auth, stagingRepo,
+		"master"); err != nil {
+		t.Fatalf("ensureDefaultBranch failed: %s", err)
+	}
+
+	// The destination doesn't have the branch.
+	err = ensureDefaultBranch(conf, logger, dst, auth, stagingRepo, "a")
+	if !errors.Is(err, ErrDefaultBranchMissing) {
+		t.Fatalf("unexpected error for a missing branch: %v", err)
+	}
+
+	// The missing branch is created.
+	conf.CreateDefaultBranch = true
+
+	if err := ensureDefaultBranch(conf, logger, dst, auth, stagingRepo,
+		"a"); err != nil {
+		t.Fatalf("ensureDefaultBranch failed creating the branch: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/a", false); err != nil {
+		t.Fatalf("the default branch was not created: %s", err)
+	}
+
+	// The source doesn't have the branch either.
+	err = ensureDefaultBranch(conf, logger, dst, auth, stagingRepo, "b")
+	if !errors.Is(err, ErrDefaultBranchMissing) {
+		t.Fatalf("unexpected error for a missing source branch: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrMirrorsFailed = errors.New("mirroring to some destinations failed")
+
+// MirrorResult is the outcome of mirroring to one of the destinations of
+// DoMirrors. RunID identifies the mirror in the logs. Err is nil when the
+// mirror succeeded. Durations are the durations of the run phases that were
+// run (e.g. 'fetch', 'filter', 'push' and 'prune'), as logged.
+// TransferredBytes is the number of bytes of the packs fetched and pushed.
+type MirrorResult struct {
+	Destination      string
+	RunID            string
+	Err              error
+	Durations        map[string]time.Duration
+	TransferredBytes int64
+}
+
+// mirrorResultJSON is the JSON document of a MirrorResult.
+type mirrorResultJSON struct {
+	Destination      string             `json:"destination"`
+	RunID            string             `json:"run_id"`
+	Status           string             `json:"status"`
+	Error            string             `json:"error,omitempty"`
+	Durations        map[string]float64 `json:"durations_seconds,omitempty"`
+	TransferredBytes int64              `json:"transferred_bytes"`
+}
+
+// MarshalJSON implements json.Marshaler. The error is given as its message
+// along with a 'success' or 'failure' status, the destination URL
+// credentials are redacted and the durations are in seconds.
+func (r MirrorResult) MarshalJSON() ([]byte, error) {
+	result := mirrorResultJSON{
+		Destination:      redactURL(r.Destination),
+		RunID:            r.RunID,
+		Status:           historyStatusSuccess,
+		TransferredBytes: r.TransferredBytes,
+	}
+
+	if len(r.Durations) != 0 {
+		result.Durations = make(map[string]float64, len(r.Durations))
+
+		for phase, duration := range r.Durations {
+			result.Durations[phase] = duration.Seconds()
+		}
+	}
+
+	if r.Err != nil {
+		result.Status = historyStatusFailure
+		result.Error = r.Err.Error()
+	}
+
+	return json.Marshal(result)
+}
+
+// DoMirrorResult runs DoMirror and returns its outcome as a MirrorResult
+// (e.g. to be reported as JSON). A run ID is generated when the
+// configuration has none.
+func DoMirrorResult(conf Config, logger Logger) MirrorResult {
+	conf.mapDeprecated()
+
+	if len(conf.RunID) == 0 {
+		conf.RunID = newRunID()
+	}
+
+	stats := newRunStats(false)
+	err := runMirror(conf, logger, stats)
+
+	return MirrorResult{
+		Destination:      conf.Destination.URL,
+		RunID:            conf.RunID,
+		Err:              err,
+		Durations:        stats.durations,
+		TransferredBytes: stats.transferred,
+	}
+}
+
+// destinationConf returns the configuration mirroring to one of the
+// destinations of DoMirrors. The destination uses the host public keys of the
+// configuration destination, if any, when it has none, so that a single
+// known_hosts content, with entries for all the hosts, covers all of them.
+func destinationConf(conf Config, dst RepoConf) Config {
+	conf.mapDeprecated()
+
+	dst.SSH.inheritHostKeys(conf.Destination.SSH)
+
+	conf.Destination = dst
+
+	return conf
+}
+
+// DoMirrors mirrors the source to each of the destinations, using the
+// configuration for everything else. All the destinations are attempted even
+// when some of them fail. The returned error, wrapping ErrMirrorsFailed, lists
+// the failed destinations while the per destination errors are in the results.
+func DoMirrors(conf Config, destinations []RepoConf, logger Logger) ([]MirrorResult, error) {
+	results := make([]MirrorResult, 0, len(destinations))
+
+	var failed []string
+
+	for i, dst := range destinations {
+		// Each destination is a run of its own.
+		dstConf := destinationConf(conf, dst)
+		if len(conf.RunID) == 0 {
+			dstConf.RunID = newRunID()
+		} else {
+			dstConf.RunID = fmt.Sprintf("%s-%d", conf.RunID, i+1)
+		}
+
+		// The destinations are logged, and reported, without credentials.
+		dstURL := redactURL(dst.URL)
+
+		logger.Info(fmt.Sprintf("Mirroring to %s (%d/%d, run=%s)...", dstURL,
+			i+1, len(destinations), dstConf.RunID))
+
+		result := DoMirrorResult(dstConf, logger)
+		if result.Err != nil {
+			logger.Error("Mirroring to", dstURL, "failed:", result.Err)
+
+			failed = append(failed, fmt.Sprintf("%s: %s", dstURL, result.Err))
+		}
+
+		results = append(results, result)
+	}
+
+	if len(failed) != 0 {
+		return results, fmt.Errorf("%w: %s", ErrMirrorsFailed, strings.Join(failed, "; "))
+	}
+
+	return results, nil
+}
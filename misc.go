@@ -7,6 +7,7 @@ package mirror
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"path"
 )
 
 func mask(what string) string {
@@ -19,3 +20,15 @@ func mask(what string) string {
 
 	return masked
 }
+
+// matchesAny checks if a name matches any of the glob patterns (see
+// path.Match).
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
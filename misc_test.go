@@ -20,3 +20,25 @@ func TestMask(t *testing.T) {
 		t.Fatalf("unexpected output for \"foo\" input, got %s", m)
 	}
 }
+
+// TestMatchesAny tests the matchesAny function.
+func TestMatchesAny(t *testing.T) {
+	t.Parallel()
+
+	patterns := []string{"refs/heads/release/*", "refs/tags/v1"}
+
+	for name, expected := range map[string]bool{
+		"refs/heads/release/1.0": true,
+		"refs/tags/v1":           true,
+		"refs/tags/v2":           false,
+		"refs/heads/release":     false,
+	} {
+		if matchesAny(patterns, name) != expected {
+			t.Fatalf("unexpected match result for %s", name)
+		}
+	}
+
+	if matchesAny(nil, "refs/heads/a") {
+		t.Fatal("unexpected match with no patterns")
+	}
+}
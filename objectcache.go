@@ -0,0 +1,284 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+)
+
+const (
+	// objectCacheRefPrefix is the prefix of the object cache references of
+	// each source, which are the tips its objects are fetched up to.
+	objectCacheRefPrefix = "refs/sources/"
+	// objectCacheHavePrefix is the prefix of the temporary staging references
+	// to the object cache tips, advertised as "haves" to the source.
+	objectCacheHavePrefix = "refs/object-cache/"
+	// objectCachePackWindow is the delta compression window of the packs
+	// written to the object cache.
+	objectCachePackWindow = 10
+	// objectCacheLockFile is the file, in the object cache, locked while
+	// reading or updating the cache by the mirrors sharing it.
+	objectCacheLockFile = "git-mirror-me.lock"
+	// objectCacheLockTimeout is how long a mirror waits for the object cache
+	// lock.
+	objectCacheLockTimeout = 5 * time.Minute
+)
+
+// objectCacheStorer is a staging repository storage reading through the
+// objects of an object cache: the objects missing from the storage are read
+// from the cache. The fetch doesn't want the objects in the cache so only the
+// new ones are fetched into the storage.
+type objectCacheStorer struct {
+	storage.Storer
+	cache storer.EncodedObjectStorer
+}
+
+// EncodedObject implements storer.EncodedObjectStorer.
+func (s objectCacheStorer) EncodedObject(objType plumbing.ObjectType,
+	hash plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.Storer.EncodedObject(objType, hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.cache.EncodedObject(objType, hash)
+	}
+
+	return obj, err
+}
+
+// HasEncodedObject implements storer.EncodedObjectStorer.
+func (s objectCacheStorer) HasEncodedObject(hash plumbing.Hash) error {
+	err := s.Storer.HasEncodedObject(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.cache.HasEncodedObject(hash)
+	}
+
+	return err
+}
+
+// EncodedObjectSize implements storer.EncodedObjectStorer.
+func (s objectCacheStorer) EncodedObjectSize(hash plumbing.Hash) (int64, error) {
+	size, err := s.Storer.EncodedObjectSize(hash)
+	if errors.Is(err, plumbing.ErrObjectNotFound) {
+		return s.cache.EncodedObjectSize(hash)
+	}
+
+	return size, err
+}
+
+// openObjectCache opens the object cache bare repository, initialising it when
+// it doesn't exist.
+func openObjectCache(path string) (*git.Repository, error) {
+	cache, err := git.PlainOpen(path)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		cache, err = git.PlainInit(path, true)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the object cache: %w", err)
+	}
+
+	return cache, nil
+}
+
+// lockObjectCache takes the lock of the object cache so that the mirrors
+// sharing it don't read or update it at the same time. The lock is released
+// by closing the returned file.
+func lockObjectCache(conf Config, logger Logger) (*os.File, error) {
+	lock, err := lockFile(filepath.Join(conf.ObjectCache, objectCacheLockFile),
+		objectCacheLockTimeout, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock the object cache: %w", err)
+	}
+
+	return lock, nil
+}
+
+// objectCacheNamespace returns the prefix of the object cache references of
+// the source. The namespace is derived from the source URL, without
+// credentials.
+func objectCacheNamespace(conf Config) string {
+	sum := sha256.Sum256([]byte(redactURL(conf.Source.URL)))
+
+	return objectCacheRefPrefix + hex.EncodeToString(sum[:8]) + "/"
+}
+
+// setObjectCacheHaves sets temporary staging references to the tips of the
+// object cache so that the fetch advertises them as "haves" and the source
+// only sends the objects the cache is missing. They are removed with
+// removeObjectCacheHaves.
+func setObjectCacheHaves(conf Config, logger Logger, repo, cache *git.Repository) error {
+	lock, err := lockObjectCache(conf, logger)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	refs, err := cache.References()
+	if err != nil {
+		return fmt.Errorf("failed to get the object cache references: %w", err)
+	}
+
+	hashes := make(map[plumbing.Hash]bool)
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference &&
+			strings.HasPrefix(ref.Name().String(), objectCacheRefPrefix) {
+			hashes[ref.Hash()] = true
+		}
+
+		return nil
+	})
+
+	count := 0
+
+	for hash := range hashes {
+		name := plumbing.ReferenceName(objectCacheHavePrefix + strconv.Itoa(count))
+		if err := repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+			return fmt.Errorf("failed to set reference: %w", err)
+		}
+
+		count++
+	}
+
+	return nil
+}
+
+// removeObjectCacheHaves removes the staging references set by
+// setObjectCacheHaves.
+func removeObjectCacheHaves(repo *git.Repository) error {
+	return filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+		return !strings.HasPrefix(ref.Name().String(), objectCacheHavePrefix)
+	})
+}
+
+// updateObjectCache writes the objects fetched into the staging storage, and
+// missing from the object cache, to the cache as a pack. The cache references
+// of the source are updated, in place, to the fetched ones so that the next
+// fetches only get the newer objects. The cache is locked while updated.
+func updateObjectCache(conf Config, logger Logger, repo *git.Repository,
+	staging objectCacheStorer, cache *git.Repository) error {
+	lock, err := lockObjectCache(conf, logger)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	objects, err := staging.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return fmt.Errorf("failed to get the fetched objects: %w", err)
+	}
+
+	var hashes []plumbing.Hash
+
+	_ = objects.ForEach(func(obj plumbing.EncodedObject) error {
+		if cache.Storer.HasEncodedObject(obj.Hash()) != nil {
+			hashes = append(hashes, obj.Hash())
+		}
+
+		return nil
+	})
+
+	if len(hashes) != 0 {
+		packWriter, ok := cache.Storer.(storer.PackfileWriter)
+		if !ok {
+			return fmt.Errorf("failed to write the object cache: %w",
+				plumbing.ErrInvalidType)
+		}
+
+		writer, err := packWriter.PackfileWriter()
+		if err != nil {
+			return fmt.Errorf("failed to write the object cache: %w", err)
+		}
+
+		_, err = packfile.NewEncoder(writer, staging.Storer, false).
+			Encode(hashes, objectCachePackWindow)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to write the object cache: %w", err)
+		}
+
+		logger.Debug(conf.Debug, "Added", len(hashes), "object(s) to the object cache.")
+	}
+
+	return updateObjectCacheRefs(conf, repo, cache)
+}
+
+// updateObjectCacheRefs sets the object cache references of the source to the
+// references of the staging repository. The references are replaced in place,
+// and only the ones the source no longer has are removed, so that a reader
+// never sees the namespace emptied.
+func updateObjectCacheRefs(conf Config, repo, cache *git.Repository) error {
+	namespace := objectCacheNamespace(conf)
+
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	current := make(map[plumbing.ReferenceName]bool)
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference ||
+			!strings.HasPrefix(ref.Name().String(), "refs/") {
+			return nil
+		}
+
+		name := plumbing.ReferenceName(namespace +
+			strings.TrimPrefix(ref.Name().String(), "refs/"))
+		current[name] = true
+
+		return cache.Storer.SetReference(plumbing.NewHashReference(name, ref.Hash()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the object cache references: %w", err)
+	}
+
+	err = filterRefsFunc(cache, func(ref *plumbing.Reference) bool {
+		return !strings.HasPrefix(ref.Name().String(), namespace) || current[ref.Name()]
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the object cache references: %w", err)
+	}
+
+	return nil
+}
+
+// writeObjectCacheAlternates makes the object cache an alternate object store
+// of the on-disk staging repository 'dir' so that the git commands run in it
+// (e.g. the pre-mirror check) find the objects read from the cache.
+func writeObjectCacheAlternates(conf Config, dir string) error {
+	objects, err := filepath.Abs(filepath.Join(conf.ObjectCache, "objects"))
+	if err != nil {
+		return fmt.Errorf("failed to get the object cache path: %w", err)
+	}
+
+	info := filepath.Join(dir, "objects", "info")
+	if err := os.MkdirAll(info, 0o755); err != nil {
+		return fmt.Errorf("failed to write the object cache alternates: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(info, "alternates"), []byte(objects+"\n"), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write the object cache alternates: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorObjectCache tests mirroring with an object cache.
+func TestDoMirrorObjectCache(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	cachePath := filepath.Join(t.TempDir(), "cache")
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		ObjectCache: cachePath,
+	}
+
+	{
+		// Test that the cache is created and populated.
+		dstRepoPath := t.TempDir()
+
+		if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		conf.Destination = RepoConf{URL: dstRepoPath}
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		cache, err := git.PlainOpen(cachePath)
+		if err != nil {
+			t.Fatalf("failed to open the object cache: %s", err)
+		}
+
+		if err := cache.Storer.HasEncodedObject(srcHead); err != nil {
+			t.Fatalf("the object cache doesn't have the src head: %s", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(cachePath, objectCacheLockFile)); err != nil {
+			t.Fatalf("the object cache was not locked: %s", err)
+		}
+
+		cacheRefs, err := utils.RepoRefsSlice(cache)
+		if err != nil {
+			t.Fatalf("failed to get the object cache refs: %s", err)
+		}
+
+		namespace := objectCacheNamespace(conf)
+		if !utils.SlicesAreEqual(cacheRefs, []string{
+			"HEAD",
+			namespace + "heads/master",
+			namespace + "heads/a",
+			namespace + "tags/v1",
+		}) {
+			t.Fatalf("unexpected refs in the object cache: %s", cacheRefs)
+		}
+	}
+	{
+		// Test that the cached objects are not fetched again.
+		repo, err := setupStagingRepo(conf, logger)
+		if err != nil {
+			t.Fatalf("failed to set up the staging repo: %s", err)
+		}
+
+		objects, err := repo.Storer.(objectCacheStorer).Storer.
+			IterEncodedObjects(plumbing.AnyObject)
+		if err != nil {
+			t.Fatalf("failed to get the staging objects: %s", err)
+		}
+
+		count := 0
+		_ = objects.ForEach(func(plumbing.EncodedObject) error {
+			count++
+
+			return nil
+		})
+
+		if count != 0 {
+			t.Fatalf("%d cached object(s) were fetched again", count)
+		}
+
+		repoRefs, err := utils.RepoRefsSlice(repo)
+		if err != nil {
+			t.Fatalf("failed to get the staging repo refs: %s", err)
+		}
+
+		for _, ref := range repoRefs {
+			if strings.HasPrefix(ref, objectCacheHavePrefix) {
+				t.Fatalf("unexpected ref in the staging repo: %s", ref)
+			}
+		}
+	}
+	{
+		// Test mirroring the cached objects, which the pre-mirror check
+		// finds too.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, err := utils.NewBareRepo(dstRepoPath)
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		conf.Destination = RepoConf{URL: dstRepoPath}
+		conf.PreMirrorCheck = "git cat-file -e " + srcHead.String()
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed from the object cache: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/a",
+			"refs/tags/v1",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+
+		ok, err := utils.RepoRefsCheckHash(dstRepo, srcHead, "refs/")
+		if err != nil || !ok {
+			t.Fatalf("unexpected hashes in the dst repo: %v", err)
+		}
+	}
+}
+
+// TestUpdateObjectCacheRefs tests that the object cache references of a
+// source are updated in place.
+func TestUpdateObjectCacheRefs(t *testing.T) {
+	t.Parallel()
+
+	repo, first, second := newTestHistoryRepo(t)
+
+	cache, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to create the object cache: %s", err)
+	}
+
+	conf := Config{Source: RepoConf{URL: "https://example.com/foo/bar"}}
+	namespace := objectCacheNamespace(conf)
+
+	for name, hash := range map[string]plumbing.Hash{
+		namespace + "heads/master":   first,
+		namespace + "heads/old":      first,
+		"refs/sources/other/heads/a": first,
+	} {
+		err := cache.Storer.SetReference(plumbing.NewHashReference(
+			plumbing.ReferenceName(name), hash))
+		if err != nil {
+			t.Fatalf("failed to set reference: %s", err)
+		}
+	}
+
+	if err := updateObjectCacheRefs(conf, repo, cache); err != nil {
+		t.Fatalf("updateObjectCacheRefs failed: %s", err)
+	}
+
+	cacheRefs, err := utils.RepoRefsSlice(cache)
+	if err != nil {
+		t.Fatalf("failed to get the object cache refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(cacheRefs, []string{
+		"HEAD",
+		namespace + "heads/master",
+		"refs/sources/other/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the object cache: %s", cacheRefs)
+	}
+
+	ref, err := cache.Reference(plumbing.ReferenceName(namespace+"heads/master"), false)
+	if err != nil || ref.Hash() != second {
+		t.Fatalf("unexpected updated reference: %v (%v)", ref, err)
+	}
+}
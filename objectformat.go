@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// objectFormatRe matches the 'object-format' capability in the text of a
+// reference advertisement.
+var objectFormatRe = regexp.MustCompile(`object-format=(\S+)`)
+
+// checkObjectFormat checks the object format a remote advertises with the
+// 'object-format' capability, returning ErrObjectFormat for the formats other
+// than SHA-1. go-git can't decode the advertisements with longer hashes (e.g.
+// SHA-256) but the decoding error has the rest of the first line, with the
+// capabilities, so the format is read from it. The advertisement error is
+// returned otherwise.
+func checkObjectFormat(advRefs *packp.AdvRefs, err error) error {
+	var format string
+
+	if err == nil {
+		if values := advRefs.Capabilities.Get(capability.ObjectFormat); len(values) != 0 {
+			format = values[0]
+		}
+	} else if match := objectFormatRe.FindStringSubmatch(err.Error()); match != nil {
+		format = match[1]
+	}
+
+	if len(format) != 0 && format != objectFormatSHA1 {
+		return fmt.Errorf("%w: the repository uses %q", ErrObjectFormat, format)
+	}
+
+	return err
+}
+
+// objectFormatTransport is a go-git transport failing, with ErrObjectFormat,
+// the sessions with remotes using an object format other than SHA-1, before
+// anything is fetched or pushed.
+type objectFormatTransport struct {
+	transport.Transport
+}
+
+// objectFormatUploadPackSession is an upload-pack session checking the
+// object format of the remote.
+type objectFormatUploadPackSession struct {
+	transport.UploadPackSession
+}
+
+// objectFormatReceivePackSession is a receive-pack session checking the
+// object format of the remote.
+type objectFormatReceivePackSession struct {
+	transport.ReceivePackSession
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t objectFormatTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := t.Transport.NewUploadPackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return objectFormatUploadPackSession{UploadPackSession: session}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t objectFormatTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	session, err := t.Transport.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return objectFormatReceivePackSession{ReceivePackSession: session}, nil
+}
+
+// AdvertisedReferences implements transport.Session.
+func (s objectFormatUploadPackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.Background())
+}
+
+// AdvertisedReferencesContext implements transport.Session.
+func (s objectFormatUploadPackSession) AdvertisedReferencesContext(
+	ctx context.Context) (*packp.AdvRefs, error) {
+	advRefs, err := s.UploadPackSession.AdvertisedReferencesContext(ctx)
+	if err := checkObjectFormat(advRefs, err); err != nil {
+		return nil, err
+	}
+
+	return advRefs, nil
+}
+
+// AdvertisedReferences implements transport.Session.
+func (s objectFormatReceivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.Background())
+}
+
+// AdvertisedReferencesContext implements transport.Session.
+func (s objectFormatReceivePackSession) AdvertisedReferencesContext(
+	ctx context.Context) (*packp.AdvRefs, error) {
+	advRefs, err := s.ReceivePackSession.AdvertisedReferencesContext(ctx)
+	if err := checkObjectFormat(advRefs, err); err != nil {
+		return nil, err
+	}
+
+	return advRefs, nil
+}
@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestCheckObjectFormat tests checkObjectFormat function.
+func TestCheckObjectFormat(t *testing.T) {
+	t.Parallel()
+
+	// advRefs returns an advertisement with an object format, if any.
+	advRefs := func(format string) *packp.AdvRefs {
+		advRefs := packp.NewAdvRefs()
+		if len(format) != 0 {
+			_ = advRefs.Capabilities.Set(capability.ObjectFormat, format)
+		}
+
+		return advRefs
+	}
+
+	errDecode := errors.New("pkt-line 1: no space after hash (HEAD\x00side-band " +
+		"object-format=sha256 agent=git/2.39.5)")
+	errOther := errors.New("other")
+
+	for _, test := range []struct {
+		advRefs  *packp.AdvRefs
+		err      error
+		expected error
+	}{
+		{advRefs(""), nil, nil},
+		{advRefs("sha1"), nil, nil},
+		{advRefs("sha256"), nil, ErrObjectFormat},
+		{nil, errDecode, ErrObjectFormat},
+		{nil, errOther, errOther},
+	} {
+		if err := checkObjectFormat(test.advRefs, test.err); !errors.Is(err, test.expected) ||
+			(test.expected == nil && err != nil) {
+			t.Fatalf("unexpected error for %v (%v): %v", test.advRefs, test.err, err)
+		}
+	}
+}
+
+// TestDoMirrorObjectFormat tests that a SHA-256 source fails the mirror before
+// fetching.
+func TestDoMirrorObjectFormat(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	// go-git can't create SHA-256 repositories.
+	err := exec.Command("git", "init", "--quiet", "--object-format=sha256", srcRepoPath).Run()
+	if err != nil {
+		t.Skipf("git can't create a SHA-256 repository: %s", err)
+	}
+
+	err = exec.Command("git", "-C", srcRepoPath, "-c", "user.name=test",
+		"-c", "user.email=test@example.com", "commit", "--quiet",
+		"--allow-empty", "--message=test").Run()
+	if err != nil {
+		t.Fatalf("failed to commit in the src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	err = DoMirror(Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+	}, logger)
+	if !errors.Is(err, ErrObjectFormat) {
+		t.Fatalf("unexpected error for a SHA-256 source: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	ErrPlanFile = errors.New("a plan file is written by a dry run and " +
+		"applied by a run that is not a dry run")
+	ErrInvalidPlan  = errors.New("invalid plan")
+	ErrPlanOutdated = errors.New("the source or the destination changed since " +
+		"the plan was written")
+)
+
+// planHeader is the first line of a plan file.
+const planHeader = "# git-mirror-me plan"
+
+// Prefixes of the plan header lines naming the source and the destination.
+const (
+	planSourcePrefix      = "# source: "
+	planDestinationPrefix = "# destination: "
+)
+
+// writePlan writes the refspecs a mirror would push and delete to a plan file.
+// Each line is the destination hash of the reference when the plan is written
+// (the zero hash when missing) followed by a refspec:
+// '<old>:<hash>:<reference>' (prefixed with '+' for a force push) for a
+// reference to push and '<old>::<reference>' for a reference to delete.
+// 'dstRefs' are the destination references. The lines are sorted by
+// reference, pushes first, so that the same changes always result in the
+// same file.
+func writePlan(conf Config, path string, dstRefs, push, del []*plumbing.Reference) error {
+	var plan strings.Builder
+
+	fmt.Fprintln(&plan, planHeader)
+	fmt.Fprintln(&plan, planSourcePrefix+redactURL(conf.Source.URL))
+	fmt.Fprintln(&plan, planDestinationPrefix+redactURL(conf.Destination.URL))
+
+	force := ""
+	if !conf.FastForwardOnly {
+		force = "+"
+	}
+
+	old := make(map[plumbing.ReferenceName]plumbing.Hash, len(dstRefs))
+	for _, ref := range dstRefs {
+		old[ref.Name()] = ref.Hash()
+	}
+
+	pushSpecs := make([]string, 0, len(push))
+	for _, ref := range push {
+		pushSpecs = append(pushSpecs, force+old[ref.Name()].String()+":"+
+			ref.Hash().String()+":"+ref.Name().String())
+	}
+
+	deleted := make(map[plumbing.ReferenceName]bool, len(del))
+	deleteSpecs := make([]string, 0, len(del))
+
+	for _, ref := range del {
+		if !deleted[ref.Name()] {
+			deleted[ref.Name()] = true
+			deleteSpecs = append(deleteSpecs, old[ref.Name()].String()+"::"+
+				ref.Name().String())
+		}
+	}
+
+	sortPlanSpecs(pushSpecs)
+	sortPlanSpecs(deleteSpecs)
+
+	for _, spec := range append(pushSpecs, deleteSpecs...) {
+		fmt.Fprintln(&plan, spec)
+	}
+
+	if err := os.WriteFile(path, []byte(plan.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write the plan: %w", err)
+	}
+
+	return nil
+}
+
+// sortPlanSpecs sorts plan lines by reference name, the last field of the
+// lines as reference names can't have colons.
+func sortPlanSpecs(specs []string) {
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i][strings.LastIndex(specs[i], ":")+1:] <
+			specs[j][strings.LastIndex(specs[j], ":")+1:]
+	})
+}
+
+// checkPlanHeader checks that the header lines of a plan are the ones
+// writePlan writes for the source and the destination of the configuration
+// so that a plan is only applied to the repositories it was reviewed for.
+func checkPlanHeader(conf Config, header []string) error {
+	expected := []string{
+		planHeader,
+		planSourcePrefix + redactURL(conf.Source.URL),
+		planDestinationPrefix + redactURL(conf.Destination.URL),
+	}
+
+	if len(header) < len(expected) {
+		return fmt.Errorf("%w: missing header", ErrInvalidPlan)
+	}
+
+	for i, line := range expected {
+		if header[i] != line {
+			return fmt.Errorf("%w: %q instead of %q", ErrInvalidPlan, header[i], line)
+		}
+	}
+
+	return nil
+}
+
+// readPlan reads a plan file written by writePlan and returns the refspecs to
+// push from the staging repository, with the destination hashes the plan
+// expects. The plan needs to be for the configured source and destination.
+// The pushed references need to still point to the hashes of the plan so
+// that only the reviewed changes are applied.
+func readPlan(conf Config, path string, repo *git.Repository) ([]config.RefSpec,
+	map[plumbing.ReferenceName]plumbing.Hash, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open the plan: %w", err)
+	}
+	defer file.Close()
+
+	var (
+		specs  []config.RefSpec
+		header []string
+	)
+
+	old := make(map[plumbing.ReferenceName]plumbing.Hash)
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			header = append(header, line)
+
+			continue
+		}
+
+		if err := checkPlanHeader(conf, header); err != nil {
+			return nil, nil, err
+		}
+
+		force := strings.HasPrefix(line, "+")
+
+		fields := strings.SplitN(strings.TrimPrefix(line, "+"), ":", 3)
+		if len(fields) != 3 || !plumbing.IsHash(fields[0]) ||
+			!strings.HasPrefix(fields[2], "refs/") {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPlan, line)
+		}
+
+		hash, name := fields[1], fields[2]
+		old[plumbing.ReferenceName(name)] = plumbing.NewHash(fields[0])
+
+		// A reference to delete.
+		if len(hash) == 0 {
+			specs = append(specs, config.RefSpec(":"+name))
+
+			continue
+		}
+
+		if !plumbing.IsHash(hash) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrInvalidPlan, line)
+		}
+
+		ref, err := repo.Reference(plumbing.ReferenceName(name), false)
+		if err != nil || ref.Hash() != plumbing.NewHash(hash) {
+			return nil, nil, fmt.Errorf("%w: %s", ErrPlanOutdated, name)
+		}
+
+		spec := name + ":" + name
+		if force {
+			spec = "+" + spec
+		}
+
+		specs = append(specs, config.RefSpec(spec))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read the plan: %w", err)
+	}
+
+	if err := checkPlanHeader(conf, header); err != nil {
+		return nil, nil, err
+	}
+
+	return specs, old, nil
+}
+
+// checkPlanDestination checks that the destination references still point to
+// the hashes of the plan, 'old', so that the references changed on the
+// destination since the review are neither overwritten nor deleted.
+func checkPlanDestination(dstRefs []*plumbing.Reference,
+	old map[plumbing.ReferenceName]plumbing.Hash) error {
+	current := make(map[plumbing.ReferenceName]plumbing.Hash, len(dstRefs))
+	for _, ref := range dstRefs {
+		current[ref.Name()] = ref.Hash()
+	}
+
+	for name, hash := range old {
+		if current[name] != hash {
+			return fmt.Errorf("%w: %s is at %s instead of %s on the destination",
+				ErrPlanOutdated, name, current[name], hash)
+		}
+	}
+
+	return nil
+}
+
+// applyPlan pushes and deletes the references of conf.ApplyPlan, in a single
+// push. It returns true when references were updated.
+func applyPlan(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository) (bool, error) {
+	specs, old, err := readPlan(conf, conf.ApplyPlan, repo)
+	if err != nil {
+		return false, err
+	}
+
+	// An empty destination has no references.
+	dstRefs, err := listRemote(conf, remote, auth)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return false, fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	if err := checkPlanDestination(dstRefs, old); err != nil {
+		return false, err
+	}
+
+	if len(specs) == 0 {
+		logger.Info("The plan has no changes.")
+
+		return false, nil
+	}
+
+	logger.Info("Applying", len(specs), "refspec(s) from the plan...")
+
+	err = withRetries(conf, logger, "Applying the plan", func() error {
+		return remote.Push(&git.PushOptions{
+			RemoteName: remote.Config().Name,
+			Auth:       auth,
+			RefSpecs:   specs,
+		})
+	})
+
+	if !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		invalidateRefsCache(conf, logger)
+	}
+
+	switch {
+	case errors.Is(err, git.NoErrAlreadyUpToDate):
+		logger.Info("Destination already up to date.")
+
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to apply the plan: %w",
+			pushError(conf.Destination.URL, err))
+	}
+
+	return true, nil
+}
@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestReadPlan tests readPlan function with invalid plans.
+func TestReadPlan(t *testing.T) {
+	t.Parallel()
+
+	repo, head, err := utils.NewTestRepo(t.TempDir(), []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: "https://example.com/src.git"},
+		Destination: RepoConf{URL: "https://example.com/dst.git"},
+	}
+	header := planHeader + "\n" +
+		"# source: https://example.com/src.git\n" +
+		"# destination: https://example.com/dst.git\n"
+	zero := plumbing.ZeroHash.String()
+
+	path := filepath.Join(t.TempDir(), "plan")
+
+	for _, plan := range []string{
+		header + "refs/heads/a\n",
+		header + "+" + head.String() + ":a\n",
+		header + "+" + zero + ":" + head.String() + ":a\n",
+		header + "+" + zero + ":foo:refs/heads/a\n",
+		header + "+foo:" + head.String() + ":refs/heads/a\n",
+		// The header is required.
+		"+" + zero + ":" + head.String() + ":refs/heads/a\n",
+		"",
+		// The plan is for another source or destination.
+		strings.Replace(header, "src.git", "other.git", 1),
+		strings.Replace(header, "dst.git", "other.git", 1) +
+			zero + "::refs/heads/b\n",
+	} {
+		if err := os.WriteFile(path, []byte(plan), 0o644); err != nil {
+			t.Fatalf("failed to write the plan: %s", err)
+		}
+
+		if _, _, err := readPlan(conf, path, repo); !errors.Is(err, ErrInvalidPlan) {
+			t.Fatalf("unexpected error for plan %q: %v", plan, err)
+		}
+	}
+
+	plan := header + "+" + zero + ":" + zero + ":refs/heads/a\n"
+	if err := os.WriteFile(path, []byte(plan), 0o644); err != nil {
+		t.Fatalf("failed to write the plan: %s", err)
+	}
+
+	if _, _, err := readPlan(conf, path, repo); !errors.Is(err, ErrPlanOutdated) {
+		t.Fatalf("unexpected error for an outdated plan: %v", err)
+	}
+
+	plan = header + "+" + zero + ":" + head.String() + ":refs/heads/a\n" +
+		head.String() + "::refs/heads/b\n"
+	if err := os.WriteFile(path, []byte(plan), 0o644); err != nil {
+		t.Fatalf("failed to write the plan: %s", err)
+	}
+
+	specs, old, err := readPlan(conf, path, repo)
+	if err != nil {
+		t.Fatalf("readPlan failed: %s", err)
+	}
+
+	if !cmp.Equal(specs, []config.RefSpec{"+refs/heads/a:refs/heads/a", ":refs/heads/b"}) ||
+		!cmp.Equal(old, map[plumbing.ReferenceName]plumbing.Hash{
+			"refs/heads/a": plumbing.ZeroHash,
+			"refs/heads/b": head,
+		}) {
+		t.Fatalf("unexpected plan: %s %v", specs, old)
+	}
+}
+
+// TestCheckPlanDestination tests that the destination references need to
+// point to the hashes of the plan.
+func TestCheckPlanDestination(t *testing.T) {
+	t.Parallel()
+
+	hash := plumbing.NewHash("7d9b1bd6e1ea9ae3ec5ba30b3bcf8bbd1bfb7bb3")
+	dstRefs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/a", hash),
+	}
+
+	for _, test := range []struct {
+		old map[plumbing.ReferenceName]plumbing.Hash
+		err error
+	}{
+		{old: map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/a": hash}},
+		{old: map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/b": plumbing.ZeroHash}},
+		{
+			old: map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/a": plumbing.ZeroHash},
+			err: ErrPlanOutdated,
+		},
+		{
+			old: map[plumbing.ReferenceName]plumbing.Hash{"refs/heads/b": hash},
+			err: ErrPlanOutdated,
+		},
+	} {
+		if err := checkPlanDestination(dstRefs, test.old); !errors.Is(err, test.err) {
+			t.Fatalf("unexpected error for %v: %v", test.old, err)
+		}
+	}
+}
+
+// TestDoMirrorPlan tests writing a plan with a dry run and applying it.
+func TestDoMirrorPlan(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	srcRepo, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, dstHead, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/c",
+	}, "dst")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan")
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		DryRun:      true,
+		PlanFile:    path,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	plan, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the plan: %s", err)
+	}
+
+	expectedPlan := planHeader + "\n" +
+		"# source: " + srcRepoPath + "\n" +
+		"# destination: " + dstRepoPath + "\n" +
+		"+" + plumbing.ZeroHash.String() + ":" + srcHead.String() + ":refs/heads/a\n" +
+		"+" + dstHead.String() + ":" + srcHead.String() + ":refs/heads/master\n" +
+		dstHead.String() + "::refs/heads/c\n"
+	if string(plan) != expectedPlan {
+		t.Fatalf("unexpected plan:\n%s", plan)
+	}
+
+	conf.DryRun, conf.PlanFile, conf.ApplyPlan = false, "", path
+
+	// A plan is only applied to its destination.
+	otherConf := conf
+	otherConf.Destination.URL = t.TempDir()
+
+	if err := DoMirror(otherConf, logger); !errors.Is(err, ErrInvalidPlan) {
+		t.Fatalf("unexpected error applying a plan to another destination: %v", err)
+	}
+
+	// A destination changed since the plan is not mirrored.
+	err = dstRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/c", srcHead))
+	if err != nil {
+		t.Fatalf("failed to update a dst repo reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); !errors.Is(err, ErrPlanOutdated) {
+		t.Fatalf("unexpected error applying a plan to a changed destination: %v", err)
+	}
+
+	err = dstRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/c", dstHead))
+	if err != nil {
+		t.Fatalf("failed to restore a dst repo reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed applying the plan: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	// A source changed since the plan is not mirrored.
+	err = srcRepo.Storer.RemoveReference("refs/heads/a")
+	if err != nil {
+		t.Fatalf("failed to remove a src repo reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); !errors.Is(err, ErrPlanOutdated) {
+		t.Fatalf("unexpected error applying an outdated plan: %v", err)
+	}
+}
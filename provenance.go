@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	ErrProvenanceSignKey = errors.New("the provenance sign key needs to be an " +
+		"unencrypted OpenPGP private key")
+	ErrNoSourceHead = errors.New("the source HEAD can't be resolved")
+)
+
+const (
+	// provenanceRefPrefix is the prefix of the provenance tags pushed to the
+	// destination. These are never pruned.
+	provenanceRefPrefix  = "refs/mirror/synced/"
+	provenanceTimeFormat = "20060102T150405Z"
+	provenanceTagger     = "git-mirror-me"
+)
+
+// provenanceSignKey reads the ASCII armored OpenPGP private key used to sign
+// the provenance tags.
+func provenanceSignKey(armored string) (*openpgp.Entity, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProvenanceSignKey, err)
+	}
+
+	if len(entities) == 0 || entities[0].PrivateKey == nil ||
+		entities[0].PrivateKey.Encrypted {
+		return nil, ErrProvenanceSignKey
+	}
+
+	return entities[0], nil
+}
+
+// redactURL masks the password of a repository URL, if any.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	return parsed.Redacted()
+}
+
+// sourceHead resolves the source HEAD, as advertised by the source remote of
+// the staging repository, to a commit hash.
+func sourceHead(conf Config, logger Logger, repo *git.Repository) (plumbing.Hash, error) {
+	auth, err := repoAuth(conf, logger, conf.Source, "source")
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	src, err := repo.Remote(srcRemoteName)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to get the source remote: %w", err)
+	}
+
+	refs, err := listRemote(conf, src, auth)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to list the source remote: %w", err)
+	}
+
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference, len(refs))
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	head, ok := byName[plumbing.HEAD]
+	for ok && head.Type() == plumbing.SymbolicReference {
+		head, ok = byName[head.Target()]
+	}
+
+	if !ok {
+		return plumbing.ZeroHash, ErrNoSourceHead
+	}
+
+	return head.Hash(), nil
+}
+
+// newProvenanceTag creates, in the staging repository, an annotated tag of
+// the source HEAD recording when and from where the destination was mirrored.
+// The tag is signed when conf.ProvenanceSignKey is set.
+func newProvenanceTag(conf Config, logger Logger, repo *git.Repository,
+	now time.Time) (*plumbing.Reference, error) {
+	head, err := sourceHead(conf, logger, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	name := now.UTC().Format(provenanceTimeFormat)
+
+	tag := &object.Tag{
+		Name: name,
+		Tagger: object.Signature{
+			Name: provenanceTagger,
+			When: now,
+		},
+		Message: fmt.Sprintf("Mirrored from %s to %s at %s.\n",
+			redactURL(conf.Source.URL), redactURL(conf.Destination.URL),
+			now.UTC().Format(time.RFC3339)),
+		TargetType: plumbing.CommitObject,
+		Target:     head,
+	}
+
+	if len(conf.ProvenanceSignKey) != 0 {
+		signKey, err := provenanceSignKey(conf.ProvenanceSignKey)
+		if err != nil {
+			return nil, err
+		}
+
+		unsigned := repo.Storer.NewEncodedObject()
+		if err := tag.Encode(unsigned); err != nil {
+			return nil, fmt.Errorf("failed to encode the provenance tag: %w", err)
+		}
+
+		reader, err := unsigned.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the provenance tag: %w", err)
+		}
+
+		var signature bytes.Buffer
+		if err := openpgp.ArmoredDetachSign(&signature, signKey, reader, nil); err != nil {
+			return nil, fmt.Errorf("failed to sign the provenance tag: %w", err)
+		}
+
+		tag.PGPSignature = signature.String()
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	if err := tag.Encode(obj); err != nil {
+		return nil, fmt.Errorf("failed to encode the provenance tag: %w", err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store the provenance tag: %w", err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.ReferenceName(provenanceRefPrefix+name), hash)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return nil, fmt.Errorf("failed to create the provenance tag: %w", err)
+	}
+
+	return ref, nil
+}
+
+// pushProvenanceTag creates a provenance tag and pushes it to the destination.
+func pushProvenanceTag(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod, repo *git.Repository) error {
+	ref, err := newProvenanceTag(conf, logger, repo, time.Now())
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Pushing the provenance tag", ref.Name(), "...")
+
+	err = withRetries(conf, logger, "Pushing the provenance tag", func() error {
+		return remote.Push(&git.PushOptions{
+			RemoteName: remote.Config().Name,
+			Auth:       auth,
+			RefSpecs: []config.RefSpec{
+				config.RefSpec(ref.Name().String() + ":" + ref.Name().String()),
+			},
+		})
+	})
+
+	invalidateRefsCache(conf, logger)
+
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to push the provenance tag: %w",
+			pushError(conf.Destination.URL, err))
+	}
+
+	return nil
+}
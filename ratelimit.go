@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// rateLimitedReader is an io.ReadCloser throttled to a rate, in bytes per
+// second, averaged from its first read.
+type rateLimitedReader struct {
+	io.ReadCloser
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+// Read implements io.Reader.
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+
+	if int64(len(p)) > r.rate {
+		p = p[:r.rate]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	r.read += int64(n)
+
+	expected := time.Duration(float64(r.read) / float64(r.rate) * float64(time.Second))
+	if wait := expected - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
+
+// rateLimitTransport is a go-git transport throttling the packs fetched and
+// pushed to a rate, in bytes per second. The pushed pack is read by the
+// transport to be sent so throttling its reader throttles the writes too.
+type rateLimitTransport struct {
+	transport.Transport
+	rate int64
+}
+
+// rateLimitUploadPackSession is an upload-pack session throttling the
+// fetched pack.
+type rateLimitUploadPackSession struct {
+	transport.UploadPackSession
+	rate int64
+}
+
+// rateLimitReceivePackSession is a receive-pack session throttling the
+// pushed pack.
+type rateLimitReceivePackSession struct {
+	transport.ReceivePackSession
+	rate int64
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t rateLimitTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := t.Transport.NewUploadPackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return rateLimitUploadPackSession{UploadPackSession: session, rate: t.rate}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t rateLimitTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	session, err := t.Transport.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return rateLimitReceivePackSession{ReceivePackSession: session, rate: t.rate}, nil
+}
+
+// UploadPack implements transport.UploadPackSession. The response is decoded
+// up to the pack so only the pack is left to be throttled.
+func (s rateLimitUploadPackSession) UploadPack(ctx context.Context,
+	req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	limited := packp.NewUploadPackResponseWithPackfile(req,
+		&rateLimitedReader{ReadCloser: resp, rate: s.rate})
+	limited.ShallowUpdate = resp.ShallowUpdate
+	limited.ServerResponse = resp.ServerResponse
+
+	return limited, nil
+}
+
+// ReceivePack implements transport.ReceivePackSession.
+func (s rateLimitReceivePackSession) ReceivePack(ctx context.Context,
+	req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if req.Packfile != nil {
+		req.Packfile = &rateLimitedReader{ReadCloser: req.Packfile, rate: s.rate}
+	}
+
+	return s.ReceivePackSession.ReceivePack(ctx, req)
+}
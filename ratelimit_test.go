@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestRateLimitedReader tests that rateLimitedReader throttles the reads.
+func TestRateLimitedReader(t *testing.T) {
+	t.Parallel()
+
+	reader := &rateLimitedReader{
+		ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, 2000))),
+		rate:       1000,
+	}
+
+	start := time.Now()
+
+	read, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+
+	if len(read) != 2000 {
+		t.Fatalf("unexpected number of bytes read: %d", len(read))
+	}
+
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatalf("reading was not throttled: %s", elapsed)
+	}
+}
+
+// TestDoMirrorRateLimit tests mirroring with a rate limit.
+func TestDoMirrorRateLimit(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		RateLimit:   1 << 20,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
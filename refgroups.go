@@ -0,0 +1,176 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var ErrAtomicPush = errors.New("the destination doesn't support atomic pushes")
+
+// atomicTransport is a go-git transport requesting atomic pushes, for which
+// the destination applies all the reference updates of a push or none. go-git
+// doesn't request them on its own.
+type atomicTransport struct {
+	transport.Transport
+}
+
+// atomicReceivePackSession is a receive-pack session requesting an atomic
+// push. It fails with ErrAtomicPush when the destination doesn't advertise
+// the 'atomic' capability.
+type atomicReceivePackSession struct {
+	transport.ReceivePackSession
+	supported bool
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t atomicTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	session, err := t.Transport.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicReceivePackSession{ReceivePackSession: session}, nil
+}
+
+// AdvertisedReferences implements transport.Session.
+func (s *atomicReceivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.Background())
+}
+
+// AdvertisedReferencesContext implements transport.Session.
+func (s *atomicReceivePackSession) AdvertisedReferencesContext(
+	ctx context.Context) (*packp.AdvRefs, error) {
+	advRefs, err := s.ReceivePackSession.AdvertisedReferencesContext(ctx)
+	if err == nil {
+		s.supported = advRefs.Capabilities.Supports(capability.Atomic)
+	}
+
+	return advRefs, err
+}
+
+// ReceivePack implements transport.ReceivePackSession.
+func (s *atomicReceivePackSession) ReceivePack(ctx context.Context,
+	req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if !s.supported {
+		return nil, ErrAtomicPush
+	}
+
+	if err := req.Capabilities.Set(capability.Atomic); err != nil {
+		return nil, fmt.Errorf("failed to request an atomic push: %w", err)
+	}
+
+	return s.ReceivePackSession.ReceivePack(ctx, req)
+}
+
+// withAtomicPush returns the authentication requesting atomic pushes.
+func withAtomicPush(auth transport.AuthMethod) transport.AuthMethod {
+	run, ok := auth.(runAuth)
+	if !ok {
+		run = runAuth{auth: auth}
+	}
+
+	run.atomic = true
+
+	return run
+}
+
+// refGroup returns the index of the first group with a glob pattern matching
+// the reference name, or -1 when none does.
+func refGroup(groups [][]string, name plumbing.ReferenceName) int {
+	for i, group := range groups {
+		if matchesAny(group, name.String()) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// pushGroups pushes the references grouped by conf.RefGroups, matched by
+// their destination names, each group in an atomic push so that the
+// references of a group are all updated or none is. The references in no
+// group are pushed first, together, as usual. The references already on the
+// destination, as listed in dstRefs, are skipped. It stops at the first
+// failed push.
+func pushGroups(conf Config, logger Logger, remote *git.Remote, auth transport.AuthMethod,
+	repo *git.Repository, specs []config.RefSpec, dstRefs []*plumbing.Reference) error {
+	specs, err := individualSpecs(repo, specs)
+	if err != nil {
+		return err
+	}
+
+	specs, err = pendingSpecs(repo, specs, dstRefs)
+	if err != nil {
+		return err
+	}
+
+	var ungrouped []config.RefSpec
+
+	groups := make([][]config.RefSpec, len(conf.RefGroups))
+
+	for _, spec := range specs {
+		src := plumbing.ReferenceName(spec.Src())
+
+		if i := refGroup(conf.RefGroups, spec.Dst(src)); i >= 0 {
+			groups[i] = append(groups[i], spec)
+		} else {
+			ungrouped = append(ungrouped, spec)
+		}
+	}
+
+	updated := false
+
+	if len(ungrouped) != 0 {
+		logger.Info(fmt.Sprintf("Pushing %d ungrouped reference(s)...", len(ungrouped)))
+
+		err := pushSpecs(conf, logger, remote, auth, ungrouped)
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+		case err != nil:
+			return fmt.Errorf("failed to push the ungrouped references: %w", err)
+		default:
+			updated = true
+		}
+	}
+
+	for i, group := range groups {
+		if len(group) == 0 {
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Pushing %d reference(s) of the group %d atomically...",
+			len(group), i+1))
+
+		for _, spec := range group {
+			logger.Debug(conf.Debug, "Group", i+1, "reference", spec.Dst(""), ".")
+		}
+
+		err := pushSpecs(conf, logger, remote, withAtomicPush(auth), group)
+		switch {
+		case errors.Is(err, git.NoErrAlreadyUpToDate):
+		case err != nil:
+			return fmt.Errorf("failed to push the group %d: %w", i+1, err)
+		default:
+			updated = true
+		}
+	}
+
+	if !updated {
+		return git.NoErrAlreadyUpToDate
+	}
+
+	return nil
+}
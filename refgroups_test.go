@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestWithAtomicPush tests that the atomic pushes are requested by the
+// authentication, keeping the other settings of the run.
+func TestWithAtomicPush(t *testing.T) {
+	t.Parallel()
+
+	basic := &githttp.BasicAuth{Username: "foo", Password: "bar"}
+
+	{
+		// Test that the run settings are kept.
+		auth := withAtomicPush(runAuth{auth: basic, rateLimit: 1000})
+
+		run, ok := auth.(runAuth)
+		if !ok || !run.atomic || run.auth != basic || run.rateLimit != 1000 {
+			t.Fatalf("unexpected atomic push authentication: %v", auth)
+		}
+	}
+	{
+		// Test that the other authentications are wrapped.
+		run, ok := withAtomicPush(basic).(runAuth)
+		if !ok || !run.atomic || run.auth != basic {
+			t.Fatalf("unexpected atomic push authentication: %v", run)
+		}
+	}
+	{
+		// Test that the sessions request atomic pushes.
+		file := mirrorTransport{Transport: githttp.DefaultClient, protocol: "file"}
+
+		current := file.sessionTransport(withAtomicPush(nil).(runAuth))
+		if _, ok := current.(atomicTransport); !ok {
+			t.Fatalf("unexpected session transport: %v", current)
+		}
+	}
+}
+
+// TestDoMirrorRefGroups tests that the references of a group are pushed
+// atomically.
+func TestDoMirrorRefGroups(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// The destination rejects the tag.
+	hookPath := filepath.Join(dstRepoPath, "hooks", "update")
+	if err := os.MkdirAll(filepath.Dir(hookPath), 0o755); err != nil {
+		t.Fatalf("failed to create the hooks directory: %s", err)
+	}
+
+	hook := "#!/bin/sh\ntest \"$1\" != refs/tags/v1\n"
+	if err := os.WriteFile(hookPath, []byte(hook), 0o755); err != nil {
+		t.Fatalf("failed to write the update hook: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		RefGroups:   [][]string{{"refs/heads/a", "refs/tags/*"}},
+	}
+
+	if err := DoMirror(conf, logger); err == nil {
+		t.Fatal("DoMirror with a rejected group reference succeeded")
+	}
+
+	// The branch of the group is not pushed with its rejected tag while the
+	// ungrouped references are.
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/b",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	if err := os.Remove(hookPath); err != nil {
+		t.Fatalf("failed to remove the update hook: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err = utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/heads/b",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var (
+	ErrDstRefLimit = errors.New("the push would exceed the maximum number of " +
+		"destination references")
+	ErrSrcRefMinimum = errors.New("the source has fewer references than the " +
+		"minimum")
+)
+
+// refLimitWarnRatio is the share of conf.MaxDstRefs from which the
+// destination is reported as getting close to the limit.
+const refLimitWarnRatio = 0.9
+
+// countRefs returns the number of references, ignoring HEAD, in 'refs'.
+func countRefs(refs []*plumbing.Reference) int {
+	count := 0
+
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name().String(), "refs/") {
+			count++
+		}
+	}
+
+	return count
+}
+
+// checkDstRefLimit fails with ErrDstRefLimit when pushing the staging
+// references would take the destination over conf.MaxDstRefs references.
+// The destination is pruned after the push so the references it has during
+// the push, the current ones and the new ones, are counted. The count is an
+// estimate when custom push refspecs rename the references.
+func checkDstRefLimit(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod, repo *git.Repository) error {
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	names := make(map[plumbing.ReferenceName]bool, len(refs))
+	for _, ref := range refs {
+		names[ref.Name()] = true
+	}
+
+	pushRefs, err := changedRefs(repo, refs)
+	if err != nil {
+		return fmt.Errorf("failed to get the push references: %w", err)
+	}
+
+	added := 0
+
+	for _, ref := range pushRefs {
+		if !names[ref.Name()] {
+			added++
+		}
+	}
+
+	count := countRefs(refs) + added
+
+	logger.Debug(conf.Debug, "The destination would have", count,
+		"reference(s) of at most", conf.MaxDstRefs, ".")
+
+	if count > conf.MaxDstRefs {
+		return fmt.Errorf("%w: %d of at most %d (%d new)", ErrDstRefLimit, count,
+			conf.MaxDstRefs, added)
+	}
+
+	if float64(count) >= refLimitWarnRatio*float64(conf.MaxDstRefs) {
+		logger.Warn("The destination is close to the maximum number of "+
+			"references:", count, "of at most", conf.MaxDstRefs, ".")
+	}
+
+	return nil
+}
+
+// checkMinRefs fails with ErrSrcRefMinimum when the staging repository, i.e.
+// the filtered source, has less than conf.MinRefs references. This guards the
+// destination against being pruned down to almost nothing when the source is
+// (e.g. temporarily) empty.
+func checkMinRefs(conf Config, logger Logger, repo *git.Repository) error {
+	iter, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	var refs []*plumbing.Reference
+
+	_ = iter.ForEach(func(ref *plumbing.Reference) error {
+		refs = append(refs, ref)
+
+		return nil
+	})
+
+	count := countRefs(refs)
+
+	logger.Debug(conf.Debug, "The source has", count, "reference(s) of at least",
+		conf.MinRefs, ".")
+
+	if count < conf.MinRefs {
+		return fmt.Errorf("%w: %d of at least %d", ErrSrcRefMinimum, count, conf.MinRefs)
+	}
+
+	return nil
+}
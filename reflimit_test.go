@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorMaxDstRefs tests that a mirror exceeding the maximum number of
+// destination references is aborted before pushing.
+func TestDoMirrorMaxDstRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+		"refs/tags/v2",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		MaxDstRefs:  3,
+	}
+
+	// The source has 4 references, with master.
+	if err := DoMirror(conf, logger); !errors.Is(err, ErrDstRefLimit) {
+		t.Fatalf("unexpected error exceeding the limit: %v", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if len(dstRepoRefs) > 1 {
+		t.Fatalf("the dst repo was pushed to over the limit: %s", dstRepoRefs)
+	}
+
+	conf.MaxDstRefs = 4
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed within the limit: %s", err)
+	}
+
+	dstRepoRefs, err = utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/tags/v1",
+		"refs/tags/v2",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
+
+// TestDoMirrorMinRefs tests that a mirror of a source with fewer references
+// than the minimum is aborted before pushing.
+func TestDoMirrorMinRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+		"refs/tags/v1",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// The source has 3 references, with master, and 2 after the filters.
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		ExcludeRefs: []string{"refs/tags/*"},
+		MinRefs:     3,
+	}
+
+	if err := DoMirror(conf, logger); !errors.Is(err, ErrSrcRefMinimum) {
+		t.Fatalf("unexpected error below the minimum: %v", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if len(dstRepoRefs) > 1 {
+		t.Fatalf("the dst repo was pushed to below the minimum: %s", dstRepoRefs)
+	}
+
+	conf.ExcludeRefs = nil
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed with the minimum: %s", err)
+	}
+
+	dstRepoRefs, err = utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+		"refs/tags/v1",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// The ways of handling the source references with an invalid name.
+const (
+	// sanitizeRefNamesDrop doesn't mirror them.
+	sanitizeRefNamesDrop = "drop"
+	// sanitizeRefNamesRename mirrors them under a sanitized name.
+	sanitizeRefNamesRename = "rename"
+)
+
+// defaultRefNameReplacement replaces the invalid parts of the renamed
+// reference names.
+const defaultRefNameReplacement = "_"
+
+// invalidRefNameRunes are the characters git doesn't allow in a reference
+// name, other than the control ones.
+const invalidRefNameRunes = " ~^:?*[\\"
+
+// invalidRefNameRune checks if a character is not allowed in a reference name:
+// the characters git doesn't allow and the non-printable ones.
+func invalidRefNameRune(r rune) bool {
+	return r == utf8.RuneError || strings.ContainsRune(invalidRefNameRunes, r) ||
+		!unicode.IsPrint(r)
+}
+
+// validRefName checks if a reference name is valid, following the git rules
+// (see git-check-ref-format), and only has printable characters.
+func validRefName(name string) bool {
+	if name == "@" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") ||
+		strings.HasSuffix(name, ".") || strings.Contains(name, "..") ||
+		strings.Contains(name, "//") || strings.Contains(name, "@{") ||
+		!utf8.ValidString(name) || strings.IndexFunc(name, invalidRefNameRune) != -1 {
+		return false
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sanitizeRefName returns a valid reference name for an invalid one: the empty
+// components are removed and the invalid characters and sequences are replaced
+// by 'replacement'.
+func sanitizeRefName(name, replacement string) string {
+	var components []string
+
+	for _, component := range strings.Split(name, "/") {
+		if len(component) == 0 {
+			continue
+		}
+
+		var builder strings.Builder
+
+		// The bytes of an invalid UTF-8 sequence are ranged over as
+		// utf8.RuneError.
+		for _, r := range component {
+			if invalidRefNameRune(r) {
+				builder.WriteString(replacement)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+
+		component = builder.String()
+		component = strings.ReplaceAll(component, "@{", replacement)
+		component = strings.ReplaceAll(component, "..", replacement)
+
+		if strings.HasPrefix(component, ".") {
+			component = replacement + component[1:]
+		}
+
+		if strings.HasSuffix(component, ".lock") {
+			component = strings.TrimSuffix(component, ".lock") + replacement + "lock"
+		}
+
+		if len(component) == 0 || component == "@" {
+			component = replacement
+		}
+
+		components = append(components, component)
+	}
+
+	sanitized := strings.Join(components, "/")
+	if strings.HasSuffix(sanitized, ".") {
+		sanitized = strings.TrimSuffix(sanitized, ".") + replacement
+	}
+
+	return sanitized
+}
+
+// sanitizeRefs handles the references of the staging repository with an
+// invalid name as configured by conf.SanitizeRefNames, instead of letting the
+// destination reject the push: they are dropped or renamed, with a warning. A
+// reference is dropped when its sanitized name is already used.
+func sanitizeRefs(conf Config, logger Logger, repo *git.Repository) error {
+	if len(conf.SanitizeRefNames) == 0 {
+		return nil
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	var (
+		invalid []*plumbing.Reference
+		names   = make(map[plumbing.ReferenceName]bool)
+	)
+
+	_ = iter.ForEach(func(ref *plumbing.Reference) error {
+		names[ref.Name()] = true
+
+		if ref.Name() != plumbing.HEAD && !validRefName(ref.Name().String()) {
+			invalid = append(invalid, ref)
+		}
+
+		return nil
+	})
+
+	replacement := conf.RefNameReplacement
+	if len(replacement) == 0 {
+		replacement = defaultRefNameReplacement
+	}
+
+	for _, ref := range invalid {
+		if err := repo.Storer.RemoveReference(ref.Name()); err != nil {
+			return fmt.Errorf("failed to remove reference: %w", err)
+		}
+
+		name := strconv.Quote(ref.Name().String())
+
+		if conf.SanitizeRefNames == sanitizeRefNamesDrop {
+			logger.Warn("Dropping the reference", name, "with an invalid name.")
+
+			continue
+		}
+
+		sanitized := plumbing.ReferenceName(sanitizeRefName(ref.Name().String(), replacement))
+		if names[sanitized] {
+			logger.Warn("Dropping the reference", name, "with an invalid name as",
+				sanitized, "already exists.")
+
+			continue
+		}
+
+		logger.Warn("Renaming the reference", name, "with an invalid name to",
+			sanitized.String()+".")
+
+		names[sanitized] = true
+
+		var renamed *plumbing.Reference
+		if ref.Type() == plumbing.SymbolicReference {
+			renamed = plumbing.NewSymbolicReference(sanitized, ref.Target())
+		} else {
+			renamed = plumbing.NewHashReference(sanitized, ref.Hash())
+		}
+
+		if err := repo.Storer.SetReference(renamed); err != nil {
+			return fmt.Errorf("failed to set reference: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestValidRefName tests validRefName function.
+func TestValidRefName(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{
+		"refs/heads/main",
+		"refs/heads/feature/a.b",
+		"refs/tags/v1.0",
+		"refs/heads/ünïcode",
+	} {
+		if !validRefName(name) {
+			t.Fatalf("valid name %q failed", name)
+		}
+	}
+
+	for _, name := range []string{
+		"@",
+		"refs/heads/trailing.",
+		"refs/heads/a..b",
+		"refs/heads/.hidden",
+		"refs/heads/a.lock",
+		"refs/heads/a//b",
+		"refs/heads/a/",
+		"refs/heads/a@{b",
+		"refs/heads/sp ace",
+		"refs/heads/a~1",
+		"refs/heads/a:b",
+		"refs/heads/ctl\x01",
+		"refs/heads/zero\u200bwidth",
+		"refs/heads/bad\xffutf8",
+	} {
+		if validRefName(name) {
+			t.Fatalf("invalid name %q passed", name)
+		}
+	}
+}
+
+// TestSanitizeRefName tests sanitizeRefName function.
+func TestSanitizeRefName(t *testing.T) {
+	t.Parallel()
+
+	for name, expected := range map[string]string{
+		"refs/heads/trailing.":       "refs/heads/trailing_",
+		"refs/heads/fix..typo.":      "refs/heads/fix_typo_",
+		"refs/heads/.hidden":         "refs/heads/_hidden",
+		"refs/heads/a.lock":          "refs/heads/a_lock",
+		"refs/heads/a//b/":           "refs/heads/a/b",
+		"refs/heads/a@{b":            "refs/heads/a_b",
+		"refs/heads/sp ace":          "refs/heads/sp_ace",
+		"refs/heads/ctl\x01":         "refs/heads/ctl_",
+		"refs/heads/zero\u200bwidth": "refs/heads/zero_width",
+		"refs/heads/bad\xffutf8":     "refs/heads/bad_utf8",
+		"refs/heads/@":               "refs/heads/_",
+	} {
+		sanitized := sanitizeRefName(name, "_")
+		if sanitized != expected || !validRefName(sanitized) {
+			t.Fatalf("unexpected sanitized name for %q: %q", name, sanitized)
+		}
+	}
+}
+
+// TestSanitizeRefs tests handling the staging references with an invalid
+// name.
+func TestSanitizeRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	hash := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	for mode, expected := range map[string][]string{
+		"": {
+			"HEAD", "refs/heads/a", "refs/heads/b.", "refs/heads/sp ace",
+			"refs/heads/sp_ace",
+		},
+		sanitizeRefNamesDrop: {"HEAD", "refs/heads/a", "refs/heads/sp_ace"},
+		// 'refs/heads/sp ace' is dropped as 'refs/heads/sp_ace' exists.
+		sanitizeRefNamesRename: {
+			"HEAD", "refs/heads/a", "refs/heads/b_", "refs/heads/sp_ace",
+		},
+	} {
+		repo, err := git.Init(memory.NewStorage(), nil)
+		if err != nil {
+			t.Fatalf("failed to create a test repo: %s", err)
+		}
+
+		for _, name := range []string{
+			"refs/heads/a", "refs/heads/b.", "refs/heads/sp ace", "refs/heads/sp_ace",
+		} {
+			err := repo.Storer.SetReference(
+				plumbing.NewHashReference(plumbing.ReferenceName(name), hash))
+			if err != nil {
+				t.Fatalf("failed to set a reference: %s", err)
+			}
+		}
+
+		if err := sanitizeRefs(Config{SanitizeRefNames: mode}, logger, repo); err != nil {
+			t.Fatalf("sanitizeRefs failed (%s): %s", mode, err)
+		}
+
+		repoRefs, err := utils.RepoRefsSlice(repo)
+		if err != nil {
+			t.Fatalf("failed to get the repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(repoRefs, expected) {
+			t.Fatalf("unexpected refs (%s): %s", mode, repoRefs)
+		}
+
+		ref, err := repo.Reference("refs/heads/a", false)
+		if err != nil || ref.Hash() != hash {
+			t.Fatalf("unexpected reference (%s): %v", mode, err)
+		}
+	}
+}
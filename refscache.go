@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var errRefsCacheMismatch = errors.New("the references cache is for another " +
+	"destination")
+
+const refsCachePerm = 0o600
+
+// loadRefsCache reads the references cached for a destination URL. The first
+// line of the cache is the destination URL, each of the following lines is a
+// reference name and its target separated by a space.
+func loadRefsCache(path, url string) ([]*plumbing.Reference, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+
+	if !scanner.Scan() || scanner.Text() != url {
+		return nil, errRefsCacheMismatch
+	}
+
+	var refs []*plumbing.Reference
+
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid references cache line: %q",
+				scanner.Text())
+		}
+
+		refs = append(refs, plumbing.NewReferenceFromStrings(fields[0], fields[1]))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return refs, nil
+}
+
+// saveRefsCache writes the references of a destination URL to the cache.
+func saveRefsCache(path, url string, refs []*plumbing.Reference) error {
+	var content strings.Builder
+
+	content.WriteString(url + "\n")
+
+	for _, ref := range refs {
+		fields := ref.Strings()
+		content.WriteString(fields[0] + " " + fields[1] + "\n")
+	}
+
+	return os.WriteFile(path, []byte(content.String()), refsCachePerm)
+}
+
+// invalidateRefsCache removes the destination references cache, if any, after
+// the destination was changed.
+func invalidateRefsCache(conf Config, logger Logger) {
+	if len(conf.DstRefsCache) == 0 {
+		return
+	}
+
+	if err := os.Remove(conf.DstRefsCache); err != nil && !os.IsNotExist(err) {
+		logger.Warn("Failed to remove the destination references cache:", err)
+	}
+}
+
+// listDstRefs lists the references of the destination remote, retried
+// conf.ListRetries times when it fails. When conf.DstRefsCache is set, the
+// cached references are used instead, if any, and the listed ones are cached
+// otherwise.
+func listDstRefs(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod) ([]*plumbing.Reference, error) {
+	if len(conf.DstRefsCache) != 0 {
+		refs, err := loadRefsCache(conf.DstRefsCache, conf.Destination.URL)
+		if err == nil {
+			logger.Debug(conf.Debug, "Using the cached destination references.")
+
+			return refs, nil
+		}
+
+		if !os.IsNotExist(err) {
+			logger.Warn("Ignoring the destination references cache:", err)
+		}
+	}
+
+	var refs []*plumbing.Reference
+
+	err := retryOperation(conf, logger, conf.ListRetries, "Listing the destination",
+		func() error {
+			var err error
+
+			refs, err = listRemote(conf, remote, auth)
+
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(conf.DstRefsCache) != 0 {
+		if err := saveRefsCache(conf.DstRefsCache, conf.Destination.URL, refs); err != nil {
+			logger.Warn("Failed to write the destination references cache:", err)
+		}
+	}
+
+	return refs, nil
+}
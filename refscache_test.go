@@ -0,0 +1,191 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestRefsCache tests saveRefsCache and loadRefsCache functions.
+func TestRefsCache(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "refs")
+
+	refs := []*plumbing.Reference{
+		plumbing.NewSymbolicReference("HEAD", "refs/heads/main"),
+		plumbing.NewHashReference("refs/heads/main",
+			plumbing.NewHash("0123456789abcdef0123456789abcdef01234567")),
+	}
+
+	if err := saveRefsCache(path, "dst", refs); err != nil {
+		t.Fatalf("saveRefsCache failed: %s", err)
+	}
+
+	cached, err := loadRefsCache(path, "dst")
+	if err != nil {
+		t.Fatalf("loadRefsCache failed: %s", err)
+	}
+
+	if len(cached) != len(refs) {
+		t.Fatalf("unexpected cached refs: %v", cached)
+	}
+
+	for i := range refs {
+		if !cmp.Equal(cached[i].Strings(), refs[i].Strings()) {
+			t.Fatalf("unexpected cached ref: %s", cached[i])
+		}
+	}
+
+	// The cache is only valid for the same destination.
+	if _, err := loadRefsCache(path, "other"); !errors.Is(err, errRefsCacheMismatch) {
+		t.Fatalf("unexpected error for another destination: %v", err)
+	}
+}
+
+// TestDoMirrorRefsCache tests the destination references cache of DoMirror.
+func TestDoMirrorRefsCache(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+		"refs/heads/c",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:       RepoConf{URL: srcRepoPath},
+		Destination:  RepoConf{URL: dstRepoPath},
+		DstRefsCache: filepath.Join(t.TempDir(), "refs"),
+	}
+
+	// The destination was changed so there is no cache.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if _, err := os.Stat(conf.DstRefsCache); !os.IsNotExist(err) {
+		t.Fatalf("the cache was kept after changing the destination: %v", err)
+	}
+
+	// The destination is unchanged so it is cached.
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if _, err := os.Stat(conf.DstRefsCache); err != nil {
+		t.Fatalf("the destination references were not cached: %s", err)
+	}
+
+	// The cache hides the references created by others.
+	ref, err := dstRepo.Head()
+	if err != nil {
+		t.Fatalf("failed to get the dst repo HEAD: %s", err)
+	}
+
+	err = dstRepo.Storer.SetReference(plumbing.NewHashReference("refs/heads/d",
+		ref.Hash()))
+	if err != nil {
+		t.Fatalf("failed to create a dst repo reference: %s", err)
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	if _, err := dstRepo.Reference("refs/heads/d", false); err != nil {
+		t.Fatalf("the cached destination references were not used: %s", err)
+	}
+}
+
+// TestListDstRefsRetries tests retrying a failed listing of the destination
+// references.
+func TestListDstRefsRetries(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	hash := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	// The server fails the first two reference advertisements.
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		advRefs := packp.NewAdvRefs()
+		advRefs.Prefix = [][]byte{[]byte("# service=git-upload-pack"), pktline.Flush}
+		_ = advRefs.AddReference(plumbing.NewHashReference("refs/heads/main", hash))
+
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		_ = advRefs.Encode(w)
+	}))
+	defer server.Close()
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: dstRemoteName,
+		URLs: []string{server.URL + "/dst.git"},
+	})
+
+	conf := Config{ListRetries: 1, RetryBackoff: time.Millisecond}
+
+	if _, err := listDstRefs(conf, logger, remote, nil); err == nil {
+		t.Fatal("listing passed with less retries than failures")
+	}
+
+	refs, err := listDstRefs(conf, logger, remote, nil)
+	if err != nil {
+		t.Fatalf("listing failed with retries: %s", err)
+	}
+
+	if len(refs) != 1 || refs[0].Hash() != hash {
+		t.Fatalf("unexpected refs: %v", refs)
+	}
+
+	if requests := atomic.LoadInt32(&requests); requests != 3 {
+		t.Fatalf("unexpected number of requests: %d", requests)
+	}
+}
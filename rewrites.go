@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+var ErrRewrite = errors.New("the source history was rewritten")
+
+// rewrite is a destination reference a mirror would update to a value that
+// is not a descendant of its current one.
+type rewrite struct {
+	name plumbing.ReferenceName
+	from plumbing.Hash
+	to   plumbing.Hash
+}
+
+// rewrittenRefs returns the references of the repository that would rewrite
+// the history of the existing remote references, i.e. whose update is not a
+// fast-forward. Only the commit updates are checked. The references matching
+// conf.ForceRefs are expected to be rewritten so they are not returned.
+func rewrittenRefs(conf Config, repo *git.Repository,
+	refs []*plumbing.Reference) ([]rewrite, error) {
+	changed, err := changedRefs(repo, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[plumbing.ReferenceName]plumbing.Hash, len(refs))
+	for _, ref := range refs {
+		hashes[ref.Name()] = ref.Hash()
+	}
+
+	var rewrites []rewrite
+
+	for _, ref := range changed {
+		hash, found := hashes[ref.Name()]
+		if !found || matchesAny(conf.ForceRefs, ref.Name().String()) {
+			continue
+		}
+
+		if _, err := repo.CommitObject(ref.Hash()); err != nil ||
+			isFastForward(repo, hash, ref.Hash()) {
+			continue
+		}
+
+		rewrites = append(rewrites, rewrite{name: ref.Name(), from: hash, to: ref.Hash()})
+	}
+
+	return rewrites, nil
+}
+
+// detectRewrites reports the destination references a mirror would update to
+// a value that is not a descendant of their current one, which usually means
+// that the source history was rewritten. With conf.FailOnRewrites, it fails
+// with ErrRewrite before anything is pushed.
+func detectRewrites(conf Config, logger Logger, remote *git.Remote,
+	auth transport.AuthMethod, repo *git.Repository) error {
+	refs, err := listDstRefs(conf, logger, remote, auth)
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to list the destination remote: %w", err)
+	}
+
+	rewrites, err := rewrittenRefs(conf, repo, refs)
+	if err != nil {
+		return err
+	}
+
+	for _, rewrite := range rewrites {
+		logger.Warn("History rewrite:", rewrite.name, "would be updated from",
+			shortHash(rewrite.from), "to", shortHash(rewrite.to),
+			"which is not a descendant.")
+	}
+
+	if len(rewrites) != 0 && conf.FailOnRewrites {
+		return fmt.Errorf("%w: %d reference(s) not fast-forward", ErrRewrite,
+			len(rewrites))
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestRewrittenRefs tests the rewrittenRefs function.
+func TestRewrittenRefs(t *testing.T) {
+	t.Parallel()
+
+	repo, head, err := utils.NewTestRepo(t.TempDir(), []string{
+		"refs/heads/a",
+		"refs/heads/b",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test repo: %s", err)
+	}
+
+	unknown := plumbing.NewHash("0123456789012345678901234567890123456789")
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference("refs/heads/master", unknown),
+		plumbing.NewHashReference("refs/heads/a", head),
+		plumbing.NewHashReference("refs/heads/b", unknown),
+		plumbing.NewHashReference("refs/heads/c", unknown),
+	}
+
+	rewrites, err := rewrittenRefs(Config{ForceRefs: []string{"refs/heads/b"}}, repo, refs)
+	if err != nil {
+		t.Fatalf("rewrittenRefs failed: %s", err)
+	}
+
+	if len(rewrites) != 1 || rewrites[0] != (rewrite{
+		name: "refs/heads/master",
+		from: unknown,
+		to:   head,
+	}) {
+		t.Fatalf("unexpected rewrites: %v", rewrites)
+	}
+}
+
+// TestDoMirrorDetectRewrites tests detecting the rewrites of the destination
+// history.
+func TestDoMirrorDetectRewrites(t *testing.T) {
+	t.Parallel()
+
+	srcRepoPath := t.TempDir()
+
+	if _, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	}); err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, dstHead, err := utils.NewTestRepoWithContent(dstRepoPath, []string{
+		"refs/heads/a",
+	}, "dst")
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	// go-git can't list a repository with a detached HEAD no branch points
+	// to, as the test repositories have once mirrored to.
+	err = dstRepo.Storer.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/master"))
+	if err != nil {
+		t.Fatalf("failed to set the dst repo HEAD: %s", err)
+	}
+
+	conf := Config{
+		Source:         RepoConf{URL: srcRepoPath},
+		Destination:    RepoConf{URL: dstRepoPath},
+		FailOnRewrites: true,
+	}
+
+	{
+		// Test that the mirror fails before pushing.
+		var logs bytes.Buffer
+		if err := DoMirror(conf, NewLogger(&logs)); !errors.Is(err, ErrRewrite) {
+			t.Fatalf("unexpected error for a rewrite: %v", err)
+		}
+
+		if !strings.Contains(logs.String(), "History rewrite: refs/heads/a") {
+			t.Fatalf("the rewrite was not reported: %s", logs.String())
+		}
+
+		ok, err := utils.RepoRefsCheckHash(dstRepo, dstHead, "refs/heads/")
+		if err != nil || !ok {
+			t.Fatalf("the dst repo was changed (%v)", err)
+		}
+	}
+	{
+		// Test that the rewrites are only reported.
+		conf.DetectRewrites, conf.FailOnRewrites = true, false
+
+		var logs bytes.Buffer
+		if err := DoMirror(conf, NewLogger(&logs)); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		if !strings.Contains(logs.String(), "History rewrite: refs/heads/master") {
+			t.Fatalf("the rewrite was not reported: %s", logs.String())
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var ErrTagSemverRange = errors.New("invalid tag semver range")
+
+// semver is a semantic version (https://semver.org). The build metadata is
+// ignored as it doesn't change the precedence.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+// parseSemver parses a 'MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD]' semantic
+// version, with an optional 'v' prefix as commonly used by the tags.
+func parseSemver(value string) (semver, bool) {
+	value = strings.TrimPrefix(value, "v")
+
+	if plus := strings.IndexByte(value, '+'); plus != -1 {
+		value = value[:plus]
+	}
+
+	var version semver
+
+	if dash := strings.IndexByte(value, '-'); dash != -1 {
+		version.pre = strings.Split(value[dash+1:], ".")
+		value = value[:dash]
+
+		for _, identifier := range version.pre {
+			if len(identifier) == 0 {
+				return semver{}, false
+			}
+		}
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return semver{}, false
+	}
+
+	numbers := make([]int, 0, len(parts))
+
+	for _, part := range parts {
+		// No sign and no leading zero.
+		number, err := strconv.Atoi(part)
+		if err != nil || strings.TrimLeft(part, "0123456789") != "" ||
+			(len(part) > 1 && part[0] == '0') {
+			return semver{}, false
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	version.major, version.minor, version.patch = numbers[0], numbers[1], numbers[2]
+
+	return version, true
+}
+
+// compareInts returns -1, 0 or 1 as a is less than, equal to or greater than b.
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// comparePrerelease compares two pre-release identifiers: the numeric ones
+// numerically, below the alphanumeric ones compared lexically.
+func comparePrerelease(a, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+// compare returns -1, 0 or 1 as the version has a lower, the same or a
+// higher precedence than the other one. A pre-release version has a lower
+// precedence than its release.
+func (v semver) compare(other semver) int {
+	if c := compareInts(v.major, other.major); c != 0 {
+		return c
+	}
+
+	if c := compareInts(v.minor, other.minor); c != 0 {
+		return c
+	}
+
+	if c := compareInts(v.patch, other.patch); c != 0 {
+		return c
+	}
+
+	switch {
+	case len(v.pre) == 0 && len(other.pre) == 0:
+		return 0
+	case len(v.pre) == 0:
+		return 1
+	case len(other.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.pre) && i < len(other.pre); i++ {
+		if c := comparePrerelease(v.pre[i], other.pre[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareInts(len(v.pre), len(other.pre))
+}
+
+// semverComparator is a version compared to with an operator.
+type semverComparator struct {
+	operator string
+	version  semver
+}
+
+// matches checks if a version satisfies the comparator.
+func (c semverComparator) matches(version semver) bool {
+	result := version.compare(c.version)
+
+	switch c.operator {
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case "!=":
+		return result != 0
+	default:
+		return result == 0
+	}
+}
+
+// semverRange is a set of alternatives, each satisfied by the versions
+// satisfying all its comparators.
+type semverRange [][]semverComparator
+
+// parseComparators parses a comparator, expanding the '^' (same major, or
+// minor for 0.x versions, or patch for 0.0.x versions) and '~' (same minor)
+// shortcuts in two.
+func parseComparators(value string) ([]semverComparator, error) {
+	operator := ""
+
+	for _, candidate := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(value, candidate) {
+			operator = candidate
+			value = strings.TrimSpace(value[len(candidate):])
+
+			break
+		}
+	}
+
+	version, ok := parseSemver(value)
+	if !ok {
+		return nil, fmt.Errorf("%w: invalid version %q", ErrTagSemverRange, value)
+	}
+
+	switch operator {
+	case "^":
+		upper := semver{major: version.major + 1}
+
+		switch {
+		case version.major == 0 && version.minor == 0:
+			upper = semver{patch: version.patch + 1}
+		case version.major == 0:
+			upper = semver{minor: version.minor + 1}
+		}
+
+		return []semverComparator{{">=", version}, {"<", upper}}, nil
+	case "~":
+		upper := semver{major: version.major, minor: version.minor + 1}
+
+		return []semverComparator{{">=", version}, {"<", upper}}, nil
+	case "":
+		operator = "="
+	}
+
+	return []semverComparator{{operator, version}}, nil
+}
+
+// parseSemverRange parses a semantic version range: comparators (e.g.
+// '>=2.0.0 <3.0.0' or '>=2.0.0, <3.0.0') all satisfied, with '||' separating
+// alternatives. The comparators use the '>', '>=', '<', '<=', '=' (default)
+// and '!=' operators, and the '^' and '~' shortcuts. The pre-releases only
+// satisfy the alternatives with a pre-release of the same version.
+func parseSemverRange(value string) (semverRange, error) {
+	var semRange semverRange
+
+	for _, alternative := range strings.Split(value, "||") {
+		fields := strings.Fields(strings.ReplaceAll(alternative, ",", " "))
+
+		// Join the operators separated from their versions (e.g. '>= 2.0.0').
+		var comparators []semverComparator
+
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			if strings.TrimLeft(field, "<>=!^~") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+
+			parsed, err := parseComparators(field)
+			if err != nil {
+				return nil, err
+			}
+
+			comparators = append(comparators, parsed...)
+		}
+
+		if len(comparators) == 0 {
+			return nil, fmt.Errorf("%w: empty alternative in %q", ErrTagSemverRange, value)
+		}
+
+		semRange = append(semRange, comparators)
+	}
+
+	return semRange, nil
+}
+
+// allowsPrerelease checks if a version can satisfy the comparators of an
+// alternative: a pre-release only can when one of the comparators has a
+// pre-release of the same version (e.g. '>=2.1.0-rc.1' for '2.1.0-rc.2' but
+// not for '2.2.0-rc.1'), as npm does.
+func allowsPrerelease(comparators []semverComparator, version semver) bool {
+	if len(version.pre) == 0 {
+		return true
+	}
+
+	for _, comparator := range comparators {
+		if len(comparator.version.pre) != 0 &&
+			comparator.version.major == version.major &&
+			comparator.version.minor == version.minor &&
+			comparator.version.patch == version.patch {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches checks if a version satisfies the range.
+func (r semverRange) matches(version semver) bool {
+	for _, comparators := range r {
+		matched := allowsPrerelease(comparators, version)
+
+		for _, comparator := range comparators {
+			if !comparator.matches(version) {
+				matched = false
+
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterSemverTags removes the tags of a repository whose name is not a
+// semantic version in the range. The tags whose name is not a semantic
+// version are kept only with 'keepNonSemver'.
+func filterSemverTags(repo *git.Repository, semRange semverRange, keepNonSemver bool) error {
+	return filterRefsFunc(repo, func(ref *plumbing.Reference) bool {
+		if !ref.Name().IsTag() {
+			return true
+		}
+
+		version, ok := parseSemver(ref.Name().Short())
+		if !ok {
+			return keepNonSemver
+		}
+
+		return semRange.matches(version)
+	})
+}
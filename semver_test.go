@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestParseSemver tests the parseSemver function.
+func TestParseSemver(t *testing.T) {
+	t.Parallel()
+
+	for _, valid := range []string{
+		"1.2.3", "v1.2.3", "0.0.0", "1.2.3-rc.1", "1.2.3+build.5", "10.20.30-alpha-1",
+	} {
+		if _, ok := parseSemver(valid); !ok {
+			t.Fatalf("valid version %q failed", valid)
+		}
+	}
+
+	for _, invalid := range []string{
+		"1.2", "1.2.3.4", "01.2.3", "1.-2.3", "1.2.3-", "1.2.3-rc..1", "a.b.c", "latest",
+	} {
+		if _, ok := parseSemver(invalid); ok {
+			t.Fatalf("invalid version %q passed", invalid)
+		}
+	}
+
+	// The precedence example of the specification, in increasing order.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+	}
+	for i := 1; i < len(ordered); i++ {
+		lower, _ := parseSemver(ordered[i-1])
+		higher, _ := parseSemver(ordered[i])
+
+		if lower.compare(higher) != -1 || higher.compare(lower) != 1 {
+			t.Fatalf("%s is not lower than %s", ordered[i-1], ordered[i])
+		}
+	}
+}
+
+// TestParseSemverRange tests the parseSemverRange function.
+func TestParseSemverRange(t *testing.T) {
+	t.Parallel()
+
+	for semRange, expected := range map[string]map[string]bool{
+		">=2.0.0": {
+			"2.0.0": true, "2.5.1": true, "1.9.9": false, "2.0.0-rc.1": false,
+		},
+		">= 2.0.0, <3.0.0": {
+			"2.0.0": true, "3.0.0": false, "1.0.0": false,
+		},
+		"^1.4.0 || ^0.3.1": {
+			"1.4.0": true, "1.9.0": true, "2.0.0": false, "0.3.5": true, "0.4.0": false,
+			"1.5.0-rc.1": false,
+		},
+		"^0.0.3": {
+			"0.0.3": true, "0.0.4": false, "0.1.0": false,
+		},
+		">=2.1.0-rc.1 <3.0.0": {
+			"2.1.0-rc.1": true, "2.1.0-rc.2": true, "2.1.0": true, "2.2.0-rc.1": false,
+			"2.1.0-beta.1": false,
+		},
+		"^2.0.0": {
+			"2.1.0": true, "2.1.0-rc1": false, "3.0.0-rc.1": false,
+		},
+		"~1.4.0": {
+			"1.4.9": true, "1.5.0": false,
+		},
+		"1.2.3": {
+			"1.2.3": true, "1.2.4": false,
+		},
+		"!=1.2.3": {
+			"1.2.3": false, "1.2.4": true,
+		},
+	} {
+		parsed, err := parseSemverRange(semRange)
+		if err != nil {
+			t.Fatalf("parsing %q failed: %s", semRange, err)
+		}
+
+		for value, matches := range expected {
+			version, _ := parseSemver(value)
+			if parsed.matches(version) != matches {
+				t.Fatalf("unexpected match of %s by %q", value, semRange)
+			}
+		}
+	}
+
+	for _, invalid := range []string{">=2.0", "", "1.0.0 ||", "=>1.0.0"} {
+		if _, err := parseSemverRange(invalid); !errors.Is(err, ErrTagSemverRange) {
+			t.Fatalf("invalid range %q passed", invalid)
+		}
+	}
+}
+
+// TestFilterSemverTags tests the filterSemverTags function.
+func TestFilterSemverTags(t *testing.T) {
+	t.Parallel()
+
+	refs := []string{
+		"refs/heads/a",
+		"refs/tags/v1.0.0",
+		"refs/tags/v2.0.0",
+		"refs/tags/2.1.0",
+		"refs/tags/v2.2.0-rc.1",
+		"refs/tags/nightly",
+	}
+
+	semRange, err := parseSemverRange(">=2.0.0")
+	if err != nil {
+		t.Fatalf("failed to parse the range: %s", err)
+	}
+
+	for keepNonSemver, expected := range map[bool][]string{
+		false: {"HEAD", "refs/heads/master", "refs/heads/a", "refs/tags/v2.0.0",
+			"refs/tags/2.1.0"},
+		true: {"HEAD", "refs/heads/master", "refs/heads/a", "refs/tags/v2.0.0",
+			"refs/tags/2.1.0", "refs/tags/nightly"},
+	} {
+		repo, _, err := utils.NewTestRepo(t.TempDir(), refs)
+		if err != nil {
+			t.Fatalf("failed to create a test repo: %s", err)
+		}
+
+		if err := filterSemverTags(repo, semRange, keepNonSemver); err != nil {
+			t.Fatalf("filterSemverTags failed: %s", err)
+		}
+
+		repoRefs, err := utils.RepoRefsSlice(repo)
+		if err != nil {
+			t.Fatalf("failed to get the repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(repoRefs, expected) {
+			t.Fatalf("unexpected refs (keep non-semver: %t): %s", keepNonSemver, repoRefs)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build !plan9
+
+package mirror
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminationSignals are the signals handled by default by
+// RemoveTempFilesOnSignal.
+var terminationSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// signalNumber returns the number of a signal, if it has one.
+func signalNumber(sig os.Signal) (int, bool) {
+	num, ok := sig.(syscall.Signal)
+
+	return int(num), ok
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+)
+
+// terminationSignals are the signals handled by default by
+// RemoveTempFilesOnSignal.
+var terminationSignals = []os.Signal{os.Interrupt, os.Kill}
+
+// signalNumber returns the number of a signal, if it has one. The Plan 9
+// notes have none.
+func signalNumber(sig os.Signal) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build go1.21
+
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// SlogLogger is a Logger backed by a slog.Logger. The messages are logged
+// using the slog.Logger's handler and attributes.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns a new SlogLogger that will use the passed slog.Logger.
+// When nil, slog.Default() is used.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogLogger{logger: logger}
+}
+
+// WithRunID returns a SlogLogger recording the run ID as the 'run' attribute
+// of every message.
+func (l SlogLogger) WithRunID(runID string) Logger {
+	return &SlogLogger{logger: l.logger.With("run", runID)}
+}
+
+// slogMessage formats the message arguments the same way StdLogger does.
+func slogMessage(v ...any) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
+// Debug is logging a message at the debug level when debug mode is enabled.
+// The message is still subject to the handler's level.
+func (l SlogLogger) Debug(debugMode bool, v ...any) {
+	if debugMode {
+		l.logger.Log(context.Background(), slog.LevelDebug, slogMessage(v...))
+	}
+}
+
+// Info is logging a message at the info level.
+func (l SlogLogger) Info(v ...any) {
+	l.logger.Log(context.Background(), slog.LevelInfo, slogMessage(v...))
+}
+
+// Warn is logging a message at the warn level.
+func (l SlogLogger) Warn(v ...any) {
+	l.logger.Log(context.Background(), slog.LevelWarn, slogMessage(v...))
+}
+
+// Error is logging a message at the error level.
+func (l SlogLogger) Error(v ...any) {
+	l.logger.Log(context.Background(), slog.LevelError, slogMessage(v...))
+}
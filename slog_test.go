@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+//go:build go1.21
+
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+// TestSlogLogger checks logging through a slog.Logger.
+func TestSlogLogger(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&b,
+		&slog.HandlerOptions{Level: slog.LevelDebug})).With("run", "test"))
+
+	logger.Debug(false, "hidden")
+	logger.Debug(true, "debug", 1)
+	logger.Info("info")
+	logger.Warn("warn")
+	logger.Error("error")
+
+	expected := []struct{ level, msg string }{
+		{"DEBUG", "debug 1"},
+		{"INFO", "info"},
+		{"WARN", "warn"},
+		{"ERROR", "error"},
+	}
+
+	decoder := json.NewDecoder(&b)
+
+	for _, exp := range expected {
+		var record map[string]any
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("failed to decode log record: %s", err)
+		}
+
+		if record["level"] != exp.level || record["msg"] != exp.msg ||
+			record["run"] != "test" {
+			t.Fatalf("unexpected log record: %v", record)
+		}
+	}
+
+	if decoder.More() {
+		t.Fatal("unexpected extra log records")
+	}
+}
+
+// TestSlogLoggerWithRunID tests that the run ID is a slog attribute.
+func TestSlogLoggerWithRunID(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+
+	logger := withRunID(NewSlogLogger(slog.New(slog.NewJSONHandler(&b, nil))), "id")
+	logger.Info("info")
+
+	var record map[string]any
+	if err := json.NewDecoder(&b).Decode(&record); err != nil {
+		t.Fatalf("failed to decode log record: %s", err)
+	}
+
+	if record["msg"] != "info" || record["run"] != "id" {
+		t.Fatalf("unexpected log record: %v", record)
+	}
+}
+
+// TestNewSlogLoggerDefault tests that the default slog.Logger is used when
+// none is provided.
+func TestNewSlogLoggerDefault(t *testing.T) {
+	t.Parallel()
+
+	if logger := NewSlogLogger(nil); logger.logger != slog.Default() {
+		t.Fatal("the default slog logger was not used")
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+)
+
+const sshCommandScriptPerm = 0o700
+
+// sshCommandAuth is an SSH "authentication" delegating the connection to an
+// external SSH command, like GIT_SSH_COMMAND. The command handles the
+// authentication and the host keys. The connection goes through the command
+// only with sshCommandTransport.
+type sshCommandAuth struct {
+	command string
+}
+
+// Name implements transport.AuthMethod.
+func (a sshCommandAuth) Name() string {
+	return "ssh-command"
+}
+
+// String implements transport.AuthMethod.
+func (a sshCommandAuth) String() string {
+	return "ssh-command: " + a.command
+}
+
+// shellQuote quotes a string for the POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// sshCommandLine returns the shell command line running a git service on the
+// endpoint host through the SSH command, like git does with GIT_SSH_COMMAND:
+// the command is followed by the port, the host and the remote command.
+func sshCommandLine(command, service string, endpoint *transport.Endpoint) string {
+	line := command
+
+	if endpoint.Port > 0 {
+		line += " -p " + strconv.Itoa(endpoint.Port)
+	}
+
+	host := endpoint.Host
+	if len(endpoint.User) != 0 {
+		host = endpoint.User + "@" + host
+	}
+
+	return line + " " + shellQuote(host) + " " +
+		shellQuote(service+" "+shellQuote(endpoint.Path))
+}
+
+// sshCommandTransport is a go-git SSH transport running the git services
+// through the external command of the sshCommandAuth authentications. The
+// other authentications use the wrapped transport.
+type sshCommandTransport struct {
+	transport.Transport
+}
+
+// newClient returns a go-git transport running the git services of the
+// endpoint through the SSH command, with scripts written to a temporary
+// directory to be removed once the session is closed.
+func (a sshCommandAuth) newClient(endpoint *transport.Endpoint) (transport.Transport,
+	string, error) {
+	dir, err := os.MkdirTemp("", "git-mirror-me-ssh-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the SSH command directory: %w", err)
+	}
+
+	tempFiles.add(dir)
+
+	scripts := make([]string, 0, 2)
+
+	for _, service := range []string{
+		transport.UploadPackServiceName,
+		transport.ReceivePackServiceName,
+	} {
+		script := filepath.Join(dir, service)
+		content := "#!/bin/sh\nexec " + sshCommandLine(a.command, service, endpoint) + "\n"
+
+		if err := os.WriteFile(script, []byte(content), sshCommandScriptPerm); err != nil {
+			tempFiles.remove(dir)
+
+			return nil, "", fmt.Errorf("failed to write the SSH command script: %w", err)
+		}
+
+		scripts = append(scripts, script)
+	}
+
+	return file.NewClient(scripts[0], scripts[1]), dir, nil
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t sshCommandTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	command, ok := auth.(sshCommandAuth)
+	if !ok {
+		return t.Transport.NewUploadPackSession(endpoint, auth)
+	}
+
+	commandClient, dir, err := command.newClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := commandClient.NewUploadPackSession(endpoint, nil)
+	if err != nil {
+		tempFiles.remove(dir)
+
+		return nil, err
+	}
+
+	return cleanupUploadPackSession{
+		UploadPackSession: session,
+		cleanup:           func() { tempFiles.remove(dir) },
+	}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t sshCommandTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	command, ok := auth.(sshCommandAuth)
+	if !ok {
+		return t.Transport.NewReceivePackSession(endpoint, auth)
+	}
+
+	commandClient, dir, err := command.newClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := commandClient.NewReceivePackSession(endpoint, nil)
+	if err != nil {
+		tempFiles.remove(dir)
+
+		return nil, err
+	}
+
+	return cleanupReceivePackSession{
+		ReceivePackSession: session,
+		cleanup:            func() { tempFiles.remove(dir) },
+	}, nil
+}
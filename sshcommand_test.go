@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestSSHCommandLine tests the sshCommandLine function.
+func TestSSHCommandLine(t *testing.T) {
+	t.Parallel()
+
+	{
+		// Test an endpoint with a user and a port.
+		endpoint, err := transport.NewEndpoint("ssh://git@example.com:2222/o'n/repo.git")
+		if err != nil {
+			t.Fatalf("failed to parse the endpoint: %s", err)
+		}
+
+		line := sshCommandLine("ssh -F config", transport.UploadPackServiceName, endpoint)
+		if line != `ssh -F config -p 2222 'git@example.com' `+
+			`'git-upload-pack '\''/o'\''\'\'''\''n/repo.git'\'''` {
+			t.Fatalf("unexpected command line: %s", line)
+		}
+	}
+	{
+		// Test an endpoint without a port.
+		endpoint, err := transport.NewEndpoint("ssh://example.com/repo.git")
+		if err != nil {
+			t.Fatalf("failed to parse the endpoint: %s", err)
+		}
+
+		line := sshCommandLine("ssh", transport.ReceivePackServiceName, endpoint)
+		if line != `ssh 'example.com' 'git-receive-pack '\''/repo.git'\'''` {
+			t.Fatalf("unexpected command line: %s", line)
+		}
+	}
+}
+
+// TestDoMirrorSSHCommand tests mirroring to an SSH destination through an
+// external SSH command. The command is a fake ssh running the remote command
+// locally.
+func TestDoMirrorSSHCommand(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	dstRepo, err := utils.NewBareRepo(dstRepoPath)
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	dir := t.TempDir()
+	argsPath := filepath.Join(dir, "args")
+	sshPath := filepath.Join(dir, "ssh")
+
+	err = os.WriteFile(sshPath, []byte("#!/bin/sh\n"+
+		"echo \"$@\" >> "+shellQuote(argsPath)+"\n"+
+		"for last; do :; done\n"+
+		"exec sh -c \"$last\"\n"), 0o700)
+	if err != nil {
+		t.Fatalf("failed to write the fake ssh: %s", err)
+	}
+
+	conf := Config{
+		Source: RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{
+			URL: "ssh://git@example.com:2222" + dstRepoPath,
+			SSH: SSHConf{Command: sshPath + " -o BatchMode=yes"},
+		},
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+	if err != nil {
+		t.Fatalf("failed to get the dst repo refs: %s", err)
+	}
+
+	if !utils.SlicesAreEqual(dstRepoRefs, []string{
+		"HEAD",
+		"refs/heads/master",
+		"refs/heads/a",
+	}) {
+		t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+	}
+
+	args, err := os.ReadFile(argsPath)
+	if err != nil {
+		t.Fatalf("failed to read the fake ssh arguments: %s", err)
+	}
+
+	if !strings.HasPrefix(string(args), "-o BatchMode=yes -p 2222 git@example.com ") {
+		t.Fatalf("unexpected fake ssh arguments: %s", args)
+	}
+}
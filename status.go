@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const statusPerm = 0o644
+
+// writeStatus replaces conf.StatusFile with the JSON document of the mirror
+// run started at 'start', with the same fields as the history entries. The
+// file is written to a temporary file renamed over it so that readers never
+// see a partial status.
+func writeStatus(conf Config, start time.Time, refsChanged int, mirrorErr error) error {
+	content, err := json.MarshalIndent(
+		newHistoryEntry(conf, start, refsChanged, mirrorErr), "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode the status: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(conf.StatusFile),
+		"."+filepath.Base(conf.StatusFile)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create the status file: %w", err)
+	}
+
+	tempFiles.add(file.Name())
+	defer tempFiles.remove(file.Name())
+
+	if _, err := file.Write(append(content, '\n')); err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to write the status file: %w", err)
+	}
+
+	if err := file.Chmod(statusPerm); err != nil {
+		file.Close()
+
+		return fmt.Errorf("failed to write the status file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write the status file: %w", err)
+	}
+
+	if err := os.Rename(file.Name(), conf.StatusFile); err != nil {
+		return fmt.Errorf("failed to write the status file: %w", err)
+	}
+
+	return nil
+}
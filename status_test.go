@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// readStatus decodes a status file.
+func readStatus(t *testing.T, path string) historyEntry {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the status file: %s", err)
+	}
+
+	var status historyEntry
+	if err := json.Unmarshal(content, &status); err != nil {
+		t.Fatalf("failed to decode the status file %q: %s", content, err)
+	}
+
+	return status
+}
+
+// TestDoMirrorStatus tests that the status file is replaced after each run.
+func TestDoMirrorStatus(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	statusDir := t.TempDir()
+	statusPath := filepath.Join(statusDir, "status.json")
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		StatusFile:  statusPath,
+	}
+
+	if err := DoMirror(conf, logger); err != nil {
+		t.Fatalf("DoMirror failed: %s", err)
+	}
+
+	status := readStatus(t, statusPath)
+	if status.Status != historyStatusSuccess || status.RefsChanged != 3 ||
+		len(status.Error) != 0 || status.Destination != dstRepoPath {
+		t.Fatalf("unexpected status after a successful run: %v", status)
+	}
+
+	conf.Source.URL = filepath.Join(srcRepoPath, "missing")
+	if err := DoMirror(conf, logger); err == nil {
+		t.Fatal("DoMirror with a missing source succeeded")
+	}
+
+	status = readStatus(t, statusPath)
+	if status.Status != historyStatusFailure || len(status.Error) == 0 {
+		t.Fatalf("unexpected status after a failed run: %v", status)
+	}
+
+	// No temporary file is left behind.
+	entries, err := os.ReadDir(statusDir)
+	if err != nil {
+		t.Fatalf("failed to read the status directory: %s", err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("unexpected files in the status directory: %v", entries)
+	}
+}
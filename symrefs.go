@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// The ways of handling the source symbolic references other than HEAD.
+const (
+	// symbolicRefsResolve pushes them as regular references to the hash of
+	// their target.
+	symbolicRefsResolve = "resolve"
+	// symbolicRefsSkip doesn't mirror them.
+	symbolicRefsSkip = "skip"
+	// symbolicRefsRecreate creates them as symbolic references in the
+	// destination.
+	symbolicRefsRecreate = "recreate"
+)
+
+// sourceSymbolicRefs returns the source symbolic references other than HEAD.
+// A local source repository is read directly as the git protocol advertises
+// the symbolic references, other than HEAD, as regular ones. Otherwise, only
+// the symbolic references advertised as such by the source are returned.
+func sourceSymbolicRefs(conf Config, logger Logger,
+	repo *git.Repository) ([]*plumbing.Reference, error) {
+	var refs []*plumbing.Reference
+
+	if path := localPath(conf.Source.URL); len(path) != 0 {
+		src, err := git.PlainOpen(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the source repository: %w", err)
+		}
+
+		iter, err := src.References()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the source references: %w", err)
+		}
+
+		_ = iter.ForEach(func(ref *plumbing.Reference) error {
+			refs = append(refs, ref)
+
+			return nil
+		})
+	} else {
+		auth, err := repoAuth(conf, logger, conf.Source, "source")
+		if err != nil {
+			return nil, err
+		}
+
+		src, err := repo.Remote(srcRemoteName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the source remote: %w", err)
+		}
+
+		refs, err = listRemote(conf, src, auth)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the source remote: %w", err)
+		}
+	}
+
+	var symRefs []*plumbing.Reference
+
+	for _, ref := range refs {
+		if ref.Type() == plumbing.SymbolicReference && ref.Name() != plumbing.HEAD {
+			symRefs = append(symRefs, ref)
+		}
+	}
+
+	return symRefs, nil
+}
+
+// stageSymbolicRefs sets the source symbolic references other than HEAD in
+// the staging repository as configured by conf.SymbolicRefs: a regular
+// reference to the hash of the target, no reference or a symbolic reference
+// (which is not pushed, see recreateSymbolicRefs).
+func stageSymbolicRefs(conf Config, logger Logger, repo *git.Repository) error {
+	if len(conf.SymbolicRefs) == 0 {
+		return nil
+	}
+
+	symRefs, err := sourceSymbolicRefs(conf, logger, repo)
+	if err != nil {
+		return err
+	}
+
+	for _, symRef := range symRefs {
+		logger.Debug(conf.Debug, "Symbolic reference", symRef.Name(), "->",
+			symRef.Target(), "("+conf.SymbolicRefs+")")
+
+		switch conf.SymbolicRefs {
+		case symbolicRefsResolve:
+			target, err := repo.Reference(symRef.Target(), true)
+			if err != nil {
+				logger.Warn("The target of the symbolic reference", symRef.Name(),
+					"was not fetched, skipping it.")
+
+				continue
+			}
+
+			err = repo.Storer.SetReference(
+				plumbing.NewHashReference(symRef.Name(), target.Hash()))
+			if err != nil {
+				return fmt.Errorf("failed to set reference: %w", err)
+			}
+		case symbolicRefsSkip:
+			err := repo.Storer.RemoveReference(symRef.Name())
+			if err != nil {
+				return fmt.Errorf("failed to remove reference: %w", err)
+			}
+		case symbolicRefsRecreate:
+			if err := repo.Storer.SetReference(symRef); err != nil {
+				return fmt.Errorf("failed to set reference: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// recreateSymbolicRefs creates the symbolic references, other than HEAD, of
+// the staging repository in the local destination repository. A push can't
+// create symbolic references so the destination repository is written
+// directly.
+func recreateSymbolicRefs(conf Config, logger Logger, repo *git.Repository) error {
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to get references: %w", err)
+	}
+
+	var symRefs []*plumbing.Reference
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.SymbolicReference && ref.Name() != plumbing.HEAD {
+			symRefs = append(symRefs, ref)
+		}
+
+		return nil
+	})
+
+	if len(symRefs) == 0 {
+		return nil
+	}
+
+	dst, err := git.PlainOpen(localPath(conf.Destination.URL))
+	if err != nil {
+		return fmt.Errorf("failed to open the destination repository: %w", err)
+	}
+
+	for _, symRef := range symRefs {
+		current, err := dst.Reference(symRef.Name(), false)
+		if err == nil && current.Type() == plumbing.SymbolicReference &&
+			current.Target() == symRef.Target() {
+			continue
+		}
+
+		logger.Info("Creating the symbolic reference", symRef.Name(), "->",
+			symRef.Target(), "...")
+
+		if err := dst.Storer.SetReference(symRef); err != nil {
+			return fmt.Errorf("failed to create the symbolic reference: %w", err)
+		}
+	}
+
+	return nil
+}
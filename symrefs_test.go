@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestDoMirrorSymbolicRefs tests the handling of the source symbolic
+// references by DoMirror.
+func TestDoMirrorSymbolicRefs(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	srcRepo, srcHead, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	err = srcRepo.Storer.SetReference(
+		plumbing.NewSymbolicReference("refs/heads/latest", "refs/heads/a"))
+	if err != nil {
+		t.Fatalf("failed to create a src repo symbolic reference: %s", err)
+	}
+
+	{
+		// Test resolving the symbolic references.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, err := utils.NewBareRepo(dstRepoPath)
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		err = DoMirror(Config{
+			Source:       RepoConf{URL: srcRepoPath},
+			Destination:  RepoConf{URL: dstRepoPath},
+			SymbolicRefs: symbolicRefsResolve,
+		}, logger)
+		if err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		ref, err := dstRepo.Reference("refs/heads/latest", false)
+		if err != nil || ref.Type() != plumbing.HashReference || ref.Hash() != srcHead {
+			t.Fatalf("unexpected resolved reference: %v (%v)", ref, err)
+		}
+	}
+	{
+		// Test skipping the symbolic references, pruning the destination
+		// copies.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+			"refs/heads/latest",
+		})
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		err = DoMirror(Config{
+			Source:       RepoConf{URL: srcRepoPath},
+			Destination:  RepoConf{URL: dstRepoPath},
+			SymbolicRefs: symbolicRefsSkip,
+		}, logger)
+		if err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		dstRepoRefs, err := utils.RepoRefsSlice(dstRepo)
+		if err != nil {
+			t.Fatalf("failed to get the dst repo refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstRepoRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+			"refs/heads/a",
+		}) {
+			t.Fatalf("unexpected refs in the dst repo: %s", dstRepoRefs)
+		}
+	}
+	{
+		// Test recreating the symbolic references, replacing the regular
+		// destination ones.
+		dstRepoPath := t.TempDir()
+
+		dstRepo, _, err := utils.NewTestRepo(dstRepoPath, []string{
+			"refs/heads/latest",
+		})
+		if err != nil {
+			t.Fatalf("failed to create a test dst repo: %s", err)
+		}
+
+		conf := Config{
+			Source:       RepoConf{URL: srcRepoPath},
+			Destination:  RepoConf{URL: dstRepoPath},
+			SymbolicRefs: symbolicRefsRecreate,
+		}
+
+		for i := 0; i < 2; i++ {
+			if err := DoMirror(conf, logger); err != nil {
+				t.Fatalf("DoMirror failed: %s", err)
+			}
+		}
+
+		ref, err := dstRepo.Reference("refs/heads/latest", false)
+		if err != nil || ref.Type() != plumbing.SymbolicReference ||
+			ref.Target() != "refs/heads/a" {
+			t.Fatalf("unexpected recreated reference: %v (%v)", ref, err)
+		}
+
+		ref, err = dstRepo.Reference("refs/heads/a", false)
+		if err != nil || ref.Hash() != srcHead {
+			t.Fatalf("unexpected symbolic reference target: %v (%v)", ref, err)
+		}
+	}
+}
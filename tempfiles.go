@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// signalExitCode is the exit code base of a process terminated by a signal,
+// added to the signal number as shells do.
+const signalExitCode = 128
+
+// tempRegistry is a registry of temporary files and directories. The deferred
+// removals don't run when the process is terminated by a signal so the
+// registered paths are removed by the signal handler instead.
+type tempRegistry struct {
+	sync.Mutex
+	paths map[string]bool
+}
+
+// tempFiles is the registry of the temporary files and directories of the
+// running mirrors (e.g. the known_hosts files and the on-disk staging
+// repositories).
+var tempFiles = newTempRegistry()
+
+// newTempRegistry returns an empty registry.
+func newTempRegistry() *tempRegistry {
+	return &tempRegistry{paths: make(map[string]bool)}
+}
+
+// add registers a temporary file or directory.
+func (r *tempRegistry) add(path string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.paths[path] = true
+}
+
+// remove removes a temporary file or directory and unregisters it.
+func (r *tempRegistry) remove(path string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.paths, path)
+
+	return os.RemoveAll(path)
+}
+
+// removeAll removes all the registered temporary files and directories.
+func (r *tempRegistry) removeAll() {
+	r.Lock()
+	defer r.Unlock()
+
+	for path := range r.paths {
+		os.RemoveAll(path)
+		delete(r.paths, path)
+	}
+}
+
+// handleSignals removes the registered temporary files and directories, and
+// exits, when a signal is received on 'signals', until 'done' is closed.
+func (r *tempRegistry) handleSignals(logger Logger, signals <-chan os.Signal,
+	done <-chan struct{}, exit func(int)) {
+	select {
+	case sig := <-signals:
+		logger.Warn("Received the", sig.String(), "signal, removing the temporary files.")
+		r.removeAll()
+
+		code := 1
+		if num, ok := signalNumber(sig); ok {
+			code = signalExitCode + num
+		}
+
+		exit(code)
+	case <-done:
+	}
+}
+
+// RemoveTempFilesOnSignal handles the 'signals', SIGINT and SIGTERM when none
+// is given, by removing the temporary files of the running mirrors and
+// exiting with 128 plus the signal number. Otherwise, the temporary files
+// would be left behind when the process is terminated (e.g. a CI job
+// container stopped mid-run). The returned function stops the handling.
+func RemoveTempFilesOnSignal(logger Logger, signals ...os.Signal) func() {
+	if len(signals) == 0 {
+		signals = terminationSignals
+	}
+
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+
+	done := make(chan struct{})
+
+	go tempFiles.handleSignals(logger, received, done, os.Exit)
+
+	return func() {
+		signal.Stop(received)
+		close(done)
+	}
+}
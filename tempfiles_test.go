@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestTempRegistry tests the temporary files registry.
+func TestTempRegistry(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	base := t.TempDir()
+	file := filepath.Join(base, "file")
+	dir := filepath.Join(base, "dir")
+
+	if err := os.WriteFile(file, []byte("foo"), 0o600); err != nil {
+		t.Fatalf("failed to write a temporary file: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatalf("failed to create a temporary directory: %s", err)
+	}
+
+	{
+		// Test removing a registered path.
+		registry := newTempRegistry()
+		registry.add(file)
+
+		if err := registry.remove(file); err != nil {
+			t.Fatalf("failed to remove a temporary file: %s", err)
+		}
+
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("the temporary file wasn't removed: %v", err)
+		}
+
+		if len(registry.paths) != 0 {
+			t.Fatalf("unexpected registered paths: %v", registry.paths)
+		}
+	}
+	{
+		// Test removing the registered paths on a signal.
+		registry := newTempRegistry()
+		registry.add(dir)
+
+		signals := make(chan os.Signal, 1)
+		signals <- syscall.SIGTERM
+
+		code := 0
+		registry.handleSignals(logger, signals, nil, func(c int) { code = c })
+
+		if code != signalExitCode+int(syscall.SIGTERM) {
+			t.Fatalf("unexpected exit code: %d", code)
+		}
+
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("the temporary directory wasn't removed: %v", err)
+		}
+	}
+	{
+		// Test stopping the signal handling.
+		registry := newTempRegistry()
+		registry.add(base)
+
+		done := make(chan struct{})
+		close(done)
+
+		registry.handleSignals(logger, nil, done, func(int) {
+			t.Fatal("unexpected exit")
+		})
+
+		if _, err := os.Stat(base); err != nil {
+			t.Fatalf("the registered path was removed: %s", err)
+		}
+	}
+}
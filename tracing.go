@@ -0,0 +1,308 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const (
+	otlpTimeout     = 10 * time.Second
+	otlpTracesPath  = "/v1/traces"
+	otlpServiceName = "git-mirror-me"
+	// otlpSpanKindInternal and otlpStatusError are the OTLP SPAN_KIND_INTERNAL
+	// and STATUS_CODE_ERROR values.
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+	// traceparentEnv is the environment variable with the W3C trace context
+	// of the parent span, e.g. set by the pipeline running the mirror.
+	traceparentEnv = "TRACEPARENT"
+)
+
+var (
+	ErrOTLPEndpoint = errors.New("the OTLP endpoint needs to be an HTTP(S) URL")
+	ErrOTLPExport   = errors.New("failed to export the trace")
+)
+
+// mirrorTrace is the trace of a mirror run, exported at the end of the run.
+type mirrorTrace struct {
+	traceID [16]byte
+	spans   []*traceSpan
+}
+
+// traceSpan is a span of a mirror trace. The methods of a nil span do
+// nothing so that the mirror is traced only when enabled.
+type traceSpan struct {
+	trace      *mirrorTrace
+	name       string
+	spanID     [8]byte
+	parentID   [8]byte
+	start      time.Time
+	end        time.Time
+	attributes []otlpAttribute
+	err        error
+}
+
+// otlpAttribute is an OTLP attribute in its JSON encoding.
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// parseTraceparent parses a W3C traceparent header value (e.g.
+// '00-<trace ID>-<parent span ID>-01') into the trace ID and the parent span
+// ID.
+func parseTraceparent(traceparent string) ([16]byte, [8]byte, bool) {
+	var (
+		traceID  [16]byte
+		parentID [8]byte
+	)
+
+	fields := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(fields) != 4 || len(fields[0]) != 2 || fields[0] == "ff" {
+		return traceID, parentID, false
+	}
+
+	if n, err := hex.Decode(traceID[:], []byte(fields[1])); err != nil ||
+		n != len(traceID) || traceID == [16]byte{} {
+		return traceID, parentID, false
+	}
+
+	if n, err := hex.Decode(parentID[:], []byte(fields[2])); err != nil ||
+		n != len(parentID) || parentID == [8]byte{} {
+		return traceID, parentID, false
+	}
+
+	return traceID, parentID, true
+}
+
+// startTrace starts the root span of the trace of a mirror run when
+// conf.OTLPEndpoint is set, and returns nil otherwise. The trace is a child of
+// the 'traceparent' span, when valid.
+func startTrace(conf Config, traceparent string) *traceSpan {
+	if len(conf.OTLPEndpoint) == 0 {
+		return nil
+	}
+
+	trace := &mirrorTrace{}
+
+	traceID, parentID, ok := parseTraceparent(traceparent)
+	if ok {
+		trace.traceID = traceID
+	} else {
+		// crypto/rand.Read doesn't fail on the supported platforms.
+		_, _ = rand.Read(trace.traceID[:])
+	}
+
+	span := trace.startSpan("mirror", parentID)
+	span.setAttribute("mirror.run_id", conf.RunID)
+	span.setAttribute("mirror.source", redactURL(conf.Source.URL))
+	span.setAttribute("mirror.destination", redactURL(conf.Destination.URL))
+	span.setAttribute("mirror.dry_run", conf.DryRun)
+
+	return span
+}
+
+// startSpan starts a span of the trace.
+func (t *mirrorTrace) startSpan(name string, parentID [8]byte) *traceSpan {
+	span := &traceSpan{
+		trace:    t,
+		name:     name,
+		parentID: parentID,
+		start:    time.Now(),
+	}
+
+	_, _ = rand.Read(span.spanID[:])
+
+	t.spans = append(t.spans, span)
+
+	return span
+}
+
+// child starts a child span.
+func (s *traceSpan) child(name string) *traceSpan {
+	if s == nil {
+		return nil
+	}
+
+	return s.trace.startSpan(name, s.spanID)
+}
+
+// setAttribute sets a string, integer or boolean attribute of the span.
+func (s *traceSpan) setAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+
+	var otlpValue map[string]any
+
+	switch value := value.(type) {
+	case string:
+		otlpValue = map[string]any{"stringValue": value}
+	case int:
+		// The OTLP JSON encoding of the 64-bit integers is a string.
+		otlpValue = map[string]any{"intValue": strconv.Itoa(value)}
+	case bool:
+		otlpValue = map[string]any{"boolValue": value}
+	default:
+		otlpValue = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+
+	s.attributes = append(s.attributes, otlpAttribute{Key: key, Value: otlpValue})
+}
+
+// setRefCount sets the number of references of a repository as the
+// 'mirror.refs' attribute of the span.
+func (s *traceSpan) setRefCount(repo *git.Repository) {
+	if s == nil {
+		return
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return
+	}
+
+	count := 0
+
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			count++
+		}
+
+		return nil
+	})
+
+	s.setAttribute("mirror.refs", count)
+}
+
+// finish ends the span, with the error of the operation it covers, if any.
+func (s *traceSpan) finish(err error) {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.err = err
+}
+
+// otlpTracesURL returns the URL the traces are exported to, from the OTLP
+// endpoint base URL (e.g. 'http://localhost:4318').
+func otlpTracesURL(endpoint string) string {
+	if strings.HasSuffix(endpoint, otlpTracesPath) {
+		return endpoint
+	}
+
+	return strings.TrimSuffix(endpoint, "/") + otlpTracesPath
+}
+
+// validateOTLPEndpoint checks that the OTLP endpoint, when set, is an HTTP(S)
+// URL.
+func validateOTLPEndpoint(endpoint string) error {
+	if len(endpoint) == 0 {
+		return nil
+	}
+
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") ||
+		len(endpointURL.Host) == 0 {
+		return fmt.Errorf("%w: %q", ErrOTLPEndpoint, endpoint)
+	}
+
+	return nil
+}
+
+// encode returns the OTLP JSON encoding of the trace.
+func (t *mirrorTrace) encode() ([]byte, error) {
+	spans := make([]map[string]any, 0, len(t.spans))
+
+	for _, span := range t.spans {
+		end := span.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+
+		otlpSpan := map[string]any{
+			"traceId":           hex.EncodeToString(t.traceID[:]),
+			"spanId":            hex.EncodeToString(span.spanID[:]),
+			"name":              span.name,
+			"kind":              otlpSpanKindInternal,
+			"startTimeUnixNano": strconv.FormatInt(span.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(end.UnixNano(), 10),
+			"attributes":        span.attributes,
+		}
+
+		if span.parentID != [8]byte{} {
+			otlpSpan["parentSpanId"] = hex.EncodeToString(span.parentID[:])
+		}
+
+		if span.err != nil {
+			otlpSpan["status"] = map[string]any{
+				"code":    otlpStatusError,
+				"message": span.err.Error(),
+			}
+		}
+
+		spans = append(spans, otlpSpan)
+	}
+
+	return json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": []otlpAttribute{{
+					Key:   "service.name",
+					Value: map[string]any{"stringValue": otlpServiceName},
+				}},
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": otlpServiceName},
+				"spans": spans,
+			}},
+		}},
+	})
+}
+
+// exportTrace ends the root span of a mirror trace, with the error of the
+// mirror, and exports the trace to the OTLP/HTTP endpoint, JSON encoded.
+func exportTrace(conf Config, root *traceSpan, mirrorErr error) error {
+	if root == nil {
+		return nil
+	}
+
+	root.finish(mirrorErr)
+
+	data, err := root.trace.encode()
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrOTLPExport, err)
+	}
+
+	httpClient := &http.Client{Timeout: otlpTimeout}
+
+	resp, err := httpClient.Post(otlpTracesURL(conf.OTLPEndpoint), "application/json",
+		bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrOTLPExport, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s", ErrOTLPExport, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// otlpTestSpan is a span of an exported test trace.
+type otlpTestSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes"`
+	Status       struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+// newTestOTLPServer starts an OTLP/HTTP server recording the spans of the
+// exported traces.
+func newTestOTLPServer(t *testing.T) (*httptest.Server, func() [][]otlpTestSpan) {
+	t.Helper()
+
+	var (
+		mutex  sync.Mutex
+		traces [][]otlpTestSpan
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpTestSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+
+		if r.URL.Path != otlpTracesPath || json.NewDecoder(r.Body).Decode(&request) != nil ||
+			len(request.ResourceSpans) != 1 || len(request.ResourceSpans[0].ScopeSpans) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		mutex.Lock()
+		traces = append(traces, request.ResourceSpans[0].ScopeSpans[0].Spans)
+		mutex.Unlock()
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() [][]otlpTestSpan {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return traces
+	}
+}
+
+// TestParseTraceparent tests parseTraceparent function.
+func TestParseTraceparent(t *testing.T) {
+	t.Parallel()
+
+	traceID, parentID, ok := parseTraceparent(
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || traceID[0] != 0x4b || traceID[15] != 0x36 ||
+		parentID[0] != 0x00 || parentID[7] != 0xb7 {
+		t.Fatalf("unexpected trace context: %x %x %t", traceID, parentID, ok)
+	}
+
+	for _, traceparent := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902zz-01",
+	} {
+		if _, _, ok := parseTraceparent(traceparent); ok {
+			t.Fatalf("invalid traceparent %q passed", traceparent)
+		}
+	}
+}
+
+// TestStartTrace tests starting the trace of a mirror run.
+func TestStartTrace(t *testing.T) {
+	t.Parallel()
+
+	if span := startTrace(Config{}, ""); span != nil {
+		t.Fatal("a trace was started without an OTLP endpoint")
+	}
+
+	// The methods of a nil span do nothing.
+	var span *traceSpan
+	span.child("fetch").setAttribute("key", "value")
+	span.finish(nil)
+
+	conf := Config{OTLPEndpoint: "http://localhost:4318"}
+
+	span = startTrace(conf, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if span.trace.traceID[0] != 0x4b || span.parentID[7] != 0xb7 {
+		t.Fatalf("the trace is not a child of the traceparent: %x %x",
+			span.trace.traceID, span.parentID)
+	}
+
+	child := span.child("fetch")
+	if child.trace.traceID != span.trace.traceID || child.parentID != span.spanID {
+		t.Fatal("unexpected child span")
+	}
+
+	span = startTrace(conf, "")
+	if span.trace.traceID == [16]byte{} || span.parentID != [8]byte{} {
+		t.Fatalf("unexpected root span: %x %x", span.trace.traceID, span.parentID)
+	}
+}
+
+// TestDoMirrorTrace tests tracing the mirror runs.
+func TestDoMirrorTrace(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	server, traces := newTestOTLPServer(t)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:       RepoConf{URL: srcRepoPath},
+		Destination:  RepoConf{URL: dstRepoPath},
+		OTLPEndpoint: server.URL,
+	}
+
+	// The first mirror, to an empty destination, has nothing to prune.
+	for _, expected := range [][]string{
+		{"mirror", "fetch", "filter", "push"},
+		{"mirror", "fetch", "filter", "push", "prune"},
+	} {
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed: %s", err)
+		}
+
+		all := traces()
+		spans := all[len(all)-1]
+
+		if len(spans) != len(expected) {
+			t.Fatalf("unexpected spans: %+v", spans)
+		}
+
+		for i, span := range spans {
+			if span.Name != expected[i] || span.TraceID != spans[0].TraceID ||
+				(i == 0 && len(span.ParentSpanID) != 0) ||
+				(i != 0 && span.ParentSpanID != spans[0].SpanID) || span.Status.Code != 0 {
+				t.Fatalf("unexpected span: %+v", span)
+			}
+		}
+	}
+
+	// A failed mirror has an error status.
+	conf.Source.URL = t.TempDir()
+
+	if err := DoMirror(conf, logger); err == nil {
+		t.Fatal("DoMirror passed with an invalid source")
+	}
+
+	all := traces()
+	spans := all[len(all)-1]
+
+	if len(spans) != 2 || spans[0].Status.Code != otlpStatusError ||
+		spans[1].Name != "fetch" || spans[1].Status.Code != otlpStatusError {
+		t.Fatalf("unexpected spans of a failed mirror: %+v", spans)
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// transferCounters are the transfer counters of the running mirrors, by run
+// ID. go-git transports are global so the run authentications find the
+// counter of their run here.
+var transferCounters sync.Map
+
+// transferCounter counts the bytes of the packs fetched and pushed by a
+// mirror run, as transferred.
+type transferCounter struct {
+	fetched int64
+	pushed  int64
+}
+
+// startTransferCount returns the transfer counter of a mirror run, counting
+// until stopTransferCount is called.
+func startTransferCount(runID string) *transferCounter {
+	counter := &transferCounter{}
+	transferCounters.Store(runID, counter)
+
+	return counter
+}
+
+// stopTransferCount stops counting the transfers of a mirror run.
+func stopTransferCount(runID string) {
+	transferCounters.Delete(runID)
+}
+
+// runTransferCounter returns the transfer counter of a mirror run, nil when
+// its transfers are not counted.
+func runTransferCounter(runID string) *transferCounter {
+	counter, ok := transferCounters.Load(runID)
+	if !ok {
+		return nil
+	}
+
+	return counter.(*transferCounter)
+}
+
+// transferred returns the bytes of the fetched and of the pushed packs.
+func (c *transferCounter) transferred() (int64, int64) {
+	return atomic.LoadInt64(&c.fetched), atomic.LoadInt64(&c.pushed)
+}
+
+// logTransferred logs, as info, the bytes of the packs fetched and pushed by
+// a mirror run. Their sum is recorded in 'stats', when not nil.
+func logTransferred(logger Logger, stats *runStats, counter *transferCounter) {
+	fetched, pushed := counter.transferred()
+
+	if stats != nil {
+		stats.transferred = fetched + pushed
+	}
+
+	logger.Info("Transferred", fetched, "bytes fetched and", pushed, "bytes pushed.")
+}
+
+// countingReader is an io.ReadCloser adding the bytes read to a counter.
+type countingReader struct {
+	io.ReadCloser
+	count *int64
+}
+
+// Read implements io.Reader.
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	atomic.AddInt64(r.count, int64(n))
+
+	return n, err
+}
+
+// countTransport is a go-git transport counting the bytes of the packs
+// fetched and pushed.
+type countTransport struct {
+	transport.Transport
+	counter *transferCounter
+}
+
+// countUploadPackSession is an upload-pack session counting the fetched pack.
+type countUploadPackSession struct {
+	transport.UploadPackSession
+	counter *transferCounter
+}
+
+// countReceivePackSession is a receive-pack session counting the pushed
+// pack.
+type countReceivePackSession struct {
+	transport.ReceivePackSession
+	counter *transferCounter
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t countTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := t.Transport.NewUploadPackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return countUploadPackSession{UploadPackSession: session, counter: t.counter}, nil
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t countTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	session, err := t.Transport.NewReceivePackSession(endpoint, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	return countReceivePackSession{ReceivePackSession: session, counter: t.counter}, nil
+}
+
+// UploadPack implements transport.UploadPackSession. The response is decoded
+// up to the pack so only the pack is counted.
+func (s countUploadPackSession) UploadPack(ctx context.Context,
+	req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	resp, err := s.UploadPackSession.UploadPack(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	counted := packp.NewUploadPackResponseWithPackfile(req,
+		countingReader{ReadCloser: resp, count: &s.counter.fetched})
+	counted.ShallowUpdate = resp.ShallowUpdate
+	counted.ServerResponse = resp.ServerResponse
+
+	return counted, nil
+}
+
+// ReceivePack implements transport.ReceivePackSession.
+func (s countReceivePackSession) ReceivePack(ctx context.Context,
+	req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	if req.Packfile != nil {
+		req.Packfile = countingReader{ReadCloser: req.Packfile, count: &s.counter.pushed}
+	}
+
+	return s.ReceivePackSession.ReceivePack(ctx, req)
+}
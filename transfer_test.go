@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestCountingReader tests that countingReader counts the bytes read.
+func TestCountingReader(t *testing.T) {
+	t.Parallel()
+
+	var count int64
+
+	reader := countingReader{
+		ReadCloser: io.NopCloser(bytes.NewReader(make([]byte, 2000))),
+		count:      &count,
+	}
+
+	if _, err := io.ReadAll(reader); err != nil {
+		t.Fatalf("failed to read: %s", err)
+	}
+
+	if count != 2000 {
+		t.Fatalf("unexpected number of bytes counted: %d", count)
+	}
+}
+
+// TestTransferCount tests that the transfers of a mirror run are counted.
+func TestTransferCount(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	srcRepoPath := t.TempDir()
+
+	_, _, err := utils.NewTestRepo(srcRepoPath, []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepoPath := t.TempDir()
+
+	if _, err := utils.NewBareRepo(dstRepoPath); err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: srcRepoPath},
+		Destination: RepoConf{URL: dstRepoPath},
+		RunID:       "transfer-count",
+	}
+
+	{
+		// Test that the fetched and the pushed packs are counted.
+		counter := startTransferCount(conf.RunID)
+
+		if runTransferCounter(conf.RunID) != counter {
+			t.Fatal("the run transfer counter was not found")
+		}
+
+		if err := doMirror(conf, logger, nil, nil); err != nil {
+			t.Fatalf("doMirror failed: %s", err)
+		}
+
+		stopTransferCount(conf.RunID)
+
+		if fetched, pushed := counter.transferred(); fetched == 0 || pushed == 0 {
+			t.Fatalf("unexpected transfers: %d fetched, %d pushed", fetched, pushed)
+		}
+
+		if runTransferCounter(conf.RunID) != nil {
+			t.Fatal("the run transfer counter was not removed")
+		}
+	}
+	{
+		// Test that the transfers are logged and recorded.
+		var b bytes.Buffer
+
+		stats := newRunStats(false)
+
+		if err := runMirror(conf, NewLogger(&b), stats); err != nil {
+			t.Fatalf("runMirror failed: %s", err)
+		}
+
+		// The destination is up to date so only the source pack is
+		// transferred.
+		if stats.transferred == 0 || !strings.Contains(b.String(),
+			"bytes fetched and 0 bytes pushed.") {
+			t.Fatalf("unexpected transfers: %d (%s)", stats.transferred, b.String())
+		}
+	}
+}
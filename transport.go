@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+var mirrorTransportOnce sync.Once
+
+// runAuth is the authentication of a repository for a mirror run. It wraps
+// the repository authentication, nil when anonymous, with the transport
+// settings of the run. go-git transports are global so, instead of
+// installing transports for each run, the settings go with the
+// authentication to mirrorTransport which applies them to the sessions.
+type runAuth struct {
+	auth transport.AuthMethod
+	// httpTransport is the go-git HTTP(S) transport of the run, nil for the
+	// default one.
+	httpTransport transport.Transport
+	// rateLimit is the transfer rate in bytes per second, zero for no limit.
+	rateLimit int64
+	// atomic requests atomic pushes.
+	atomic bool
+	// transfer counts the transferred packs, nil when they are not counted.
+	transfer *transferCounter
+}
+
+// Name implements transport.AuthMethod.
+func (a runAuth) Name() string {
+	if a.auth == nil {
+		return "none"
+	}
+
+	return a.auth.Name()
+}
+
+// String implements transport.AuthMethod.
+func (a runAuth) String() string {
+	if a.auth == nil {
+		return "none"
+	}
+
+	return a.auth.String()
+}
+
+// newRunAuth returns the run authentication wrapping 'auth' with the
+// transport settings of the configuration. The go-git HTTP(S) transport is
+// only customized when the configuration needs it.
+func newRunAuth(conf Config, auth transport.AuthMethod) (transport.AuthMethod, error) {
+	installMirrorTransport()
+
+	run := runAuth{
+		auth:      auth,
+		rateLimit: conf.RateLimit,
+		transfer:  runTransferCounter(conf.RunID),
+	}
+
+	if needsHTTPClient(conf) {
+		httpClient, err := newHTTPClient(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		run.httpTransport = githttp.NewClient(httpClient)
+	}
+
+	return run, nil
+}
+
+// installMirrorTransport installs, once, mirrorTransport for all the go-git
+// protocols. go-git transports are global but the transport only changes the
+// connections using a runAuth.
+func installMirrorTransport() {
+	mirrorTransportOnce.Do(func() {
+		for protocol, current := range client.Protocols {
+			client.InstallProtocol(protocol, mirrorTransport{
+				Transport: current,
+				protocol:  protocol,
+			})
+		}
+	})
+}
+
+// mirrorTransport is a go-git transport applying the transport settings of
+// the runAuth authentications. The other authentications use the wrapped
+// transport as they are.
+type mirrorTransport struct {
+	transport.Transport
+	protocol string
+}
+
+// sessionTransport returns the go-git transport for the sessions of a run:
+// the run HTTP(S) transport or the wrapped one, connecting through the
+// tunnels and the SSH commands, checking the object format, throttled,
+// counting the transfers and requesting atomic pushes as set up for the run.
+func (t mirrorTransport) sessionTransport(run runAuth) transport.Transport {
+	current := t.Transport
+
+	if run.httpTransport != nil && (t.protocol == "http" || t.protocol == "https") {
+		current = run.httpTransport
+	}
+
+	current = objectFormatTransport{
+		Transport: sshCommandTransport{Transport: tunnelTransport{Transport: current}},
+	}
+
+	if run.rateLimit != 0 {
+		current = rateLimitTransport{Transport: current, rate: run.rateLimit}
+	}
+
+	if run.transfer != nil {
+		current = countTransport{Transport: current, counter: run.transfer}
+	}
+
+	if run.atomic {
+		current = atomicTransport{Transport: current}
+	}
+
+	return current
+}
+
+// NewUploadPackSession implements transport.Transport.
+func (t mirrorTransport) NewUploadPackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	run, ok := auth.(runAuth)
+	if !ok {
+		return t.Transport.NewUploadPackSession(endpoint, auth)
+	}
+
+	return t.sessionTransport(run).NewUploadPackSession(endpoint, run.auth)
+}
+
+// NewReceivePackSession implements transport.Transport.
+func (t mirrorTransport) NewReceivePackSession(endpoint *transport.Endpoint,
+	auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	run, ok := auth.(runAuth)
+	if !ok {
+		return t.Transport.NewReceivePackSession(endpoint, auth)
+	}
+
+	return t.sessionTransport(run).NewReceivePackSession(endpoint, run.auth)
+}
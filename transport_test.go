@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// TestNewRunAuth tests that the run authentication carries the transport
+// settings of the configuration.
+func TestNewRunAuth(t *testing.T) {
+	t.Parallel()
+	{
+		// Test that the default settings need no custom transport.
+		auth, err := newRunAuth(Config{}, nil)
+		if err != nil {
+			t.Fatalf("newRunAuth failed: %s", err)
+		}
+
+		run := auth.(runAuth)
+		if run.auth != nil || run.httpTransport != nil || run.rateLimit != 0 {
+			t.Fatalf("unexpected run authentication: %v", run)
+		}
+
+		if auth.Name() != "none" || auth.String() != "none" {
+			t.Fatalf("unexpected anonymous authentication: %s", auth)
+		}
+	}
+	{
+		// Test that the authentication and the settings are carried.
+		basic := &githttp.BasicAuth{Username: "foo", Password: "bar"}
+
+		auth, err := newRunAuth(Config{UserAgent: "foo", RateLimit: 1000}, basic)
+		if err != nil {
+			t.Fatalf("newRunAuth failed: %s", err)
+		}
+
+		run := auth.(runAuth)
+		if run.auth != basic || run.httpTransport == nil || run.rateLimit != 1000 {
+			t.Fatalf("unexpected run authentication: %v", run)
+		}
+
+		if auth.Name() != basic.Name() || auth.String() != basic.String() {
+			t.Fatalf("unexpected authentication: %s", auth)
+		}
+	}
+}
+
+// TestInstallMirrorTransport tests that the mirror transport is installed,
+// once, for all the protocols.
+func TestInstallMirrorTransport(t *testing.T) {
+	t.Parallel()
+
+	installMirrorTransport()
+	installMirrorTransport()
+
+	for _, protocol := range []string{"http", "https", "ssh", "git", "file"} {
+		installed, ok := client.Protocols[protocol].(mirrorTransport)
+		if !ok || installed.protocol != protocol {
+			t.Fatalf("mirror transport not installed for %s", protocol)
+		}
+
+		if _, ok := installed.Transport.(mirrorTransport); ok {
+			t.Fatalf("mirror transport installed twice for %s", protocol)
+		}
+	}
+}
+
+// TestMirrorTransportSessionTransport tests that the sessions of a run use the
+// transport settings of the run.
+func TestMirrorTransportSessionTransport(t *testing.T) {
+	t.Parallel()
+
+	runTransport := githttp.NewClient(&http.Client{})
+
+	// unwrap returns the transport wrapped by the object format, the tunnel
+	// and the SSH command transports.
+	unwrap := func(current transport.Transport) transport.Transport {
+		return current.(objectFormatTransport).Transport.(sshCommandTransport).
+			Transport.(tunnelTransport).Transport
+	}
+
+	https := mirrorTransport{Transport: githttp.DefaultClient, protocol: "https"}
+	{
+		// Test that the default HTTP transport is used without a run one.
+		current := https.sessionTransport(runAuth{})
+		if unwrap(current) != githttp.DefaultClient {
+			t.Fatalf("unexpected session transport: %v", current)
+		}
+	}
+	{
+		// Test that the run HTTP transport is used and throttled.
+		current := https.sessionTransport(runAuth{
+			httpTransport: runTransport,
+			rateLimit:     1000,
+		})
+
+		limited, ok := current.(rateLimitTransport)
+		if !ok || limited.rate != 1000 || unwrap(limited.Transport) != runTransport {
+			t.Fatalf("unexpected session transport: %v", current)
+		}
+	}
+	{
+		// Test that the run HTTP transport is not used for the other protocols.
+		ssh := mirrorTransport{Transport: githttp.DefaultClient, protocol: "ssh"}
+
+		current := ssh.sessionTransport(runAuth{httpTransport: runTransport})
+		if unwrap(current) != githttp.DefaultClient {
+			t.Fatalf("unexpected session transport: %v", current)
+		}
+	}
+}
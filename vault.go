@@ -0,0 +1,203 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const vaultTimeout = 30 * time.Second
+
+var (
+	ErrVaultConf = errors.New("reading secrets from Vault requires a secret " +
+		"path and a token, a token path or an AppRole role ID and secret ID path")
+	ErrVaultAPI = errors.New("request to Vault failed")
+)
+
+// VaultConf structure defines the HashiCorp Vault secret providing the
+// secrets otherwise provided via environment variables.
+type VaultConf struct {
+	// Address is the Vault server URL (e.g. 'https://vault.example.com:8200').
+	Address string
+	// Token authenticates the Vault requests. It can be read from
+	// TokenPath instead (e.g. a Vault agent sink) or obtained with an AppRole
+	// login using RoleID and the secret ID read from SecretIDPath.
+	Token        string
+	TokenPath    string
+	RoleID       string
+	SecretIDPath string
+	// SecretPath is the API path of the secret, after '/v1/' (e.g.
+	// 'secret/data/mirror' for a KV version 2 secrets engine). The secret
+	// keys are the names of the environment variables providing secrets
+	// (e.g. 'GMM_SSH_PRIVATE_KEY').
+	SecretPath string
+}
+
+// validate checks the Vault configuration, when Vault is used.
+func (vault VaultConf) validate() error {
+	if len(vault.Address) == 0 {
+		return nil
+	}
+
+	if len(vault.SecretPath) == 0 || (len(vault.Token) == 0 &&
+		len(vault.TokenPath) == 0 && len(vault.RoleID) == 0) ||
+		(len(vault.RoleID) != 0 && len(vault.SecretIDPath) == 0) {
+		return ErrVaultConf
+	}
+
+	return nil
+}
+
+// do sends a Vault API request, with an optional JSON body, and decodes the
+// JSON response in 'out'.
+func (vault VaultConf) do(httpClient *http.Client, method, path, token string,
+	body, out any) error {
+	var reqBody io.Reader
+
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode the request: %w", err)
+		}
+
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method,
+		strings.TrimSuffix(vault.Address, "/")+"/v1/"+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create the request: %w", err)
+	}
+
+	if len(token) != 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrVaultAPI, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%w: %s %s: %s", ErrVaultAPI, method, path, resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode the response: %w", err)
+	}
+
+	return nil
+}
+
+// token returns the token authenticating the Vault requests.
+func (vault VaultConf) token(httpClient *http.Client) (string, error) {
+	switch {
+	case len(vault.Token) != 0:
+		return vault.Token, nil
+	case len(vault.TokenPath) != 0:
+		token, err := os.ReadFile(vault.TokenPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the Vault token: %w", err)
+		}
+
+		return strings.TrimSpace(string(token)), nil
+	}
+
+	secretID, err := os.ReadFile(vault.SecretIDPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the Vault secret ID: %w", err)
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+
+	err = vault.do(httpClient, http.MethodPost, "auth/approle/login", "",
+		map[string]string{
+			"role_id":   vault.RoleID,
+			"secret_id": strings.TrimSpace(string(secretID)),
+		}, &login)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to Vault: %w", err)
+	}
+
+	return login.Auth.ClientToken, nil
+}
+
+// vaultSecrets reads the secrets from the configured Vault secret. Both the
+// KV version 1 and version 2 secrets engines are supported.
+func vaultSecrets(conf Config) (map[string]string, error) {
+	httpClient, err := newHTTPClient(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient.Timeout = vaultTimeout
+
+	token, err := conf.Vault.token(httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+
+	err = conf.Vault.do(httpClient, http.MethodGet, conf.Vault.SecretPath, token,
+		nil, &secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the Vault secret: %w", err)
+	}
+
+	// The KV version 2 secrets engine nests the secret data along with its
+	// metadata.
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok && data["metadata"] != nil {
+		data = nested
+	}
+
+	secrets := make(map[string]string, len(data))
+
+	for key, value := range data {
+		str, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a string", ErrVaultAPI, key)
+		}
+
+		secrets[key] = str
+	}
+
+	return secrets, nil
+}
+
+// processVault sets the secrets read from Vault, when configured, as
+// ProcessEnv does for the environment variables.
+func (conf *Config) processVault(logger Logger) error {
+	if len(conf.Vault.Address) == 0 {
+		return nil
+	}
+
+	logger.Info("Reading the secrets from Vault...")
+
+	secrets, err := vaultSecrets(*conf)
+	if err != nil {
+		return err
+	}
+
+	conf.ProcessEnv(logger, secrets)
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestVault starts a Vault server mock with an AppRole login and a KV
+// version 2 and a version 1 secret.
+func newTestVault(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	vault := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPost && r.URL.Path == "/v1/auth/approle/login":
+				var login map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&login); err != nil ||
+					login["role_id"] != "role" || login["secret_id"] != "secretid" {
+					w.WriteHeader(http.StatusBadRequest)
+
+					return
+				}
+
+				_, _ = w.Write([]byte(`{"auth": {"client_token": "token"}}`))
+			case r.Header.Get("X-Vault-Token") != "token":
+				w.WriteHeader(http.StatusForbidden)
+			case r.Method == http.MethodGet && r.URL.Path == "/v1/secret/data/gmm":
+				_, _ = w.Write([]byte(`{"data": {"data": {` +
+					`"GMM_SSH_PRIVATE_KEY": "key", "GMM_SSH_KNOWN_HOSTS": "hosts"` +
+					`}, "metadata": {"version": 1}}}`))
+			case r.Method == http.MethodGet && r.URL.Path == "/v1/kv/gmm":
+				_, _ = w.Write([]byte(`{"data": {"GMM_DST_HTTP_PASSWORD": "password"}}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	t.Cleanup(vault.Close)
+
+	return vault
+}
+
+// TestProcessVault tests reading the secrets from Vault.
+func TestProcessVault(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	vault := newTestVault(t)
+
+	dir := t.TempDir()
+	tokenPath := filepath.Join(dir, "token")
+	secretIDPath := filepath.Join(dir, "secretid")
+
+	if err := os.WriteFile(tokenPath, []byte("token\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the token: %s", err)
+	}
+
+	if err := os.WriteFile(secretIDPath, []byte("secretid\n"), 0o600); err != nil {
+		t.Fatalf("failed to write the secret ID: %s", err)
+	}
+
+	{
+		// Test a KV version 2 secret with an AppRole login.
+		conf := Config{
+			Vault: VaultConf{
+				Address:      vault.URL,
+				RoleID:       "role",
+				SecretIDPath: secretIDPath,
+				SecretPath:   "secret/data/gmm",
+			},
+		}
+		if err := conf.processVault(logger); err != nil {
+			t.Fatalf("processVault failed: %s", err)
+		}
+		if conf.Destination.SSH.PrivateKey != "key" ||
+			conf.Destination.SSH.KnownHosts != "hosts" {
+			t.Fatalf("unexpected secrets: %s", conf.Pretty())
+		}
+	}
+	{
+		// Test a KV version 1 secret with a token file.
+		conf := Config{
+			Vault: VaultConf{
+				Address:    vault.URL,
+				TokenPath:  tokenPath,
+				SecretPath: "kv/gmm",
+			},
+		}
+		if err := conf.processVault(logger); err != nil {
+			t.Fatalf("processVault failed: %s", err)
+		}
+		if conf.Destination.HTTP.Password != "password" {
+			t.Fatalf("unexpected secrets: %s", conf.Pretty())
+		}
+	}
+	{
+		// Test an invalid token.
+		conf := Config{
+			Vault: VaultConf{
+				Address:    vault.URL,
+				Token:      "invalid",
+				SecretPath: "kv/gmm",
+			},
+		}
+		if err := conf.processVault(logger); !errors.Is(err, ErrVaultAPI) {
+			t.Fatalf("unexpected error for an invalid token: %v", err)
+		}
+	}
+	{
+		// Vault is not used without an address.
+		conf := Config{Vault: VaultConf{SecretPath: "kv/gmm"}}
+		if err := conf.processVault(logger); err != nil {
+			t.Fatalf("processVault without an address failed: %s", err)
+		}
+	}
+}
+
+// TestVaultConfValidate tests the Vault configuration validation.
+func TestVaultConfValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, vault := range []VaultConf{
+		{Address: "https://vault", Token: "token"},
+		{Address: "https://vault", SecretPath: "kv/gmm"},
+		{Address: "https://vault", SecretPath: "kv/gmm", RoleID: "role"},
+	} {
+		if err := vault.validate(); !errors.Is(err, ErrVaultConf) {
+			t.Fatalf("invalid Vault configuration passed: %v", vault)
+		}
+	}
+
+	for _, vault := range []VaultConf{
+		{},
+		{Address: "https://vault", SecretPath: "kv/gmm", TokenPath: "token"},
+		{
+			Address: "https://vault", SecretPath: "kv/gmm", RoleID: "role",
+			SecretIDPath: "secretid",
+		},
+	} {
+		if err := vault.validate(); err != nil {
+			t.Fatalf("valid Vault configuration failed: %v (%s)", vault, err)
+		}
+	}
+}
+
+// TestVaultSSHValidation tests that the SSH configurations are checked with
+// the SSH keys read from Vault.
+func TestVaultSSHValidation(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	vault := newTestVault(t)
+
+	conf := Config{
+		Source: RepoConf{URL: "/invalid"},
+		Destination: RepoConf{
+			URL: "ssh://git@example.com/foo/bar.git",
+			SSH: SSHConf{JumpHost: "user@jump.example.com"},
+		},
+		Vault: VaultConf{
+			Address:    vault.URL,
+			Token:      "token",
+			SecretPath: "secret/data/gmm",
+		},
+	}
+
+	{
+		// Test that a jump host is allowed with the SSH key in Vault.
+		if err := conf.Validate(logger); err != nil {
+			t.Fatalf("Validate failed with the SSH key in Vault: %s", err)
+		}
+
+		if err := DoMirror(conf, logger); err == nil || errors.Is(err, ErrJumpHostAuth) {
+			t.Fatalf("unexpected error with the SSH key in Vault: %v", err)
+		}
+	}
+	{
+		// Test that the SSH configuration is checked once the Vault secrets
+		// are read.
+		noKeyConf := conf
+		noKeyConf.Vault.SecretPath = "kv/gmm"
+
+		if err := DoMirror(noKeyConf, logger); !errors.Is(err, ErrJumpHostAuth) {
+			t.Fatalf("unexpected error without an SSH key in Vault: %v", err)
+		}
+	}
+}
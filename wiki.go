@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// wikiSuffix is added to the name of a repository, before its '.git' suffix,
+// if any, to get the name of its wiki repository (e.g. 'project.wiki.git' on
+// GitHub and GitLab).
+const wikiSuffix = ".wiki"
+
+// wikiURL returns the URL of the wiki repository of the 'url' repository.
+func wikiURL(url string) string {
+	url = strings.TrimSuffix(url, "/")
+
+	if strings.HasSuffix(url, ".git") {
+		return strings.TrimSuffix(url, ".git") + wikiSuffix + ".git"
+	}
+
+	return url + wikiSuffix
+}
+
+// wikiConf returns the configuration mirroring the wiki repositories of the
+// source and the destination. The options that only apply to the main
+// repository, like the reference selection, or that were already handled
+// for the run (e.g. the lock and the Vault secrets), are turned off.
+func wikiConf(conf Config) Config {
+	conf.Source.URL = wikiURL(conf.Source.URL)
+	conf.Destination.URL = wikiURL(conf.Destination.URL)
+
+	conf.MirrorWiki = false
+	conf.Vault = VaultConf{}
+	conf.LockFile = ""
+	conf.DstRefsCache = ""
+	conf.OnlyOnNewTag = false
+	conf.MinRefs = 0
+	conf.SyncMetadata = false
+	conf.CreateDefaultBranch = false
+	conf.PlanFile = ""
+	conf.ApplyPlan = ""
+	conf.Changelog = ""
+
+	// The references of the main repository are selected by these, and the
+	// wiki has its own (e.g. a 'master' branch filtered out by a 'main'
+	// branch allowlist).
+	conf.FetchRefSpecs = nil
+	conf.PushRefSpecs = nil
+	conf.IncludeRefs = nil
+	conf.IncludeRefsFile = ""
+	conf.ExcludeRefs = nil
+	conf.ExcludeRefsFile = ""
+	conf.BranchAllowlistFile = ""
+	conf.RefFilter = nil
+	conf.OnlyReachableFrom = nil
+	conf.ExcludeAuthorDomains = nil
+	conf.ExcludeEmptyRefs = false
+	conf.PlaceholderPattern = ""
+	conf.TagRetention = 0
+	conf.TagSemverRange = ""
+	conf.KeepNonSemverTags = false
+	conf.MaxDstRefs = 0
+	conf.DetectRewrites = false
+	conf.FailOnRewrites = false
+	conf.PreserveRefs = nil
+	conf.DeleteRefs = nil
+	conf.RefGroups = nil
+
+	return conf
+}
+
+// srcWikiExists returns whether the source wiki repository exists. A wiki
+// that was never written to doesn't exist (GitHub) or is empty (GitLab).
+func srcWikiExists(conf Config, logger Logger) (bool, error) {
+	auth, err := repoAuth(conf, logger, conf.Source, "source")
+	if err != nil {
+		return false, err
+	}
+
+	src := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: srcRemoteName,
+		URLs: []string{conf.Source.URL},
+	})
+
+	_, err = listRemote(conf, src, auth)
+
+	switch {
+	case errors.Is(err, transport.ErrRepositoryNotFound),
+		errors.Is(err, transport.ErrEmptyRemoteRepository):
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("failed to list the source wiki: %w", err)
+	}
+
+	return true, nil
+}
+
+// mirrorWiki mirrors the source wiki repository to the destination one. The
+// wiki mirror is skipped when the source has no wiki. The statistics of the
+// wiki mirror are added to 'stats', when not nil, with the phases prefixed by
+// 'wiki-'. ErrNoChange is returned, with conf.FailOnNoChange, when the wiki
+// was not changed, skipped included.
+func mirrorWiki(conf Config, logger Logger, stats *runStats, span *traceSpan) error {
+	wiki := wikiConf(conf)
+
+	exists, err := srcWikiExists(wiki, logger)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		logger.Info("The source has no wiki, skipping the wiki mirror.")
+
+		if wiki.FailOnNoChange {
+			return ErrNoChange
+		}
+
+		return nil
+	}
+
+	logger.Info("Mirroring the wiki", redactURL(wiki.Source.URL), "to",
+		redactURL(wiki.Destination.URL)+"...")
+
+	wikiSpan := span.child("wiki")
+
+	var wikiStats *runStats
+	if stats != nil {
+		wikiStats = newRunStats(stats.countChanged)
+	}
+
+	err = doMirror(wiki, logger, wikiStats, wikiSpan)
+	wikiSpan.finish(err)
+
+	if stats != nil {
+		stats.add(wikiStats, "wiki-")
+	}
+
+	switch {
+	case errors.Is(err, ErrNoChange):
+		return ErrNoChange
+	case err != nil:
+		return fmt.Errorf("failed to mirror the wiki: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+// SPDX-FileCopyrightText: Andrei Gherzan <andrei@gherzan.com>
+//
+// SPDX-License-Identifier: MIT
+
+package mirror
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	"github.com/agherzan/git-mirror-me/internal/utils"
+)
+
+// TestWikiURL tests wikiURL function.
+func TestWikiURL(t *testing.T) {
+	t.Parallel()
+
+	for url, expected := range map[string]string{
+		"https://github.com/foo/bar.git":   "https://github.com/foo/bar.wiki.git",
+		"https://github.com/foo/bar":       "https://github.com/foo/bar.wiki",
+		"https://gitlab.com/foo/bar/":      "https://gitlab.com/foo/bar.wiki",
+		"git@github.com:foo/bar.git":       "git@github.com:foo/bar.wiki.git",
+		"ssh://git@gitlab.com/foo/bar.git": "ssh://git@gitlab.com/foo/bar.wiki.git",
+		"/srv/git/bar":                     "/srv/git/bar.wiki",
+	} {
+		if wikiURL(url) != expected {
+			t.Fatalf("unexpected wiki URL of %q: %q", url, wikiURL(url))
+		}
+	}
+}
+
+// TestDoMirrorWiki tests mirroring the wiki along with the repository.
+func TestDoMirrorWiki(t *testing.T) {
+	t.Parallel()
+
+	// no need for logs
+	devnull, _ := os.Open(os.DevNull)
+	defer devnull.Close()
+	logger := NewLogger(devnull)
+
+	base := t.TempDir()
+
+	_, srcHead, err := utils.NewTestRepo(filepath.Join(base, "src.git"), []string{
+		"refs/heads/a",
+	})
+	if err != nil {
+		t.Fatalf("failed to create a test src repo: %s", err)
+	}
+
+	dstRepo, err := utils.NewBareRepo(filepath.Join(base, "dst.git"))
+	if err != nil {
+		t.Fatalf("failed to create a test dst repo: %s", err)
+	}
+
+	conf := Config{
+		Source:      RepoConf{URL: filepath.Join(base, "src.git")},
+		Destination: RepoConf{URL: filepath.Join(base, "dst.git")},
+		MirrorWiki:  true,
+	}
+
+	{
+		// Test that a missing source wiki is skipped.
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed without a source wiki: %s", err)
+		}
+
+		ok, err := utils.RepoRefsCheckHash(dstRepo, srcHead, "refs/")
+		if err != nil || !ok {
+			t.Fatalf("unexpected hashes in the dst repo: %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(base, "dst.wiki.git")); !os.IsNotExist(err) {
+			t.Fatalf("unexpected dst wiki: %v", err)
+		}
+	}
+	var (
+		srcWiki, dstWiki *git.Repository
+		srcWikiHead      plumbing.Hash
+	)
+	{
+		// Test mirroring the wiki.
+		srcWiki, srcWikiHead, err = utils.NewTestRepoWithContent(
+			filepath.Join(base, "src.wiki.git"), nil, "wiki")
+		if err != nil {
+			t.Fatalf("failed to create a test src wiki: %s", err)
+		}
+
+		dstWiki, err = utils.NewBareRepo(filepath.Join(base, "dst.wiki.git"))
+		if err != nil {
+			t.Fatalf("failed to create a test dst wiki: %s", err)
+		}
+
+		if err := DoMirror(conf, logger); err != nil {
+			t.Fatalf("DoMirror failed with a source wiki: %s", err)
+		}
+
+		dstWikiRefs, err := utils.RepoRefsSlice(dstWiki)
+		if err != nil {
+			t.Fatalf("failed to get the dst wiki refs: %s", err)
+		}
+
+		if !utils.SlicesAreEqual(dstWikiRefs, []string{
+			"HEAD",
+			"refs/heads/master",
+		}) {
+			t.Fatalf("unexpected refs in the dst wiki: %s", dstWikiRefs)
+		}
+
+		ok, err := utils.RepoRefsCheckHash(dstWiki, srcWikiHead, "refs/")
+		if err != nil || !ok {
+			t.Fatalf("unexpected hashes in the dst wiki: %v", err)
+		}
+	}
+	{
+		// Test that the reference selection of the repository doesn't apply
+		// to the wiki.
+		allowlist := filepath.Join(base, "allowlist")
+		if err := os.WriteFile(allowlist, []byte("master\na\n"), 0o644); err != nil {
+			t.Fatalf("failed to write the allowlist: %s", err)
+		}
+
+		err := srcWiki.Storer.SetReference(plumbing.NewHashReference("refs/heads/b",
+			srcWikiHead))
+		if err != nil {
+			t.Fatalf("failed to add a src wiki reference: %s", err)
+		}
+
+		allowlistConf := conf
+		allowlistConf.BranchAllowlistFile = allowlist
+
+		if err := DoMirror(allowlistConf, logger); err != nil {
+			t.Fatalf("DoMirror failed with an allowlist: %s", err)
+		}
+
+		if _, err := dstWiki.Reference("refs/heads/b", false); err != nil {
+			t.Fatalf("the dst wiki was filtered: %s", err)
+		}
+	}
+	{
+		// Test that the wiki changes are changes of the run.
+		noChangeConf := conf
+		noChangeConf.FailOnNoChange = true
+
+		if err := DoMirror(noChangeConf, logger); !errors.Is(err, ErrNoChange) {
+			t.Fatalf("unexpected error without changes: %v", err)
+		}
+
+		err := srcWiki.Storer.SetReference(plumbing.NewHashReference("refs/heads/c",
+			srcWikiHead))
+		if err != nil {
+			t.Fatalf("failed to add a src wiki reference: %s", err)
+		}
+
+		stats := newRunStats(true)
+
+		if err := doMirror(noChangeConf, logger, stats, nil); err != nil {
+			t.Fatalf("doMirror failed with a changed wiki: %s", err)
+		}
+
+		if stats.changed != 1 {
+			t.Fatalf("unexpected number of changed references: %d", stats.changed)
+		}
+
+		if _, ok := stats.durations["wiki-push"]; !ok {
+			t.Fatalf("missing wiki push duration: %v", stats.durations)
+		}
+	}
+	{
+		// Test that a failed wiki mirror fails the run.
+		if err := os.RemoveAll(filepath.Join(base, "dst.wiki.git")); err != nil {
+			t.Fatalf("failed to remove the dst wiki: %s", err)
+		}
+
+		if err := DoMirror(conf, logger); err == nil {
+			t.Fatal("DoMirror didn't fail without a destination wiki")
+		}
+	}
+}